@@ -0,0 +1,88 @@
+package broker
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Event is a WebSocket message for the clients subscribed to a channel
+type Event struct {
+	Channel string `bson:"channel"`
+	Payload []byte `bson:"payload"`
+}
+
+// Handler receives published events. It is called synchronously and must not block.
+type Handler func(Event)
+
+// Broker fans events out to every subscribed WebSocket hub, which may run in
+// other server instances
+type Broker interface {
+	Publish(ctx context.Context, event Event) error
+	// Subscribe registers a handler and returns a function that removes it
+	Subscribe(handler Handler) (unsubscribe func())
+	Close() error
+}
+
+// FromEnv builds the broker selected by EVENT_BROKER: "mongo", which shares
+// events between instances through a capped collection, or "memory" (the
+// default) for a single instance.
+func FromEnv(ctx context.Context, db *mongo.Database) (Broker, error) {
+	switch backend := os.Getenv("EVENT_BROKER"); backend {
+	case "mongo":
+		return NewMongoBroker(ctx, db, DefaultCollection)
+	case "", "memory":
+		return NewMemoryBroker(), nil
+	default:
+		return nil, fmt.Errorf("unknown EVENT_BROKER %q", backend)
+	}
+}
+
+// MemoryBroker delivers events to the hubs of the current process
+type MemoryBroker struct {
+	mu       sync.RWMutex
+	handlers map[int]Handler
+	nextID   int
+}
+
+// NewMemoryBroker creates a MemoryBroker
+func NewMemoryBroker() *MemoryBroker {
+	return &MemoryBroker{handlers: make(map[int]Handler)}
+}
+
+// Publish calls every subscribed handler
+func (b *MemoryBroker) Publish(ctx context.Context, event Event) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, handler := range b.handlers {
+		handler(event)
+	}
+	return nil
+}
+
+// Subscribe registers a handler
+func (b *MemoryBroker) Subscribe(handler Handler) func() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	id := b.nextID
+	b.nextID++
+	b.handlers[id] = handler
+
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.handlers, id)
+	}
+}
+
+// Close removes all handlers
+func (b *MemoryBroker) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.handlers = make(map[int]Handler)
+	return nil
+}
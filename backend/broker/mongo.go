@@ -0,0 +1,201 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// DefaultCollection is the capped collection events are shared through
+	DefaultCollection = "ws_events"
+
+	cappedSizeBytes = 16 << 20
+	tailRetryDelay  = 250 * time.Millisecond
+	tailAwaitTime   = time.Second
+	// tailOverlap is how far back a reopened tail starts. ObjectIDs of documents
+	// inserted by different instances in the same second are not ordered, so
+	// the tail re-reads a few seconds and skips what it already delivered.
+	tailOverlap = 5 * time.Second
+	seenTTL     = time.Minute
+)
+
+// mongoEvent is an event stored in the capped collection
+type mongoEvent struct {
+	ID        primitive.ObjectID `bson:"_id"`
+	Origin    primitive.ObjectID `bson:"origin"`
+	Channel   string             `bson:"channel"`
+	Payload   []byte             `bson:"payload"`
+	CreatedAt primitive.DateTime `bson:"created_at"`
+}
+
+// MongoBroker shares events between server instances through a capped
+// collection. Each instance inserts the events it publishes and follows the
+// collection with a tailable cursor to pick up the others' events. Local
+// subscribers get events straight away, without the round trip.
+type MongoBroker struct {
+	collection *mongo.Collection
+	origin     primitive.ObjectID
+	local      *MemoryBroker
+	cancel     context.CancelFunc
+	done       chan struct{}
+	closeOnce  sync.Once
+}
+
+// NewMongoBroker creates the capped collection if needed and starts following it
+func NewMongoBroker(ctx context.Context, db *mongo.Database, collection string) (*MongoBroker, error) {
+	err := db.CreateCollection(ctx, collection, options.CreateCollection().
+		SetCapped(true).
+		SetSizeInBytes(cappedSizeBytes))
+	var commandErr mongo.CommandError
+	if err != nil && !(errors.As(err, &commandErr) && commandErr.Name == "NamespaceExists") {
+		return nil, err
+	}
+
+	b := &MongoBroker{
+		collection: db.Collection(collection),
+		origin:     primitive.NewObjectID(),
+		local:      NewMemoryBroker(),
+		done:       make(chan struct{}),
+	}
+
+	// A tailable cursor on an empty collection is closed right away, so make
+	// sure there is something to tail
+	start := time.Now()
+	_, err = b.collection.InsertOne(ctx, mongoEvent{
+		ID:        primitive.NewObjectID(),
+		Origin:    b.origin,
+		CreatedAt: primitive.NewDateTimeFromTime(start),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	tailCtx, cancel := context.WithCancel(context.Background())
+	b.cancel = cancel
+	go b.tail(tailCtx, start)
+	return b, nil
+}
+
+// Publish delivers an event to local subscribers and stores it for the other instances
+func (b *MongoBroker) Publish(ctx context.Context, event Event) error {
+	b.local.Publish(ctx, event)
+
+	_, err := b.collection.InsertOne(ctx, mongoEvent{
+		ID:        primitive.NewObjectID(),
+		Origin:    b.origin,
+		Channel:   event.Channel,
+		Payload:   event.Payload,
+		CreatedAt: primitive.NewDateTimeFromTime(time.Now()),
+	})
+	return err
+}
+
+// Subscribe registers a handler for events from every instance
+func (b *MongoBroker) Subscribe(handler Handler) func() {
+	return b.local.Subscribe(handler)
+}
+
+// Close stops following the collection
+func (b *MongoBroker) Close() error {
+	b.closeOnce.Do(func() {
+		b.cancel()
+		<-b.done
+		b.local.Close()
+	})
+	return nil
+}
+
+// tail follows the capped collection until ctx is done, reopening the cursor
+// whenever the server closes it
+func (b *MongoBroker) tail(ctx context.Context, since time.Time) {
+	defer close(b.done)
+
+	seen := newSeenEvents()
+	for {
+		since = b.follow(ctx, since, seen)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(tailRetryDelay):
+		}
+	}
+}
+
+// follow reads events from one tailable cursor and returns the creation time
+// of the last event it saw
+func (b *MongoBroker) follow(ctx context.Context, since time.Time, seen *seenEvents) time.Time {
+	filter := bson.M{"_id": bson.M{"$gte": primitive.NewObjectIDFromTimestamp(since.Add(-tailOverlap))}}
+	cursor, err := b.collection.Find(ctx, filter, options.Find().
+		SetCursorType(options.TailableAwait).
+		SetMaxAwaitTime(tailAwaitTime))
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Printf("Failed to tail %s: %v", b.collection.Name(), err)
+		}
+		return since
+	}
+	defer cursor.Close(context.Background())
+
+	for cursor.Next(ctx) {
+		var event mongoEvent
+		if err := cursor.Decode(&event); err != nil {
+			log.Printf("Failed to decode event from %s: %v", b.collection.Name(), err)
+			continue
+		}
+		if event.CreatedAt.Time().After(since) {
+			since = event.CreatedAt.Time()
+		}
+		if !seen.add(event.ID, time.Now()) {
+			continue
+		}
+
+		if event.Origin == b.origin || event.Channel == "" {
+			continue
+		}
+		b.local.Publish(ctx, Event{Channel: event.Channel, Payload: event.Payload})
+	}
+	if err := cursor.Err(); err != nil && ctx.Err() == nil {
+		log.Printf("Lost tail of %s: %v", b.collection.Name(), err)
+	}
+	return since
+}
+
+// seenEvents remembers the events a tail delivered in the last seenTTL, so
+// that a reopened tail skips the overlap it reads again
+type seenEvents struct {
+	at       map[primitive.ObjectID]time.Time
+	prunedAt time.Time
+}
+
+func newSeenEvents() *seenEvents {
+	return &seenEvents{at: make(map[primitive.ObjectID]time.Time), prunedAt: time.Now()}
+}
+
+// add records an event and reports whether it is new. Entries older than
+// seenTTL are dropped as it goes, since a cursor can stay open for the life
+// of the process.
+func (s *seenEvents) add(id primitive.ObjectID, now time.Time) bool {
+	if now.Sub(s.prunedAt) > seenTTL {
+		for seenID, at := range s.at {
+			if now.Sub(at) > seenTTL {
+				delete(s.at, seenID)
+			}
+		}
+		s.prunedAt = now
+	}
+
+	if _, ok := s.at[id]; ok {
+		return false
+	}
+	s.at[id] = now
+	return true
+}
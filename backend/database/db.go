@@ -16,12 +16,22 @@ import (
 
 // Collection names
 const (
-	UsersCollection    = "users"
-	GroupsCollection   = "groups"
-	PollsCollection    = "polls"
-	VotesCollection    = "votes"
-	CommentsCollection = "comments"
-	MembersCollection  = "group_members"
+	UsersCollection         = "users"
+	GroupsCollection        = "groups"
+	PollsCollection         = "polls"
+	VotesCollection         = "votes"
+	CommentsCollection      = "comments"
+	MembersCollection       = "group_members"
+	EventsCollection        = "events"
+	SessionsCollection      = "sessions"
+	ResetsCollection        = "password_resets"
+	ResetRequestsCollection = "password_reset_requests"
+	VerificationsCollection = "email_verifications"
+	OIDCStatesCollection    = "oidc_states"
+	APITokensCollection     = "api_tokens"
+	ThrottlesCollection     = "signin_throttles"
+	SignInEventsCollection  = "signin_events"
+	PresenceCollection      = "presence"
 )
 
 // getDefaultAdminCredentials retrieves admin credentials from environment variables
@@ -60,6 +70,10 @@ func createCollectionIndexes(db *mongo.Database) error {
 			Keys:    bson.D{{Key: "username", Value: 1}},
 			Options: options.Index().SetUnique(true),
 		},
+		{
+			Keys:    bson.D{{Key: "external_identities.issuer", Value: 1}, {Key: "external_identities.subject", Value: 1}},
+			Options: options.Index().SetUnique(true).SetSparse(true),
+		},
 	}
 	_, err := db.Collection(UsersCollection).Indexes().CreateMany(ctx, usersIndexes)
 	if err != nil {
@@ -78,6 +92,12 @@ func createCollectionIndexes(db *mongo.Database) error {
 		{
 			Keys: bson.D{{Key: "created_by", Value: 1}},
 		},
+		{
+			Keys: bson.D{{Key: "parent_id", Value: 1}},
+		},
+		{
+			Keys: bson.D{{Key: "ancestors", Value: 1}},
+		},
 	}
 	_, err = db.Collection(GroupsCollection).Indexes().CreateMany(ctx, groupsIndexes)
 	if err != nil {
@@ -154,6 +174,179 @@ func createCollectionIndexes(db *mongo.Database) error {
 		return err
 	}
 
+	// Events Collection Indexes
+	eventsIndexes := []mongo.IndexModel{
+		{
+			Keys: bson.D{
+				{Key: "group_id", Value: 1},
+				{Key: "_id", Value: -1},
+			},
+		},
+	}
+	_, err = db.Collection(EventsCollection).Indexes().CreateMany(ctx, eventsIndexes)
+	if err != nil {
+		return err
+	}
+
+	// Sessions Collection Indexes
+	sessionsIndexes := []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "refresh_token_hash", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.D{{Key: "previous_token_hash", Value: 1}},
+		},
+		{
+			Keys: bson.D{{Key: "user_id", Value: 1}},
+		},
+		{
+			Keys:    bson.D{{Key: "expires_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0), // Expired sessions are removed by MongoDB
+		},
+	}
+	_, err = db.Collection(SessionsCollection).Indexes().CreateMany(ctx, sessionsIndexes)
+	if err != nil {
+		return err
+	}
+
+	// Password Resets Collection Indexes
+	resetsIndexes := []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "token_hash", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.D{{Key: "user_id", Value: 1}},
+		},
+		{
+			Keys:    bson.D{{Key: "expires_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+	}
+	_, err = db.Collection(ResetsCollection).Indexes().CreateMany(ctx, resetsIndexes)
+	if err != nil {
+		return err
+	}
+
+	// Password Reset Requests Collection Indexes
+	resetRequestsIndexes := []mongo.IndexModel{
+		{
+			Keys: bson.D{{Key: "key", Value: 1}, {Key: "created_at", Value: -1}},
+		},
+		{
+			Keys:    bson.D{{Key: "expires_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+	}
+	_, err = db.Collection(ResetRequestsCollection).Indexes().CreateMany(ctx, resetRequestsIndexes)
+	if err != nil {
+		return err
+	}
+
+	// Email Verifications Collection Indexes
+	verificationsIndexes := []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "token_hash", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.D{{Key: "user_id", Value: 1}},
+		},
+		{
+			Keys:    bson.D{{Key: "expires_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+	}
+	_, err = db.Collection(VerificationsCollection).Indexes().CreateMany(ctx, verificationsIndexes)
+	if err != nil {
+		return err
+	}
+
+	// API Tokens Collection Indexes
+	apiTokensIndexes := []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "token_hash", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.D{{Key: "user_id", Value: 1}},
+		},
+	}
+	_, err = db.Collection(APITokensCollection).Indexes().CreateMany(ctx, apiTokensIndexes)
+	if err != nil {
+		return err
+	}
+
+	// Sign-in Throttles Collection Indexes
+	throttlesIndexes := []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "key", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "expires_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+	}
+	_, err = db.Collection(ThrottlesCollection).Indexes().CreateMany(ctx, throttlesIndexes)
+	if err != nil {
+		return err
+	}
+
+	// Sign-in Events Collection Indexes
+	signInEventsIndexes := []mongo.IndexModel{
+		{
+			Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}},
+		},
+		{
+			Keys: bson.D{{Key: "email", Value: 1}, {Key: "created_at", Value: -1}},
+		},
+		{
+			Keys:    bson.D{{Key: "created_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(90 * 24 * 60 * 60), // Sign-in history is kept for 90 days
+		},
+	}
+	_, err = db.Collection(SignInEventsCollection).Indexes().CreateMany(ctx, signInEventsIndexes)
+	if err != nil {
+		return err
+	}
+
+	// OIDC States Collection Indexes
+	oidcStatesIndexes := []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "state_hash", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "expires_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+	}
+	_, err = db.Collection(OIDCStatesCollection).Indexes().CreateMany(ctx, oidcStatesIndexes)
+	if err != nil {
+		return err
+	}
+
+	// Presence Collection Indexes
+	presenceIndexes := []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "connection_id", Value: 1}, {Key: "channel", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.D{{Key: "channel", Value: 1}, {Key: "expires_at", Value: 1}},
+		},
+		{
+			Keys:    bson.D{{Key: "expires_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+	}
+	_, err = db.Collection(PresenceCollection).Indexes().CreateMany(ctx, presenceIndexes)
+	if err != nil {
+		return err
+	}
+
 	log.Println("Successfully created all collection indexes")
 	return nil
 }
@@ -188,13 +381,14 @@ func EnsureDefaultAdmin(db *mongo.Database) error {
 	// Create default admin user
 	now := primitive.NewDateTimeFromTime(time.Now())
 	adminUser := models.User{
-		ID:        primitive.NewObjectID(),
-		Username:  username,
-		Email:     email,
-		Password:  string(hashedPassword),
-		Role:      models.RoleAdmin,
-		CreatedAt: now,
-		UpdatedAt: now,
+		ID:            primitive.NewObjectID(),
+		Username:      username,
+		Email:         email,
+		Password:      string(hashedPassword),
+		Role:          models.RoleAdmin,
+		EmailVerified: true,
+		CreatedAt:     now,
+		UpdatedAt:     now,
 	}
 
 	// Insert admin user
@@ -215,6 +409,10 @@ func InitializeDatabase(db *mongo.Database) error {
 		return err
 	}
 
+	if err := backfillEmailVerified(db); err != nil {
+		return err
+	}
+
 	// Ensure default admin exists
 	if err := EnsureDefaultAdmin(db); err != nil {
 		return err
@@ -222,3 +420,23 @@ func InitializeDatabase(db *mongo.Database) error {
 
 	return nil
 }
+
+// backfillEmailVerified marks users created before email verification existed
+// as verified, so that REQUIRE_EMAIL_VERIFICATION does not lock them out. Users
+// created since then always store the field, so this only matches old accounts.
+func backfillEmailVerified(db *mongo.Database) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	result, err := db.Collection(UsersCollection).UpdateMany(ctx,
+		bson.M{"email_verified": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"email_verified": true}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.ModifiedCount > 0 {
+		log.Printf("Marked %d existing users as having a verified email", result.ModifiedCount)
+	}
+	return nil
+}
@@ -0,0 +1,315 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+	"voteverse/mailer"
+	"voteverse/models"
+	"voteverse/services"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// deletedUsername is shown as the author of comments whose author deleted their account
+const deletedUsername = "Deleted user"
+
+// UpdateProfileRequest represents a partial update of the signed-in user's
+// profile. Changing the email address needs the current password.
+type UpdateProfileRequest struct {
+	Username        *string `json:"username" binding:"omitempty,min=3"`
+	Email           *string `json:"email" binding:"omitempty,email"`
+	DisplayName     *string `json:"display_name" binding:"omitempty,max=100"`
+	AvatarURL       *string `json:"avatar_url"`
+	CurrentPassword string  `json:"current_password"`
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" binding:"required"`
+	NewPassword     string `json:"new_password" binding:"required,min=6"`
+}
+
+type DeleteAccountRequest struct {
+	Password string `json:"password" binding:"required"`
+	Code     string `json:"code"` // TOTP or recovery code, required with 2FA
+}
+
+// checkCurrentPassword verifies the signed-in user's password before a
+// sensitive change. Wrong passwords count as failed sign-ins, so a stolen
+// session cannot be used to guess the password faster than the sign-in form
+// allows. It writes the error response and returns false on failure.
+func checkCurrentPassword(c *gin.Context, db *mongo.Database, user models.User, password, message string) bool {
+	if signInThrottled(c, db, user.Email) {
+		return false
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)); err != nil {
+		recordSignInFailure(c, db, user.Email, &user)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": message})
+		return false
+	}
+	return true
+}
+
+// UpdateProfile handles PATCH /api/user/profile requests. A new email address
+// has to be verified again.
+func UpdateProfile(c *gin.Context, db *mongo.Database) {
+	var req UpdateProfileRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, ok := currentUser(c, db)
+	if !ok {
+		return
+	}
+
+	usersCollection := db.Collection("users")
+	set := bson.M{}
+	emailChanged := false
+
+	if req.Username != nil {
+		username := strings.TrimSpace(*req.Username)
+		if len(username) < 3 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Username must be at least 3 characters"})
+			return
+		}
+		if username != user.Username {
+			count, err := usersCollection.CountDocuments(context.Background(), bson.M{"username": username})
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+				return
+			}
+			if count > 0 {
+				c.JSON(http.StatusConflict, gin.H{"error": "Username is already taken"})
+				return
+			}
+			set["username"] = username
+		}
+	}
+
+	if req.Email != nil {
+		email := strings.TrimSpace(*req.Email)
+		if email != user.Email {
+			if !checkCurrentPassword(c, db, user, req.CurrentPassword, "Current password is required to change the email address") {
+				return
+			}
+
+			count, err := usersCollection.CountDocuments(context.Background(), bson.M{"email": email})
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+				return
+			}
+			if count > 0 {
+				c.JSON(http.StatusConflict, gin.H{"error": "Email already registered"})
+				return
+			}
+			set["email"] = email
+			set["email_verified"] = false
+			emailChanged = true
+		}
+	}
+
+	if req.DisplayName != nil {
+		set["display_name"] = strings.TrimSpace(*req.DisplayName)
+	}
+
+	if req.AvatarURL != nil {
+		if *req.AvatarURL != "" && !isHTTPURL(*req.AvatarURL) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Avatar URL must be an http or https URL"})
+			return
+		}
+		set["avatar_url"] = *req.AvatarURL
+	}
+
+	if len(set) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No fields to update"})
+		return
+	}
+	set["updated_at"] = primitive.NewDateTimeFromTime(time.Now())
+
+	oldEmail := user.Email
+	err := usersCollection.FindOneAndUpdate(
+		context.Background(),
+		bson.M{"_id": user.ID, "deleted_at": nil},
+		bson.M{"$set": set},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&user)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		} else if mongo.IsDuplicateKeyError(err) {
+			// The unique indexes caught a concurrent change
+			c.JSON(http.StatusConflict, gin.H{"error": "Username or email is already taken"})
+		} else {
+			log.Printf("Failed to update profile of user %s: %v", user.ID.Hex(), err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update profile"})
+		}
+		return
+	}
+
+	if emailChanged {
+		if err := sendVerificationEmail(c.Request.Context(), db, user); err != nil {
+			log.Printf("Failed to send verification email to user %s: %v", user.ID.Hex(), err)
+		}
+
+		// Let the old address know, in case someone else changed it
+		err := appMailer.Send(c.Request.Context(), mailer.Message{
+			To:      oldEmail,
+			Subject: "Your VoteVerse email address was changed",
+			Body: fmt.Sprintf("Hi %s,\n\nThe email address of your VoteVerse account was changed to %s.\n\nIf you did not make this change, please contact an administrator.\n",
+				user.Username, user.Email),
+		})
+		if err != nil {
+			log.Printf("Failed to send email change notice to user %s: %v", user.ID.Hex(), err)
+		}
+	}
+
+	user.Password = "" // Don't send password back
+	c.JSON(http.StatusOK, user)
+}
+
+// ChangePassword handles POST /api/user/password requests. Every other
+// session of the user is signed out and every API token is revoked.
+func ChangePassword(c *gin.Context, db *mongo.Database) {
+	var req ChangePasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, ok := currentUser(c, db)
+	if !ok {
+		return
+	}
+
+	if !checkCurrentPassword(c, db, user, req.CurrentPassword, "Current password is incorrect") {
+		return
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcryptCost)
+	if err != nil {
+		log.Printf("Password hashing error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
+		return
+	}
+
+	_, err = db.Collection("users").UpdateOne(context.Background(), bson.M{"_id": user.ID}, bson.M{
+		"$set": bson.M{
+			"password":   string(hashedPassword),
+			"updated_at": primitive.NewDateTimeFromTime(time.Now()),
+		},
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update password"})
+		return
+	}
+
+	filter := bson.M{"user_id": user.ID}
+	if sessionID, err := primitive.ObjectIDFromHex(c.GetString("session_id")); err == nil {
+		filter["_id"] = bson.M{"$ne": sessionID}
+	}
+	revoked, err := revokeSessions(db, filter)
+	if err != nil {
+		log.Printf("Failed to revoke sessions of user %s after password change: %v", user.ID.Hex(), err)
+	}
+	revokedTokens, err := revokeAPITokens(db, user.ID)
+	if err != nil {
+		log.Printf("Failed to revoke API tokens of user %s after password change: %v", user.ID.Hex(), err)
+	}
+
+	err = appMailer.Send(c.Request.Context(), mailer.Message{
+		To:      user.Email,
+		Subject: "Your VoteVerse password was changed",
+		Body:    fmt.Sprintf("Hi %s,\n\nThe password of your VoteVerse account was just changed, and your other devices were signed out and your API tokens were revoked.\n\nIf you did not make this change, reset your password right away.\n", user.Username),
+	})
+	if err != nil {
+		log.Printf("Failed to send password change notice to user %s: %v", user.ID.Hex(), err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":        "Password changed successfully",
+		"revoked":        revoked,
+		"revoked_tokens": revokedTokens,
+	})
+}
+
+// DeleteAccount handles DELETE /api/user/account requests. The account is
+// soft-deleted like an admin delete, but the user's comments stay up without
+// an author.
+func DeleteAccount(c *gin.Context, db *mongo.Database) {
+	var req DeleteAccountRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, ok := currentUser(c, db)
+	if !ok {
+		return
+	}
+
+	if !checkCurrentPassword(c, db, user, req.Password, "Invalid password") {
+		return
+	}
+
+	if user.TwoFactorEnabled {
+		valid, err := verifySecondFactor(db, user, req.Code)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		if !valid {
+			recordSignInFailure(c, db, user.Email, &user)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid code"})
+			return
+		}
+	}
+
+	// Someone has to be left to manage the site
+	if user.Role == models.RoleAdmin {
+		count, err := db.Collection("users").CountDocuments(context.Background(), bson.M{
+			"role":       models.RoleAdmin,
+			"deleted_at": nil,
+			"_id":        bson.M{"$ne": user.ID},
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		if count == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot delete the last admin account"})
+			return
+		}
+	}
+
+	counts, err := services.NewCascadeService(db).DeleteUser(context.Background(), user.ID, services.DeleteOptions{
+		Mode:              services.SoftDelete,
+		DeletedBy:         user.ID,
+		AnonymizeComments: true,
+	})
+	if errors.Is(err, services.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to delete account %s: %v", user.ID.Hex(), err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete account"})
+		return
+	}
+	log.Printf("User %s deleted their account: %v", user.ID.Hex(), counts)
+
+	// The cascade revoked the user's sessions; also drop their open sockets
+	hub.DisconnectUser(user.ID)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Account deleted successfully"})
+}
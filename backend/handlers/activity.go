@@ -0,0 +1,257 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+	"voteverse/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	defaultActivityLimit = 50
+	maxActivityLimit     = 100
+)
+
+// publishEvent records an event in its group's activity feed and broadcasts the
+// matching WebSocket update, so the feed and the live stream always agree.
+// Events without a group (public polls) are broadcast but not stored.
+func publishEvent(db *mongo.Database, event models.GroupEvent) {
+	event.ID = primitive.NewObjectID()
+	event.CreatedAt = primitive.NewDateTimeFromTime(time.Now())
+
+	if !event.GroupID.IsZero() {
+		_, err := db.Collection("events").InsertOne(context.Background(), event)
+		if err != nil {
+			log.Printf("Failed to record %s event for group %s: %v", event.Type, event.GroupID.Hex(), err)
+		}
+	}
+
+	groupID := optionalHex(event.GroupID)
+	pollID := event.PollID.Hex()
+	switch event.Type {
+	case models.EventPollCreated:
+		var poll models.Poll
+		err := db.Collection("polls").FindOne(context.Background(), bson.M{"_id": event.PollID}).Decode(&poll)
+		if err != nil {
+			log.Printf("Failed to load poll %s for poll update: %v", pollID, err)
+			return
+		}
+		NotifyPollUpdate(PollUpdate{GroupID: groupID, PollID: pollID, Type: "created", Poll: &poll})
+	case models.EventPollClosed:
+		NotifyPollUpdate(PollUpdate{GroupID: groupID, PollID: pollID, Type: "closed"})
+	case models.EventVoteCast:
+		voteUpdates.notify(db, event.PollID)
+	case models.EventCommentPosted:
+		comment, err := loadCommentView(db, event.CommentID)
+		if err != nil {
+			log.Printf("Failed to load comment %s for comment update: %v", event.CommentID.Hex(), err)
+			return
+		}
+		NotifyCommentUpdate(CommentUpdate{
+			GroupID:   groupID,
+			PollID:    pollID,
+			Type:      "created",
+			CommentID: event.CommentID.Hex(),
+			Comment:   comment,
+		})
+	case models.EventCommentDeleted:
+		NotifyCommentUpdate(CommentUpdate{
+			GroupID:   groupID,
+			PollID:    pollID,
+			Type:      "deleted",
+			CommentID: event.CommentID.Hex(),
+		})
+	}
+}
+
+// NotifyPollClosed records and broadcasts that a poll has ended
+func NotifyPollClosed(db *mongo.Database, poll models.Poll) {
+	publishEvent(db, models.GroupEvent{
+		GroupID: poll.GroupID,
+		Type:    models.EventPollClosed,
+		PollID:  poll.ID,
+	})
+}
+
+// totalVotes returns the number of votes cast on a poll
+func totalVotes(poll models.Poll) int {
+	total := 0
+	for _, opt := range poll.Options {
+		total += opt.VoteCount
+	}
+	return total
+}
+
+// GetGroupActivity handles GET /api/groups/:id/activity requests.
+// Events are returned newest first; pass the last event's ID as ?before= to get the next page.
+func GetGroupActivity(c *gin.Context) {
+	groupID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid group ID"})
+		return
+	}
+
+	userIDStr, _ := c.Get("user_id")
+	userID, _ := primitive.ObjectIDFromHex(userIDStr.(string))
+	db := c.MustGet("db").(*mongo.Database)
+
+	limit := defaultActivityLimit
+	if limitStr := c.Query("limit"); limitStr != "" {
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit parameter"})
+			return
+		}
+		if limit > maxActivityLimit {
+			limit = maxActivityLimit
+		}
+	}
+
+	match := bson.M{"group_id": groupID}
+	if before := c.Query("before"); before != "" {
+		beforeID, err := primitive.ObjectIDFromHex(before)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid before parameter"})
+			return
+		}
+		match["_id"] = bson.M{"$lt": beforeID}
+	}
+
+	// Deleted groups have no feed, like they have no other reads
+	count, err := db.Collection("groups").CountDocuments(context.Background(), bson.M{
+		"_id":        groupID,
+		"deleted_at": nil,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch group"})
+		return
+	}
+	if count == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Group not found"})
+		return
+	}
+
+	// Check if user is a member of the group
+	count, err = db.Collection("group_members").CountDocuments(context.Background(), bson.M{
+		"group_id": groupID,
+		"user_id":  userID,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check membership"})
+		return
+	}
+	if count == 0 {
+		if _, isAdmin := IsAdmin(userID, db); !isAdmin {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Not a member of this group"})
+			return
+		}
+	}
+
+	// Get events with actor details
+	pipeline := []bson.M{
+		{"$match": match},
+		{"$sort": bson.D{{Key: "_id", Value: -1}}},
+		{"$limit": limit},
+		{
+			"$lookup": bson.M{
+				"from":         "users",
+				"localField":   "actor_id",
+				"foreignField": "_id",
+				"as":           "actor",
+			},
+		},
+		{
+			"$unwind": bson.M{
+				"path":                       "$actor",
+				"preserveNullAndEmptyArrays": true,
+			},
+		},
+		{
+			"$project": bson.M{
+				"_id":        1,
+				"type":       1,
+				"poll_id":    1,
+				"comment_id": 1,
+				"vote_count": 1,
+				"created_at": 1,
+				"actor": bson.M{
+					"_id":      1,
+					"username": 1,
+				},
+			},
+		},
+	}
+
+	cursor, err := db.Collection("events").Aggregate(context.Background(), pipeline)
+	if err != nil {
+		log.Printf("Failed to fetch activity for group %s: %v", groupID.Hex(), err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch activity"})
+		return
+	}
+	defer cursor.Close(context.Background())
+
+	events := []bson.M{}
+	if err := cursor.All(context.Background(), &events); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decode activity"})
+		return
+	}
+	if err := hideActivityVoteCounts(db, events); err != nil {
+		log.Printf("Failed to check results visibility for group %s: %v", groupID.Hex(), err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch activity"})
+		return
+	}
+
+	response := gin.H{"events": events}
+	if len(events) == limit {
+		if lastID, ok := events[len(events)-1]["_id"].(primitive.ObjectID); ok {
+			response["next_before"] = lastID.Hex()
+		}
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// hideActivityVoteCounts removes the vote totals of polls whose results are
+// currently hidden, including totals recorded before the poll hid them
+func hideActivityVoteCounts(db *mongo.Database, events []bson.M) error {
+	var pollIDs []primitive.ObjectID
+	for _, event := range events {
+		if _, ok := event["vote_count"]; !ok {
+			continue
+		}
+		if pollID, ok := event["poll_id"].(primitive.ObjectID); ok {
+			pollIDs = append(pollIDs, pollID)
+		}
+	}
+	if len(pollIDs) == 0 {
+		return nil
+	}
+
+	cursor, err := db.Collection("polls").Find(context.Background(), bson.M{"_id": bson.M{"$in": pollIDs}})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(context.Background())
+
+	var polls []models.Poll
+	if err := cursor.All(context.Background(), &polls); err != nil {
+		return err
+	}
+	hidden := make(map[primitive.ObjectID]bool, len(polls))
+	for _, poll := range polls {
+		hidden[poll.ID] = resultsHidden(poll, primitive.NilObjectID, false)
+	}
+
+	for _, event := range events {
+		if pollID, ok := event["poll_id"].(primitive.ObjectID); ok && hidden[pollID] {
+			delete(event, "vote_count")
+		}
+	}
+	return nil
+}
@@ -2,11 +2,13 @@ package handlers
 
 import (
 	"context"
+	"errors"
 	"log"
 	"net/http"
 	"strconv"
 	"time"
 	"voteverse/models"
+	"voteverse/services"
 
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/bson"
@@ -61,38 +63,39 @@ func AdminDeleteGroup(c *gin.Context, db *mongo.Database) {
 		log.Printf("No reason provided for group deletion: %v", err)
 	}
 
-	// Delete the group
-	result, err := db.Collection("groups").DeleteOne(context.Background(), bson.M{"_id": groupObjID})
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete group"})
-		return
-	}
-
-	if result.DeletedCount == 0 {
+	// Soft-delete the group together with its polls and their comments
+	counts, err := services.NewCascadeService(db).DeleteGroup(context.Background(), groupObjID, services.DeleteOptions{
+		Mode:      services.SoftDelete,
+		DeletedBy: userObjID,
+	})
+	if errors.Is(err, services.ErrNotFound) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Group not found"})
 		return
 	}
-
-	// Also delete all polls in this group
-	deleteResult, err := db.Collection("polls").DeleteMany(context.Background(), bson.M{"group_id": groupID})
 	if err != nil {
-		log.Printf("Failed to delete polls in group: %v", err)
-		// Continue anyway, we've already deleted the group
-	} else {
-		log.Printf("Deleted %d polls in group %s", deleteResult.DeletedCount, groupID)
+		log.Printf("Failed to delete group %s: %v", groupID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete group"})
+		return
 	}
+	log.Printf("Deleted group %s: %v", groupID, counts)
+	hub.RecheckGroup(db, groupID)
 
-	// Also delete all memberships for this group
-	_, err = db.Collection("group_members").DeleteMany(context.Background(), bson.M{"group_id": groupObjID})
+	// The cascade took the subgroups along; their followers lose access too
+	subgroupIDs, err := db.Collection("groups").Distinct(context.Background(), "_id", bson.M{"ancestors": groupObjID})
 	if err != nil {
-		log.Printf("Failed to delete group memberships: %v", err)
-		// Continue anyway, we've already deleted the group
+		log.Printf("Failed to find subgroups of deleted group %s: %v", groupID, err)
+	}
+	for _, id := range subgroupIDs {
+		if subgroupID, ok := id.(primitive.ObjectID); ok {
+			hub.RecheckGroup(db, subgroupID.Hex())
+		}
 	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"message":       "Group deleted successfully",
 		"reason":        req.Reason,
-		"deleted_polls": deleteResult.DeletedCount,
+		"deleted_polls": counts["polls"],
+		"deleted":       counts,
 	})
 }
 
@@ -142,48 +145,35 @@ func AdminDeletePoll(c *gin.Context, db *mongo.Database) {
 		log.Printf("No reason provided for poll deletion: %v", err)
 	}
 
-	// Delete the poll
-	result, err := db.Collection("polls").DeleteOne(context.Background(), bson.M{"_id": pollObjID})
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete poll"})
-		return
-	}
-
-	if result.DeletedCount == 0 {
+	// Soft-delete the poll together with its comments. Votes stay in place and are hidden with it.
+	counts, err := services.NewCascadeService(db).DeletePoll(context.Background(), pollObjID, services.DeleteOptions{
+		Mode:      services.SoftDelete,
+		DeletedBy: userObjID,
+	})
+	if errors.Is(err, services.ErrNotFound) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Poll not found"})
 		return
 	}
-
-	// Also delete all votes for this poll
-	deleteVotesResult, err := db.Collection("votes").DeleteMany(context.Background(), bson.M{"poll_id": pollObjID})
-	if err != nil {
-		log.Printf("Failed to delete votes for poll: %v", err)
-		// Continue anyway, we've already deleted the poll
-	} else {
-		log.Printf("Deleted %d votes for poll %s", deleteVotesResult.DeletedCount, pollID)
-	}
-
-	// Also delete all comments for this poll
-	deleteCommentsResult, err := db.Collection("comments").DeleteMany(context.Background(), bson.M{"poll_id": pollObjID})
 	if err != nil {
-		log.Printf("Failed to delete comments for poll: %v", err)
-		// Continue anyway, we've already deleted the poll
-	} else {
-		log.Printf("Deleted %d comments for poll %s", deleteCommentsResult.DeletedCount, pollID)
+		log.Printf("Failed to delete poll %s: %v", pollID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete poll"})
+		return
 	}
+	log.Printf("Deleted poll %s: %v", pollID, counts)
+	hub.RecheckPoll(db, pollID)
 
 	c.JSON(http.StatusOK, gin.H{
 		"message":          "Poll deleted successfully",
 		"reason":           req.Reason,
-		"deleted_votes":    deleteVotesResult.DeletedCount,
-		"deleted_comments": deleteCommentsResult.DeletedCount,
+		"deleted_comments": counts["comments"],
+		"deleted":          counts,
 	})
 }
 
 // IsAdmin checks if a user is an admin and returns the user object if they are
 func IsAdmin(userID primitive.ObjectID, db *mongo.Database) (models.User, bool) {
 	var user models.User
-	err := db.Collection("users").FindOne(context.Background(), bson.M{"_id": userID}).Decode(&user)
+	err := db.Collection("users").FindOne(context.Background(), bson.M{"_id": userID, "deleted_at": nil}).Decode(&user)
 	if err != nil {
 		return models.User{}, false
 	}
@@ -213,8 +203,12 @@ func AdminListAllGroups(c *gin.Context, db *mongo.Database) {
 		return
 	}
 
-	// Fetch all groups
-	cursor, err := db.Collection("groups").Find(context.Background(), bson.M{})
+	// Fetch all groups, including soft-deleted ones only when asked
+	filter := bson.M{"deleted_at": nil}
+	if c.Query("include_deleted") == "true" {
+		filter = bson.M{}
+	}
+	cursor, err := db.Collection("groups").Find(context.Background(), filter)
 	if err != nil {
 		log.Printf("Failed to fetch groups: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch groups"})
@@ -251,6 +245,10 @@ func AdminListAllGroups(c *gin.Context, db *mongo.Database) {
 	}
 
 	log.Printf("Admin %s (%s) fetched all %d groups", user.Username, user.ID.Hex(), len(groups))
+	if c.Query("tree") == "true" {
+		c.JSON(http.StatusOK, buildGroupTree(groups))
+		return
+	}
 	c.JSON(http.StatusOK, groups)
 }
 
@@ -292,21 +290,6 @@ func AdminListAllPolls(c *gin.Context, db *mongo.Database) {
 		limit = limit64
 	}
 
-	// Update expired polls
-	now := time.Now()
-	nowTime := primitive.NewDateTimeFromTime(now)
-	_, err = db.Collection("polls").UpdateMany(
-		context.Background(),
-		bson.M{
-			"is_active": true,
-			"end_time":  bson.M{"$lt": nowTime},
-		},
-		bson.M{"$set": bson.M{"is_active": false}},
-	)
-	if err != nil {
-		log.Printf("Error updating expired polls: %v", err)
-	}
-
 	// Build find options
 	findOptions := options.Find()
 	if limit > 0 {
@@ -321,8 +304,12 @@ func AdminListAllPolls(c *gin.Context, db *mongo.Database) {
 		findOptions.SetSort(bson.D{{"created_at", -1}})
 	}
 
-	// Fetch all polls
-	cursor, err := db.Collection("polls").Find(context.Background(), bson.M{}, findOptions)
+	// Fetch all polls, including soft-deleted ones only when asked
+	filter := bson.M{"deleted_at": nil}
+	if c.Query("include_deleted") == "true" {
+		filter = bson.M{}
+	}
+	cursor, err := db.Collection("polls").Find(context.Background(), filter, findOptions)
 	if err != nil {
 		log.Printf("Error fetching polls: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch polls"})
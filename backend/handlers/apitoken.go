@@ -0,0 +1,347 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+	"voteverse/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// apiTokenPrefix marks personal access tokens, so AuthMiddleware can tell them from JWTs
+	apiTokenPrefix        = "vv_pat_"
+	maxAPITokensPerUser   = 50
+	apiTokenTouchInterval = time.Minute
+)
+
+var errAPITokenForbidden = errors.New("API tokens cannot be used by this account")
+
+// apiTokenScopes lists the routes API tokens may use and the scope each one
+// needs. Everything else, like account, session and admin routes, needs a
+// signed-in session.
+var apiTokenScopes = map[string]string{
+	"GET /api/groups":                 models.ScopeGroupsRead,
+	"GET /api/groups/search":          models.ScopeGroupsRead,
+	"GET /api/groups/:id":             models.ScopeGroupsRead,
+	"GET /api/groups/:id/activity":    models.ScopeGroupsRead,
+	"GET /api/groups/:id/presence":    models.ScopeGroupsRead,
+	"POST /api/groups":                models.ScopeGroupsWrite,
+	"PATCH /api/groups/:id":           models.ScopeGroupsWrite,
+	"POST /api/groups/:id/join":       models.ScopeGroupsWrite,
+	"POST /api/groups/:id/leave":      models.ScopeGroupsWrite,
+	"GET /api/polls":                  models.ScopePollsRead,
+	"GET /api/polls/group/:groupId":   models.ScopePollsRead,
+	"GET /api/polls/:id":              models.ScopePollsRead,
+	"GET /api/polls/:id/presence":     models.ScopePollsRead,
+	"GET /api/comments/poll/:pollId":  models.ScopePollsRead,
+	"POST /api/polls":                 models.ScopePollsWrite,
+	"POST /api/polls/:id/vote":        models.ScopePollsWrite,
+	"POST /api/comments/poll/:pollId": models.ScopePollsWrite,
+	"DELETE /api/comments/:id":        models.ScopePollsWrite,
+}
+
+var validAPITokenScopes = map[string]bool{
+	models.ScopePollsRead:   true,
+	models.ScopePollsWrite:  true,
+	models.ScopeGroupsRead:  true,
+	models.ScopeGroupsWrite: true,
+}
+
+type CreateAPITokenRequest struct {
+	Name      string     `json:"name" binding:"required,max=100"`
+	Scopes    []string   `json:"scopes" binding:"required,min=1"`
+	ExpiresAt *time.Time `json:"expires_at"` // Optional; the token never expires without it
+}
+
+// APITokenResponse is returned once when a token is created. The token itself
+// cannot be shown again.
+type APITokenResponse struct {
+	models.APIToken
+	Token string `json:"token"`
+}
+
+// apiTokenScope returns the scope an API token needs for a route, if the route
+// accepts API tokens at all
+func apiTokenScope(method, path string) (string, bool) {
+	scope, ok := apiTokenScopes[method+" "+path]
+	return scope, ok
+}
+
+// authenticateAPIToken looks up an active personal access token and checks
+// that its user may still use it
+func authenticateAPIToken(db *mongo.Database, tokenString string) (models.APIToken, error) {
+	var apiToken models.APIToken
+	err := db.Collection("api_tokens").FindOne(context.Background(), bson.M{
+		"token_hash": hashToken(tokenString),
+		"revoked_at": nil,
+		"$or": bson.A{
+			bson.M{"expires_at": nil},
+			bson.M{"expires_at": bson.M{"$gt": primitive.NewDateTimeFromTime(time.Now())}},
+		},
+	}).Decode(&apiToken)
+	if err == mongo.ErrNoDocuments {
+		return models.APIToken{}, errInvalidToken
+	}
+	if err != nil {
+		return models.APIToken{}, err
+	}
+
+	var user models.User
+	err = db.Collection("users").FindOne(context.Background(), bson.M{
+		"_id":        apiToken.UserID,
+		"deleted_at": nil,
+	}).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		return models.APIToken{}, errInvalidToken
+	}
+	if err != nil {
+		return models.APIToken{}, err
+	}
+
+	// Admins who have to enrol in 2FA cannot fall back to their tokens
+	if user.Role == models.RoleAdmin && !user.TwoFactorEnabled {
+		settings, err := loadSecuritySettings(db)
+		if err != nil {
+			return models.APIToken{}, err
+		}
+		if settings.RequireAdminTwoFactor {
+			return models.APIToken{}, errAPITokenForbidden
+		}
+	}
+
+	return apiToken, nil
+}
+
+// touchAPIToken records when and from where a token was last used, at most
+// once a minute
+func touchAPIToken(db *mongo.Database, apiToken models.APIToken, ipAddress string) {
+	now := time.Now()
+	if apiToken.LastUsedAt != nil && now.Sub(apiToken.LastUsedAt.Time()) < apiTokenTouchInterval {
+		return
+	}
+
+	_, err := db.Collection("api_tokens").UpdateOne(context.Background(), bson.M{
+		"_id": apiToken.ID,
+		"$or": bson.A{
+			bson.M{"last_used_at": nil},
+			bson.M{"last_used_at": bson.M{"$lt": primitive.NewDateTimeFromTime(now.Add(-apiTokenTouchInterval))}},
+		},
+	}, bson.M{
+		"$set": bson.M{
+			"last_used_at": primitive.NewDateTimeFromTime(now),
+			"last_used_ip": ipAddress,
+		},
+	})
+	if err != nil {
+		log.Printf("Failed to update last use of API token %s: %v", apiToken.ID.Hex(), err)
+	}
+}
+
+// apiTokenMiddleware authenticates a request made with a personal access token
+func apiTokenMiddleware(c *gin.Context, db *mongo.Database, tokenString string) {
+	apiToken, err := authenticateAPIToken(db, tokenString)
+	if err == errAPITokenForbidden {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Two-factor authentication must be enabled for admin accounts"})
+		c.Abort()
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
+		c.Abort()
+		return
+	}
+
+	scope, ok := apiTokenScope(c.Request.Method, c.FullPath())
+	if !ok {
+		c.JSON(http.StatusForbidden, gin.H{"error": "This route cannot be used with an API token"})
+		c.Abort()
+		return
+	}
+	if !containsString(apiToken.Scopes, scope) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "API token is missing the " + scope + " scope"})
+		c.Abort()
+		return
+	}
+
+	touchAPIToken(db, apiToken, c.ClientIP())
+
+	c.Set("user_id", apiToken.UserID.Hex())
+	c.Set("api_token_id", apiToken.ID.Hex())
+	c.Set("db", db)
+	c.Next()
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// CreateAPIToken handles POST /api/user/tokens requests
+func CreateAPIToken(c *gin.Context, db *mongo.Database) {
+	userID, err := primitive.ObjectIDFromHex(c.GetString("user_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var req CreateAPITokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Name is required"})
+		return
+	}
+
+	scopes := make([]string, 0, len(req.Scopes))
+	for _, scope := range req.Scopes {
+		if !validAPITokenScopes[scope] {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown scope: " + scope})
+			return
+		}
+		if !containsString(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+
+	now := time.Now()
+	var expiresAt *primitive.DateTime
+	if req.ExpiresAt != nil {
+		if !req.ExpiresAt.After(now) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Expiry date must be in the future"})
+			return
+		}
+		expires := primitive.NewDateTimeFromTime(*req.ExpiresAt)
+		expiresAt = &expires
+	}
+
+	tokensCollection := db.Collection("api_tokens")
+	count, err := tokensCollection.CountDocuments(context.Background(), bson.M{
+		"user_id":    userID,
+		"revoked_at": nil,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if count >= maxAPITokensPerUser {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Too many API tokens. Revoke an unused token first"})
+		return
+	}
+
+	secret, _, err := newOpaqueToken()
+	if err != nil {
+		log.Printf("API token generation error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+	token := apiTokenPrefix + secret
+
+	apiToken := models.APIToken{
+		ID:        primitive.NewObjectID(),
+		UserID:    userID,
+		Name:      req.Name,
+		TokenHash: hashToken(token),
+		Prefix:    token[:len(apiTokenPrefix)+4],
+		Scopes:    scopes,
+		CreatedAt: primitive.NewDateTimeFromTime(now),
+		ExpiresAt: expiresAt,
+	}
+	if _, err := tokensCollection.InsertOne(context.Background(), apiToken); err != nil {
+		log.Printf("Failed to store API token: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create token"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, APITokenResponse{
+		APIToken: apiToken,
+		Token:    token,
+	})
+}
+
+// ListAPITokens handles GET /api/user/tokens requests. Revoked tokens are left out.
+func ListAPITokens(c *gin.Context, db *mongo.Database) {
+	userID, err := primitive.ObjectIDFromHex(c.GetString("user_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	cursor, err := db.Collection("api_tokens").Find(context.Background(), bson.M{
+		"user_id":    userID,
+		"revoked_at": nil,
+	}, options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer cursor.Close(context.Background())
+
+	tokens := []models.APIToken{}
+	if err := cursor.All(context.Background(), &tokens); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decode tokens"})
+		return
+	}
+
+	c.JSON(http.StatusOK, tokens)
+}
+
+// RevokeAPIToken handles DELETE /api/user/tokens/:id requests
+func RevokeAPIToken(c *gin.Context, db *mongo.Database) {
+	userID, err := primitive.ObjectIDFromHex(c.GetString("user_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	tokenID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid token ID"})
+		return
+	}
+
+	result, err := db.Collection("api_tokens").UpdateOne(context.Background(), bson.M{
+		"_id":        tokenID,
+		"user_id":    userID,
+		"revoked_at": nil,
+	}, bson.M{"$set": bson.M{"revoked_at": primitive.NewDateTimeFromTime(time.Now())}})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke token"})
+		return
+	}
+	if result.MatchedCount == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Token not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Token revoked"})
+}
+
+// revokeAPITokens revokes every API token of a user. It returns how many were
+// revoked.
+func revokeAPITokens(db *mongo.Database, userID primitive.ObjectID) (int64, error) {
+	result, err := db.Collection("api_tokens").UpdateMany(context.Background(), bson.M{
+		"user_id":    userID,
+		"revoked_at": nil,
+	}, bson.M{"$set": bson.M{"revoked_at": primitive.NewDateTimeFromTime(time.Now())}})
+	if err != nil {
+		return 0, err
+	}
+	return result.ModifiedCount, nil
+}
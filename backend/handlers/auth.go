@@ -6,8 +6,6 @@ import (
 	"io"
 	"log"
 	"net/http"
-	"os"
-	"strconv"
 	"strings"
 	"time"
 	"voteverse/models"
@@ -34,21 +32,22 @@ type SignInRequest struct {
 }
 
 type AuthResponse struct {
-	Token string      `json:"token"`
-	User  models.User `json:"user"`
+	TokenResponse
+	User models.User `json:"user"`
 }
 
-func generateToken(userID primitive.ObjectID) (string, error) {
-	expiryHours, err := strconv.Atoi(os.Getenv("JWT_EXPIRY_HOURS"))
-	if err != nil {
-		expiryHours = 24 // default to 24 hours if not set
-	}
-
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+// generateToken issues a short-lived access token bound to a session
+func generateToken(userID, sessionID primitive.ObjectID) (string, error) {
+	now := time.Now()
+	return tokenKeys.Sign(jwt.MapClaims{
+		"iss":     TokenIssuer(),
+		"aud":     AccessTokenAudience,
+		"typ":     accessTokenType,
 		"user_id": userID.Hex(),
-		"exp":     time.Now().Add(time.Hour * time.Duration(expiryHours)).Unix(),
+		"sid":     sessionID.Hex(),
+		"iat":     now.Unix(),
+		"exp":     now.Add(accessTokenTTL()).Unix(),
 	})
-	return token.SignedString([]byte(os.Getenv("JWT_SECRET")))
 }
 
 func SignUp(c *gin.Context, db *mongo.Database) {
@@ -146,8 +145,13 @@ func SignUp(c *gin.Context, db *mongo.Database) {
 		return
 	}
 
-	// Generate JWT token
-	token, err := generateToken(user.ID)
+	// The account works right away; the verification policy limits what it can do
+	if err := sendVerificationEmail(c.Request.Context(), db, user); err != nil {
+		log.Printf("Failed to send verification email to user %s: %v", user.ID.Hex(), err)
+	}
+
+	// Start a session and generate its tokens
+	tokens, err := startSession(c, db, user)
 	if err != nil {
 		log.Printf("Token generation error: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
@@ -156,8 +160,8 @@ func SignUp(c *gin.Context, db *mongo.Database) {
 
 	user.Password = "" // Don't send password back
 	c.JSON(http.StatusCreated, AuthResponse{
-		Token: token,
-		User:  user,
+		TokenResponse: tokens,
+		User:          user,
 	})
 }
 
@@ -168,11 +172,19 @@ func SignIn(c *gin.Context, db *mongo.Database) {
 		return
 	}
 
+	// Slow down repeated failures before doing any password work
+	if signInThrottled(c, db, req.Email) {
+		recordSignInEvent(c, db, models.SignInEvent{Email: req.Email, Method: models.SignInMethodPassword, Reason: signInReasonThrottled})
+		return
+	}
+
 	// Find user by email
 	var user models.User
 	usersCollection := db.Collection("users")
-	err := usersCollection.FindOne(context.Background(), bson.M{"email": req.Email}).Decode(&user)
+	err := usersCollection.FindOne(context.Background(), bson.M{"email": req.Email, "deleted_at": nil}).Decode(&user)
 	if err == mongo.ErrNoDocuments {
+		recordSignInFailure(c, db, req.Email, nil)
+		recordSignInEvent(c, db, models.SignInEvent{Email: req.Email, Method: models.SignInMethodPassword, Reason: signInReasonUnknownEmail})
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid email or password"})
 		return
 	}
@@ -181,24 +193,61 @@ func SignIn(c *gin.Context, db *mongo.Database) {
 		return
 	}
 
+	if accountLocked(c, user) {
+		recordSignInEvent(c, db, models.SignInEvent{UserID: user.ID, Email: req.Email, Method: models.SignInMethodPassword, Reason: signInReasonLocked})
+		return
+	}
+
 	// Verify password
 	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password))
 	if err != nil {
+		recordSignInFailure(c, db, req.Email, &user)
+		recordSignInEvent(c, db, models.SignInEvent{UserID: user.ID, Email: req.Email, Method: models.SignInMethodPassword, Reason: signInReasonInvalidPassword})
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid email or password"})
 		return
 	}
 
-	// Generate JWT token
-	token, err := generateToken(user.ID)
+	// With 2FA the password alone does not reset the failure count, so codes
+	// cannot be guessed by signing in again between attempts
+	event := models.SignInEvent{UserID: user.ID, Email: req.Email, Method: models.SignInMethodPassword, Success: true}
+	if user.TwoFactorEnabled {
+		event.Reason = signInReasonTwoFactorRequired
+	} else {
+		clearSignInFailures(db, req.Email)
+	}
+	recordSignInEvent(c, db, event)
+
+	completeSignIn(c, db, user, http.StatusOK)
+}
+
+// completeSignIn finishes a successful first-factor sign-in. Users with 2FA get
+// a challenge token for /api/auth/2fa/verify; everyone else gets a session.
+func completeSignIn(c *gin.Context, db *mongo.Database, user models.User, status int) {
+	if user.TwoFactorEnabled {
+		challengeToken, err := generateChallengeToken(user.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"two_factor_required": true,
+			"challenge_token":     challengeToken,
+		})
+		return
+	}
+
+	// Start a session and generate its tokens
+	tokens, err := startSession(c, db, user)
 	if err != nil {
+		log.Printf("Token generation error: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
 		return
 	}
 
 	user.Password = "" // Don't send password back
-	c.JSON(http.StatusOK, AuthResponse{
-		Token: token,
-		User:  user,
+	c.JSON(status, AuthResponse{
+		TokenResponse: tokens,
+		User:          user,
 	})
 }
 
@@ -221,7 +270,7 @@ func GetProfile(c *gin.Context) {
 	// Get user from database
 	var user models.User
 	err = c.MustGet("db").(*mongo.Database).Collection("users").
-		FindOne(context.Background(), bson.M{"_id": userID}).
+		FindOne(context.Background(), bson.M{"_id": userID, "deleted_at": nil}).
 		Decode(&user)
 
 	if err != nil {
@@ -239,7 +288,7 @@ func GetProfile(c *gin.Context) {
 	c.JSON(http.StatusOK, user)
 }
 
-// AuthMiddleware verifies the JWT token and sets user_id in context
+// AuthMiddleware verifies the JWT token and its session and sets user_id in context
 func AuthMiddleware(db *mongo.Database) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
@@ -257,29 +306,40 @@ func AuthMiddleware(db *mongo.Database) gin.HandlerFunc {
 			return
 		}
 
-		token := tokenParts[1]
-		claims := jwt.MapClaims{}
-
-		// Parse and validate token
-		parsedToken, err := jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
-			return []byte(os.Getenv("JWT_SECRET")), nil
-		})
+		// Personal access tokens are opaque and limited by their scopes
+		if strings.HasPrefix(tokenParts[1], apiTokenPrefix) {
+			apiTokenMiddleware(c, db, tokenParts[1])
+			return
+		}
 
-		if err != nil || !parsedToken.Valid {
+		session, err := authenticateToken(db, tokenParts[1])
+		if err == errSessionRevoked {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Session has been revoked"})
+			c.Abort()
+			return
+		}
+		if err != nil {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
 			c.Abort()
 			return
 		}
 
-		// Set user_id in context
-		if userID, ok := claims["user_id"].(string); ok {
-			c.Set("user_id", userID)
-			c.Set("db", db)
-			c.Next()
-		} else {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token claims"})
+		// Admins who still have to enrol in 2FA can only reach the enrolment routes
+		if session.TwoFactorSetupRequired && !twoFactorSetupAllowed(c.FullPath()) {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error":                     "Two-factor authentication must be enabled for admin accounts",
+				"two_factor_setup_required": true,
+			})
 			c.Abort()
 			return
 		}
+
+		touchSession(db, session, c.ClientIP())
+
+		// Set user_id and session_id in context
+		c.Set("user_id", session.UserID.Hex())
+		c.Set("session_id", session.ID.Hex())
+		c.Set("db", db)
+		c.Next()
 	}
 }
@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"context"
+	"strings"
+	"voteverse/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Clients subscribe to channels. A group's channel carries the events of all
+// its polls, a poll's channel those of that poll only, and the public channel
+// announces newly created public polls.
+const (
+	PublicChannel = "public"
+
+	groupChannelPrefix = "group:"
+	pollChannelPrefix  = "poll:"
+)
+
+// GroupChannel is the channel of a group's events
+func GroupChannel(groupID string) string {
+	return groupChannelPrefix + groupID
+}
+
+// PollChannel is the channel of a poll's events
+func PollChannel(pollID string) string {
+	return pollChannelPrefix + pollID
+}
+
+// channelMessage builds a message about a channel, with the group or poll ID
+// filled in for clients that only look at those
+func channelMessage(messageType, channel string) Message {
+	message := Message{Type: messageType, Channel: channel}
+	switch {
+	case strings.HasPrefix(channel, groupChannelPrefix):
+		message.GroupID = strings.TrimPrefix(channel, groupChannelPrefix)
+	case strings.HasPrefix(channel, pollChannelPrefix):
+		message.PollID = strings.TrimPrefix(channel, pollChannelPrefix)
+	}
+	return message
+}
+
+// resumeChannel returns the channel a resume message is about. Clients name
+// it, or send the group_id or poll_id they subscribed with.
+func resumeChannel(msg Message) string {
+	switch {
+	case msg.Channel != "":
+		return msg.Channel
+	case msg.PollID != "":
+		return PollChannel(msg.PollID)
+	default:
+		return GroupChannel(msg.GroupID)
+	}
+}
+
+// canSubscribe checks whether a user may follow a channel. It also returns
+// the error shown to clients that may not.
+func canSubscribe(db *mongo.Database, channel string, userID primitive.ObjectID) (bool, string, error) {
+	switch {
+	case channel == PublicChannel:
+		return true, "", nil
+	case strings.HasPrefix(channel, groupChannelPrefix):
+		allowed, err := canSubscribeToGroup(db, strings.TrimPrefix(channel, groupChannelPrefix), userID)
+		return allowed, "You are not a member of this group", err
+	case strings.HasPrefix(channel, pollChannelPrefix):
+		allowed, err := canSubscribeToPoll(db, strings.TrimPrefix(channel, pollChannelPrefix), userID)
+		return allowed, "You cannot view this poll", err
+	default:
+		return false, "Unknown channel", nil
+	}
+}
+
+// canSubscribeToGroup checks that a group exists and that the user can see its
+// polls, which is what its WebSocket events are about
+func canSubscribeToGroup(db *mongo.Database, groupID string, userID primitive.ObjectID) (bool, error) {
+	groupObjID, err := primitive.ObjectIDFromHex(groupID)
+	if err != nil {
+		return false, nil
+	}
+
+	count, err := db.Collection("groups").CountDocuments(context.Background(), bson.M{
+		"_id":        groupObjID,
+		"deleted_at": nil,
+	})
+	if err != nil {
+		return false, err
+	}
+	if count == 0 {
+		return false, nil
+	}
+
+	return canViewGroupPolls(db, groupObjID, userID)
+}
+
+// canSubscribeToPoll checks that a poll exists and that the user can see it.
+// Public polls are open to every signed-in user.
+func canSubscribeToPoll(db *mongo.Database, pollID string, userID primitive.ObjectID) (bool, error) {
+	pollObjID, err := primitive.ObjectIDFromHex(pollID)
+	if err != nil {
+		return false, nil
+	}
+
+	var poll models.Poll
+	err = db.Collection("polls").FindOne(context.Background(), bson.M{
+		"_id":        pollObjID,
+		"deleted_at": nil,
+	}).Decode(&poll)
+	if err == mongo.ErrNoDocuments {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if poll.GroupID.IsZero() {
+		return true, nil
+	}
+	return canViewGroupPolls(db, poll.GroupID, userID)
+}
+
+// groupPollChannels returns the poll channels among channels whose polls
+// belong to a group, deleted polls included
+func groupPollChannels(db *mongo.Database, groupID primitive.ObjectID, channels []string) ([]string, error) {
+	var pollIDs []primitive.ObjectID
+	for _, channel := range channels {
+		if !strings.HasPrefix(channel, pollChannelPrefix) {
+			continue
+		}
+		if id, err := primitive.ObjectIDFromHex(strings.TrimPrefix(channel, pollChannelPrefix)); err == nil {
+			pollIDs = append(pollIDs, id)
+		}
+	}
+	if len(pollIDs) == 0 {
+		return nil, nil
+	}
+
+	ids, err := db.Collection("polls").Distinct(context.Background(), "_id", bson.M{
+		"_id":      bson.M{"$in": pollIDs},
+		"group_id": groupID,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if objID, ok := id.(primitive.ObjectID); ok {
+			result = append(result, PollChannel(objID.Hex()))
+		}
+	}
+	return result, nil
+}
+
+// optionalHex returns an ID's hex form, or "" for the nil ID public polls have
+// as their group
+func optionalHex(id primitive.ObjectID) string {
+	if id.IsZero() {
+		return ""
+	}
+	return id.Hex()
+}
@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"time"
 	"voteverse/models"
+	"voteverse/services"
 
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/bson"
@@ -35,47 +36,13 @@ func CreateComment(c *gin.Context) {
 	userID, _ := primitive.ObjectIDFromHex(userIDStr.(string))
 	db := c.MustGet("db").(*mongo.Database)
 
-	// Check if poll exists and is active
-	var poll models.Poll
-	err = db.Collection("polls").FindOne(context.Background(), bson.M{
-		"_id":       pollID,
-		"is_active": true,
-	}).Decode(&poll)
-	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Poll not found"})
-		return
-	}
-
-	// Check if user is a member of the group
-	count, err := db.Collection("group_members").CountDocuments(context.Background(), bson.M{
-		"group_id": poll.GroupID,
-		"user_id":  userID,
-	})
-	if err != nil || count == 0 {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Not a member of this group"})
-		return
-	}
-
-	// Create comment
-	now := primitive.NewDateTimeFromTime(time.Now())
-	comment := models.Comment{
-		ID:        primitive.NewObjectID(),
-		PollID:    pollID,
-		UserID:    userID,
-		Text:      req.Text,
-		CreatedAt: now,
-		UpdatedAt: now,
-	}
-
-	_, err = db.Collection("comments").InsertOne(context.Background(), comment)
+	comment, err := postComment(db, userID, pollID, req.Text)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create comment"})
+		status, message := participationError(err, "Failed to create comment")
+		c.JSON(status, gin.H{"error": message})
 		return
 	}
 
-	// Send WebSocket notification
-	NotifyCommentUpdate(poll.GroupID.Hex(), pollID.Hex(), "created")
-
 	c.JSON(http.StatusCreated, comment)
 }
 
@@ -94,20 +61,22 @@ func ListComments(c *gin.Context) {
 	// Check if poll exists and user has access
 	var poll models.Poll
 	err = db.Collection("polls").FindOne(context.Background(), bson.M{
-		"_id":       pollID,
-		"is_active": true,
+		"_id":        pollID,
+		"is_active":  true,
+		"deleted_at": nil,
 	}).Decode(&poll)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Poll not found"})
 		return
 	}
 
-	// Check if user is a member of the group
-	count, err := db.Collection("group_members").CountDocuments(context.Background(), bson.M{
-		"group_id": poll.GroupID,
-		"user_id":  userID,
-	})
-	if err != nil || count == 0 {
+	// Comments are visible to everyone who can see the poll
+	canView, err := canViewGroupPolls(db, poll.GroupID, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check membership"})
+		return
+	}
+	if !canView {
 		c.JSON(http.StatusForbidden, gin.H{"error": "Not a member of this group"})
 		return
 	}
@@ -116,7 +85,8 @@ func ListComments(c *gin.Context) {
 	pipeline := []bson.M{
 		{
 			"$match": bson.M{
-				"poll_id": pollID,
+				"poll_id":    pollID,
+				"deleted_at": nil,
 			},
 		},
 		{
@@ -128,7 +98,11 @@ func ListComments(c *gin.Context) {
 			},
 		},
 		{
-			"$unwind": "$user",
+			// Comments of deleted accounts have no author
+			"$unwind": bson.M{
+				"path":                       "$user",
+				"preserveNullAndEmptyArrays": true,
+			},
 		},
 		{
 			"$project": bson.M{
@@ -162,6 +136,12 @@ func ListComments(c *gin.Context) {
 		return
 	}
 
+	for _, comment := range comments {
+		if _, ok := comment["user"]; !ok {
+			comment["user"] = bson.M{"username": deletedUsername}
+		}
+	}
+
 	c.JSON(http.StatusOK, comments)
 }
 
@@ -180,7 +160,8 @@ func DeleteComment(c *gin.Context) {
 	// Get comment and poll details
 	var comment models.Comment
 	err = db.Collection("comments").FindOne(context.Background(), bson.M{
-		"_id": commentID,
+		"_id":        commentID,
+		"deleted_at": nil,
 	}).Decode(&comment)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Comment not found"})
@@ -203,17 +184,24 @@ func DeleteComment(c *gin.Context) {
 		return
 	}
 
-	// Delete comment
-	_, err = db.Collection("comments").DeleteOne(context.Background(), bson.M{
-		"_id": commentID,
+	// Soft-delete comment
+	_, err = services.NewCascadeService(db).DeleteComment(context.Background(), commentID, services.DeleteOptions{
+		Mode:      services.SoftDelete,
+		DeletedBy: userID,
 	})
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete comment"})
 		return
 	}
 
-	// Send WebSocket notification
-	NotifyCommentUpdate(poll.GroupID.Hex(), poll.ID.Hex(), "deleted")
+	// Record the event and send WebSocket notification
+	publishEvent(db, models.GroupEvent{
+		GroupID:   poll.GroupID,
+		Type:      models.EventCommentDeleted,
+		ActorID:   userID,
+		PollID:    poll.ID,
+		CommentID: commentID,
+	})
 
 	c.JSON(http.StatusOK, gin.H{"message": "Comment deleted successfully"})
 }
@@ -230,7 +218,7 @@ func CommentHealthCheck(c *gin.Context) {
 // AdminCountComments provides the total number of comments in the system
 func AdminCountComments(c *gin.Context) {
 	db := c.MustGet("db").(*mongo.Database)
-	count, err := db.Collection("comments").CountDocuments(context.Background(), bson.M{})
+	count, err := db.Collection("comments").CountDocuments(context.Background(), bson.M{"deleted_at": nil})
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count comments"})
 		return
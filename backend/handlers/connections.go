@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	defaultMaxConnectionsPerUser = 10
+	defaultMaxConnectionsPerIP   = 100
+
+	slowConsumerCloseReason = "connection too slow"
+)
+
+// Reasons a streaming connection is refused
+var (
+	errHubClosing             = errors.New("server is restarting")
+	errTooManyUserConnections = errors.New("too many open connections for this user")
+	errTooManyIPConnections   = errors.New("too many open connections from this address")
+)
+
+// streamStats counts what happened to the hub's connections since it started
+type streamStats struct {
+	slowConsumerDisconnects atomic.Uint64
+	rejectedPerUser         atomic.Uint64
+	rejectedPerIP           atomic.Uint64
+}
+
+// StreamMetrics describes a hub's WebSocket and event stream connections and
+// how far behind they are
+type StreamMetrics struct {
+	Connections             int    `json:"connections"`
+	WebSockets              int    `json:"websockets"`
+	EventStreams            int    `json:"event_streams"`
+	Channels                int    `json:"channels"`
+	QueuedMessages          int    `json:"queued_messages"`           // Waiting in all send buffers
+	MaxQueueDepth           int    `json:"max_queue_depth"`           // Waiting for the client furthest behind
+	QueueCapacity           int    `json:"queue_capacity"`            // How far a client may fall behind before it is disconnected
+	SlowConsumerDisconnects uint64 `json:"slow_consumer_disconnects"` // Clients disconnected for falling behind
+	RejectedPerUser         uint64 `json:"rejected_per_user"`         // Connections refused by MAX_CONNECTIONS_PER_USER
+	RejectedPerIP           uint64 `json:"rejected_per_ip"`           // Connections refused by MAX_CONNECTIONS_PER_IP
+}
+
+// connectionLimit reads a per-user or per-IP connection limit. Zero or less
+// means no limit.
+func connectionLimit(name string, defaultLimit int) int {
+	limit, err := strconv.Atoi(os.Getenv(name))
+	if err != nil {
+		return defaultLimit
+	}
+	return limit
+}
+
+// checkAdmission reports why a client cannot be added, if it cannot. The
+// caller holds mu.
+func (h *Hub) checkAdmission(client *Client) error {
+	if h.closing {
+		return errHubClosing
+	}
+	if limit := connectionLimit("MAX_CONNECTIONS_PER_USER", defaultMaxConnectionsPerUser); limit > 0 && h.connsByUser[client.userID] >= limit {
+		h.stats.rejectedPerUser.Add(1)
+		return errTooManyUserConnections
+	}
+	if limit := connectionLimit("MAX_CONNECTIONS_PER_IP", defaultMaxConnectionsPerIP); limit > 0 && h.connsByIP[client.ip] >= limit {
+		h.stats.rejectedPerIP.Add(1)
+		return errTooManyIPConnections
+	}
+	return nil
+}
+
+// admit checks whether a client could be added, without adding it
+func (h *Hub) admit(client *Client) error {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.checkAdmission(client)
+}
+
+// release gives back an unregistered client's connection slots. The caller holds mu.
+func (h *Hub) release(client *Client) {
+	if h.connsByUser[client.userID]--; h.connsByUser[client.userID] <= 0 {
+		delete(h.connsByUser, client.userID)
+	}
+	if h.connsByIP[client.ip]--; h.connsByIP[client.ip] <= 0 {
+		delete(h.connsByIP, client.ip)
+	}
+}
+
+// refuse answers a streaming connection the hub will not take
+func (h *Hub) refuse(c *gin.Context, err error) {
+	if err == errHubClosing {
+		c.Header("Retry-After", strconv.Itoa(int(reconnectDelay().Round(time.Second)/time.Second)))
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Server is restarting"})
+		return
+	}
+	log.Printf("Refused streaming connection from %s: %v", c.ClientIP(), err)
+	c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many open connections"})
+}
+
+// offer queues data for a client without blocking. A client whose buffer is
+// full cannot keep up, so it is disconnected instead of being sent an
+// incomplete stream; it can resume after reconnecting. Its send channel is
+// left to run to close. The caller holds mu, at least for reading.
+func (h *Hub) offer(client *Client, data []byte) {
+	select {
+	case client.send <- data:
+	default:
+		client.evict()
+	}
+}
+
+// evict disconnects a client that fell too far behind
+func (c *Client) evict() {
+	c.evictOnce.Do(func() {
+		c.hub.stats.slowConsumerDisconnects.Add(1)
+		log.Printf("Disconnecting user %s: %d messages waiting to be sent", c.userID.Hex(), len(c.send))
+		// Closing a WebSocket may block on writing the close frame, and the
+		// caller holds the hub lock
+		go c.closeConn(websocket.CloseTryAgainLater, slowConsumerCloseReason)
+	})
+}
+
+// Metrics reports the hub's connections, their queues and the connections
+// refused or dropped so far
+func (h *Hub) Metrics() StreamMetrics {
+	metrics := StreamMetrics{
+		QueueCapacity:           sendBufferSize,
+		SlowConsumerDisconnects: h.stats.slowConsumerDisconnects.Load(),
+		RejectedPerUser:         h.stats.rejectedPerUser.Load(),
+		RejectedPerIP:           h.stats.rejectedPerIP.Load(),
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	metrics.Connections = len(h.clients)
+	metrics.Channels = len(h.channels)
+	for client := range h.clients {
+		if client.conn != nil {
+			metrics.WebSockets++
+		} else {
+			metrics.EventStreams++
+		}
+		depth := len(client.send)
+		metrics.QueuedMessages += depth
+		if depth > metrics.MaxQueueDepth {
+			metrics.MaxQueueDepth = depth
+		}
+	}
+	return metrics
+}
+
+// AdminGetStreamMetrics handles GET /api/admin/streams requests. The numbers
+// are for the instance answering the request.
+func AdminGetStreamMetrics(c *gin.Context, db *mongo.Database) {
+	if _, ok := requireAdmin(c, db); !ok {
+		return
+	}
+	c.JSON(http.StatusOK, hub.Metrics())
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 	"log"
 	"net/http"
+	"net/url"
+	"strings"
 	"time"
 	"voteverse/models"
 
@@ -18,6 +20,7 @@ import (
 type CreateGroupRequest struct {
 	Name        string `json:"name" binding:"required"`
 	Description string `json:"description" binding:"required"`
+	ParentID    string `json:"parent_id,omitempty"` // Creates a subgroup; requires admin rights on the parent
 }
 
 // CreateGroup handles the creation of a new group
@@ -60,6 +63,10 @@ func CreateGroup(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
 		return
 	}
+
+	if !requireVerifiedEmail(c, db, userID) {
+		return
+	}
 	
 	// Check if a group with the same name already exists
 	existingGroup := db.Collection("groups").FindOne(context.Background(), bson.M{
@@ -71,12 +78,51 @@ func CreateGroup(c *gin.Context) {
 		return
 	}
 
+	// Resolve the parent group for subgroups
+	var parentID primitive.ObjectID
+	var ancestors []primitive.ObjectID
+	if req.ParentID != "" {
+		parentID, err = primitive.ObjectIDFromHex(req.ParentID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid parent group ID"})
+			return
+		}
+
+		var parent models.Group
+		err = db.Collection("groups").FindOne(context.Background(), bson.M{
+			"_id":        parentID,
+			"is_active":  true,
+			"deleted_at": nil,
+		}).Decode(&parent)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Parent group not found"})
+			return
+		}
+
+		canManage, err := canManageGroup(db, parent, userID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check membership"})
+			return
+		}
+		if !canManage {
+			if _, isAdmin := IsAdmin(userID, db); !isAdmin {
+				c.JSON(http.StatusForbidden, gin.H{"error": "Only admins of the parent group can create subgroups"})
+				return
+			}
+		}
+
+		ancestors = append(parent.Ancestors, parent.ID)
+	}
+
 	// Create new group
 	now := primitive.NewDateTimeFromTime(time.Now())
 	group := models.Group{
 		ID:          primitive.NewObjectID(),
 		Name:        req.Name,
 		Description: req.Description,
+		ParentID:    parentID,
+		Ancestors:   ancestors,
+		Settings:    defaultGroupSettings(),
 		CreatedBy:   userID,
 		CreatedAt:   now,
 		UpdatedAt:   now,
@@ -117,7 +163,8 @@ func CreateGroup(c *gin.Context) {
 	c.JSON(http.StatusCreated, group)
 }
 
-// ListGroups returns a list of groups the user is a member of
+// ListGroups returns a list of groups the user is a member of, plus the subgroups
+// they manage. With ?tree=true the groups are nested under their parents.
 func ListGroups(c *gin.Context) {
 	userIDStr, exists := c.Get("user_id")
 	if !exists {
@@ -180,7 +227,8 @@ func ListGroups(c *gin.Context) {
 	var groups []models.Group
 	if len(groupIDs) > 0 {
 		cursor, err = db.Collection("groups").Find(context.Background(), bson.M{
-			"_id": bson.M{"$in": groupIDs},
+			"_id":        bson.M{"$in": groupIDs},
+			"deleted_at": nil,
 		})
 		if err != nil {
 			log.Printf("Failed to fetch group details: %v", err)
@@ -202,7 +250,23 @@ func ListGroups(c *gin.Context) {
 		}
 	}
 
+	// Include subgroups the user manages as an admin of a parent group
+	subgroups, err := managedSubgroups(db, userID)
+	if err != nil {
+		log.Printf("Failed to fetch managed subgroups: %v", err)
+	}
+	for _, subgroup := range subgroups {
+		if !membershipMap[subgroup.ID] {
+			membershipMap[subgroup.ID] = true
+			groups = append(groups, subgroup)
+		}
+	}
+
 	log.Printf("Successfully fetched %d groups for user %s", len(groups), userID.Hex())
+	if c.Query("tree") == "true" {
+		c.JSON(http.StatusOK, buildGroupTree(groups))
+		return
+	}
 	c.JSON(http.StatusOK, groups)
 }
 
@@ -221,8 +285,9 @@ func JoinGroup(c *gin.Context) {
 	// Check if group exists
 	var group models.Group
 	err = db.Collection("groups").FindOne(context.Background(), bson.M{
-		"_id":       groupID,
-		"is_active": true,
+		"_id":        groupID,
+		"is_active":  true,
+		"deleted_at": nil,
 	}).Decode(&group)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Group not found"})
@@ -262,6 +327,12 @@ func JoinGroup(c *gin.Context) {
 		return
 	}
 
+	publishEvent(db, models.GroupEvent{
+		GroupID: groupID,
+		Type:    models.EventMemberJoined,
+		ActorID: userID,
+	})
+
 	// Return the updated group with is_member set to true
 	group.IsMember = true
 	log.Printf("User %s successfully joined group %s (%s), setting IsMember=true", userID.Hex(), group.Name, group.ID.Hex())
@@ -296,7 +367,8 @@ func SearchGroups(c *gin.Context) {
 			{"name": bson.M{"$regex": query, "$options": "i"}},
 			{"description": bson.M{"$regex": query, "$options": "i"}},
 		},
-		"is_active": true,
+		"is_active":  true,
+		"deleted_at": nil,
 	}
 
 	opts := options.Find().SetLimit(20) // Limit results to 20 groups
@@ -357,7 +429,8 @@ func GetGroup(c *gin.Context) {
 	// Get the group
 	var group models.Group
 	err = db.Collection("groups").FindOne(context.Background(), bson.M{
-		"_id": groupID,
+		"_id":        groupID,
+		"deleted_at": nil,
 	}).Decode(&group)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
@@ -448,8 +521,205 @@ func LeaveGroup(c *gin.Context) {
 		return
 	}
 
+	publishEvent(db, models.GroupEvent{
+		GroupID: groupID,
+		Type:    models.EventMemberLeft,
+		ActorID: userID,
+	})
+
+	// Stop sending the group's events to the user's open connections
+	hub.RecheckUser(db, userID)
+
 	log.Printf("User %s successfully left group %s (%s)", userID.Hex(), group.Name, group.ID.Hex())
 	c.JSON(http.StatusOK, gin.H{"message": "Successfully left group"})
 }
 
+// UpdateGroupRequest represents the request body for updating a group.
+// Only the fields present in the request are changed.
+type UpdateGroupRequest struct {
+	Name        *string                     `json:"name"`
+	Description *string                     `json:"description"`
+	AvatarURL   *string                     `json:"avatar_url"`
+	Settings    *UpdateGroupSettingsRequest `json:"settings"`
+}
+
+// UpdateGroupSettingsRequest represents a partial update of a group's settings
+type UpdateGroupSettingsRequest struct {
+	PollCreation             *string `json:"poll_creation" binding:"omitempty,oneof=members admins"`
+	DefaultPollDurationHours *int    `json:"default_poll_duration_hours" binding:"omitempty,min=1,max=8760"`
+	DefaultResultsVisibility *string `json:"default_results_visibility" binding:"omitempty,oneof=always after_vote after_close"`
+	MembersCanComment        *bool   `json:"members_can_comment"`
+	SharePollsWithSubgroups  *bool   `json:"share_polls_with_subgroups"`
+}
+
+// UpdateGroup handles PATCH /api/groups/:id requests
+func UpdateGroup(c *gin.Context) {
+	groupID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid group ID"})
+		return
+	}
+
+	var req UpdateGroupRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		log.Printf("Invalid group update request: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userIDStr, _ := c.Get("user_id")
+	userID, _ := primitive.ObjectIDFromHex(userIDStr.(string))
+	db := c.MustGet("db").(*mongo.Database)
+
+	var existing models.Group
+	err = db.Collection("groups").FindOne(context.Background(), bson.M{"_id": groupID, "deleted_at": nil}).Decode(&existing)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Group not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch group"})
+		}
+		return
+	}
+
+	// Only group admins, parent group admins and site admins may edit a group
+	canEdit, err := canManageGroup(db, existing, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check membership"})
+		return
+	}
+	if !canEdit {
+		if _, isAdmin := IsAdmin(userID, db); !isAdmin {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Only group admins can update this group"})
+			return
+		}
+	}
+
+	set := bson.M{}
+	if req.Name != nil {
+		name := strings.TrimSpace(*req.Name)
+		if name == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Group name cannot be empty"})
+			return
+		}
+
+		// Check if another group already uses this name
+		existingGroup := db.Collection("groups").FindOne(context.Background(), bson.M{
+			"name": name,
+			"_id":  bson.M{"$ne": groupID},
+		})
+		if existingGroup.Err() == nil {
+			c.JSON(http.StatusConflict, gin.H{"error": "Group with this name already exists"})
+			return
+		}
+		set["name"] = name
+	}
+	if req.Description != nil {
+		set["description"] = *req.Description
+	}
+	if req.AvatarURL != nil {
+		if *req.AvatarURL != "" && !isHTTPURL(*req.AvatarURL) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Avatar URL must be an http or https URL"})
+			return
+		}
+		set["avatar_url"] = *req.AvatarURL
+	}
+	if req.Settings != nil {
+		if req.Settings.PollCreation != nil {
+			set["settings.poll_creation"] = *req.Settings.PollCreation
+		}
+		if req.Settings.DefaultPollDurationHours != nil {
+			set["settings.default_poll_duration_hours"] = *req.Settings.DefaultPollDurationHours
+		}
+		if req.Settings.DefaultResultsVisibility != nil {
+			set["settings.default_results_visibility"] = *req.Settings.DefaultResultsVisibility
+		}
+		if req.Settings.MembersCanComment != nil {
+			set["settings.members_can_comment"] = *req.Settings.MembersCanComment
+		}
+		if req.Settings.SharePollsWithSubgroups != nil {
+			set["settings.share_polls_with_subgroups"] = *req.Settings.SharePollsWithSubgroups
+		}
+	}
+
+	if len(set) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No fields to update"})
+		return
+	}
+	set["updated_at"] = primitive.NewDateTimeFromTime(time.Now())
+
+	var group models.Group
+	err = db.Collection("groups").FindOneAndUpdate(
+		context.Background(),
+		bson.M{"_id": groupID},
+		bson.M{"$set": set},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&group)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Group not found"})
+		} else if mongo.IsDuplicateKeyError(err) {
+			// The unique name index caught a concurrent rename
+			c.JSON(http.StatusConflict, gin.H{"error": "Group with this name already exists"})
+		} else {
+			log.Printf("Failed to update group: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update group"})
+		}
+		return
+	}
+
+	count, err := db.Collection("group_members").CountDocuments(context.Background(), bson.M{
+		"group_id": groupID,
+		"user_id":  userID,
+	})
+	group.IsMember = err == nil && count > 0
+
+	// Subgroup members may have lost access to the group's polls
+	if req.Settings != nil && req.Settings.SharePollsWithSubgroups != nil && !*req.Settings.SharePollsWithSubgroups {
+		hub.RecheckGroup(db, groupID.Hex())
+	}
+
+	log.Printf("User %s updated group %s (%s)", userID.Hex(), group.Name, group.ID.Hex())
+	c.JSON(http.StatusOK, group)
+}
+
+// defaultGroupSettings returns the settings given to newly created groups
+func defaultGroupSettings() models.GroupSettings {
+	membersCanComment := true
+	return models.GroupSettings{
+		PollCreation:             models.PollCreationMembers,
+		DefaultPollDurationHours: 24,
+		DefaultResultsVisibility: models.ResultsVisibilityAlways,
+		MembersCanComment:        &membersCanComment,
+	}
+}
+
+// effectiveGroupSettings fills in defaults for any setting that was never stored,
+// e.g. on groups created before settings existed
+func effectiveGroupSettings(settings models.GroupSettings) models.GroupSettings {
+	defaults := defaultGroupSettings()
+	if settings.PollCreation == "" {
+		settings.PollCreation = defaults.PollCreation
+	}
+	if settings.DefaultPollDurationHours <= 0 {
+		settings.DefaultPollDurationHours = defaults.DefaultPollDurationHours
+	}
+	if settings.DefaultResultsVisibility == "" {
+		settings.DefaultResultsVisibility = defaults.DefaultResultsVisibility
+	}
+	if settings.MembersCanComment == nil {
+		settings.MembersCanComment = defaults.MembersCanComment
+	}
+	return settings
+}
+
+// isHTTPURL checks that a string is an absolute http or https URL
+func isHTTPURL(raw string) bool {
+	u, err := url.ParseRequestURI(raw)
+	if err != nil {
+		return false
+	}
+	return (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
 // The AdminListAllGroups function has been moved to admin.go
@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"voteverse/broker"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// controlChannel carries instructions between the hubs sharing a broker.
+// Clients cannot follow it, since canSubscribe does not know it.
+const controlChannel = "hub:control"
+
+// Instructions hubs send each other
+const (
+	controlDisconnectSessions = "disconnect_sessions"
+	controlDisconnectUser     = "disconnect_user"
+	controlRecheckGroup       = "recheck_group"
+	controlRecheckPoll        = "recheck_poll"
+	controlRecheckUser        = "recheck_user"
+)
+
+// hubControl asks the other hubs to disconnect or recheck their clients, so
+// that revoking a session or removing a member on one server instance reaches
+// the connections held by every other instance
+type hubControl struct {
+	Origin     string   `json:"origin"` // The hub that sent it, which has already acted on it
+	Action     string   `json:"action"`
+	SessionIDs []string `json:"session_ids,omitempty"`
+	UserID     string   `json:"user_id,omitempty"`
+	GroupID    string   `json:"group_id,omitempty"`
+	PollID     string   `json:"poll_id,omitempty"`
+}
+
+// publishControl sends an instruction to the other hubs sharing the broker
+func (h *Hub) publishControl(control hubControl) {
+	control.Origin = h.id
+	payload, err := json.Marshal(control)
+	if err != nil {
+		log.Printf("error marshaling hub control %s: %v", control.Action, err)
+		return
+	}
+
+	h.mu.RLock()
+	b := h.broker
+	h.mu.RUnlock()
+
+	if err := b.Publish(context.Background(), broker.Event{Channel: controlChannel, Payload: payload}); err != nil {
+		log.Printf("Failed to publish hub control %s: %v", control.Action, err)
+	}
+}
+
+// control carries out an instruction from another hub. Brokers call it
+// synchronously, and rechecks query the database, so it runs on its own.
+func (h *Hub) control(payload []byte) {
+	var control hubControl
+	if err := json.Unmarshal(payload, &control); err != nil {
+		log.Printf("error unmarshaling hub control: %v", err)
+		return
+	}
+	if control.Origin == h.id {
+		return
+	}
+
+	go func() {
+		switch control.Action {
+		case controlDisconnectSessions:
+			h.disconnectSessions(control.SessionIDs)
+		case controlDisconnectUser:
+			if userID, err := primitive.ObjectIDFromHex(control.UserID); err == nil {
+				h.disconnectUser(userID)
+			}
+		case controlRecheckGroup:
+			if db := h.clientDB(); db != nil {
+				h.recheckGroup(db, control.GroupID)
+			}
+		case controlRecheckPoll:
+			if db := h.clientDB(); db != nil {
+				h.recheckChannel(db, PollChannel(control.PollID))
+			}
+		case controlRecheckUser:
+			if userID, err := primitive.ObjectIDFromHex(control.UserID); err == nil {
+				if db := h.clientDB(); db != nil {
+					h.recheckUser(db, userID)
+				}
+			}
+		default:
+			log.Printf("Unknown hub control %q", control.Action)
+		}
+	}()
+}
+
+// clientDB returns the database the hub's clients were opened with, or nil
+// without clients, in which case there is nothing to recheck
+func (h *Hub) clientDB() *mongo.Database {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for client := range h.clients {
+		return client.db
+	}
+	return nil
+}
@@ -0,0 +1,346 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"voteverse/mailer"
+	"voteverse/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	accountFreeAttempts     = 3  // Failed sign-ins per account before backoff starts
+	ipFreeAttempts          = 20 // Higher, since many users can share an address
+	signInBackoffBase       = time.Second
+	signInBackoffMax        = 15 * time.Minute
+	signInFailureWindow     = time.Hour // Counters start over after an hour without failures
+	defaultLockoutThreshold = 10
+	defaultLockoutMinutes   = 15
+	defaultSignInEventLimit = 100
+	maxSignInEventLimit     = 500
+)
+
+// Reasons recorded with sign-in events
+const (
+	signInReasonUnknownEmail      = "unknown_email"
+	signInReasonInvalidPassword   = "invalid_password"
+	signInReasonInvalidCode       = "invalid_code"
+	signInReasonThrottled         = "throttled"
+	signInReasonLocked            = "locked"
+	signInReasonTwoFactorRequired = "two_factor_required"
+	signInReasonProviderRejected  = "provider_rejected"
+)
+
+// lockoutThreshold reads SIGNIN_LOCKOUT_THRESHOLD (default 10 failures)
+func lockoutThreshold() int {
+	threshold, err := strconv.Atoi(os.Getenv("SIGNIN_LOCKOUT_THRESHOLD"))
+	if err != nil || threshold <= 0 {
+		threshold = defaultLockoutThreshold
+	}
+	return threshold
+}
+
+// lockoutDuration reads SIGNIN_LOCKOUT_MINUTES (default 15 minutes)
+func lockoutDuration() time.Duration {
+	minutes, err := strconv.Atoi(os.Getenv("SIGNIN_LOCKOUT_MINUTES"))
+	if err != nil || minutes <= 0 {
+		minutes = defaultLockoutMinutes
+	}
+	return time.Duration(minutes) * time.Minute
+}
+
+func accountThrottleKey(email string) string {
+	return "account:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func ipThrottleKey(ip string) string {
+	return "ip:" + ip
+}
+
+// signInBackoff returns how long to wait before the next attempt after the
+// given number of failures
+func signInBackoff(failures, freeAttempts int) time.Duration {
+	if failures < freeAttempts {
+		return 0
+	}
+	backoff := signInBackoffBase
+	for i := freeAttempts; i < failures && backoff < signInBackoffMax; i++ {
+		backoff *= 2
+	}
+	if backoff > signInBackoffMax {
+		backoff = signInBackoffMax
+	}
+	return backoff
+}
+
+// signInRetryAfter returns how long the client has to wait before it may try
+// to sign in again for an account from an address
+func signInRetryAfter(db *mongo.Database, email, ip string) (time.Duration, error) {
+	now := time.Now()
+	cursor, err := db.Collection("signin_throttles").Find(context.Background(), bson.M{
+		"key":           bson.M{"$in": bson.A{accountThrottleKey(email), ipThrottleKey(ip)}},
+		"blocked_until": bson.M{"$gt": primitive.NewDateTimeFromTime(now)},
+	})
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(context.Background())
+
+	var throttles []models.SignInThrottle
+	if err := cursor.All(context.Background(), &throttles); err != nil {
+		return 0, err
+	}
+
+	var wait time.Duration
+	for _, throttle := range throttles {
+		if remaining := throttle.BlockedUntil.Time().Sub(now); remaining > wait {
+			wait = remaining
+		}
+	}
+	return wait, nil
+}
+
+// incrementThrottle counts a failure for a key and blocks it for the backoff.
+// It returns the number of failures in the current window.
+func incrementThrottle(db *mongo.Database, key string, freeAttempts int) (int, error) {
+	now := time.Now()
+	throttles := db.Collection("signin_throttles")
+
+	// Failures from before the window no longer count
+	_, err := throttles.UpdateOne(context.Background(), bson.M{
+		"key":             key,
+		"last_failure_at": bson.M{"$lt": primitive.NewDateTimeFromTime(now.Add(-signInFailureWindow))},
+	}, bson.M{"$set": bson.M{"failures": 0}})
+	if err != nil {
+		return 0, err
+	}
+
+	var throttle models.SignInThrottle
+	err = throttles.FindOneAndUpdate(context.Background(), bson.M{"key": key}, bson.M{
+		"$inc": bson.M{"failures": 1},
+		"$set": bson.M{
+			"last_failure_at": primitive.NewDateTimeFromTime(now),
+			"expires_at":      primitive.NewDateTimeFromTime(now.Add(signInFailureWindow)),
+		},
+	}, options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)).Decode(&throttle)
+	if err != nil {
+		return 0, err
+	}
+
+	if backoff := signInBackoff(throttle.Failures, freeAttempts); backoff > 0 {
+		_, err = throttles.UpdateOne(context.Background(), bson.M{"_id": throttle.ID}, bson.M{
+			"$set": bson.M{"blocked_until": primitive.NewDateTimeFromTime(now.Add(backoff))},
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+	return throttle.Failures, nil
+}
+
+// recordSignInFailure counts a failed sign-in against the account and the
+// address, and locks the account once it reaches the lockout threshold
+func recordSignInFailure(c *gin.Context, db *mongo.Database, email string, user *models.User) {
+	ip := c.ClientIP()
+	if _, err := incrementThrottle(db, ipThrottleKey(ip), ipFreeAttempts); err != nil {
+		log.Printf("Failed to record failed sign-in from %s: %v", ip, err)
+	}
+
+	failures, err := incrementThrottle(db, accountThrottleKey(email), accountFreeAttempts)
+	if err != nil {
+		log.Printf("Failed to record failed sign-in for %s: %v", email, err)
+		return
+	}
+	if user != nil && failures >= lockoutThreshold() {
+		lockAccount(c, db, *user, failures)
+	}
+}
+
+// clearSignInFailures resets the failure count of an account
+func clearSignInFailures(db *mongo.Database, email string) {
+	_, err := db.Collection("signin_throttles").DeleteOne(context.Background(), bson.M{"key": accountThrottleKey(email)})
+	if err != nil {
+		log.Printf("Failed to reset failed sign-ins for %s: %v", email, err)
+	}
+}
+
+// lockAccount locks an account for the lockout duration and tells its owner
+func lockAccount(c *gin.Context, db *mongo.Database, user models.User, failures int) {
+	now := time.Now()
+	lockedUntil := now.Add(lockoutDuration())
+
+	// Only the request that actually locks the account sends the email
+	result, err := db.Collection("users").UpdateOne(context.Background(), bson.M{
+		"_id":          user.ID,
+		"locked_until": bson.M{"$not": bson.M{"$gt": primitive.NewDateTimeFromTime(now)}},
+	}, bson.M{"$set": bson.M{"locked_until": primitive.NewDateTimeFromTime(lockedUntil)}})
+	if err != nil {
+		log.Printf("Failed to lock user %s: %v", user.ID.Hex(), err)
+		return
+	}
+	if result.ModifiedCount == 0 {
+		return
+	}
+
+	// The next lockout needs a full set of new failures
+	clearSignInFailures(db, user.Email)
+	log.Printf("Locked user %s until %s after %d failed sign-ins", user.ID.Hex(), lockedUntil.Format(time.RFC3339), failures)
+
+	err = appMailer.Send(c.Request.Context(), mailer.Message{
+		To:      user.Email,
+		Subject: "Your VoteVerse account has been locked",
+		Body: fmt.Sprintf("Hi %s,\n\nAfter %d failed sign-in attempts, the last one from %s, your account is locked for %d minutes.\n\nIf this was not you, someone may be trying to guess your password. Consider resetting it once the lock ends.\n",
+			user.Username, failures, c.ClientIP(), int(lockoutDuration().Minutes())),
+	})
+	if err != nil {
+		log.Printf("Failed to send lockout email to user %s: %v", user.ID.Hex(), err)
+	}
+}
+
+// accountLocked reports whether a user is locked out. It writes the error
+// response if so, answering like signInThrottled so a lock does not reveal
+// that the email has an account.
+func accountLocked(c *gin.Context, user models.User) bool {
+	if user.LockedUntil == nil || !user.LockedUntil.Time().After(time.Now()) {
+		return false
+	}
+	c.Header("Retry-After", retryAfterSeconds(time.Until(user.LockedUntil.Time())))
+	c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many failed sign-in attempts. Please try again later"})
+	return true
+}
+
+// signInThrottled checks the backoff for an account and address. It writes the
+// error response and returns true if the client has to wait.
+func signInThrottled(c *gin.Context, db *mongo.Database, email string) bool {
+	wait, err := signInRetryAfter(db, email, c.ClientIP())
+	if err != nil {
+		// Failing closed would let a database hiccup lock everyone out
+		log.Printf("Failed to check sign-in backoff: %v", err)
+		return false
+	}
+	if wait <= 0 {
+		return false
+	}
+	c.Header("Retry-After", retryAfterSeconds(wait))
+	c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many failed sign-in attempts. Please try again later"})
+	return true
+}
+
+func retryAfterSeconds(wait time.Duration) string {
+	return strconv.Itoa(int(wait.Seconds()) + 1)
+}
+
+// recordSignInEvent stores a sign-in attempt for review
+func recordSignInEvent(c *gin.Context, db *mongo.Database, event models.SignInEvent) {
+	event.ID = primitive.NewObjectID()
+	event.Email = strings.ToLower(strings.TrimSpace(event.Email))
+	event.IPAddress = c.ClientIP()
+	event.UserAgent = c.Request.UserAgent()
+	event.CreatedAt = primitive.NewDateTimeFromTime(time.Now())
+
+	if _, err := db.Collection("signin_events").InsertOne(context.Background(), event); err != nil {
+		log.Printf("Failed to record sign-in event: %v", err)
+	}
+}
+
+// AdminUnlockUser handles POST /api/admin/users/:id/unlock requests
+func AdminUnlockUser(c *gin.Context, db *mongo.Database) {
+	adminID, ok := requireAdmin(c, db)
+	if !ok {
+		return
+	}
+
+	targetUserID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid target user ID"})
+		return
+	}
+
+	var user models.User
+	err = db.Collection("users").FindOneAndUpdate(context.Background(), bson.M{
+		"_id":        targetUserID,
+		"deleted_at": nil,
+	}, bson.M{"$unset": bson.M{"locked_until": ""}}).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unlock user"})
+		return
+	}
+
+	clearSignInFailures(db, user.Email)
+
+	log.Printf("Admin %s unlocked user %s", adminID.Hex(), targetUserID.Hex())
+	c.JSON(http.StatusOK, gin.H{"message": "User unlocked successfully"})
+}
+
+// AdminListSignInEvents handles GET /api/admin/signin-events requests. Results
+// can be filtered by user_id, email and success, newest first.
+func AdminListSignInEvents(c *gin.Context, db *mongo.Database) {
+	if _, ok := requireAdmin(c, db); !ok {
+		return
+	}
+
+	filter := bson.M{}
+	if userIDStr := c.Query("user_id"); userIDStr != "" {
+		userID, err := primitive.ObjectIDFromHex(userIDStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+			return
+		}
+		filter["user_id"] = userID
+	}
+	if email := c.Query("email"); email != "" {
+		filter["email"] = strings.ToLower(strings.TrimSpace(email))
+	}
+	if success := c.Query("success"); success != "" {
+		value, err := strconv.ParseBool(success)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "success must be true or false"})
+			return
+		}
+		filter["success"] = value
+	}
+
+	limit := defaultSignInEventLimit
+	if limitStr := c.Query("limit"); limitStr != "" {
+		value, err := strconv.Atoi(limitStr)
+		if err != nil || value <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+			return
+		}
+		if value > maxSignInEventLimit {
+			value = maxSignInEventLimit
+		}
+		limit = value
+	}
+
+	cursor, err := db.Collection("signin_events").Find(context.Background(), filter,
+		options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}}).SetLimit(int64(limit)))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer cursor.Close(context.Background())
+
+	events := []models.SignInEvent{}
+	if err := cursor.All(context.Background(), &events); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decode sign-in events"})
+		return
+	}
+
+	c.JSON(http.StatusOK, events)
+}
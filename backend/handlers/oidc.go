@@ -0,0 +1,305 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+	"voteverse/models"
+	"voteverse/oidc"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const oidcStateTTL = 10 * time.Minute
+
+// oidcBrowserCookie ties a sign-on state to the browser that started it, so a
+// code and state from someone else's flow cannot sign this browser in.
+const oidcBrowserCookie = "oidc_browser"
+
+// oidcProvider is the single sign-on provider. It stays nil unless main
+// configures one from the environment.
+var oidcProvider *oidc.Provider
+
+// SetOIDCProvider sets the provider used for single sign-on
+func SetOIDCProvider(p *oidc.Provider) {
+	oidcProvider = p
+}
+
+var (
+	errEmailNotVerified = errors.New("email not verified by identity provider")
+	errNoEmailClaim     = errors.New("ID token has no email claim")
+)
+
+type OIDCCallbackRequest struct {
+	Code  string `json:"code" binding:"required"`
+	State string `json:"state" binding:"required"`
+}
+
+// OIDCLogin handles GET /api/auth/oidc/login requests. It returns the URL the
+// frontend should redirect to; the provider sends the user back with a code
+// and state for OIDCCallback.
+func OIDCLogin(c *gin.Context, db *mongo.Database) {
+	if oidcProvider == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Single sign-on is not configured"})
+		return
+	}
+
+	state, stateHash, err := newOpaqueToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start sign-on"})
+		return
+	}
+	nonce, err := oidc.RandomString()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start sign-on"})
+		return
+	}
+	verifier, err := oidc.RandomString()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start sign-on"})
+		return
+	}
+	browser, browserHash, err := newOpaqueToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start sign-on"})
+		return
+	}
+
+	now := time.Now()
+	_, err = db.Collection("oidc_states").InsertOne(context.Background(), models.OIDCState{
+		ID:          primitive.NewObjectID(),
+		StateHash:   stateHash,
+		BrowserHash: browserHash,
+		Nonce:       nonce,
+		Verifier:    verifier,
+		CreatedAt:   primitive.NewDateTimeFromTime(now),
+		ExpiresAt:   primitive.NewDateTimeFromTime(now.Add(oidcStateTTL)),
+	})
+	if err != nil {
+		log.Printf("Failed to store OIDC state: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	setOIDCBrowserCookie(c, browser, int(oidcStateTTL.Seconds()))
+	c.JSON(http.StatusOK, gin.H{"authorization_url": oidcProvider.AuthCodeURL(state, nonce, verifier)})
+}
+
+// setOIDCBrowserCookie sets or, with a negative maxAge, clears the cookie that
+// binds a sign-on state to this browser
+func setOIDCBrowserCookie(c *gin.Context, value string, maxAge int) {
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcBrowserCookie, value, maxAge, "/api/auth/oidc", "", c.Request.TLS != nil, true)
+}
+
+// OIDCCallback handles POST /api/auth/oidc/callback requests. It exchanges the
+// code, validates the ID token and signs in the matching user, linking or
+// creating the account on first use.
+func OIDCCallback(c *gin.Context, db *mongo.Database) {
+	if oidcProvider == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Single sign-on is not configured"})
+		return
+	}
+
+	var req OIDCCallbackRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// The state must come back to the browser that started the sign-on
+	browser, err := c.Cookie(oidcBrowserCookie)
+	if err != nil || browser == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired sign-on state"})
+		return
+	}
+	setOIDCBrowserCookie(c, "", -1)
+
+	// Each state can only be used once
+	var state models.OIDCState
+	err = db.Collection("oidc_states").FindOneAndDelete(context.Background(), bson.M{
+		"state_hash":   hashToken(req.State),
+		"browser_hash": hashToken(browser),
+		"expires_at":   bson.M{"$gt": primitive.NewDateTimeFromTime(time.Now())},
+	}).Decode(&state)
+	if err == mongo.ErrNoDocuments {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired sign-on state"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	tokens, err := oidcProvider.Exchange(c.Request.Context(), req.Code, state.Verifier)
+	if err != nil {
+		log.Printf("OIDC code exchange failed: %v", err)
+		recordSignInEvent(c, db, models.SignInEvent{Method: models.SignInMethodOIDC, Reason: signInReasonProviderRejected})
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Sign-on failed"})
+		return
+	}
+
+	claims, err := oidcProvider.VerifyIDToken(c.Request.Context(), tokens.IDToken, state.Nonce)
+	if err != nil {
+		log.Printf("OIDC ID token rejected: %v", err)
+		recordSignInEvent(c, db, models.SignInEvent{Method: models.SignInMethodOIDC, Reason: signInReasonProviderRejected})
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Sign-on failed"})
+		return
+	}
+
+	user, created, err := findOrProvisionOIDCUser(db, oidcProvider.Issuer(), claims)
+	if err == errEmailNotVerified {
+		c.JSON(http.StatusConflict, gin.H{"error": "An account with this email already exists. Sign in with your password, or verify your email with the identity provider first"})
+		return
+	}
+	if err == errNoEmailClaim {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "The identity provider did not share an email address"})
+		return
+	}
+	if err != nil {
+		log.Printf("OIDC user provisioning failed for %s: %v", claims.Subject, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to sign in"})
+		return
+	}
+
+	recordSignInEvent(c, db, models.SignInEvent{UserID: user.ID, Email: user.Email, Method: models.SignInMethodOIDC, Success: true})
+
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	completeSignIn(c, db, user, status)
+}
+
+// findOrProvisionOIDCUser returns the user for an external identity. An
+// unknown identity is linked to the account with the same email if the
+// provider verified that email, otherwise a new account is created.
+func findOrProvisionOIDCUser(db *mongo.Database, issuer string, claims *oidc.Claims) (models.User, bool, error) {
+	ctx := context.Background()
+	usersCollection := db.Collection("users")
+
+	var user models.User
+	err := usersCollection.FindOne(ctx, bson.M{
+		"external_identities": bson.M{"$elemMatch": bson.M{"issuer": issuer, "subject": claims.Subject}},
+		"deleted_at":          nil,
+	}).Decode(&user)
+	if err == nil {
+		return user, false, nil
+	}
+	if err != mongo.ErrNoDocuments {
+		return user, false, err
+	}
+
+	email := strings.TrimSpace(claims.Email)
+	if email == "" {
+		return user, false, errNoEmailClaim
+	}
+
+	now := primitive.NewDateTimeFromTime(time.Now())
+	identity := models.ExternalIdentity{
+		Issuer:   issuer,
+		Subject:  claims.Subject,
+		Email:    email,
+		LinkedAt: now,
+	}
+
+	err = usersCollection.FindOne(ctx, bson.M{"email": email, "deleted_at": nil}).Decode(&user)
+	if err == nil {
+		// Linking by an unverified email would let anyone who controls the
+		// provider account take over the VoteVerse account
+		if !claims.EmailVerified {
+			return user, false, errEmailNotVerified
+		}
+
+		err = usersCollection.FindOneAndUpdate(ctx, bson.M{"_id": user.ID}, bson.M{
+			"$push": bson.M{"external_identities": identity},
+			"$set": bson.M{
+				"email_verified": true,
+				"updated_at":     now,
+			},
+		}, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&user)
+		if err != nil {
+			return user, false, err
+		}
+		log.Printf("Linked %s identity %s to user %s", issuer, claims.Subject, user.ID.Hex())
+		return user, false, nil
+	}
+	if err != mongo.ErrNoDocuments {
+		return user, false, err
+	}
+
+	// Just-in-time provisioning. The account has a random password, so it can
+	// only sign in through the provider until the user resets it.
+	password, err := oidc.RandomString()
+	if err != nil {
+		return user, false, err
+	}
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcryptCost)
+	if err != nil {
+		return user, false, err
+	}
+
+	username, err := availableUsername(db, claims.PreferredUsername, email)
+	if err != nil {
+		return user, false, err
+	}
+
+	user = models.User{
+		ID:                 primitive.NewObjectID(),
+		Username:           username,
+		Email:              email,
+		Password:           string(hashedPassword),
+		Role:               models.RoleUser,
+		EmailVerified:      bool(claims.EmailVerified),
+		ExternalIdentities: []models.ExternalIdentity{identity},
+		CreatedAt:          now,
+		UpdatedAt:          now,
+	}
+	if _, err := usersCollection.InsertOne(ctx, user); err != nil {
+		return user, false, err
+	}
+	log.Printf("Provisioned user %s for %s identity %s", user.ID.Hex(), issuer, claims.Subject)
+	return user, true, nil
+}
+
+var usernameDisallowed = regexp.MustCompile(`[^a-zA-Z0-9_.-]+`)
+
+// availableUsername derives an unused username from the provider's preferred
+// username, falling back to the local part of the email
+func availableUsername(db *mongo.Database, preferred, email string) (string, error) {
+	base := usernameDisallowed.ReplaceAllString(preferred, "")
+	if len(base) < 3 {
+		base = usernameDisallowed.ReplaceAllString(strings.SplitN(email, "@", 2)[0], "")
+	}
+	if len(base) < 3 {
+		base = "user"
+	}
+
+	candidate := base
+	for i := 2; i < 100; i++ {
+		count, err := db.Collection("users").CountDocuments(context.Background(), bson.M{"username": candidate})
+		if err != nil {
+			return "", err
+		}
+		if count == 0 {
+			return candidate, nil
+		}
+		candidate = fmt.Sprintf("%s%d", base, i)
+	}
+
+	suffix, err := oidc.RandomString()
+	if err != nil {
+		return "", err
+	}
+	return base + "-" + strings.ToLower(suffix[:8]), nil
+}
@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"voteverse/models"
+	"voteverse/services"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Replies to cast_vote and post_comment; refusals are sent as error messages
+const messageAck = "ack"
+
+// PollWithUserVote is a poll as returned after voting
+type PollWithUserVote struct {
+	models.Poll
+	UserVote string `json:"user_vote"`
+}
+
+// participation returns the service votes and comments go through, whether
+// they come in over REST or the WebSocket
+func participation(db *mongo.Database) *services.ParticipationService {
+	return services.NewParticipationService(db, services.ParticipationPolicy{
+		RequireVerifiedEmail: emailVerificationRequired(),
+		GroupSettings:        effectiveGroupSettings,
+		CanViewGroup: func(groupID, userID primitive.ObjectID) (bool, error) {
+			return canViewGroupPolls(db, groupID, userID)
+		},
+		CanManageGroup: func(group models.Group, userID primitive.ObjectID) (bool, error) {
+			return canManageGroup(db, group, userID)
+		},
+	})
+}
+
+// participationError returns the status and message for an error from the
+// participation service, with fallback as the message for unexpected errors
+func participationError(err error, fallback string) (int, string) {
+	switch {
+	case errors.Is(err, services.ErrPollNotFound):
+		return http.StatusNotFound, "Poll not found or not active"
+	case errors.Is(err, services.ErrPollEnded):
+		return http.StatusBadRequest, "Poll has ended"
+	case errors.Is(err, services.ErrInvalidOption):
+		return http.StatusBadRequest, "Invalid option for this poll"
+	case errors.Is(err, services.ErrEmptyComment):
+		return http.StatusBadRequest, "Comment text is required"
+	case errors.Is(err, services.ErrEmailNotVerified):
+		return http.StatusForbidden, "Please verify your email address first"
+	case errors.Is(err, services.ErrNotGroupMember):
+		return http.StatusForbidden, "Not a member of this group"
+	case errors.Is(err, services.ErrCommentsRestricted):
+		return http.StatusForbidden, "Comments are restricted to group admins"
+	default:
+		return http.StatusInternalServerError, fallback
+	}
+}
+
+// castVote votes for a user, publishes the vote and returns the poll as the
+// user may see it
+func castVote(db *mongo.Database, userID, pollID, optionID primitive.ObjectID) (PollWithUserVote, error) {
+	poll, err := participation(db).CastVote(context.Background(), userID, pollID, optionID)
+	if err != nil {
+		return PollWithUserVote{}, err
+	}
+
+	pollWithVote := PollWithUserVote{
+		Poll:     poll,
+		UserVote: optionID.Hex(),
+	}
+	if resultsHidden(poll, userID, true) {
+		hidePollResults(&pollWithVote.Poll)
+	}
+
+	// Record the event and send WebSocket notification. Votes are anonymous in
+	// the feed, which only shows the running total of polls with visible results.
+	event := models.GroupEvent{
+		GroupID: poll.GroupID,
+		Type:    models.EventVoteCast,
+		PollID:  pollID,
+	}
+	if !resultsHidden(poll, primitive.NilObjectID, false) {
+		event.VoteCount = totalVotes(poll)
+	}
+	publishEvent(db, event)
+	return pollWithVote, nil
+}
+
+// postComment comments for a user and publishes the comment
+func postComment(db *mongo.Database, userID, pollID primitive.ObjectID, text string) (models.Comment, error) {
+	comment, poll, err := participation(db).PostComment(context.Background(), userID, pollID, text)
+	if err != nil {
+		return models.Comment{}, err
+	}
+
+	// Record the event and send WebSocket notification
+	publishEvent(db, models.GroupEvent{
+		GroupID:   poll.GroupID,
+		Type:      models.EventCommentPosted,
+		ActorID:   userID,
+		PollID:    pollID,
+		CommentID: comment.ID,
+	})
+	return comment, nil
+}
+
+// handleCastVote answers a cast_vote message with the poll or an error
+func (c *Client) handleCastVote(msg Message) {
+	pollID, err := primitive.ObjectIDFromHex(msg.PollID)
+	if err != nil {
+		c.replyError(msg.RequestID, "Invalid poll ID")
+		return
+	}
+	optionID, err := primitive.ObjectIDFromHex(msg.OptionID)
+	if err != nil {
+		c.replyError(msg.RequestID, "Invalid option ID")
+		return
+	}
+
+	poll, err := castVote(c.db, c.userID, pollID, optionID)
+	if err != nil {
+		status, message := participationError(err, "Failed to process vote")
+		if status == http.StatusInternalServerError {
+			log.Printf("Failed to process WebSocket vote of user %s: %v", c.userID.Hex(), err)
+		}
+		c.replyError(msg.RequestID, message)
+		return
+	}
+	c.reply(Message{Type: messageAck, RequestID: msg.RequestID, Data: poll})
+}
+
+// handlePostComment answers a post_comment message with the comment or an error
+func (c *Client) handlePostComment(msg Message) {
+	pollID, err := primitive.ObjectIDFromHex(msg.PollID)
+	if err != nil {
+		c.replyError(msg.RequestID, "Invalid poll ID")
+		return
+	}
+
+	comment, err := postComment(c.db, c.userID, pollID, msg.Text)
+	if err != nil {
+		status, message := participationError(err, "Failed to create comment")
+		if status == http.StatusInternalServerError {
+			log.Printf("Failed to create WebSocket comment of user %s: %v", c.userID.Hex(), err)
+		}
+		c.replyError(msg.RequestID, message)
+		return
+	}
+	c.reply(Message{Type: messageAck, RequestID: msg.RequestID, Data: comment})
+}
+
+// replyError refuses a client request
+func (c *Client) replyError(requestID, message string) {
+	c.reply(Message{Type: messageError, RequestID: requestID, Error: message})
+}
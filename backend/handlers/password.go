@@ -0,0 +1,291 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"voteverse/mailer"
+	"voteverse/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	defaultPasswordResetMinutes = 60
+
+	// Reset emails can be asked for once a minute and five times an hour per
+	// email. An address gets more and no cooldown, since many users can share it.
+	passwordResetCooldown         = time.Minute
+	maxPasswordResetsPerHour      = 5
+	maxPasswordResetsPerHourPerIP = 20
+)
+
+// appMailer sends account emails. main replaces it with the transport from the environment.
+var appMailer mailer.Mailer = mailer.NewLogMailer("no-reply@voteverse.com")
+
+// SetMailer sets the mailer used for account emails
+func SetMailer(m mailer.Mailer) {
+	appMailer = m
+}
+
+// Mailer returns the mailer used for account emails
+func Mailer() mailer.Mailer {
+	return appMailer
+}
+
+type PasswordResetRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+type PasswordResetConfirmRequest struct {
+	Token    string `json:"token" binding:"required"`
+	Password string `json:"password" binding:"required,min=6"`
+}
+
+// passwordResetTTL reads PASSWORD_RESET_EXPIRY_MINUTES (default 60 minutes)
+func passwordResetTTL() time.Duration {
+	minutes, err := strconv.Atoi(os.Getenv("PASSWORD_RESET_EXPIRY_MINUTES"))
+	if err != nil || minutes <= 0 {
+		minutes = defaultPasswordResetMinutes
+	}
+	return time.Duration(minutes) * time.Minute
+}
+
+// appURL builds a link into the frontend from APP_BASE_URL
+func appURL(path string, query url.Values) string {
+	base := os.Getenv("APP_BASE_URL")
+	if base == "" {
+		base = "http://localhost:3000"
+	}
+	return strings.TrimRight(base, "/") + path + "?" + query.Encode()
+}
+
+// RequestPasswordReset handles POST /api/auth/password-reset/request requests.
+// The response is the same whether or not the email belongs to an account.
+func RequestPasswordReset(c *gin.Context, db *mongo.Database) {
+	var req PasswordResetRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Requests are limited by email whether or not it has an account, so a
+	// 429 does not reveal registered emails either
+	keys := []string{resetEmailKey(req.Email), ipThrottleKey(c.ClientIP())}
+	wait, err := passwordResetWait(db, keys[0], keys[1])
+	if err != nil {
+		log.Printf("Failed to check password reset requests: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if wait > 0 {
+		c.Header("Retry-After", retryAfterSeconds(wait))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many password reset requests. Please try again later"})
+		return
+	}
+
+	now := time.Now()
+	requests := make([]interface{}, len(keys))
+	for i, key := range keys {
+		requests[i] = models.ResetRequest{
+			ID:        primitive.NewObjectID(),
+			Key:       key,
+			CreatedAt: primitive.NewDateTimeFromTime(now),
+			ExpiresAt: primitive.NewDateTimeFromTime(now.Add(time.Hour)),
+		}
+	}
+	if _, err := db.Collection("password_reset_requests").InsertMany(context.Background(), requests); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	// Looking up the account and sending the email happen after the response,
+	// so its timing does not reveal whether the email is registered
+	go sendPasswordReset(db, appMailer, req.Email)
+
+	c.JSON(http.StatusOK, gin.H{"message": "If an account exists for this email, a password reset link has been sent"})
+}
+
+// resetEmailKey is the rate limit key of an email for password reset requests
+func resetEmailKey(email string) string {
+	return "email:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+// passwordResetWait returns how long a client has to wait before it may ask
+// for another reset email, for an email and for its address
+func passwordResetWait(db *mongo.Database, emailKey, ipKey string) (time.Duration, error) {
+	now := time.Now()
+	var wait time.Duration
+	for _, limit := range []struct {
+		key      string
+		cooldown time.Duration
+		perHour  int
+	}{
+		{emailKey, passwordResetCooldown, maxPasswordResetsPerHour},
+		{ipKey, 0, maxPasswordResetsPerHourPerIP},
+	} {
+		findOptions := options.Find().
+			SetSort(bson.D{{Key: "created_at", Value: -1}}).
+			SetLimit(int64(limit.perHour))
+		cursor, err := db.Collection("password_reset_requests").Find(context.Background(), bson.M{
+			"key":        limit.key,
+			"created_at": bson.M{"$gt": primitive.NewDateTimeFromTime(now.Add(-time.Hour))},
+		}, findOptions)
+		if err != nil {
+			return 0, err
+		}
+
+		var requests []models.ResetRequest
+		err = cursor.All(context.Background(), &requests)
+		cursor.Close(context.Background())
+		if err != nil {
+			return 0, err
+		}
+
+		requestedAt := make([]time.Time, len(requests))
+		for i, request := range requests {
+			requestedAt[i] = request.CreatedAt.Time()
+		}
+		if keyWait := emailWait(requestedAt, now, limit.cooldown, limit.perHour); keyWait > wait {
+			wait = keyWait
+		}
+	}
+	return wait, nil
+}
+
+// sendPasswordReset emails a new reset link if the email belongs to an
+// account. Earlier links stop working. It runs after the response has been
+// sent, so it only logs failures; the mailer is passed in for the same reason.
+func sendPasswordReset(db *mongo.Database, m mailer.Mailer, email string) {
+	ctx := context.Background()
+
+	var user models.User
+	err := db.Collection("users").FindOne(ctx, bson.M{
+		"email":      email,
+		"deleted_at": nil,
+	}).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to look up user for password reset: %v", err)
+		return
+	}
+
+	token, tokenHash, err := newOpaqueToken()
+	if err != nil {
+		log.Printf("Reset token generation error: %v", err)
+		return
+	}
+
+	now := time.Now()
+	resets := db.Collection("password_resets")
+
+	// Only the most recent link works
+	_, err = resets.UpdateMany(ctx, bson.M{
+		"user_id": user.ID,
+		"used_at": nil,
+	}, bson.M{"$set": bson.M{"used_at": primitive.NewDateTimeFromTime(now)}})
+	if err != nil {
+		log.Printf("Failed to cancel earlier reset tokens of user %s: %v", user.ID.Hex(), err)
+		return
+	}
+
+	_, err = resets.InsertOne(ctx, models.PasswordReset{
+		ID:        primitive.NewObjectID(),
+		UserID:    user.ID,
+		TokenHash: tokenHash,
+		CreatedAt: primitive.NewDateTimeFromTime(now),
+		ExpiresAt: primitive.NewDateTimeFromTime(now.Add(passwordResetTTL())),
+	})
+	if err != nil {
+		log.Printf("Failed to store reset token: %v", err)
+		return
+	}
+
+	err = m.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your VoteVerse password",
+		Body: fmt.Sprintf("Hi %s,\n\nUse the link below to choose a new password. It expires in %d minutes.\n\n%s\n\nIf you did not ask to reset your password, you can ignore this email.\n",
+			user.Username, int(passwordResetTTL().Minutes()), appURL("/reset-password", url.Values{"token": {token}})),
+	})
+	if err != nil {
+		log.Printf("Failed to send password reset email to user %s: %v", user.ID.Hex(), err)
+	}
+}
+
+// ConfirmPasswordReset handles POST /api/auth/password-reset/confirm requests.
+// The token can only be used once, and every session and API token of the user
+// is revoked.
+func ConfirmPasswordReset(c *gin.Context, db *mongo.Database) {
+	var req PasswordResetConfirmRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	now := primitive.NewDateTimeFromTime(time.Now())
+	var reset models.PasswordReset
+	err := db.Collection("password_resets").FindOneAndUpdate(context.Background(), bson.M{
+		"token_hash": hashToken(req.Token),
+		"used_at":    nil,
+		"expires_at": bson.M{"$gt": now},
+	}, bson.M{"$set": bson.M{"used_at": now}}).Decode(&reset)
+	if err == mongo.ErrNoDocuments {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired reset token"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcryptCost)
+	if err != nil {
+		log.Printf("Password hashing error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
+		return
+	}
+
+	// A new password also ends a lockout
+	var user models.User
+	err = db.Collection("users").FindOneAndUpdate(context.Background(), bson.M{
+		"_id":        reset.UserID,
+		"deleted_at": nil,
+	}, bson.M{
+		"$set": bson.M{
+			"password":   string(hashedPassword),
+			"updated_at": now,
+		},
+		"$unset": bson.M{"locked_until": ""},
+	}).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired reset token"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update password"})
+		return
+	}
+	clearSignInFailures(db, user.Email)
+
+	if _, err := revokeSessions(db, bson.M{"user_id": reset.UserID}); err != nil {
+		log.Printf("Failed to revoke sessions of user %s after password reset: %v", reset.UserID.Hex(), err)
+	}
+	if _, err := revokeAPITokens(db, reset.UserID); err != nil {
+		log.Printf("Failed to revoke API tokens of user %s after password reset: %v", reset.UserID.Hex(), err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Password reset successfully"})
+}
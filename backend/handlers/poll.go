@@ -24,6 +24,8 @@ type CreatePollRequest struct {
 	StartTime   time.Time    `json:"start_time"`
 	EndTime     time.Time    `json:"end_time"`
 	Visibility  string       `json:"visibility" binding:"required,oneof=public group"`
+	// Optional; defaults to the group's settings for group polls
+	ResultsVisibility string `json:"results_visibility" binding:"omitempty,oneof=always after_vote after_close"`
 }
 
 type PollOption struct {
@@ -46,6 +48,9 @@ func CreatePoll(c *gin.Context) {
 	userID, _ := primitive.ObjectIDFromHex(userIDStr.(string))
 	db := c.MustGet("db").(*mongo.Database)
 
+	// Public polls use the built-in defaults; group polls use the group's settings
+	settings := effectiveGroupSettings(models.GroupSettings{})
+
 	// Check group membership if it's a group poll
 	var groupID primitive.ObjectID
 	if req.Visibility == "group" {
@@ -63,14 +68,45 @@ func CreatePoll(c *gin.Context) {
 			return
 		}
 
+		var group models.Group
+		err = db.Collection("groups").FindOne(context.Background(), bson.M{
+			"_id":        groupID,
+			"is_active":  true,
+			"deleted_at": nil,
+		}).Decode(&group)
+		if err != nil {
+			log.Printf("Group %s not found: %v", groupID.Hex(), err)
+			c.JSON(http.StatusNotFound, gin.H{"error": "Group not found"})
+			return
+		}
+		settings = effectiveGroupSettings(group.Settings)
+
+		// Admins of the group or of a parent group may always create polls
+		canManage, err := canManageGroup(db, group, userID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check membership"})
+			return
+		}
+
 		// Check if user is a member of the group
-		count, err := db.Collection("group_members").CountDocuments(context.Background(), bson.M{
-			"group_id": groupID,
-			"user_id":  userID,
-		})
-		if err != nil || count == 0 {
-			log.Printf("User %s is not a member of group %s", userID.Hex(), groupID.Hex())
-			c.JSON(http.StatusForbidden, gin.H{"error": "Not a member of this group"})
+		if !canManage {
+			count, err := db.Collection("group_members").CountDocuments(context.Background(), bson.M{
+				"group_id": groupID,
+				"user_id":  userID,
+			})
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check membership"})
+				return
+			}
+			if count == 0 {
+				log.Printf("User %s is not a member of group %s", userID.Hex(), groupID.Hex())
+				c.JSON(http.StatusForbidden, gin.H{"error": "Not a member of this group"})
+				return
+			}
+		}
+
+		if settings.PollCreation == models.PollCreationAdmins && !canManage {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Only group admins can create polls in this group"})
 			return
 		}
 	}
@@ -95,28 +131,34 @@ func CreatePoll(c *gin.Context) {
 		startTime = time.Now()
 	}
 
-	// Set default end time to 24 hours from start time if not provided
+	// Set default end time from the default poll duration if not provided
 	endTime := req.EndTime
 	if endTime.IsZero() {
-		endTime = startTime.Add(24 * time.Hour)
+		endTime = startTime.Add(time.Duration(settings.DefaultPollDurationHours) * time.Hour)
+	}
+
+	resultsVisibility := req.ResultsVisibility
+	if resultsVisibility == "" {
+		resultsVisibility = settings.DefaultResultsVisibility
 	}
 
 	log.Printf("Poll timing: Start=%v, End=%v", startTime, endTime)
 
 	// Create poll
 	poll := models.Poll{
-		ID:          primitive.NewObjectID(),
-		GroupID:     groupID,
-		CreatedBy:   userID,
-		Title:       req.Title,
-		Description: req.Description,
-		Options:     pollOptions,
-		StartTime:   primitive.NewDateTimeFromTime(startTime),
-		EndTime:     primitive.NewDateTimeFromTime(endTime),
-		CreatedAt:   now,
-		UpdatedAt:   now,
-		IsActive:    true,
-		Visibility:  req.Visibility,
+		ID:                primitive.NewObjectID(),
+		GroupID:           groupID,
+		CreatedBy:         userID,
+		Title:             req.Title,
+		Description:       req.Description,
+		Options:           pollOptions,
+		StartTime:         primitive.NewDateTimeFromTime(startTime),
+		EndTime:           primitive.NewDateTimeFromTime(endTime),
+		CreatedAt:         now,
+		UpdatedAt:         now,
+		IsActive:          true,
+		Visibility:        req.Visibility,
+		ResultsVisibility: resultsVisibility,
 	}
 
 	_, err := db.Collection("polls").InsertOne(context.Background(), poll)
@@ -126,10 +168,13 @@ func CreatePoll(c *gin.Context) {
 		return
 	}
 
-	// Send WebSocket notification for group polls
-	if req.Visibility == "group" {
-		NotifyPollUpdate(req.GroupID, poll.ID.Hex(), "created")
-	}
+	// Record the event and send WebSocket notification. Public polls go to the public feed.
+	publishEvent(db, models.GroupEvent{
+		GroupID: poll.GroupID,
+		Type:    models.EventPollCreated,
+		ActorID: userID,
+		PollID:  poll.ID,
+	})
 
 	c.JSON(http.StatusCreated, poll)
 }
@@ -176,21 +221,6 @@ func ListPolls(c *gin.Context) {
 		limit = limit64
 	}
 	
-	// Update expired polls
-	now := time.Now()
-	nowTime := primitive.NewDateTimeFromTime(now)
-	_, err = db.Collection("polls").UpdateMany(
-		context.Background(),
-		bson.M{
-			"is_active": true,
-			"end_time": bson.M{"$lt": nowTime},
-		},
-		bson.M{"$set": bson.M{"is_active": false}},
-	)
-	if err != nil {
-		log.Printf("Error updating expired polls: %v", err)
-	}
-	
 	// Build find options
 	findOptions := options.Find()
 	if limit > 0 {
@@ -215,47 +245,28 @@ func ListPolls(c *gin.Context) {
 			return
 		}
 
-		// Check if user is a member of the group
-		count, err := db.Collection("group_members").CountDocuments(context.Background(), bson.M{
-			"group_id": groupID,
-			"user_id":  userID,
-		})
+		// Check if user is a member of the group or of a subgroup it shares polls with
+		canView, err := canViewGroupPolls(db, groupID, userID)
 		if err != nil {
 			log.Printf("Error checking group membership: %v", err)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check group membership"})
 			return
 		}
-		if count == 0 {
+		if !canView {
 			log.Printf("User %s is not a member of group %s", userID, groupID)
 			c.JSON(http.StatusForbidden, gin.H{"error": "Not a member of this group"})
 			return
 		}
 
-		filter = bson.M{"group_id": groupID}
+		filter = bson.M{"group_id": groupID, "deleted_at": nil}
 	} else {
-		// Get user's group memberships
-		cursor, err := db.Collection("group_members").Find(context.Background(), bson.M{
-			"user_id": userID,
-		})
+		// Get the groups whose polls the user can see, including shared parent groups
+		groupIDs, err := visiblePollGroupIDs(db, userID)
 		if err != nil {
 			log.Printf("Error fetching group memberships: %v", err)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch group memberships"})
 			return
 		}
-		defer cursor.Close(context.Background())
-
-		var memberships []models.GroupMember
-		if err = cursor.All(context.Background(), &memberships); err != nil {
-			log.Printf("Error decoding group memberships: %v", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decode group memberships"})
-			return
-		}
-
-		// Get group IDs
-		var groupIDs []primitive.ObjectID
-		for _, membership := range memberships {
-			groupIDs = append(groupIDs, membership.GroupID)
-		}
 
 		// Build query for polls
 		filter = bson.M{
@@ -268,6 +279,7 @@ func ListPolls(c *gin.Context) {
 					},
 				},
 			},
+			"deleted_at": nil,
 		}
 	}
 
@@ -324,10 +336,14 @@ func ListPolls(c *gin.Context) {
 		}
 		
 		// Add user's vote if exists
-		if optionID, ok := userVotes[poll.ID]; ok {
+		optionID, hasVoted := userVotes[poll.ID]
+		if hasVoted {
 			pollWithVote.UserVote = optionID.Hex()
 			log.Printf("Adding user vote to poll %s: %s", poll.ID.Hex(), optionID.Hex())
 		}
+		if resultsHidden(poll, userID, hasVoted) {
+			hidePollResults(&pollWithVote.Poll)
+		}
 		
 		pollsWithVotes[i] = pollWithVote
 	}
@@ -370,162 +386,14 @@ func Vote(c *gin.Context) {
 	userID, _ := primitive.ObjectIDFromHex(userIDStr.(string))
 	db := c.MustGet("db").(*mongo.Database)
 
-	// Check if poll exists and is active
-	var poll models.Poll
-	err = db.Collection("polls").FindOne(context.Background(), bson.M{
-		"_id":       pollID,
-		"is_active": true,
-	}).Decode(&poll)
-	if err != nil {
-		log.Printf("Poll not found or not active: %v", err)
-		c.JSON(http.StatusNotFound, gin.H{"error": "Poll not found or not active"})
-		return
-	}
-
-	// Check if poll has ended
-	now := time.Now()
-	endTime := poll.EndTime.Time()
-	if now.After(endTime) {
-		log.Printf("Poll has ended. End time: %v, Current time: %v", endTime, now)
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Poll has ended"})
-		return
-	}
-
-	log.Printf("Poll found: %s, Options count: %d", poll.Title, len(poll.Options))
-
-	// Check if option belongs to poll
-	validOption := false
-	for _, opt := range poll.Options {
-		log.Printf("Checking option: %s (ID: %s) against requested option ID: %s", opt.Text, opt.ID.Hex(), optionID.Hex())
-		if opt.ID.Hex() == optionID.Hex() {
-			validOption = true
-			break
-		}
-	}
-	if !validOption {
-		log.Printf("Invalid option for poll: %s", optionID.Hex())
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid option for this poll"})
-		return
-	}
-
-	// Start a session for the transaction
-	session, err := db.Client().StartSession()
-	if err != nil {
-		log.Printf("Failed to start transaction: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction"})
-		return
-	}
-	defer session.EndSession(context.Background())
-
-	// Execute the transaction
-	_, err = session.WithTransaction(context.Background(), func(ctx mongo.SessionContext) (interface{}, error) {
-		// Check if user has already voted
-		var existingVote models.Vote
-		err := db.Collection("votes").FindOne(ctx, bson.M{
-			"poll_id": pollID,
-			"user_id": userID,
-		}).Decode(&existingVote)
-
-		now := primitive.NewDateTimeFromTime(time.Now())
-		if err == mongo.ErrNoDocuments {
-			// Create new vote
-			vote := models.Vote{
-				ID:        primitive.NewObjectID(),
-				PollID:    pollID,
-				UserID:    userID,
-				OptionID:  optionID,
-				CreatedAt: now,
-				UpdatedAt: now,
-			}
-
-			_, err = db.Collection("votes").InsertOne(ctx, vote)
-			if err != nil {
-				log.Printf("Failed to insert vote: %v", err)
-				return nil, err
-			}
-
-			// Increment vote count for the option
-			_, err = db.Collection("polls").UpdateOne(ctx,
-				bson.M{"_id": pollID, "options._id": optionID},
-				bson.M{"$inc": bson.M{"options.$.vote_count": 1}},
-			)
-			if err != nil {
-				log.Printf("Failed to increment vote count: %v", err)
-			}
-			return nil, err
-		} else if err != nil {
-			log.Printf("Error checking for existing vote: %v", err)
-			return nil, err
-		}
-
-		// Update existing vote
-		if existingVote.OptionID.Hex() != optionID.Hex() {
-			// Decrement old option's vote count
-			_, err = db.Collection("polls").UpdateOne(ctx,
-				bson.M{"_id": pollID, "options._id": existingVote.OptionID},
-				bson.M{"$inc": bson.M{"options.$.vote_count": -1}},
-			)
-			if err != nil {
-				log.Printf("Failed to decrement old option vote count: %v", err)
-				return nil, err
-			}
-
-			// Increment new option's vote count
-			_, err = db.Collection("polls").UpdateOne(ctx,
-				bson.M{"_id": pollID, "options._id": optionID},
-				bson.M{"$inc": bson.M{"options.$.vote_count": 1}},
-			)
-			if err != nil {
-				log.Printf("Failed to increment new option vote count: %v", err)
-				return nil, err
-			}
-
-			// Update vote record
-			_, err = db.Collection("votes").UpdateOne(ctx,
-				bson.M{"_id": existingVote.ID},
-				bson.M{
-					"$set": bson.M{
-						"option_id":  optionID,
-						"updated_at": now,
-					},
-				},
-			)
-			if err != nil {
-				log.Printf("Failed to update vote record: %v", err)
-			}
-			return nil, err
-		}
-
-		return nil, nil
-	})
-
+	pollWithVote, err := castVote(db, userID, pollID, optionID)
 	if err != nil {
-		log.Printf("Transaction failed: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process vote"})
+		status, message := participationError(err, "Failed to process vote")
+		log.Printf("Vote on poll %s refused: %v", pollID.Hex(), err)
+		c.JSON(status, gin.H{"error": message})
 		return
 	}
 
-	// Get the updated poll to return to the client
-	var updatedPoll models.Poll
-	err = db.Collection("polls").FindOne(context.Background(), bson.M{"_id": pollID}).Decode(&updatedPoll)
-	if err != nil {
-		log.Printf("Failed to fetch updated poll: %v", err)
-	}
-
-	// Add user_vote field to the response
-	type PollWithUserVote struct {
-		models.Poll
-		UserVote string `json:"user_vote"`
-	}
-
-	pollWithVote := PollWithUserVote{
-		Poll:     updatedPoll,
-		UserVote: optionID.Hex(),
-	}
-
-	// Send WebSocket notification
-	NotifyVoteUpdate(poll.GroupID.Hex(), pollID.Hex())
-
 	c.JSON(http.StatusOK, pollWithVote)
 }
 
@@ -549,7 +417,8 @@ func GetPoll(c *gin.Context) {
 	// Get the poll
 	var poll models.Poll
 	err = db.Collection("polls").FindOne(context.Background(), bson.M{
-		"_id": pollID,
+		"_id":        pollID,
+		"deleted_at": nil,
 	}).Decode(&poll)
 
 	if err != nil {
@@ -563,15 +432,12 @@ func GetPoll(c *gin.Context) {
 
 	// If it's a group poll, check if user is a member
 	if poll.GroupID != primitive.NilObjectID {
-		count, err := db.Collection("group_members").CountDocuments(context.Background(), bson.M{
-			"group_id": poll.GroupID,
-			"user_id":  userID,
-		})
+		canView, err := canViewGroupPolls(db, poll.GroupID, userID)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check group membership"})
 			return
 		}
-		if count == 0 {
+		if !canView {
 			c.JSON(http.StatusForbidden, gin.H{"error": "Not a member of this group"})
 			return
 		}
@@ -595,9 +461,41 @@ func GetPoll(c *gin.Context) {
 	}
 
 	// Add user's vote if exists
-	if err == nil {
+	hasVoted := err == nil
+	if hasVoted {
 		pollWithVote.UserVote = vote.OptionID.Hex()
 	}
+	if resultsHidden(poll, userID, hasVoted) {
+		hidePollResults(&pollWithVote.Poll)
+	}
 
 	c.JSON(http.StatusOK, pollWithVote)
 }
+
+// resultsHidden checks if a poll's tallies should be withheld from a user
+// according to the poll's results visibility. Poll creators always see results.
+func resultsHidden(poll models.Poll, userID primitive.ObjectID, hasVoted bool) bool {
+	if poll.CreatedBy == userID {
+		return false
+	}
+
+	open := poll.IsActive && time.Now().Before(poll.EndTime.Time())
+	switch poll.ResultsVisibility {
+	case models.ResultsVisibilityAfterVote:
+		return open && !hasVoted
+	case models.ResultsVisibilityAfterClose:
+		return open
+	default:
+		return false
+	}
+}
+
+// hidePollResults clears the vote counts of a poll before it is sent to a client
+func hidePollResults(poll *models.Poll) {
+	hidden := make([]models.PollOption, len(poll.Options))
+	for i, opt := range poll.Options {
+		opt.VoteCount = 0
+		hidden[i] = opt
+	}
+	poll.Options = hidden
+}
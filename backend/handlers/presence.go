@@ -0,0 +1,273 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"voteverse/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	messagePresenceUpdate = "presence_update"
+
+	// presenceTTL is how long a connection counts as present without a pong
+	// (or, for event streams, a successful ping). Pings go out every 54 seconds.
+	presenceTTL                     = 2 * time.Minute
+	defaultPresenceUpdateIntervalMS = 1000
+)
+
+// PresenceUpdate is who is following a group, or how many people are looking
+// at a poll. It is the data of presence_update messages and the response of
+// the presence endpoints.
+type PresenceUpdate struct {
+	GroupID string         `json:"group_id,omitempty"`
+	PollID  string         `json:"poll_id,omitempty"`
+	Count   int            `json:"count"`           // Distinct users
+	Users   []PresenceUser `json:"users,omitempty"` // Only listed for groups
+}
+
+// PresenceUser is a user online in a group
+type PresenceUser struct {
+	ID       primitive.ObjectID `json:"_id"`
+	Username string             `json:"username"`
+}
+
+// tracksPresence reports whether a channel's followers are counted
+func tracksPresence(channel string) bool {
+	return strings.HasPrefix(channel, groupChannelPrefix) || strings.HasPrefix(channel, pollChannelPrefix)
+}
+
+// markPresent records that the client follows a channel
+func (c *Client) markPresent(channel string) {
+	if !tracksPresence(channel) {
+		return
+	}
+
+	_, err := c.db.Collection("presence").ReplaceOne(context.Background(), bson.M{
+		"connection_id": c.id,
+		"channel":       channel,
+	}, models.Presence{
+		ConnectionID: c.id,
+		Channel:      channel,
+		UserID:       c.userID,
+		ExpiresAt:    primitive.NewDateTimeFromTime(time.Now().Add(presenceTTL)),
+	}, options.Replace().SetUpsert(true))
+	if err != nil {
+		log.Printf("Failed to record presence of user %s in %s: %v", c.userID.Hex(), channel, err)
+		return
+	}
+	presenceUpdates.notify(c.hub, c.db, channel)
+}
+
+// markAbsent removes the client from a channel's presence
+func (c *Client) markAbsent(channel string) {
+	if !tracksPresence(channel) {
+		return
+	}
+
+	_, err := c.db.Collection("presence").DeleteOne(context.Background(), bson.M{
+		"connection_id": c.id,
+		"channel":       channel,
+	})
+	if err != nil {
+		log.Printf("Failed to remove presence of user %s in %s: %v", c.userID.Hex(), channel, err)
+		return
+	}
+	presenceUpdates.notify(c.hub, c.db, channel)
+}
+
+// refreshPresence keeps the client's entries from expiring. It is called when
+// the connection proves to be alive.
+func (c *Client) refreshPresence() {
+	_, err := c.db.Collection("presence").UpdateMany(context.Background(), bson.M{
+		"connection_id": c.id,
+	}, bson.M{
+		"$set": bson.M{"expires_at": primitive.NewDateTimeFromTime(time.Now().Add(presenceTTL))},
+	})
+	if err != nil {
+		log.Printf("Failed to refresh presence of user %s: %v", c.userID.Hex(), err)
+	}
+}
+
+// clearPresence removes a closed connection from the channels it followed
+func clearPresence(client *Client, channels []string) {
+	_, err := client.db.Collection("presence").DeleteMany(context.Background(), bson.M{
+		"connection_id": client.id,
+	})
+	if err != nil {
+		log.Printf("Failed to clear presence of user %s: %v", client.userID.Hex(), err)
+		return
+	}
+	for _, channel := range channels {
+		if tracksPresence(channel) {
+			presenceUpdates.notify(client.hub, client.db, channel)
+		}
+	}
+}
+
+// loadPresence counts the users following a channel on any instance
+func loadPresence(db *mongo.Database, channel string) (PresenceUpdate, error) {
+	message := channelMessage(messagePresenceUpdate, channel)
+	update := PresenceUpdate{GroupID: message.GroupID, PollID: message.PollID}
+
+	userIDs, err := db.Collection("presence").Distinct(context.Background(), "user_id", bson.M{
+		"channel":    channel,
+		"expires_at": bson.M{"$gt": primitive.NewDateTimeFromTime(time.Now())},
+	})
+	if err != nil {
+		return update, err
+	}
+	update.Count = len(userIDs)
+	if update.GroupID == "" || len(userIDs) == 0 {
+		return update, nil
+	}
+
+	cursor, err := db.Collection("users").Find(context.Background(), bson.M{
+		"_id":        bson.M{"$in": userIDs},
+		"deleted_at": nil,
+	})
+	if err != nil {
+		return update, err
+	}
+	var users []models.User
+	if err := cursor.All(context.Background(), &users); err != nil {
+		return update, err
+	}
+
+	update.Users = make([]PresenceUser, len(users))
+	for i, user := range users {
+		update.Users[i] = PresenceUser{ID: user.ID, Username: user.Username}
+	}
+	sort.Slice(update.Users, func(i, j int) bool {
+		return update.Users[i].Username < update.Users[j].Username
+	})
+	return update, nil
+}
+
+// sendPresenceUpdate publishes a channel's current presence to its followers
+func sendPresenceUpdate(h *Hub, db *mongo.Database, channel string) {
+	update, err := loadPresence(db, channel)
+	if err != nil {
+		log.Printf("Failed to load presence of %s: %v", channel, err)
+		return
+	}
+
+	message := channelMessage(messagePresenceUpdate, channel)
+	message.Data = update
+	data, err := json.Marshal(message)
+	if err != nil {
+		log.Printf("error marshaling presence update: %v", err)
+		return
+	}
+	h.Publish(channel, data)
+}
+
+// presenceUpdateInterval is how long joins and leaves are collected before a
+// presence_update is sent, read from PRESENCE_UPDATE_INTERVAL_MS (default 1000)
+func presenceUpdateInterval() time.Duration {
+	ms, err := strconv.Atoi(os.Getenv("PRESENCE_UPDATE_INTERVAL_MS"))
+	if err != nil || ms < 0 {
+		ms = defaultPresenceUpdateIntervalMS
+	}
+	return time.Duration(ms) * time.Millisecond
+}
+
+// presenceDebouncer sends one presence_update per hub and channel for the
+// joins and leaves within an interval, once the interval is over
+type presenceDebouncer struct {
+	mu      sync.Mutex
+	pending map[presenceKey]*time.Timer
+}
+
+type presenceKey struct {
+	hub     *Hub
+	channel string
+}
+
+var presenceUpdates = &presenceDebouncer{pending: make(map[presenceKey]*time.Timer)}
+
+// notify reports a join or leave in a channel
+func (p *presenceDebouncer) notify(h *Hub, db *mongo.Database, channel string) {
+	// Everyone is leaving during a shutdown and there is nobody left to tell
+	if h.isClosing() {
+		return
+	}
+	key := presenceKey{hub: h, channel: channel}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.pending[key] != nil {
+		return
+	}
+
+	p.pending[key] = time.AfterFunc(presenceUpdateInterval(), func() {
+		p.mu.Lock()
+		delete(p.pending, key)
+		p.mu.Unlock()
+
+		if h.isClosing() {
+			return
+		}
+		sendPresenceUpdate(h, db, channel)
+	})
+}
+
+// stop cancels a hub's pending updates once it is shutting down
+func (p *presenceDebouncer) stop(h *Hub) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for key, timer := range p.pending {
+		if key.hub == h {
+			timer.Stop()
+			delete(p.pending, key)
+		}
+	}
+}
+
+// GetGroupPresence handles GET /api/groups/:id/presence requests
+func GetGroupPresence(c *gin.Context) {
+	presenceSnapshot(c, GroupChannel(c.Param("id")))
+}
+
+// GetPollPresence handles GET /api/polls/:id/presence requests
+func GetPollPresence(c *gin.Context) {
+	presenceSnapshot(c, PollChannel(c.Param("id")))
+}
+
+// presenceSnapshot returns a channel's current presence to users who may follow it
+func presenceSnapshot(c *gin.Context, channel string) {
+	userID, _ := primitive.ObjectIDFromHex(c.GetString("user_id"))
+	db := c.MustGet("db").(*mongo.Database)
+
+	allowed, denied, err := canSubscribe(db, channel, userID)
+	if err != nil {
+		log.Printf("Failed to check access to %s: %v", channel, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get presence"})
+		return
+	}
+	if !allowed {
+		c.JSON(http.StatusForbidden, gin.H{"error": denied})
+		return
+	}
+
+	update, err := loadPresence(db, channel)
+	if err != nil {
+		log.Printf("Failed to load presence of %s: %v", channel, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get presence"})
+		return
+	}
+	c.JSON(http.StatusOK, update)
+}
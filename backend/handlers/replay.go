@@ -0,0 +1,165 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+	"voteverse/broker"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// replayBufferSize is how many of a channel's latest events a hub keeps for
+	// clients that reconnect
+	replayBufferSize = 100
+	// replayRetention is how long a channel's events are kept after the last
+	// client on this hub stopped following it
+	replayRetention     = 2 * time.Minute
+	replaySweepInterval = 30 * time.Second
+)
+
+// Replies to resume
+const (
+	messageResumed = "resumed"
+	messageResync  = "resync"
+)
+
+// replayBuffer numbers a channel's events and keeps the latest ones. Sequence
+// numbers are only meaningful within one epoch: a new buffer, on another
+// instance or after a restart, starts a new epoch.
+type replayBuffer struct {
+	epoch          string
+	lastSeq        uint64
+	events         [][]byte // Oldest first, the last one has lastSeq
+	lastSubscribed time.Time
+}
+
+func newReplayBuffer() *replayBuffer {
+	return &replayBuffer{
+		epoch:          primitive.NewObjectID().Hex(),
+		lastSubscribed: time.Now(),
+	}
+}
+
+// next numbers a message and stores it
+func (b *replayBuffer) next(message Message) ([]byte, error) {
+	message.Seq = b.lastSeq + 1
+	message.Epoch = b.epoch
+	data, err := json.Marshal(message)
+	if err != nil {
+		return nil, err
+	}
+
+	b.lastSeq = message.Seq
+	b.events = append(b.events, data)
+	if len(b.events) > replayBufferSize {
+		b.events = b.events[len(b.events)-replayBufferSize:]
+	}
+	return data, nil
+}
+
+// since returns the events after seq, or false if the client has to
+// resynchronize because some of them are gone or seq is from another epoch
+func (b *replayBuffer) since(epoch string, seq uint64) ([][]byte, bool) {
+	if epoch != b.epoch || seq > b.lastSeq {
+		return nil, false
+	}
+	missed := b.lastSeq - seq
+	if missed > uint64(len(b.events)) {
+		return nil, false
+	}
+	return b.events[uint64(len(b.events))-missed:], true
+}
+
+// deliver numbers an event from the broker and sends it to the channel's
+// clients. Channels nobody on this hub follows are skipped, and control
+// messages from other hubs are carried out.
+func (h *Hub) deliver(event broker.Event) {
+	if event.Channel == controlChannel {
+		h.control(event.Payload)
+		return
+	}
+
+	h.replayMu.Lock()
+	defer h.replayMu.Unlock()
+
+	buffer, ok := h.replay[event.Channel]
+	if !ok {
+		return
+	}
+
+	var message Message
+	if err := json.Unmarshal(event.Payload, &message); err != nil {
+		log.Printf("error unmarshaling event for channel %s: %v", event.Channel, err)
+		return
+	}
+	// Presence updates are snapshots, so they are neither numbered nor replayed
+	if message.Type == messagePresenceUpdate {
+		h.BroadcastToChannel(event.Channel, event.Payload)
+		return
+	}
+	// A poll's events reach both its channel and its group's, numbered separately
+	message.Channel = event.Channel
+	data, err := buffer.next(message)
+	if err != nil {
+		log.Printf("error marshaling event for channel %s: %v", event.Channel, err)
+		return
+	}
+
+	h.BroadcastToChannel(event.Channel, data)
+}
+
+// subscribe adds a client to a channel and replies with the channel's epoch
+// and latest sequence number. With resume set, the events the client missed
+// since then are queued first, or the client is told to resynchronize.
+func (h *Hub) subscribe(client *Client, channel string, resume *Message) {
+	h.replayMu.Lock()
+	defer h.replayMu.Unlock()
+
+	buffer, ok := h.replay[channel]
+	if !ok {
+		buffer = newReplayBuffer()
+		h.replay[channel] = buffer
+	}
+	h.join(client, channel)
+
+	reply := channelMessage(messageSubscribed, channel)
+	reply.Epoch = buffer.epoch
+	reply.Seq = buffer.lastSeq
+	if resume == nil {
+		client.reply(reply)
+		return
+	}
+
+	missed, ok := buffer.since(resume.Epoch, resume.Seq)
+	// Catching up must fit in the send buffer, or the client would miss events anyway
+	if !ok || len(client.send)+len(missed) >= cap(client.send) {
+		reply.Type = messageResync
+		client.reply(reply)
+		return
+	}
+	for _, data := range missed {
+		client.queue(data)
+	}
+	reply.Type = messageResumed
+	client.reply(reply)
+}
+
+// sweepReplay drops the events of channels nobody on this hub has followed for replayRetention
+func (h *Hub) sweepReplay() {
+	now := time.Now()
+
+	h.replayMu.Lock()
+	defer h.replayMu.Unlock()
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for channel, buffer := range h.replay {
+		if len(h.channels[channel]) > 0 {
+			buffer.lastSubscribed = now
+		} else if now.Sub(buffer.lastSubscribed) > replayRetention {
+			delete(h.replay, channel)
+		}
+	}
+}
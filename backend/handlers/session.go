@@ -0,0 +1,554 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+	"voteverse/models"
+	"voteverse/signing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Audiences of the tokens VoteVerse signs. Services that verify access tokens
+// with the published keys should check the audience and the typ claim, so that
+// they don't accept a 2FA challenge token in place of an access token.
+const (
+	AccessTokenAudience    = "voteverse-api"
+	challengeTokenAudience = "voteverse-2fa"
+	accessTokenType        = "access"
+	defaultTokenIssuer     = "voteverse"
+)
+
+const (
+	defaultAccessTokenMinutes = 15
+	defaultRefreshTokenDays   = 30
+	sessionTouchInterval      = time.Minute
+)
+
+var (
+	errInvalidToken   = errors.New("invalid or expired token")
+	errSessionRevoked = errors.New("session has been revoked")
+)
+
+// tokenKeys signs and verifies access tokens. main replaces it with the keys from the environment.
+var tokenKeys = signing.MustGenerateKeySet(signing.AlgEdDSA)
+
+// SetTokenKeys sets the keys access tokens are signed and verified with
+func SetTokenKeys(keys *signing.KeySet) {
+	tokenKeys = keys
+}
+
+// TokenKeys returns the keys access tokens are signed and verified with
+func TokenKeys() *signing.KeySet {
+	return tokenKeys
+}
+
+// GetJWKS handles GET /.well-known/jwks.json requests. Other services verify
+// VoteVerse access tokens with these keys.
+func GetJWKS(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, tokenKeys.JWKS())
+}
+
+// TokenIssuer reads TOKEN_ISSUER (default "voteverse"), the iss claim of every
+// token VoteVerse signs
+func TokenIssuer() string {
+	if issuer := os.Getenv("TOKEN_ISSUER"); issuer != "" {
+		return issuer
+	}
+	return defaultTokenIssuer
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+// TokenResponse is returned by /api/auth/refresh
+type TokenResponse struct {
+	Token                  string `json:"token"`
+	RefreshToken           string `json:"refresh_token"`
+	ExpiresIn              int64  `json:"expires_in"`                          // Access token lifetime in seconds
+	TwoFactorSetupRequired bool   `json:"two_factor_setup_required,omitempty"` // The session may only be used to enrol in 2FA
+}
+
+// accessTokenTTL reads ACCESS_TOKEN_EXPIRY_MINUTES (default 15 minutes)
+func accessTokenTTL() time.Duration {
+	minutes, err := strconv.Atoi(os.Getenv("ACCESS_TOKEN_EXPIRY_MINUTES"))
+	if err != nil || minutes <= 0 {
+		minutes = defaultAccessTokenMinutes
+	}
+	return time.Duration(minutes) * time.Minute
+}
+
+// refreshTokenTTL reads REFRESH_TOKEN_EXPIRY_DAYS (default 30 days)
+func refreshTokenTTL() time.Duration {
+	days, err := strconv.Atoi(os.Getenv("REFRESH_TOKEN_EXPIRY_DAYS"))
+	if err != nil || days <= 0 {
+		days = defaultRefreshTokenDays
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// newOpaqueToken returns a random token and the hash that is stored for it
+func newOpaqueToken() (string, string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(buf)
+	return token, hashToken(token), nil
+}
+
+// hashToken hashes an opaque token for storage. Tokens are long and random,
+// so a fast hash is enough.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// startSession creates a session for a user who just signed in and returns
+// the access and refresh tokens for it
+func startSession(c *gin.Context, db *mongo.Database, user models.User) (TokenResponse, error) {
+	refreshToken, refreshHash, err := newOpaqueToken()
+	if err != nil {
+		return TokenResponse{}, err
+	}
+
+	setupRequired := false
+	if user.Role == models.RoleAdmin && !user.TwoFactorEnabled {
+		settings, err := loadSecuritySettings(db)
+		if err != nil {
+			return TokenResponse{}, err
+		}
+		setupRequired = settings.RequireAdminTwoFactor
+	}
+
+	now := time.Now()
+	session := models.Session{
+		ID:                     primitive.NewObjectID(),
+		UserID:                 user.ID,
+		RefreshTokenHash:       refreshHash,
+		UserAgent:              c.Request.UserAgent(),
+		IPAddress:              c.ClientIP(),
+		CreatedAt:              primitive.NewDateTimeFromTime(now),
+		LastUsedAt:             primitive.NewDateTimeFromTime(now),
+		ExpiresAt:              primitive.NewDateTimeFromTime(now.Add(refreshTokenTTL())),
+		TwoFactorSetupRequired: setupRequired,
+	}
+	if _, err := db.Collection("sessions").InsertOne(context.Background(), session); err != nil {
+		return TokenResponse{}, err
+	}
+
+	token, err := generateToken(user.ID, session.ID)
+	if err != nil {
+		return TokenResponse{}, err
+	}
+
+	return TokenResponse{
+		Token:                  token,
+		RefreshToken:           refreshToken,
+		ExpiresIn:              int64(accessTokenTTL().Seconds()),
+		TwoFactorSetupRequired: setupRequired,
+	}, nil
+}
+
+// authenticateToken validates an access token and returns its session if the
+// session is still active. Other tokens, such as 2FA challenge tokens, are
+// rejected by their audience and type.
+func authenticateToken(db *mongo.Database, tokenString string) (models.Session, error) {
+	claims := jwt.MapClaims{}
+	err := tokenKeys.Parse(tokenString, claims, jwt.WithIssuer(TokenIssuer()), jwt.WithAudience(AccessTokenAudience))
+	if err != nil || claims["typ"] != accessTokenType {
+		return models.Session{}, errInvalidToken
+	}
+
+	userID, ok := claims["user_id"].(string)
+	if !ok {
+		return models.Session{}, errInvalidToken
+	}
+	sessionIDStr, ok := claims["sid"].(string)
+	if !ok {
+		return models.Session{}, errInvalidToken
+	}
+	sessionID, err := primitive.ObjectIDFromHex(sessionIDStr)
+	if err != nil {
+		return models.Session{}, errInvalidToken
+	}
+
+	var session models.Session
+	err = db.Collection("sessions").FindOne(context.Background(), bson.M{
+		"_id":        sessionID,
+		"revoked_at": nil,
+		"expires_at": bson.M{"$gt": primitive.NewDateTimeFromTime(time.Now())},
+	}).Decode(&session)
+	if err == mongo.ErrNoDocuments {
+		return models.Session{}, errSessionRevoked
+	}
+	if err != nil {
+		return models.Session{}, err
+	}
+	if session.UserID.Hex() != userID {
+		return models.Session{}, errInvalidToken
+	}
+
+	return session, nil
+}
+
+// revokeSessions marks every active session matching the filter as revoked and
+// closes the WebSocket connections that were opened with them
+func revokeSessions(db *mongo.Database, filter bson.M) (int64, error) {
+	active := bson.M{"revoked_at": nil}
+	for key, value := range filter {
+		active[key] = value
+	}
+
+	ids, err := db.Collection("sessions").Distinct(context.Background(), "_id", active)
+	if err != nil {
+		return 0, err
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	result, err := db.Collection("sessions").UpdateMany(context.Background(), bson.M{
+		"_id":        bson.M{"$in": ids},
+		"revoked_at": nil,
+	}, bson.M{
+		"$set": bson.M{"revoked_at": primitive.NewDateTimeFromTime(time.Now())},
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	sessionIDs := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if oid, ok := id.(primitive.ObjectID); ok {
+			sessionIDs = append(sessionIDs, oid.Hex())
+		}
+	}
+	hub.DisconnectSessions(sessionIDs...)
+
+	return result.ModifiedCount, nil
+}
+
+// touchSession records that a session was used. Writes are throttled to one
+// per minute so that busy clients don't cause a write on every request.
+func touchSession(db *mongo.Database, session models.Session, ipAddress string) {
+	now := time.Now()
+	if now.Sub(session.LastUsedAt.Time()) < sessionTouchInterval {
+		return
+	}
+
+	_, err := db.Collection("sessions").UpdateOne(context.Background(), bson.M{
+		"_id":          session.ID,
+		"last_used_at": bson.M{"$lt": primitive.NewDateTimeFromTime(now.Add(-sessionTouchInterval))},
+	}, bson.M{
+		"$set": bson.M{
+			"last_used_at": primitive.NewDateTimeFromTime(now),
+			"ip_address":   ipAddress,
+		},
+	})
+	if err != nil {
+		log.Printf("Failed to update last use of session %s: %v", session.ID.Hex(), err)
+	}
+}
+
+// RefreshToken handles POST /api/auth/refresh requests. The refresh token is
+// rotated on every use; presenting an already rotated token revokes the session,
+// since it means the token was copied.
+func RefreshToken(c *gin.Context, db *mongo.Database) {
+	var req RefreshRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	presentedHash := hashToken(req.RefreshToken)
+	newToken, newHash, err := newOpaqueToken()
+	if err != nil {
+		log.Printf("Refresh token generation error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+
+	now := time.Now()
+	var session models.Session
+	err = db.Collection("sessions").FindOneAndUpdate(context.Background(), bson.M{
+		"refresh_token_hash": presentedHash,
+		"revoked_at":         nil,
+		"expires_at":         bson.M{"$gt": primitive.NewDateTimeFromTime(now)},
+	}, bson.M{
+		"$set": bson.M{
+			"refresh_token_hash":  newHash,
+			"previous_token_hash": presentedHash,
+			"last_used_at":        primitive.NewDateTimeFromTime(now),
+			"expires_at":          primitive.NewDateTimeFromTime(now.Add(refreshTokenTTL())),
+		},
+	}, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&session)
+
+	if err == mongo.ErrNoDocuments {
+		revoked, revokeErr := revokeSessions(db, bson.M{"previous_token_hash": presentedHash})
+		if revokeErr != nil {
+			log.Printf("Failed to revoke session after refresh token reuse: %v", revokeErr)
+		} else if revoked > 0 {
+			log.Printf("Refresh token reuse detected, session revoked")
+		}
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired refresh token"})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to refresh session: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	// Sessions of deleted users stop working even if they were not revoked
+	count, err := db.Collection("users").CountDocuments(context.Background(), bson.M{
+		"_id":        session.UserID,
+		"deleted_at": nil,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if count == 0 {
+		revokeSessions(db, bson.M{"_id": session.ID})
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired refresh token"})
+		return
+	}
+
+	token, err := generateToken(session.UserID, session.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+
+	c.JSON(http.StatusOK, TokenResponse{
+		Token:                  token,
+		RefreshToken:           newToken,
+		ExpiresIn:              int64(accessTokenTTL().Seconds()),
+		TwoFactorSetupRequired: session.TwoFactorSetupRequired,
+	})
+}
+
+// Logout handles POST /api/auth/logout requests by revoking the current session
+func Logout(c *gin.Context, db *mongo.Database) {
+	sessionID, err := primitive.ObjectIDFromHex(c.GetString("session_id"))
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	if _, err := revokeSessions(db, bson.M{"_id": sessionID}); err != nil {
+		log.Printf("Failed to revoke session %s: %v", sessionID.Hex(), err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to sign out"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Signed out successfully"})
+}
+
+// SessionResponse is a session as shown to its owner or an admin
+type SessionResponse struct {
+	models.Session
+	Current bool `json:"current"` // Whether the request was made with this session
+}
+
+// listActiveSessions returns the active sessions of a user, most recently used first
+func listActiveSessions(db *mongo.Database, userID primitive.ObjectID, currentSessionID string) ([]SessionResponse, error) {
+	findOptions := options.Find().SetSort(bson.D{{Key: "last_used_at", Value: -1}})
+	cursor, err := db.Collection("sessions").Find(context.Background(), bson.M{
+		"user_id":    userID,
+		"revoked_at": nil,
+		"expires_at": bson.M{"$gt": primitive.NewDateTimeFromTime(time.Now())},
+	}, findOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.Background())
+
+	var sessions []models.Session
+	if err := cursor.All(context.Background(), &sessions); err != nil {
+		return nil, err
+	}
+
+	response := make([]SessionResponse, len(sessions))
+	for i, session := range sessions {
+		response[i] = SessionResponse{
+			Session: session,
+			Current: session.ID.Hex() == currentSessionID,
+		}
+	}
+	return response, nil
+}
+
+// ListSessions handles GET /api/user/sessions requests
+func ListSessions(c *gin.Context, db *mongo.Database) {
+	userID, err := primitive.ObjectIDFromHex(c.GetString("user_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	sessions, err := listActiveSessions(db, userID, c.GetString("session_id"))
+	if err != nil {
+		log.Printf("Failed to list sessions of user %s: %v", userID.Hex(), err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get sessions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, sessions)
+}
+
+// RevokeSession handles DELETE /api/user/sessions/:id requests
+func RevokeSession(c *gin.Context, db *mongo.Database) {
+	userID, err := primitive.ObjectIDFromHex(c.GetString("user_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	sessionID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid session ID"})
+		return
+	}
+
+	revoked, err := revokeSessions(db, bson.M{"_id": sessionID, "user_id": userID})
+	if err != nil {
+		log.Printf("Failed to revoke session %s: %v", sessionID.Hex(), err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke session"})
+		return
+	}
+	if revoked == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Session revoked successfully"})
+}
+
+// RevokeOtherSessions handles DELETE /api/user/sessions requests by signing
+// out every session except the one making the request
+func RevokeOtherSessions(c *gin.Context, db *mongo.Database) {
+	userID, err := primitive.ObjectIDFromHex(c.GetString("user_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	filter := bson.M{"user_id": userID}
+	if currentID, err := primitive.ObjectIDFromHex(c.GetString("session_id")); err == nil {
+		filter["_id"] = bson.M{"$ne": currentID}
+	}
+
+	revoked, err := revokeSessions(db, filter)
+	if err != nil {
+		log.Printf("Failed to revoke sessions of user %s: %v", userID.Hex(), err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke sessions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Other sessions revoked successfully",
+		"revoked": revoked,
+	})
+}
+
+// AdminListUserSessions handles GET /api/admin/users/:id/sessions requests
+func AdminListUserSessions(c *gin.Context, db *mongo.Database) {
+	if _, ok := requireAdmin(c, db); !ok {
+		return
+	}
+
+	targetUserID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid target user ID"})
+		return
+	}
+
+	sessions, err := listActiveSessions(db, targetUserID, c.GetString("session_id"))
+	if err != nil {
+		log.Printf("Failed to list sessions of user %s: %v", targetUserID.Hex(), err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get sessions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, sessions)
+}
+
+// AdminRevokeUserSession handles DELETE /api/admin/users/:id/sessions/:sessionId requests
+func AdminRevokeUserSession(c *gin.Context, db *mongo.Database) {
+	adminID, ok := requireAdmin(c, db)
+	if !ok {
+		return
+	}
+
+	targetUserID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid target user ID"})
+		return
+	}
+
+	sessionID, err := primitive.ObjectIDFromHex(c.Param("sessionId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid session ID"})
+		return
+	}
+
+	revoked, err := revokeSessions(db, bson.M{"_id": sessionID, "user_id": targetUserID})
+	if err != nil {
+		log.Printf("Failed to revoke session %s: %v", sessionID.Hex(), err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke session"})
+		return
+	}
+	if revoked == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+		return
+	}
+
+	log.Printf("Admin %s revoked session %s of user %s", adminID.Hex(), sessionID.Hex(), targetUserID.Hex())
+	c.JSON(http.StatusOK, gin.H{"message": "Session revoked successfully"})
+}
+
+// AdminRevokeUserSessions handles DELETE /api/admin/users/:id/sessions requests
+// by signing the user out everywhere
+func AdminRevokeUserSessions(c *gin.Context, db *mongo.Database) {
+	adminID, ok := requireAdmin(c, db)
+	if !ok {
+		return
+	}
+
+	targetUserID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid target user ID"})
+		return
+	}
+
+	revoked, err := revokeSessions(db, bson.M{"user_id": targetUserID})
+	if err != nil {
+		log.Printf("Failed to revoke sessions of user %s: %v", targetUserID.Hex(), err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke sessions"})
+		return
+	}
+
+	log.Printf("Admin %s revoked %d sessions of user %s", adminID.Hex(), revoked, targetUserID.Hex())
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Sessions revoked successfully",
+		"revoked": revoked,
+	})
+}
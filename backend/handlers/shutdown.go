@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"math/rand"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// messageServerRestarting is the last message clients get before the
+	// server closes their connection to shut down
+	messageServerRestarting = "server_restarting"
+	restartCloseReason      = "server restarting"
+
+	// Clients are told to reconnect after a random delay in this range, so
+	// they do not all come back to the next instance at once
+	minReconnectDelay = time.Second
+	maxReconnectDelay = 5 * time.Second
+)
+
+// RestartNotice is the data of server_restarting messages
+type RestartNotice struct {
+	RetryAfterMS int64 `json:"retry_after_ms"` // How long to wait before reconnecting
+}
+
+// Shutdown drains the WebSocket and event stream connections of the default
+// hub and stops the vote updates waiting to be sent to it
+func Shutdown(ctx context.Context) error {
+	err := hub.Shutdown(ctx)
+	voteUpdates.stop()
+	return err
+}
+
+// Shutdown stops the hub taking new connections, tells every client that the
+// server is restarting and when to reconnect, and waits for their transports
+// to close. WebSockets get a close frame with code 1012 (service restart).
+// Clients still connected when ctx is done are closed without waiting. The
+// hub's background work is stopped before Shutdown returns.
+func (h *Hub) Shutdown(ctx context.Context) error {
+	h.mu.Lock()
+	h.closing = true
+	clients := make([]*Client, 0, len(h.clients))
+	for client := range h.clients {
+		clients = append(clients, client)
+	}
+	h.checkDrained()
+	h.mu.Unlock()
+
+	if len(clients) > 0 {
+		log.Printf("Closing %d streaming connections", len(clients))
+	}
+	for _, client := range clients {
+		client.restart(reconnectDelay())
+	}
+
+	var err error
+	select {
+	case <-h.drained:
+	case <-ctx.Done():
+		err = ctx.Err()
+		h.disconnect(func(*Client) bool { return true }, websocket.CloseServiceRestart, restartCloseReason)
+	}
+
+	// Let departed clients' presence be cleared while the database is still connected
+	tasks := make(chan struct{})
+	go func() {
+		h.tasks.Wait()
+		close(tasks)
+	}()
+	select {
+	case <-tasks:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	presenceUpdates.stop(h)
+	h.stop()
+	return err
+}
+
+// checkDrained signals Shutdown once the last client is gone. The caller holds mu.
+func (h *Hub) checkDrained() {
+	if h.closing && len(h.clients) == 0 {
+		h.drainOnce.Do(func() { close(h.drained) })
+	}
+}
+
+// stop ends run and the broker subscription
+func (h *Hub) stop() {
+	h.mu.Lock()
+	unsubscribe := h.unsubscribe
+	h.unsubscribe = nil
+	h.mu.Unlock()
+
+	if unsubscribe != nil {
+		unsubscribe()
+	}
+	select {
+	case <-h.done:
+	default:
+		close(h.done)
+	}
+}
+
+// isClosing reports whether Shutdown has been called
+func (h *Hub) isClosing() bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.closing
+}
+
+// refuseWhileClosing answers a new streaming connection with 503 during a
+// shutdown, before it is authenticated
+func (h *Hub) refuseWhileClosing(c *gin.Context) bool {
+	if !h.isClosing() {
+		return false
+	}
+	h.refuse(c, errHubClosing)
+	return true
+}
+
+// reconnectDelay picks when a client should reconnect
+func reconnectDelay() time.Duration {
+	return minReconnectDelay + time.Duration(rand.Int63n(int64(maxReconnectDelay-minReconnectDelay)))
+}
+
+// restart sends the client a server_restarting message and has its transport
+// close once the messages queued before it are written
+func (c *Client) restart(retryAfter time.Duration) {
+	data, err := json.Marshal(Message{
+		Type: messageServerRestarting,
+		Data: RestartNotice{RetryAfterMS: retryAfter.Milliseconds()},
+	})
+	if err != nil {
+		log.Printf("error marshaling %s message: %v", messageServerRestarting, err)
+	} else {
+		c.queue(data)
+	}
+	c.restartOnce.Do(func() { close(c.restarting) })
+}
+
+// pending takes the messages waiting in the send buffer without blocking
+func (c *Client) pending() [][]byte {
+	var messages [][]byte
+	for {
+		select {
+		case data, ok := <-c.send:
+			if !ok {
+				return messages
+			}
+			messages = append(messages, data)
+		default:
+			return messages
+		}
+	}
+}
@@ -0,0 +1,303 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+	"voteverse/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Soft-deleted documents keep their data but carry deleted_at and deleted_by.
+// Every read path filters on "deleted_at": nil; the purge job in the jobs
+// package removes them for good once the retention window has passed.
+// A cascade stamps every document it touches with the same deleted_at, which
+// is how a restore finds the documents that were deleted together.
+
+// restoreUpdate clears the soft-delete markers from a document
+func restoreUpdate() bson.M {
+	return bson.M{
+		"$unset": bson.M{
+			"deleted_at": "",
+			"deleted_by": "",
+		},
+		"$set": bson.M{
+			"updated_at": primitive.NewDateTimeFromTime(time.Now()),
+		},
+	}
+}
+
+// requireAdmin returns the calling user's ID if they are a site admin,
+// otherwise it writes the error response and returns false
+func requireAdmin(c *gin.Context, db *mongo.Database) (primitive.ObjectID, bool) {
+	userIDStr, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return primitive.NilObjectID, false
+	}
+
+	userID, err := primitive.ObjectIDFromHex(userIDStr.(string))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return primitive.NilObjectID, false
+	}
+
+	if _, isAdmin := IsAdmin(userID, db); !isAdmin {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Forbidden: Admin access required"})
+		return primitive.NilObjectID, false
+	}
+	return userID, true
+}
+
+// AdminRestoreGroup handles POST /api/admin/groups/:id/restore requests.
+// Subgroups, polls and comments that were deleted together with the group are
+// restored with it.
+func AdminRestoreGroup(c *gin.Context, db *mongo.Database) {
+	adminID, ok := requireAdmin(c, db)
+	if !ok {
+		return
+	}
+
+	groupID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid group ID"})
+		return
+	}
+
+	var group models.Group
+	err = db.Collection("groups").FindOne(context.Background(), bson.M{
+		"_id":        groupID,
+		"deleted_at": bson.M{"$ne": nil},
+	}).Decode(&group)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Deleted group not found"})
+		return
+	}
+
+	// A subgroup cannot come back below a deleted parent
+	if len(group.Ancestors) > 0 {
+		count, err := db.Collection("groups").CountDocuments(context.Background(), bson.M{
+			"_id":        bson.M{"$in": group.Ancestors},
+			"deleted_at": bson.M{"$ne": nil},
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check parent group"})
+			return
+		}
+		if count > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "The group's parent is deleted; restore the parent first"})
+			return
+		}
+	}
+
+	groupIDs := []primitive.ObjectID{groupID}
+	subgroupIDs, err := db.Collection("groups").Distinct(context.Background(), "_id", bson.M{
+		"ancestors":  groupID,
+		"deleted_at": group.DeletedAt,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore group"})
+		return
+	}
+	for _, id := range subgroupIDs {
+		if subgroupID, ok := id.(primitive.ObjectID); ok {
+			groupIDs = append(groupIDs, subgroupID)
+		}
+	}
+
+	groupsResult, err := db.Collection("groups").UpdateMany(context.Background(), bson.M{
+		"_id": bson.M{"$in": groupIDs},
+	}, restoreUpdate())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore group"})
+		return
+	}
+
+	pollIDs, err := db.Collection("polls").Distinct(context.Background(), "_id", bson.M{
+		"group_id":   bson.M{"$in": groupIDs},
+		"deleted_at": group.DeletedAt,
+	})
+	if err != nil {
+		log.Printf("Failed to find polls of group %s: %v", groupID.Hex(), err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore group polls"})
+		return
+	}
+
+	var restoredPolls, restoredComments int64
+	if len(pollIDs) > 0 {
+		pollsResult, err := db.Collection("polls").UpdateMany(context.Background(), bson.M{
+			"_id": bson.M{"$in": pollIDs},
+		}, restoreUpdate())
+		if err != nil {
+			log.Printf("Failed to restore polls of group %s: %v", groupID.Hex(), err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore group polls"})
+			return
+		}
+		restoredPolls = pollsResult.ModifiedCount
+
+		commentsResult, err := db.Collection("comments").UpdateMany(context.Background(), bson.M{
+			"poll_id":    bson.M{"$in": pollIDs},
+			"deleted_at": group.DeletedAt,
+		}, restoreUpdate())
+		if err != nil {
+			log.Printf("Failed to restore comments of group %s: %v", groupID.Hex(), err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore group comments"})
+			return
+		}
+		restoredComments = commentsResult.ModifiedCount
+	}
+
+	restoredSubgroups := groupsResult.ModifiedCount - 1
+	log.Printf("Admin %s restored group %s, %d subgroups, %d polls and %d comments", adminID.Hex(), groupID.Hex(), restoredSubgroups, restoredPolls, restoredComments)
+	c.JSON(http.StatusOK, gin.H{
+		"message":            "Group restored successfully",
+		"restored_subgroups": restoredSubgroups,
+		"restored_polls":     restoredPolls,
+		"restored_comments":  restoredComments,
+	})
+}
+
+// AdminRestorePoll handles POST /api/admin/polls/:id/restore requests.
+// Comments that were deleted together with the poll are restored with it.
+func AdminRestorePoll(c *gin.Context, db *mongo.Database) {
+	adminID, ok := requireAdmin(c, db)
+	if !ok {
+		return
+	}
+
+	pollID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid poll ID"})
+		return
+	}
+
+	var poll models.Poll
+	err = db.Collection("polls").FindOne(context.Background(), bson.M{
+		"_id":        pollID,
+		"deleted_at": bson.M{"$ne": nil},
+	}).Decode(&poll)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Deleted poll not found"})
+		return
+	}
+
+	// A poll cannot come back without its group; restoring the group brings it back
+	if !poll.GroupID.IsZero() {
+		count, err := db.Collection("groups").CountDocuments(context.Background(), bson.M{
+			"_id":        poll.GroupID,
+			"deleted_at": bson.M{"$ne": nil},
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check poll group"})
+			return
+		}
+		if count > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "The poll's group is deleted; restore the group first"})
+			return
+		}
+	}
+
+	_, err = db.Collection("polls").UpdateOne(context.Background(), bson.M{"_id": pollID}, restoreUpdate())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore poll"})
+		return
+	}
+
+	commentsResult, err := db.Collection("comments").UpdateMany(context.Background(), bson.M{
+		"poll_id":    pollID,
+		"deleted_at": poll.DeletedAt,
+	}, restoreUpdate())
+	if err != nil {
+		log.Printf("Failed to restore comments of poll %s: %v", pollID.Hex(), err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore poll comments"})
+		return
+	}
+
+	log.Printf("Admin %s restored poll %s and %d comments", adminID.Hex(), pollID.Hex(), commentsResult.ModifiedCount)
+	c.JSON(http.StatusOK, gin.H{
+		"message":           "Poll restored successfully",
+		"restored_comments": commentsResult.ModifiedCount,
+	})
+}
+
+// AdminRestoreComment handles POST /api/admin/comments/:id/restore requests
+func AdminRestoreComment(c *gin.Context, db *mongo.Database) {
+	adminID, ok := requireAdmin(c, db)
+	if !ok {
+		return
+	}
+
+	commentID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid comment ID"})
+		return
+	}
+
+	result, err := db.Collection("comments").UpdateOne(context.Background(), bson.M{
+		"_id":        commentID,
+		"deleted_at": bson.M{"$ne": nil},
+	}, restoreUpdate())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore comment"})
+		return
+	}
+	if result.MatchedCount == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Deleted comment not found"})
+		return
+	}
+
+	log.Printf("Admin %s restored comment %s", adminID.Hex(), commentID.Hex())
+	c.JSON(http.StatusOK, gin.H{"message": "Comment restored successfully"})
+}
+
+// AdminRestoreUser handles POST /api/admin/users/:id/restore requests.
+// Comments that were deleted together with the user are restored with them.
+func AdminRestoreUser(c *gin.Context, db *mongo.Database) {
+	adminID, ok := requireAdmin(c, db)
+	if !ok {
+		return
+	}
+
+	targetUserID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid target user ID"})
+		return
+	}
+
+	var user models.User
+	err = db.Collection("users").FindOne(context.Background(), bson.M{
+		"_id":        targetUserID,
+		"deleted_at": bson.M{"$ne": nil},
+	}).Decode(&user)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Deleted user not found"})
+		return
+	}
+
+	_, err = db.Collection("users").UpdateOne(context.Background(), bson.M{"_id": targetUserID}, restoreUpdate())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore user"})
+		return
+	}
+
+	commentsResult, err := db.Collection("comments").UpdateMany(context.Background(), bson.M{
+		"user_id":    targetUserID,
+		"deleted_at": user.DeletedAt,
+	}, restoreUpdate())
+	if err != nil {
+		log.Printf("Failed to restore comments of user %s: %v", targetUserID.Hex(), err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore user comments"})
+		return
+	}
+
+	log.Printf("Admin %s restored user %s and %d comments", adminID.Hex(), targetUserID.Hex(), commentsResult.ModifiedCount)
+	c.JSON(http.StatusOK, gin.H{
+		"message":           "User restored successfully",
+		"restored_comments": commentsResult.ModifiedCount,
+	})
+}
@@ -0,0 +1,215 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// maxEventStreamChannels limits how many channels one event stream follows
+	maxEventStreamChannels = 20
+	eventStreamPingPeriod  = 54 * time.Second
+)
+
+// eventCursor is the last event a stream sent on a channel
+type eventCursor struct {
+	epoch string
+	seq   uint64
+}
+
+// HandleEvents streams events as Server-Sent Events, for networks that break
+// WebSocket upgrades
+func HandleEvents(c *gin.Context) {
+	hub.ServeEvents(c)
+}
+
+// ServeEvents streams the events of the channels given as ?channel= with this
+// hub. Subscriptions are checked like join_group, subscribe_poll and
+// subscribe_public, and every message a WebSocket client would get is sent as
+// an event of the same type. Each event ID holds the position in every
+// channel, so a reconnecting EventSource resumes all of them from its
+// Last-Event-ID.
+func (h *Hub) ServeEvents(c *gin.Context) {
+	channels := uniqueChannels(c.QueryArray("channel"))
+	if len(channels) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "At least one channel is required"})
+		return
+	}
+	if len(channels) > maxEventStreamChannels {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("At most %d channels can be followed", maxEventStreamChannels)})
+		return
+	}
+
+	if h.refuseWhileClosing(c) {
+		return
+	}
+	db, session, ok := authenticateStream(c)
+	if !ok {
+		return
+	}
+
+	closed := make(chan struct{})
+	var closeOnce sync.Once
+	client := h.newClient(db, session, c.ClientIP())
+	client.closeConn = func(int, string) {
+		closeOnce.Do(func() { close(closed) })
+	}
+	if err := h.addClient(client); err != nil {
+		h.refuse(c, err)
+		return
+	}
+	defer h.removeClient(client)
+
+	lastEventID := c.GetHeader("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = c.Query("last_event_id")
+	}
+	cursors := parseEventID(lastEventID)
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+
+	for _, channel := range channels {
+		if cursor, ok := cursors[channel]; ok {
+			client.follow(channel, &Message{Type: "resume", Channel: channel, Epoch: cursor.epoch, Seq: cursor.seq})
+		} else {
+			client.follow(channel, nil)
+		}
+	}
+
+	stream := &eventStream{w: c.Writer, cursors: make(map[string]eventCursor)}
+	ticker := time.NewTicker(eventStreamPingPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case data, ok := <-client.send:
+			if !ok {
+				return
+			}
+			if err := stream.write(data); err != nil {
+				return
+			}
+
+		case <-ticker.C:
+			if err := stream.ping(); err != nil {
+				return
+			}
+			go client.refreshPresence()
+
+		case <-client.restarting:
+			for _, data := range client.pending() {
+				if err := stream.write(data); err != nil {
+					return
+				}
+			}
+			return
+
+		case <-closed:
+			return
+
+		case <-c.Request.Context().Done():
+			return
+		}
+	}
+}
+
+// eventStream writes hub messages as Server-Sent Events and keeps track of
+// the stream's position in each channel
+type eventStream struct {
+	w       gin.ResponseWriter
+	cursors map[string]eventCursor
+}
+
+func (s *eventStream) write(data []byte) error {
+	var message Message
+	if err := json.Unmarshal(data, &message); err != nil {
+		log.Printf("error unmarshaling event stream message: %v", err)
+		return nil
+	}
+
+	switch {
+	case message.Type == messageSubscribed || message.Type == messageResumed || message.Type == messageResync:
+		s.cursors[message.Channel] = eventCursor{epoch: message.Epoch, seq: message.Seq}
+	case message.Type == messageUnsubscribed:
+		delete(s.cursors, message.Channel)
+	case message.Seq > 0 && message.Channel != "":
+		s.cursors[message.Channel] = eventCursor{epoch: message.Epoch, seq: message.Seq}
+	}
+
+	// EventSource waits for the retry time before reconnecting
+	if notice, ok := message.Data.(map[string]interface{}); ok && message.Type == messageServerRestarting {
+		if _, err := fmt.Fprintf(s.w, "retry: %.0f\n", notice["retry_after_ms"]); err != nil {
+			return err
+		}
+	}
+	if _, err := fmt.Fprintf(s.w, "id: %s\nevent: %s\ndata: %s\n\n", formatEventID(s.cursors), message.Type, data); err != nil {
+		return err
+	}
+	s.w.Flush()
+	return nil
+}
+
+// ping keeps proxies from closing an idle stream
+func (s *eventStream) ping() error {
+	if _, err := fmt.Fprint(s.w, ": ping\n\n"); err != nil {
+		return err
+	}
+	s.w.Flush()
+	return nil
+}
+
+// formatEventID encodes the cursors as "channel/epoch/seq" entries separated
+// by commas, in channel order
+func formatEventID(cursors map[string]eventCursor) string {
+	entries := make([]string, 0, len(cursors))
+	for channel, cursor := range cursors {
+		entries = append(entries, channel+"/"+cursor.epoch+"/"+strconv.FormatUint(cursor.seq, 10))
+	}
+	sort.Strings(entries)
+	return strings.Join(entries, ",")
+}
+
+// parseEventID decodes the cursors of an event ID, skipping malformed entries
+func parseEventID(id string) map[string]eventCursor {
+	cursors := make(map[string]eventCursor)
+	for _, entry := range strings.Split(id, ",") {
+		parts := strings.Split(entry, "/")
+		if len(parts) != 3 {
+			continue
+		}
+		seq, err := strconv.ParseUint(parts[2], 10, 64)
+		if err != nil {
+			continue
+		}
+		cursors[parts[0]] = eventCursor{epoch: parts[1], seq: seq}
+	}
+	return cursors
+}
+
+// uniqueChannels drops empty and repeated channels, keeping their order
+func uniqueChannels(channels []string) []string {
+	seen := make(map[string]bool, len(channels))
+	var result []string
+	for _, channel := range channels {
+		if channel == "" || seen[channel] {
+			continue
+		}
+		seen[channel] = true
+		result = append(result, channel)
+	}
+	return result
+}
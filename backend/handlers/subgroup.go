@@ -0,0 +1,248 @@
+package handlers
+
+import (
+	"context"
+	"voteverse/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// GroupTreeNode is a group together with its subgroups, used by ListGroups?tree=true
+type GroupTreeNode struct {
+	models.Group
+	Children []*GroupTreeNode `json:"children"`
+}
+
+// buildGroupTree arranges groups by parent. Groups whose parent is not in the
+// list become roots, so a user only sees the part of the hierarchy they can access.
+func buildGroupTree(groups []models.Group) []*GroupTreeNode {
+	nodes := make(map[primitive.ObjectID]*GroupTreeNode, len(groups))
+	for _, group := range groups {
+		nodes[group.ID] = &GroupTreeNode{Group: group, Children: []*GroupTreeNode{}}
+	}
+
+	roots := []*GroupTreeNode{}
+	for _, group := range groups {
+		node := nodes[group.ID]
+		if parent, ok := nodes[group.ParentID]; ok && !group.ParentID.IsZero() {
+			parent.Children = append(parent.Children, node)
+		} else {
+			roots = append(roots, node)
+		}
+	}
+	return roots
+}
+
+// groupLineage returns the group's ID followed by the IDs of all its ancestors
+func groupLineage(group models.Group) []primitive.ObjectID {
+	return append([]primitive.ObjectID{group.ID}, group.Ancestors...)
+}
+
+// canManageGroup checks if a user is an admin of the group or of any of its
+// parent groups. Parent group admins manage all of their subgroups.
+func canManageGroup(db *mongo.Database, group models.Group, userID primitive.ObjectID) (bool, error) {
+	count, err := db.Collection("group_members").CountDocuments(context.Background(), bson.M{
+		"group_id": bson.M{"$in": groupLineage(group)},
+		"user_id":  userID,
+		"role":     "admin",
+	})
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// managedSubgroups returns the subgroups below every group the user is an admin of
+func managedSubgroups(db *mongo.Database, userID primitive.ObjectID) ([]models.Group, error) {
+	cursor, err := db.Collection("group_members").Find(context.Background(), bson.M{
+		"user_id": userID,
+		"role":    "admin",
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.Background())
+
+	var memberships []models.GroupMember
+	if err := cursor.All(context.Background(), &memberships); err != nil {
+		return nil, err
+	}
+	if len(memberships) == 0 {
+		return nil, nil
+	}
+
+	adminGroupIDs := make([]primitive.ObjectID, len(memberships))
+	for i, membership := range memberships {
+		adminGroupIDs[i] = membership.GroupID
+	}
+
+	cursor, err = db.Collection("groups").Find(context.Background(), bson.M{
+		"ancestors":  bson.M{"$in": adminGroupIDs},
+		"deleted_at": nil,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.Background())
+
+	var groups []models.Group
+	if err := cursor.All(context.Background(), &groups); err != nil {
+		return nil, err
+	}
+	return withoutDeletedAncestors(db, groups)
+}
+
+// withoutDeletedAncestors drops the groups below a soft-deleted group
+func withoutDeletedAncestors(db *mongo.Database, groups []models.Group) ([]models.Group, error) {
+	var ancestorIDs []primitive.ObjectID
+	for _, group := range groups {
+		ancestorIDs = append(ancestorIDs, group.Ancestors...)
+	}
+	if len(ancestorIDs) == 0 {
+		return groups, nil
+	}
+
+	deletedIDs, err := db.Collection("groups").Distinct(context.Background(), "_id", bson.M{
+		"_id":        bson.M{"$in": ancestorIDs},
+		"deleted_at": bson.M{"$ne": nil},
+	})
+	if err != nil {
+		return nil, err
+	}
+	deleted := make(map[primitive.ObjectID]bool, len(deletedIDs))
+	for _, id := range deletedIDs {
+		if objID, ok := id.(primitive.ObjectID); ok {
+			deleted[objID] = true
+		}
+	}
+
+	live := groups[:0]
+	for _, group := range groups {
+		keep := true
+		for _, ancestorID := range group.Ancestors {
+			if deleted[ancestorID] {
+				keep = false
+				break
+			}
+		}
+		if keep {
+			live = append(live, group)
+		}
+	}
+	return live, nil
+}
+
+// canViewGroupPolls checks if a user can see the polls of a group, either as a
+// member, as an admin of a parent group or as a member of one of its subgroups
+// when the group shares its polls
+func canViewGroupPolls(db *mongo.Database, groupID, userID primitive.ObjectID) (bool, error) {
+	count, err := db.Collection("group_members").CountDocuments(context.Background(), bson.M{
+		"group_id": groupID,
+		"user_id":  userID,
+	})
+	if err != nil {
+		return false, err
+	}
+	if count > 0 {
+		return true, nil
+	}
+
+	var group models.Group
+	err = db.Collection("groups").FindOne(context.Background(), bson.M{"_id": groupID, "deleted_at": nil}).Decode(&group)
+	if err == mongo.ErrNoDocuments {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if len(group.Ancestors) > 0 {
+		canManage, err := canManageGroup(db, group, userID)
+		if err != nil || canManage {
+			return canManage, err
+		}
+	}
+	if !group.Settings.SharePollsWithSubgroups {
+		return false, nil
+	}
+
+	subgroupIDs, err := db.Collection("groups").Distinct(context.Background(), "_id", bson.M{
+		"ancestors":  groupID,
+		"deleted_at": nil,
+	})
+	if err != nil {
+		return false, err
+	}
+	if len(subgroupIDs) == 0 {
+		return false, nil
+	}
+
+	count, err = db.Collection("group_members").CountDocuments(context.Background(), bson.M{
+		"group_id": bson.M{"$in": subgroupIDs},
+		"user_id":  userID,
+	})
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// visiblePollGroupIDs returns every group whose polls the user can see: the
+// groups they belong to, any ancestors that share polls with subgroups and the
+// subgroups they manage as an admin of a parent group
+func visiblePollGroupIDs(db *mongo.Database, userID primitive.ObjectID) ([]primitive.ObjectID, error) {
+	cursor, err := db.Collection("group_members").Find(context.Background(), bson.M{
+		"user_id": userID,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(context.Background())
+
+	var memberships []models.GroupMember
+	if err := cursor.All(context.Background(), &memberships); err != nil {
+		return nil, err
+	}
+
+	groupIDs := make([]primitive.ObjectID, 0, len(memberships))
+	for _, membership := range memberships {
+		groupIDs = append(groupIDs, membership.GroupID)
+	}
+	if len(groupIDs) == 0 {
+		return groupIDs, nil
+	}
+
+	// Collect the ancestors of every group the user belongs to
+	ancestorIDs, err := db.Collection("groups").Distinct(context.Background(), "ancestors", bson.M{
+		"_id":       bson.M{"$in": groupIDs},
+		"ancestors": bson.M{"$exists": true},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(ancestorIDs) > 0 {
+		sharedIDs, err := db.Collection("groups").Distinct(context.Background(), "_id", bson.M{
+			"_id":                                 bson.M{"$in": ancestorIDs},
+			"settings.share_polls_with_subgroups": true,
+			"deleted_at":                          nil,
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range sharedIDs {
+			if oid, ok := id.(primitive.ObjectID); ok {
+				groupIDs = append(groupIDs, oid)
+			}
+		}
+	}
+
+	subgroups, err := managedSubgroups(db, userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, subgroup := range subgroups {
+		groupIDs = append(groupIDs, subgroup.ID)
+	}
+	return groupIDs, nil
+}
@@ -0,0 +1,506 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+	"voteverse/models"
+	"voteverse/totp"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	twoFactorIssuer    = "VoteVerse"
+	challengeTokenType = "2fa_challenge"
+	challengeTokenTTL  = 5 * time.Minute
+	recoveryCodeCount  = 10
+	securitySettingsID = "security"
+)
+
+type EnrollTwoFactorRequest struct {
+	Password string `json:"password" binding:"required"`
+}
+
+type ConfirmTwoFactorRequest struct {
+	Password string `json:"password" binding:"required"`
+	Code     string `json:"code" binding:"required"`
+}
+
+type DisableTwoFactorRequest struct {
+	Password string `json:"password" binding:"required"`
+	Code     string `json:"code" binding:"required"` // TOTP code or recovery code
+}
+
+type TwoFactorChallengeRequest struct {
+	ChallengeToken string `json:"challenge_token" binding:"required"`
+	Code           string `json:"code" binding:"required"` // TOTP code or recovery code
+}
+
+type UpdateSecuritySettingsRequest struct {
+	RequireAdminTwoFactor *bool `json:"require_admin_two_factor" binding:"required"`
+}
+
+// loadSecuritySettings returns the site-wide security settings, or the defaults
+// if they have never been changed
+func loadSecuritySettings(db *mongo.Database) (models.SecuritySettings, error) {
+	var settings models.SecuritySettings
+	err := db.Collection("settings").FindOne(context.Background(), bson.M{"_id": securitySettingsID}).Decode(&settings)
+	if err == mongo.ErrNoDocuments {
+		return models.SecuritySettings{ID: securitySettingsID}, nil
+	}
+	return settings, err
+}
+
+// twoFactorSetupAllowed lists the routes a session that still has to enrol in 2FA may use
+func twoFactorSetupAllowed(path string) bool {
+	return strings.HasPrefix(path, "/api/user/2fa/") ||
+		path == "/api/auth/logout" ||
+		path == "/api/user/profile"
+}
+
+// generateChallengeToken issues the token that SignIn returns to users with 2FA
+// enabled. Its audience and type differ from access tokens, so neither
+// AuthMiddleware nor services that verify tokens with the JWKS accept it.
+func generateChallengeToken(userID primitive.ObjectID) (string, error) {
+	return tokenKeys.Sign(jwt.MapClaims{
+		"iss":     TokenIssuer(),
+		"aud":     challengeTokenAudience,
+		"typ":     challengeTokenType,
+		"user_id": userID.Hex(),
+		"exp":     time.Now().Add(challengeTokenTTL).Unix(),
+	})
+}
+
+// parseChallengeToken returns the user a challenge token was issued to
+func parseChallengeToken(tokenString string) (primitive.ObjectID, error) {
+	claims := jwt.MapClaims{}
+	err := tokenKeys.Parse(tokenString, claims, jwt.WithIssuer(TokenIssuer()), jwt.WithAudience(challengeTokenAudience))
+	if err != nil || claims["typ"] != challengeTokenType {
+		return primitive.NilObjectID, errInvalidToken
+	}
+
+	userID, ok := claims["user_id"].(string)
+	if !ok {
+		return primitive.NilObjectID, errInvalidToken
+	}
+	return primitive.ObjectIDFromHex(userID)
+}
+
+// generateRecoveryCodes returns new recovery codes and their hashes
+func generateRecoveryCodes() ([]string, []string, error) {
+	encoding := base32.StdEncoding.WithPadding(base32.NoPadding)
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		buf := make([]byte, 5)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, nil, err
+		}
+		code := strings.ToLower(encoding.EncodeToString(buf))
+		codes[i] = code[:4] + "-" + code[4:]
+		hashes[i] = hashRecoveryCode(codes[i])
+	}
+	return codes, hashes, nil
+}
+
+// hashRecoveryCode normalizes a recovery code before hashing it, so codes can
+// be typed without the dash or in upper case
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	return hashToken(code)
+}
+
+// verifySecondFactor checks a TOTP code or, failing that, a recovery code.
+// Accepted TOTP codes cannot be reused and recovery codes are consumed.
+func verifySecondFactor(db *mongo.Database, user models.User, code string) (bool, error) {
+	if step, ok := totp.ValidateAfter(user.TwoFactorSecret, code, time.Now(), user.TwoFactorLastStep); ok {
+		result, err := db.Collection("users").UpdateOne(context.Background(), bson.M{
+			"_id": user.ID,
+			"$or": []bson.M{
+				{"two_factor_last_step": bson.M{"$lt": step}},
+				{"two_factor_last_step": bson.M{"$exists": false}},
+			},
+		}, bson.M{"$set": bson.M{"two_factor_last_step": step}})
+		if err != nil {
+			return false, err
+		}
+		return result.ModifiedCount == 1, nil
+	}
+
+	codeHash := hashRecoveryCode(code)
+	result, err := db.Collection("users").UpdateOne(context.Background(), bson.M{
+		"_id":                  user.ID,
+		"recovery_code_hashes": codeHash,
+	}, bson.M{"$pull": bson.M{"recovery_code_hashes": codeHash}})
+	if err != nil {
+		return false, err
+	}
+	if result.ModifiedCount == 1 {
+		log.Printf("User %s signed in with a recovery code", user.ID.Hex())
+		return true, nil
+	}
+	return false, nil
+}
+
+// currentUser loads the signed-in user, writing the error response if that fails
+func currentUser(c *gin.Context, db *mongo.Database) (models.User, bool) {
+	userID, err := primitive.ObjectIDFromHex(c.GetString("user_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return models.User{}, false
+	}
+
+	var user models.User
+	err = db.Collection("users").FindOne(context.Background(), bson.M{"_id": userID, "deleted_at": nil}).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return models.User{}, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return models.User{}, false
+	}
+	return user, true
+}
+
+// EnrollTwoFactor handles POST /api/user/2fa/enroll requests. It returns a new
+// secret that only takes effect once a code from it is confirmed. Like the
+// other security changes it needs the password, so a stolen session cannot
+// lock the owner out with a second factor of its own.
+func EnrollTwoFactor(c *gin.Context, db *mongo.Database) {
+	var req EnrollTwoFactorRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, ok := currentUser(c, db)
+	if !ok {
+		return
+	}
+	if user.TwoFactorEnabled {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Two-factor authentication is already enabled"})
+		return
+	}
+	if !checkCurrentPassword(c, db, user, req.Password, "Invalid password") {
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate secret"})
+		return
+	}
+
+	_, err = db.Collection("users").UpdateOne(context.Background(), bson.M{"_id": user.ID}, bson.M{
+		"$set": bson.M{"pending_two_factor_secret": secret},
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start enrolment"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"secret":      secret,
+		"otpauth_uri": totp.URI(twoFactorIssuer, user.Email, secret),
+	})
+}
+
+// ConfirmTwoFactor handles POST /api/user/2fa/confirm requests. A valid code
+// from the pending secret enables 2FA and returns the recovery codes, which are
+// only shown this once.
+func ConfirmTwoFactor(c *gin.Context, db *mongo.Database) {
+	var req ConfirmTwoFactorRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, ok := currentUser(c, db)
+	if !ok {
+		return
+	}
+	if user.PendingTwoFactorSecret == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No two-factor enrolment in progress"})
+		return
+	}
+	if !checkCurrentPassword(c, db, user, req.Password, "Invalid password") {
+		return
+	}
+
+	step, valid := totp.Validate(user.PendingTwoFactorSecret, req.Code, time.Now())
+	if !valid {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid code"})
+		return
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate recovery codes"})
+		return
+	}
+
+	_, err = db.Collection("users").UpdateOne(context.Background(), bson.M{"_id": user.ID}, bson.M{
+		"$set": bson.M{
+			"two_factor_enabled":   true,
+			"two_factor_secret":    user.PendingTwoFactorSecret,
+			"two_factor_last_step": step,
+			"recovery_code_hashes": hashes,
+			"updated_at":           primitive.NewDateTimeFromTime(time.Now()),
+		},
+		"$unset": bson.M{"pending_two_factor_secret": ""},
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to enable two-factor authentication"})
+		return
+	}
+
+	// Sessions that were waiting for enrolment get full access
+	_, err = db.Collection("sessions").UpdateMany(context.Background(), bson.M{
+		"user_id":                   user.ID,
+		"two_factor_setup_required": true,
+	}, bson.M{"$unset": bson.M{"two_factor_setup_required": ""}})
+	if err != nil {
+		log.Printf("Failed to lift 2FA setup restriction for user %s: %v", user.ID.Hex(), err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":        "Two-factor authentication enabled",
+		"recovery_codes": codes,
+	})
+}
+
+// DisableTwoFactor handles POST /api/user/2fa/disable requests
+func DisableTwoFactor(c *gin.Context, db *mongo.Database) {
+	var req DisableTwoFactorRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, ok := currentUser(c, db)
+	if !ok {
+		return
+	}
+	if !user.TwoFactorEnabled {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Two-factor authentication is not enabled"})
+		return
+	}
+
+	if user.Role == models.RoleAdmin {
+		settings, err := loadSecuritySettings(db)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		if settings.RequireAdminTwoFactor {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Two-factor authentication is required for admin accounts"})
+			return
+		}
+	}
+
+	if !checkCurrentPassword(c, db, user, req.Password, "Invalid password") {
+		return
+	}
+
+	valid, err := verifySecondFactor(db, user, req.Code)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if !valid {
+		recordSignInFailure(c, db, user.Email, &user)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid code"})
+		return
+	}
+
+	_, err = db.Collection("users").UpdateOne(context.Background(), bson.M{"_id": user.ID}, bson.M{
+		"$set": bson.M{
+			"two_factor_enabled": false,
+			"updated_at":         primitive.NewDateTimeFromTime(time.Now()),
+		},
+		"$unset": bson.M{
+			"two_factor_secret":    "",
+			"two_factor_last_step": "",
+			"recovery_code_hashes": "",
+		},
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to disable two-factor authentication"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication disabled"})
+}
+
+// VerifyTwoFactorChallenge handles POST /api/auth/2fa/verify requests, the second
+// sign-in step for users with 2FA enabled
+func VerifyTwoFactorChallenge(c *gin.Context, db *mongo.Database) {
+	var req TwoFactorChallengeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID, err := parseChallengeToken(req.ChallengeToken)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired challenge token"})
+		return
+	}
+
+	var user models.User
+	err = db.Collection("users").FindOne(context.Background(), bson.M{"_id": userID, "deleted_at": nil}).Decode(&user)
+	if err != nil || !user.TwoFactorEnabled {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired challenge token"})
+		return
+	}
+
+	if signInThrottled(c, db, user.Email) {
+		recordSignInEvent(c, db, models.SignInEvent{UserID: user.ID, Email: user.Email, Method: models.SignInMethodTwoFactor, Reason: signInReasonThrottled})
+		return
+	}
+	if accountLocked(c, user) {
+		recordSignInEvent(c, db, models.SignInEvent{UserID: user.ID, Email: user.Email, Method: models.SignInMethodTwoFactor, Reason: signInReasonLocked})
+		return
+	}
+
+	valid, err := verifySecondFactor(db, user, req.Code)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if !valid {
+		recordSignInFailure(c, db, user.Email, &user)
+		recordSignInEvent(c, db, models.SignInEvent{UserID: user.ID, Email: user.Email, Method: models.SignInMethodTwoFactor, Reason: signInReasonInvalidCode})
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid code"})
+		return
+	}
+
+	clearSignInFailures(db, user.Email)
+	recordSignInEvent(c, db, models.SignInEvent{UserID: user.ID, Email: user.Email, Method: models.SignInMethodTwoFactor, Success: true})
+
+	tokens, err := startSession(c, db, user)
+	if err != nil {
+		log.Printf("Token generation error: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+
+	user.Password = "" // Don't send password back
+	c.JSON(http.StatusOK, AuthResponse{
+		TokenResponse: tokens,
+		User:          user,
+	})
+}
+
+// GetSecuritySettings handles GET /api/admin/settings/security requests
+func GetSecuritySettings(c *gin.Context, db *mongo.Database) {
+	if _, ok := requireAdmin(c, db); !ok {
+		return
+	}
+
+	settings, err := loadSecuritySettings(db)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get settings"})
+		return
+	}
+	c.JSON(http.StatusOK, settings)
+}
+
+// UpdateSecuritySettings handles PUT /api/admin/settings/security requests.
+// Requiring 2FA for admins restricts the existing sessions of admins without it
+// to the enrolment routes.
+func UpdateSecuritySettings(c *gin.Context, db *mongo.Database) {
+	adminID, ok := requireAdmin(c, db)
+	if !ok {
+		return
+	}
+
+	var req UpdateSecuritySettingsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if *req.RequireAdminTwoFactor {
+		var admin models.User
+		if err := db.Collection("users").FindOne(context.Background(), bson.M{"_id": adminID}).Decode(&admin); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get user"})
+			return
+		}
+		if !admin.TwoFactorEnabled {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Enable two-factor authentication on your own account first"})
+			return
+		}
+	}
+
+	var settings models.SecuritySettings
+	err := db.Collection("settings").FindOneAndUpdate(context.Background(), bson.M{"_id": securitySettingsID}, bson.M{
+		"$set": bson.M{
+			"require_admin_two_factor": *req.RequireAdminTwoFactor,
+			"updated_by":               adminID,
+			"updated_at":               primitive.NewDateTimeFromTime(time.Now()),
+		},
+	}, options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)).Decode(&settings)
+	if err != nil {
+		log.Printf("Failed to update security settings: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update settings"})
+		return
+	}
+
+	if settings.RequireAdminTwoFactor {
+		if err := restrictAdminsWithoutTwoFactor(db); err != nil {
+			log.Printf("Failed to restrict sessions of admins without 2FA: %v", err)
+		}
+	}
+
+	log.Printf("Admin %s set require_admin_two_factor to %v", adminID.Hex(), settings.RequireAdminTwoFactor)
+	c.JSON(http.StatusOK, settings)
+}
+
+// restrictAdminsWithoutTwoFactor limits the active sessions of admins who have
+// not enrolled in 2FA to the enrolment routes and closes their sockets
+func restrictAdminsWithoutTwoFactor(db *mongo.Database) error {
+	adminIDs, err := db.Collection("users").Distinct(context.Background(), "_id", bson.M{
+		"role":               models.RoleAdmin,
+		"two_factor_enabled": bson.M{"$ne": true},
+		"deleted_at":         nil,
+	})
+	if err != nil || len(adminIDs) == 0 {
+		return err
+	}
+
+	filter := bson.M{
+		"user_id":    bson.M{"$in": adminIDs},
+		"revoked_at": nil,
+	}
+	sessionIDs, err := db.Collection("sessions").Distinct(context.Background(), "_id", filter)
+	if err != nil {
+		return err
+	}
+	_, err = db.Collection("sessions").UpdateMany(context.Background(), filter, bson.M{
+		"$set": bson.M{"two_factor_setup_required": true},
+	})
+	if err != nil {
+		return err
+	}
+
+	hexIDs := make([]string, 0, len(sessionIDs))
+	for _, id := range sessionIDs {
+		if oid, ok := id.(primitive.ObjectID); ok {
+			hexIDs = append(hexIDs, oid.Hex())
+		}
+	}
+	hub.DisconnectSessions(hexIDs...)
+	return nil
+}
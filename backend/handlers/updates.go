@@ -0,0 +1,220 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+	"voteverse/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const defaultVoteUpdateIntervalMS = 250
+
+// PollUpdate is the data of a poll_update message
+type PollUpdate struct {
+	GroupID string       `json:"group_id,omitempty"` // Empty for public polls
+	PollID  string       `json:"poll_id"`
+	Type    string       `json:"type"`           // "created" or "closed"
+	Poll    *models.Poll `json:"poll,omitempty"` // Set for created polls
+}
+
+// VoteUpdate is the data of a vote_update message
+type VoteUpdate struct {
+	GroupID       string        `json:"group_id,omitempty"` // Empty for public polls
+	PollID        string        `json:"poll_id"`
+	TotalVotes    *int          `json:"total_votes,omitempty"` // Left out while the results are hidden
+	ResultsHidden bool          `json:"results_hidden"`
+	Options       []OptionTally `json:"options,omitempty"` // Left out while the results are hidden
+}
+
+// OptionTally is the vote count of one poll option
+type OptionTally struct {
+	ID        string `json:"id"`
+	VoteCount int    `json:"vote_count"`
+}
+
+// CommentUpdate is the data of a comment_update message
+type CommentUpdate struct {
+	GroupID   string       `json:"group_id,omitempty"` // Empty for public polls
+	PollID    string       `json:"poll_id"`
+	Type      string       `json:"type"` // "created" or "deleted"
+	CommentID string       `json:"comment_id"`
+	Comment   *CommentView `json:"comment,omitempty"` // Set for created comments
+}
+
+// CommentView is a comment as ListComments returns it
+type CommentView struct {
+	ID        primitive.ObjectID `json:"_id"`
+	Text      string             `json:"text"`
+	CreatedAt primitive.DateTime `json:"created_at"`
+	UpdatedAt primitive.DateTime `json:"updated_at"`
+	User      CommentAuthor      `json:"user"`
+}
+
+// CommentAuthor identifies the author of a comment
+type CommentAuthor struct {
+	ID       primitive.ObjectID `json:"_id,omitempty"` // Not set once the author deleted their account
+	Username string             `json:"username"`
+}
+
+// newVoteUpdate builds the vote_update for a poll. Everyone following the poll
+// gets the same message, so the tallies are only included when the poll's
+// results visibility shows them to everybody.
+func newVoteUpdate(poll models.Poll) VoteUpdate {
+	update := VoteUpdate{
+		GroupID: optionalHex(poll.GroupID),
+		PollID:  poll.ID.Hex(),
+	}
+	if resultsHidden(poll, primitive.NilObjectID, false) {
+		update.ResultsHidden = true
+		return update
+	}
+
+	total := totalVotes(poll)
+	update.TotalVotes = &total
+
+	update.Options = make([]OptionTally, len(poll.Options))
+	for i, opt := range poll.Options {
+		update.Options[i] = OptionTally{ID: opt.ID.Hex(), VoteCount: opt.VoteCount}
+	}
+	return update
+}
+
+// sendVoteUpdate publishes the current tallies of a poll
+func sendVoteUpdate(db *mongo.Database, pollID primitive.ObjectID) {
+	var poll models.Poll
+	err := db.Collection("polls").FindOne(context.Background(), bson.M{
+		"_id":        pollID,
+		"deleted_at": nil,
+	}).Decode(&poll)
+	if err != nil {
+		log.Printf("Failed to load poll %s for vote update: %v", pollID.Hex(), err)
+		return
+	}
+
+	NotifyVoteUpdate(newVoteUpdate(poll))
+}
+
+// loadCommentView loads a comment with its author's username
+func loadCommentView(db *mongo.Database, commentID primitive.ObjectID) (*CommentView, error) {
+	var comment models.Comment
+	err := db.Collection("comments").FindOne(context.Background(), bson.M{"_id": commentID}).Decode(&comment)
+	if err != nil {
+		return nil, err
+	}
+
+	view := &CommentView{
+		ID:        comment.ID,
+		Text:      comment.Text,
+		CreatedAt: comment.CreatedAt,
+		UpdatedAt: comment.UpdatedAt,
+		User:      CommentAuthor{Username: deletedUsername},
+	}
+
+	var author models.User
+	err = db.Collection("users").FindOne(context.Background(), bson.M{
+		"_id":        comment.UserID,
+		"deleted_at": nil,
+	}).Decode(&author)
+	if err == nil {
+		view.User = CommentAuthor{ID: author.ID, Username: author.Username}
+	} else if err != mongo.ErrNoDocuments {
+		return nil, err
+	}
+	return view, nil
+}
+
+// voteUpdateInterval is the minimum time between two vote_update messages for
+// the same poll, read from VOTE_UPDATE_INTERVAL_MS (default 250)
+func voteUpdateInterval() time.Duration {
+	ms, err := strconv.Atoi(os.Getenv("VOTE_UPDATE_INTERVAL_MS"))
+	if err != nil || ms < 0 {
+		ms = defaultVoteUpdateIntervalMS
+	}
+	return time.Duration(ms) * time.Millisecond
+}
+
+// voteCoalescer sends at most one vote_update per poll and interval. The first
+// vote is sent right away; the votes after it in the same interval are folded
+// into a single update with the tallies at the end of the interval.
+type voteCoalescer struct {
+	mu      sync.Mutex
+	pending map[primitive.ObjectID]bool        // Polls inside an interval, true if votes are waiting
+	timers  map[primitive.ObjectID]*time.Timer // End each poll's interval
+	stopped bool                               // Set by stop; every vote is then sent right away
+}
+
+var voteUpdates = &voteCoalescer{
+	pending: make(map[primitive.ObjectID]bool),
+	timers:  make(map[primitive.ObjectID]*time.Timer),
+}
+
+// notify reports a vote on a poll
+func (v *voteCoalescer) notify(db *mongo.Database, pollID primitive.ObjectID) {
+	v.mu.Lock()
+	if v.stopped {
+		v.mu.Unlock()
+		sendVoteUpdate(db, pollID)
+		return
+	}
+	if _, open := v.pending[pollID]; open {
+		v.pending[pollID] = true
+		v.mu.Unlock()
+		return
+	}
+	v.pending[pollID] = false
+	v.mu.Unlock()
+
+	sendVoteUpdate(db, pollID)
+	v.schedule(db, pollID)
+}
+
+// schedule starts the timer that ends a poll's interval
+func (v *voteCoalescer) schedule(db *mongo.Database, pollID primitive.ObjectID) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.stopped {
+		return
+	}
+	v.timers[pollID] = time.AfterFunc(voteUpdateInterval(), func() { v.flush(db, pollID) })
+}
+
+// flush ends a poll's interval, starting another one if votes were waiting
+func (v *voteCoalescer) flush(db *mongo.Database, pollID primitive.ObjectID) {
+	v.mu.Lock()
+	delete(v.timers, pollID)
+	if v.stopped {
+		v.mu.Unlock()
+		return
+	}
+	if !v.pending[pollID] {
+		delete(v.pending, pollID)
+		v.mu.Unlock()
+		return
+	}
+	v.pending[pollID] = false
+	v.mu.Unlock()
+
+	sendVoteUpdate(db, pollID)
+	v.schedule(db, pollID)
+}
+
+// stop cancels the open intervals so no update reads the database after it
+// has been disconnected. Waiting votes are dropped; clients reload the tallies
+// when they reconnect.
+func (v *voteCoalescer) stop() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.stopped = true
+	for pollID, timer := range v.timers {
+		timer.Stop()
+		delete(v.timers, pollID)
+	}
+	v.pending = make(map[primitive.ObjectID]bool)
+}
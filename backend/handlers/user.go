@@ -2,10 +2,12 @@ package handlers
 
 import (
 	"context"
+	"errors"
 	"log"
 	"net/http"
 	"time"
 	"voteverse/models"
+	"voteverse/services"
 
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/bson"
@@ -48,7 +50,13 @@ func ListUsers(c *gin.Context, db *mongo.Database) {
 	findOptions := options.Find()
 	findOptions.SetSort(bson.D{{Key: "created_at", Value: -1}})
 
-	cursor, err := db.Collection("users").Find(context.Background(), bson.M{}, findOptions)
+	// Soft-deleted users are only listed when asked for
+	filter := bson.M{"deleted_at": nil}
+	if c.Query("include_deleted") == "true" {
+		filter = bson.M{}
+	}
+
+	cursor, err := db.Collection("users").Find(context.Background(), filter, findOptions)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get users"})
 		return
@@ -151,6 +159,17 @@ func UpdateUserRole(c *gin.Context, db *mongo.Database) {
 		return
 	}
 
+	// New admins must enrol in 2FA first if the site requires it
+	if req.Role == models.RoleAdmin {
+		settings, err := loadSecuritySettings(db)
+		if err == nil && settings.RequireAdminTwoFactor {
+			err = restrictAdminsWithoutTwoFactor(db)
+		}
+		if err != nil {
+			log.Printf("Failed to apply 2FA requirement to user %s: %v", targetUserID, err)
+		}
+	}
+
 	c.JSON(http.StatusOK, gin.H{"message": "User role updated successfully"})
 }
 
@@ -197,31 +216,29 @@ func DeleteUser(c *gin.Context, db *mongo.Database) {
 		return
 	}
 
-	// Delete the user
-	result, err := db.Collection("users").DeleteOne(context.Background(), bson.M{"_id": targetUserObjID})
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete user"})
-		return
-	}
-
-	if result.DeletedCount == 0 {
+	// Soft-delete the user together with their comments
+	counts, err := services.NewCascadeService(db).DeleteUser(context.Background(), targetUserObjID, services.DeleteOptions{
+		Mode:      services.SoftDelete,
+		DeletedBy: userObjID,
+	})
+	if errors.Is(err, services.ErrNotFound) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
 		return
 	}
-
-	// Also delete user's votes, comments, etc.
-	// This would be a good place to use transactions, but for simplicity, we'll just delete them one by one
-	_, err = db.Collection("votes").DeleteMany(context.Background(), bson.M{"user_id": targetUserObjID})
 	if err != nil {
-		log.Printf("Failed to delete user's votes: %v", err)
+		log.Printf("Failed to delete user %s: %v", targetUserID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete user"})
+		return
 	}
+	log.Printf("Deleted user %s: %v", targetUserID, counts)
 
-	_, err = db.Collection("comments").DeleteMany(context.Background(), bson.M{"user_id": targetUserObjID})
-	if err != nil {
-		log.Printf("Failed to delete user's comments: %v", err)
-	}
+	// The cascade revoked the user's sessions; also drop their open sockets
+	hub.DisconnectUser(targetUserObjID)
 
-	c.JSON(http.StatusOK, gin.H{"message": "User deleted successfully"})
+	c.JSON(http.StatusOK, gin.H{
+		"message": "User deleted successfully",
+		"deleted": counts,
+	})
 }
 
 // CreateUser handles POST /api/admin/users requests
@@ -316,13 +333,14 @@ func CreateUser(c *gin.Context, db *mongo.Database) {
 	// Create new user
 	now := primitive.NewDateTimeFromTime(time.Now())
 	newUser := models.User{
-		ID:        primitive.NewObjectID(),
-		Username:  req.Username,
-		Email:     req.Email,
-		Password:  string(hashedPassword),
-		Role:      req.Role,
-		CreatedAt: now,
-		UpdatedAt: now,
+		ID:            primitive.NewObjectID(),
+		Username:      req.Username,
+		Email:         req.Email,
+		Password:      string(hashedPassword),
+		Role:          req.Role,
+		EmailVerified: true, // Accounts created by admins are trusted
+		CreatedAt:     now,
+		UpdatedAt:     now,
 	}
 
 	_, err = db.Collection("users").InsertOne(context.Background(), newUser)
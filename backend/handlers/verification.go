@@ -0,0 +1,231 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+	"voteverse/mailer"
+	"voteverse/models"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	emailVerificationTTL = 48 * time.Hour
+
+	// Verification emails can be resent once a minute, and at most five times an hour
+	verificationResendCooldown   = time.Minute
+	maxVerificationEmailsPerHour = 5
+)
+
+type VerifyEmailRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+// emailVerificationRequired reports whether REQUIRE_EMAIL_VERIFICATION is on. When it
+// is, unverified users cannot create groups or vote in public polls.
+func emailVerificationRequired() bool {
+	return os.Getenv("REQUIRE_EMAIL_VERIFICATION") == "true"
+}
+
+// requireVerifiedEmail checks the email verification policy for a user. It
+// writes the error response and returns false if the user may not continue.
+func requireVerifiedEmail(c *gin.Context, db *mongo.Database, userID primitive.ObjectID) bool {
+	if !emailVerificationRequired() {
+		return true
+	}
+
+	var user models.User
+	err := db.Collection("users").FindOne(context.Background(), bson.M{"_id": userID}).Decode(&user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get user"})
+		return false
+	}
+	if !user.EmailVerified {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Please verify your email address first"})
+		return false
+	}
+	return true
+}
+
+// sendVerificationEmail emails a new verification link for the user's current
+// address. Earlier links stop working.
+func sendVerificationEmail(ctx context.Context, db *mongo.Database, user models.User) error {
+	token, tokenHash, err := newOpaqueToken()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	verifications := db.Collection("email_verifications")
+
+	_, err = verifications.UpdateMany(ctx, bson.M{
+		"user_id": user.ID,
+		"used_at": nil,
+	}, bson.M{"$set": bson.M{"used_at": primitive.NewDateTimeFromTime(now)}})
+	if err != nil {
+		return err
+	}
+
+	_, err = verifications.InsertOne(ctx, models.EmailVerification{
+		ID:        primitive.NewObjectID(),
+		UserID:    user.ID,
+		Email:     user.Email,
+		TokenHash: tokenHash,
+		CreatedAt: primitive.NewDateTimeFromTime(now),
+		ExpiresAt: primitive.NewDateTimeFromTime(now.Add(emailVerificationTTL)),
+	})
+	if err != nil {
+		return err
+	}
+
+	return appMailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Verify your VoteVerse email address",
+		Body: fmt.Sprintf("Hi %s,\n\nPlease confirm your email address by opening the link below. It expires in %d hours.\n\n%s\n",
+			user.Username, int(emailVerificationTTL.Hours()), appURL("/verify-email", url.Values{"token": {token}})),
+	})
+}
+
+// VerifyEmail handles POST /api/auth/verify-email requests
+func VerifyEmail(c *gin.Context, db *mongo.Database) {
+	var req VerifyEmailRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	now := primitive.NewDateTimeFromTime(time.Now())
+	var verification models.EmailVerification
+	err := db.Collection("email_verifications").FindOneAndUpdate(context.Background(), bson.M{
+		"token_hash": hashToken(req.Token),
+		"used_at":    nil,
+		"expires_at": bson.M{"$gt": now},
+	}, bson.M{"$set": bson.M{"used_at": now}}).Decode(&verification)
+	if err == mongo.ErrNoDocuments {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired verification token"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	// The link only verifies the address it was sent to
+	result, err := db.Collection("users").UpdateOne(context.Background(), bson.M{
+		"_id":        verification.UserID,
+		"email":      verification.Email,
+		"deleted_at": nil,
+	}, bson.M{"$set": bson.M{
+		"email_verified": true,
+		"updated_at":     now,
+	}})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify email"})
+		return
+	}
+	if result.MatchedCount == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired verification token"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Email verified successfully"})
+}
+
+// ResendVerificationEmail handles POST /api/auth/verify-email/resend requests
+func ResendVerificationEmail(c *gin.Context, db *mongo.Database) {
+	userID, err := primitive.ObjectIDFromHex(c.GetString("user_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var user models.User
+	err = db.Collection("users").FindOne(context.Background(), bson.M{"_id": userID, "deleted_at": nil}).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	if user.EmailVerified {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Email is already verified"})
+		return
+	}
+
+	wait, err := verificationResendWait(db, user.ID)
+	if err != nil {
+		log.Printf("Failed to check verification emails of user %s: %v", user.ID.Hex(), err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if wait > 0 {
+		c.Header("Retry-After", retryAfterSeconds(wait))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many verification emails. Please try again later"})
+		return
+	}
+
+	if err := sendVerificationEmail(c.Request.Context(), db, user); err != nil {
+		log.Printf("Failed to send verification email to user %s: %v", user.ID.Hex(), err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send email"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Verification email sent"})
+}
+
+// verificationResendWait returns how long a user has to wait before another
+// verification email may be sent, counting the one sent at sign-up
+func verificationResendWait(db *mongo.Database, userID primitive.ObjectID) (time.Duration, error) {
+	now := time.Now()
+	findOptions := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}}).
+		SetLimit(maxVerificationEmailsPerHour)
+	cursor, err := db.Collection("email_verifications").Find(context.Background(), bson.M{
+		"user_id":    userID,
+		"created_at": bson.M{"$gt": primitive.NewDateTimeFromTime(now.Add(-time.Hour))},
+	}, findOptions)
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(context.Background())
+
+	var sent []models.EmailVerification
+	if err := cursor.All(context.Background(), &sent); err != nil {
+		return 0, err
+	}
+
+	sentAt := make([]time.Time, len(sent))
+	for i, verification := range sent {
+		sentAt[i] = verification.CreatedAt.Time()
+	}
+	return emailWait(sentAt, now, verificationResendCooldown, maxVerificationEmailsPerHour), nil
+}
+
+// emailWait returns how long to wait before another email may be sent, given
+// when the emails of the last hour were sent, newest first and at most limit
+func emailWait(sentAt []time.Time, now time.Time, cooldown time.Duration, limit int) time.Duration {
+	if len(sentAt) == 0 {
+		return 0
+	}
+
+	wait := sentAt[0].Add(cooldown).Sub(now)
+	if len(sentAt) == limit {
+		// Wait until the oldest email of the last hour drops out of the window
+		if untilOldest := sentAt[len(sentAt)-1].Add(time.Hour).Sub(now); untilOldest > wait {
+			wait = untilOldest
+		}
+	}
+	return wait
+}
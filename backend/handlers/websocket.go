@@ -1,19 +1,30 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
 	"log"
 	"net/http"
-	"os"
+	"strings"
 	"sync"
 	"time"
+	"voteverse/broker"
+	"voteverse/models"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v5"
 	"github.com/gorilla/websocket"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
+// maxMessageSize is the largest message a client may send, which also bounds
+// comments posted over the WebSocket
+const maxMessageSize = 8192
+
+// sendBufferSize is how many messages may wait for a client's transport
+// before the client counts as too slow and is disconnected
+const sendBufferSize = 256
+
 var upgrader = websocket.Upgrader{
 	ReadBufferSize:  1024,
 	WriteBufferSize: 1024,
@@ -23,97 +34,220 @@ var upgrader = websocket.Upgrader{
 	},
 }
 
+// Client is a connection receiving events, over a WebSocket or a Server-Sent
+// Events stream. Both subscribe to channels the same way; only the transport
+// that drains send differs.
 type Client struct {
-	conn   *websocket.Conn
-	userID primitive.ObjectID
-	groups map[string]bool
-	send   chan []byte
-	hub    *Hub
-	mu     sync.Mutex
+	conn        *websocket.Conn // Nil for event streams
+	closeConn   func(code int, reason string)
+	id          string // Identifies the connection's presence entries
+	db          *mongo.Database
+	userID      primitive.ObjectID
+	sessionID   string
+	ip          string
+	channels    map[string]bool
+	send        chan []byte
+	restarting  chan struct{} // Closed when the transport should flush send and close
+	restartOnce sync.Once
+	evictOnce   sync.Once
+	hub         *Hub
+	mu          sync.Mutex
+	follows     sync.Mutex // Serializes subscribing with rechecks of the client's channels
 }
 
 type Hub struct {
-	clients    map[*Client]bool
-	groups     map[string]map[*Client]bool
-	broadcast  chan []byte
-	register   chan *Client
-	unregister chan *Client
-	mu         sync.RWMutex
+	id          string // Tells the hub's own control messages apart
+	clients     map[*Client]bool
+	channels    map[string]map[*Client]bool
+	connsByUser map[primitive.ObjectID]int
+	connsByIP   map[string]int
+	stats       streamStats
+	unregister  chan *Client
+	broker      broker.Broker
+	unsubscribe func()
+	replay      map[string]*replayBuffer
+	replayMu    sync.Mutex // Held while numbering and sending events; taken before mu
+	mu          sync.RWMutex
+	closing     bool          // Set by Shutdown; no clients are added after it
+	drained     chan struct{} // Closed once the hub is closing and has no clients left
+	drainOnce   sync.Once
+	done        chan struct{} // Closed when run stops
+	tasks       sync.WaitGroup
 }
 
 type Message struct {
-	Type    string      `json:"type"`
-	Token   string      `json:"token,omitempty"`
-	GroupID string      `json:"group_id,omitempty"`
-	Data    interface{} `json:"data,omitempty"`
+	Type      string      `json:"type"`
+	Token     string      `json:"token,omitempty"`
+	Channel   string      `json:"channel,omitempty"`
+	GroupID   string      `json:"group_id,omitempty"`
+	PollID    string      `json:"poll_id,omitempty"`
+	OptionID  string      `json:"option_id,omitempty"`  // cast_vote
+	Text      string      `json:"text,omitempty"`       // post_comment
+	RequestID string      `json:"request_id,omitempty"` // Chosen by the client and sent back with the ack or error
+	Data      interface{} `json:"data,omitempty"`
+	Error     string      `json:"error,omitempty"`
+	Seq       uint64      `json:"seq,omitempty"`   // Per-channel sequence number of an event, or the last one seen when resuming
+	Epoch     string      `json:"epoch,omitempty"` // Sequence numbers only compare within an epoch
+}
+
+// Replies to subscription requests
+const (
+	messageSubscribed   = "subscribed"
+	messageUnsubscribed = "unsubscribed"
+	messageError        = "error"
+)
+
+// NewHub creates and starts a hub that shares events with other hubs through a broker
+func NewHub(b broker.Broker) *Hub {
+	h := &Hub{
+		id:          primitive.NewObjectID().Hex(),
+		clients:     make(map[*Client]bool),
+		channels:    make(map[string]map[*Client]bool),
+		connsByUser: make(map[primitive.ObjectID]int),
+		connsByIP:   make(map[string]int),
+		unregister:  make(chan *Client),
+		replay:      make(map[string]*replayBuffer),
+		drained:     make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+	h.SetBroker(b)
+	go h.run()
+	return h
+}
+
+var hub = NewHub(broker.NewMemoryBroker())
+
+// SetBroker sets the broker WebSocket events are shared between server instances with
+func SetBroker(b broker.Broker) {
+	hub.SetBroker(b)
 }
 
-func newHub() *Hub {
-	return &Hub{
-		clients:    make(map[*Client]bool),
-		groups:     make(map[string]map[*Client]bool),
-		broadcast:  make(chan []byte),
-		register:   make(chan *Client),
-		unregister: make(chan *Client),
+// SetBroker switches the hub to another broker
+func (h *Hub) SetBroker(b broker.Broker) {
+	unsubscribe := b.Subscribe(h.deliver)
+
+	h.mu.Lock()
+	previous := h.unsubscribe
+	h.broker = b
+	h.unsubscribe = unsubscribe
+	h.mu.Unlock()
+
+	if previous != nil {
+		previous()
 	}
 }
 
-var hub = newHub()
+// Publish sends a message to the clients following a channel on every hub sharing the broker
+func (h *Hub) Publish(channel string, message []byte) {
+	h.mu.RLock()
+	b := h.broker
+	h.mu.RUnlock()
 
-func init() {
-	go hub.run()
+	if err := b.Publish(context.Background(), broker.Event{Channel: channel, Payload: message}); err != nil {
+		log.Printf("Failed to publish event for channel %s: %v", channel, err)
+	}
 }
 
 func (h *Hub) run() {
+	sweep := time.NewTicker(replaySweepInterval)
+	defer sweep.Stop()
+
 	for {
 		select {
-		case client := <-h.register:
-			h.mu.Lock()
-			h.clients[client] = true
-			h.mu.Unlock()
-
 		case client := <-h.unregister:
+			var followed []string
 			h.mu.Lock()
 			if _, ok := h.clients[client]; ok {
+				// The only place send is closed, under the write lock, so that
+				// nothing holding the read lock can send on a closed channel
 				delete(h.clients, client)
 				close(client.send)
-				// Remove client from all groups
-				for groupID := range client.groups {
-					if group, exists := h.groups[groupID]; exists {
-						delete(group, client)
-						if len(group) == 0 {
-							delete(h.groups, groupID)
+				h.release(client)
+
+				// Remove client from all channels
+				client.mu.Lock()
+				for channel := range client.channels {
+					followed = append(followed, channel)
+					if followers, exists := h.channels[channel]; exists {
+						delete(followers, client)
+						if len(followers) == 0 {
+							delete(h.channels, channel)
 						}
 					}
 				}
+				client.mu.Unlock()
 			}
+			// Counted before the hub can report itself drained, so Shutdown
+			// waits for the presence to be cleared
+			h.tasks.Add(1)
+			h.checkDrained()
 			h.mu.Unlock()
 
-		case message := <-h.broadcast:
-			h.mu.RLock()
-			for client := range h.clients {
-				select {
-				case client.send <- message:
-				default:
-					close(client.send)
-					delete(h.clients, client)
-				}
-			}
-			h.mu.RUnlock()
+			go func() {
+				defer h.tasks.Done()
+				clearPresence(client, followed)
+			}()
+
+		case <-sweep.C:
+			h.sweepReplay()
+
+		case <-h.done:
+			return
 		}
 	}
 }
 
+// newClient creates a client for an authenticated session connecting from ip
+func (h *Hub) newClient(db *mongo.Database, session models.Session, ip string) *Client {
+	return &Client{
+		id:         primitive.NewObjectID().Hex(),
+		db:         db,
+		userID:     session.UserID,
+		sessionID:  session.ID.Hex(),
+		ip:         ip,
+		channels:   make(map[string]bool),
+		send:       make(chan []byte, sendBufferSize),
+		restarting: make(chan struct{}),
+		hub:        h,
+	}
+}
+
+// addClient registers a client, unless the hub is shutting down or the
+// client's user or address has too many connections. It is done right away
+// rather than through run, so that replies to subscriptions made straight
+// after are not dropped.
+func (h *Hub) addClient(client *Client) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err := h.checkAdmission(client); err != nil {
+		return err
+	}
+	h.clients[client] = true
+	h.connsByUser[client.userID]++
+	h.connsByIP[client.ip]++
+	return nil
+}
+
+// removeClient unregisters a client whose transport has closed. Once the hub
+// has stopped nobody is left to unregister it, which no longer matters.
+func (h *Hub) removeClient(client *Client) {
+	select {
+	case h.unregister <- client:
+	case <-h.done:
+	}
+}
+
 func (c *Client) readPump() {
 	defer func() {
-		c.hub.unregister <- c
+		c.hub.removeClient(c)
 		c.conn.Close()
 	}()
 
-	c.conn.SetReadLimit(512)
+	c.conn.SetReadLimit(maxMessageSize)
 	c.conn.SetReadDeadline(time.Now().Add(60 * time.Second))
 	c.conn.SetPongHandler(func(string) error {
 		c.conn.SetReadDeadline(time.Now().Add(60 * time.Second))
+		go c.refreshPresence()
 		return nil
 	})
 
@@ -134,34 +268,245 @@ func (c *Client) readPump() {
 
 		switch msg.Type {
 		case "join_group":
-			c.mu.Lock()
-			c.groups[msg.GroupID] = true
-			c.mu.Unlock()
-
-			c.hub.mu.Lock()
-			if _, exists := c.hub.groups[msg.GroupID]; !exists {
-				c.hub.groups[msg.GroupID] = make(map[*Client]bool)
-			}
-			c.hub.groups[msg.GroupID][c] = true
-			c.hub.mu.Unlock()
+			c.follow(GroupChannel(msg.GroupID), nil)
 
 		case "leave_group":
-			c.mu.Lock()
-			delete(c.groups, msg.GroupID)
-			c.mu.Unlock()
-
-			c.hub.mu.Lock()
-			if group, exists := c.hub.groups[msg.GroupID]; exists {
-				delete(group, c)
-				if len(group) == 0 {
-					delete(c.hub.groups, msg.GroupID)
-				}
-			}
-			c.hub.mu.Unlock()
+			c.unfollow(GroupChannel(msg.GroupID))
+
+		case "subscribe_poll":
+			c.follow(PollChannel(msg.PollID), nil)
+
+		case "unsubscribe_poll":
+			c.unfollow(PollChannel(msg.PollID))
+
+		case "subscribe_public":
+			c.follow(PublicChannel, nil)
+
+		case "unsubscribe_public":
+			c.unfollow(PublicChannel)
+
+		case "resume":
+			c.follow(resumeChannel(msg), &msg)
+
+		case "cast_vote":
+			c.handleCastVote(msg)
+
+		case "post_comment":
+			c.handlePostComment(msg)
 		}
 	}
 }
 
+// follow subscribes the client to a channel if the user may see its events,
+// and tells the client either way. Resuming clients also get the events they
+// missed.
+func (c *Client) follow(channel string, resume *Message) {
+	c.follows.Lock()
+	defer c.follows.Unlock()
+
+	allowed, denied, err := canSubscribe(c.db, channel, c.userID)
+	if err != nil {
+		log.Printf("Failed to check channel %s subscription for user %s: %v", channel, c.userID.Hex(), err)
+		reply := channelMessage(messageError, channel)
+		reply.Error = "Failed to subscribe"
+		c.reply(reply)
+		return
+	}
+	if !allowed {
+		reply := channelMessage(messageError, channel)
+		reply.Error = denied
+		c.reply(reply)
+		return
+	}
+
+	c.hub.subscribe(c, channel, resume)
+
+	// Access may have been revoked after the check but before a recheck could
+	// see the subscription, so check again now that it is visible
+	if !c.hub.keepIfAllowed(c.db, c, channel) {
+		return
+	}
+	c.markPresent(channel)
+}
+
+// unfollow unsubscribes the client from a channel
+func (c *Client) unfollow(channel string) {
+	c.hub.leave(c, channel)
+	c.markAbsent(channel)
+	c.reply(channelMessage(messageUnsubscribed, channel))
+}
+
+// reply queues a message for this client only
+func (c *Client) reply(message Message) {
+	data, err := json.Marshal(message)
+	if err != nil {
+		log.Printf("error marshaling %s reply: %v", message.Type, err)
+		return
+	}
+	c.queue(data)
+}
+
+// queue sends data to this client only. The hub lock keeps the send channel
+// from being closed by an unregister in the meantime.
+func (c *Client) queue(data []byte) {
+	c.hub.mu.RLock()
+	defer c.hub.mu.RUnlock()
+	if !c.hub.clients[c] {
+		return
+	}
+	c.hub.offer(c, data)
+}
+
+// join adds a client to a channel, unless it has been unregistered already
+func (h *Hub) join(client *Client, channel string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.clients[client] {
+		return
+	}
+
+	client.mu.Lock()
+	client.channels[channel] = true
+	client.mu.Unlock()
+
+	if _, exists := h.channels[channel]; !exists {
+		h.channels[channel] = make(map[*Client]bool)
+	}
+	h.channels[channel][client] = true
+}
+
+func (h *Hub) leave(client *Client, channel string) {
+	client.mu.Lock()
+	delete(client.channels, channel)
+	client.mu.Unlock()
+
+	h.mu.Lock()
+	if followers, exists := h.channels[channel]; exists {
+		delete(followers, client)
+		if len(followers) == 0 {
+			delete(h.channels, channel)
+		}
+	}
+	h.mu.Unlock()
+}
+
+// RecheckGroup re-validates every subscription to a group and its polls on
+// every hub, e.g. after the group was deleted or stopped sharing its polls
+// with subgroups
+func (h *Hub) RecheckGroup(db *mongo.Database, groupID string) {
+	h.recheckGroup(db, groupID)
+	h.publishControl(hubControl{Action: controlRecheckGroup, GroupID: groupID})
+}
+
+func (h *Hub) recheckGroup(db *mongo.Database, groupID string) {
+	h.mu.RLock()
+	channels := make([]string, 0, len(h.channels))
+	for channel := range h.channels {
+		channels = append(channels, channel)
+	}
+	h.mu.RUnlock()
+
+	recheck := []string{GroupChannel(groupID)}
+	if groupObjID, err := primitive.ObjectIDFromHex(groupID); err == nil {
+		pollChannels, err := groupPollChannels(db, groupObjID, channels)
+		if err != nil {
+			log.Printf("Failed to find poll subscriptions of group %s: %v", groupID, err)
+		}
+		recheck = append(recheck, pollChannels...)
+	}
+
+	for _, channel := range recheck {
+		h.recheckChannel(db, channel)
+	}
+}
+
+// RecheckPoll re-validates every subscription to a poll on every hub, e.g.
+// after it was deleted
+func (h *Hub) RecheckPoll(db *mongo.Database, pollID string) {
+	h.recheckChannel(db, PollChannel(pollID))
+	h.publishControl(hubControl{Action: controlRecheckPoll, PollID: pollID})
+}
+
+// recheckChannel re-validates every subscription to one channel
+func (h *Hub) recheckChannel(db *mongo.Database, channel string) {
+	h.mu.RLock()
+	var clients []*Client
+	for client := range h.channels[channel] {
+		clients = append(clients, client)
+	}
+	h.mu.RUnlock()
+
+	for _, client := range clients {
+		h.recheck(db, client, channel)
+	}
+}
+
+// RecheckUser re-validates every subscription of a user on every hub, e.g.
+// after they left or were removed from a group
+func (h *Hub) RecheckUser(db *mongo.Database, userID primitive.ObjectID) {
+	h.recheckUser(db, userID)
+	h.publishControl(hubControl{Action: controlRecheckUser, UserID: userID.Hex()})
+}
+
+func (h *Hub) recheckUser(db *mongo.Database, userID primitive.ObjectID) {
+	h.mu.RLock()
+	var clients []*Client
+	for client := range h.clients {
+		if client.userID == userID {
+			clients = append(clients, client)
+		}
+	}
+	h.mu.RUnlock()
+
+	for _, client := range clients {
+		client.mu.Lock()
+		channels := make([]string, 0, len(client.channels))
+		for channel := range client.channels {
+			channels = append(channels, channel)
+		}
+		client.mu.Unlock()
+
+		for _, channel := range channels {
+			h.recheck(db, client, channel)
+		}
+	}
+}
+
+// recheck drops a client from a channel it may no longer see. It waits for a
+// subscription in progress, so the check sees its presence entry.
+func (h *Hub) recheck(db *mongo.Database, client *Client, channel string) {
+	client.follows.Lock()
+	defer client.follows.Unlock()
+
+	client.mu.Lock()
+	subscribed := client.channels[channel]
+	client.mu.Unlock()
+	if subscribed {
+		h.keepIfAllowed(db, client, channel)
+	}
+}
+
+// keepIfAllowed checks a subscription and drops it if the client may no longer
+// see the channel. On database errors the subscription is kept; the next
+// membership change checks it again. The caller holds client.follows.
+func (h *Hub) keepIfAllowed(db *mongo.Database, client *Client, channel string) bool {
+	allowed, _, err := canSubscribe(db, channel, client.userID)
+	if err != nil {
+		log.Printf("Failed to recheck channel %s subscription for user %s: %v", channel, client.userID.Hex(), err)
+		return true
+	}
+	if allowed {
+		return true
+	}
+
+	h.leave(client, channel)
+	client.markAbsent(channel)
+	reply := channelMessage(messageUnsubscribed, channel)
+	reply.Error = "You no longer have access to this channel"
+	client.reply(reply)
+	return false
+}
+
 func (c *Client) writePump() {
 	ticker := time.NewTicker(54 * time.Second)
 	defer func() {
@@ -193,59 +538,135 @@ func (c *Client) writePump() {
 			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
 				return
 			}
+
+		case <-c.restarting:
+			for _, message := range c.pending() {
+				c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+				if err := c.conn.WriteMessage(websocket.TextMessage, message); err != nil {
+					return
+				}
+			}
+			closeMessage := websocket.FormatCloseMessage(websocket.CloseServiceRestart, restartCloseReason)
+			c.conn.WriteControl(websocket.CloseMessage, closeMessage, time.Now().Add(time.Second))
+			return
 		}
 	}
 }
 
-// BroadcastToGroup sends a message to all clients in a specific group
-func (h *Hub) BroadcastToGroup(groupID string, message []byte) {
+// BroadcastToChannel sends a message to all clients following a channel.
+// Clients that cannot keep up are disconnected rather than holding up the others.
+func (h *Hub) BroadcastToChannel(channel string, message []byte) {
 	h.mu.RLock()
-	if group, exists := h.groups[groupID]; exists {
-		for client := range group {
-			select {
-			case client.send <- message:
-			default:
-				close(client.send)
-				delete(h.clients, client)
-			}
+	defer h.mu.RUnlock()
+	for client := range h.channels[channel] {
+		h.offer(client, message)
+	}
+}
+
+// DisconnectSessions closes the connections that were opened with any of the
+// given sessions, on every hub
+func (h *Hub) DisconnectSessions(sessionIDs ...string) {
+	if len(sessionIDs) == 0 {
+		return
+	}
+	h.disconnectSessions(sessionIDs)
+	h.publishControl(hubControl{Action: controlDisconnectSessions, SessionIDs: sessionIDs})
+}
+
+func (h *Hub) disconnectSessions(sessionIDs []string) {
+	revoked := make(map[string]bool, len(sessionIDs))
+	for _, id := range sessionIDs {
+		revoked[id] = true
+	}
+	h.disconnect(func(client *Client) bool {
+		return revoked[client.sessionID]
+	}, websocket.ClosePolicyViolation, "session revoked")
+}
+
+// DisconnectUser closes every connection of a user, on every hub
+func (h *Hub) DisconnectUser(userID primitive.ObjectID) {
+	h.disconnectUser(userID)
+	h.publishControl(hubControl{Action: controlDisconnectUser, UserID: userID.Hex()})
+}
+
+func (h *Hub) disconnectUser(userID primitive.ObjectID) {
+	h.disconnect(func(client *Client) bool {
+		return client.userID == userID
+	}, websocket.ClosePolicyViolation, "user removed")
+}
+
+// disconnect closes the connections of the matching clients, with a close
+// frame for WebSockets. Their transport then unregisters them.
+func (h *Hub) disconnect(match func(*Client) bool, code int, reason string) {
+	var targets []*Client
+	h.mu.RLock()
+	for client := range h.clients {
+		if match(client) {
+			targets = append(targets, client)
 		}
 	}
 	h.mu.RUnlock()
+
+	for _, client := range targets {
+		client.closeConn(code, reason)
+	}
 }
 
 // HandleWebSocket upgrades the HTTP connection to a WebSocket connection
 func HandleWebSocket(c *gin.Context) {
-	// Get token from URL parameters
+	hub.ServeWebSocket(c)
+}
+
+// authenticateStream checks the access token of a WebSocket or event stream
+// connection. Browsers cannot set headers on either, so the token may be given
+// as ?token= as well as in the Authorization header.
+func authenticateStream(c *gin.Context) (*mongo.Database, models.Session, bool) {
 	token := c.Query("token")
 	if token == "" {
-		log.Println("No token provided in WebSocket connection")
+		token = strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+	}
+	if token == "" {
+		log.Println("No token provided in streaming connection")
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "No token provided"})
-		return
+		return nil, models.Session{}, false
 	}
 
-	// Validate token
-	claims := jwt.MapClaims{}
-	parsedToken, err := jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
-		return []byte(os.Getenv("JWT_SECRET")), nil
-	})
-
-	if err != nil || !parsedToken.Valid {
-		log.Printf("Invalid WebSocket token: %v", err)
+	// Validate token and its session
+	db := c.MustGet("db").(*mongo.Database)
+	session, err := authenticateToken(db, token)
+	if err == errSessionRevoked {
+		log.Println("Streaming connection token from revoked session")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Session has been revoked"})
+		return nil, models.Session{}, false
+	}
+	if err != nil {
+		log.Printf("Invalid streaming connection token: %v", err)
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
-		return
+		return nil, models.Session{}, false
+	}
+	if session.TwoFactorSetupRequired {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Two-factor authentication must be enabled for admin accounts"})
+		return nil, models.Session{}, false
 	}
 
-	userIDStr, ok := claims["user_id"].(string)
+	touchSession(db, session, c.ClientIP())
+	return db, session, true
+}
+
+// ServeWebSocket upgrades the HTTP connection to a WebSocket connection served by this hub
+func (h *Hub) ServeWebSocket(c *gin.Context) {
+	if h.refuseWhileClosing(c) {
+		return
+	}
+	db, session, ok := authenticateStream(c)
 	if !ok {
-		log.Println("No user_id in token claims")
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token claims"})
 		return
 	}
 
-	userID, err := primitive.ObjectIDFromHex(userIDStr)
-	if err != nil {
-		log.Printf("Invalid user ID format: %v", err)
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+	// Refuse before upgrading while the answer can still be an HTTP error
+	client := h.newClient(db, session, c.ClientIP())
+	if err := h.admit(client); err != nil {
+		h.refuse(c, err)
 		return
 	}
 
@@ -256,75 +677,70 @@ func HandleWebSocket(c *gin.Context) {
 		return
 	}
 
-	client := &Client{
-		conn:   conn,
-		userID: userID,
-		groups: make(map[string]bool),
-		send:   make(chan []byte, 256),
-		hub:    hub,
+	client.conn = conn
+	client.closeConn = func(code int, reason string) {
+		closeMessage := websocket.FormatCloseMessage(code, reason)
+		conn.WriteControl(websocket.CloseMessage, closeMessage, time.Now().Add(time.Second))
+		conn.Close()
+	}
+	// Other connections may have taken the last slots since admit
+	if err := h.addClient(client); err != nil {
+		if err == errHubClosing {
+			client.closeConn(websocket.CloseServiceRestart, restartCloseReason)
+		} else {
+			client.closeConn(websocket.CloseTryAgainLater, err.Error())
+		}
+		return
 	}
-
-	client.hub.register <- client
 
 	go client.writePump()
 	go client.readPump()
 }
 
-// NotifyPollUpdate sends a poll update to all clients in the group
-func NotifyPollUpdate(groupID string, pollID string, updateType string) {
-	message := Message{
-		Type: "poll_update",
-		Data: map[string]string{
-			"group_id": groupID,
-			"poll_id":  pollID,
-			"type":     updateType,
-		},
-	}
-
-	data, err := json.Marshal(message)
-	if err != nil {
-		log.Printf("error marshaling poll update: %v", err)
+// NotifyPollUpdate sends a created or closed poll to the clients following
+// the poll and its group. New public polls are also announced on the public channel.
+func NotifyPollUpdate(update PollUpdate) {
+	data, ok := marshalMessage("poll_update", update)
+	if !ok {
 		return
 	}
 
-	hub.BroadcastToGroup(groupID, data)
-}
-
-// NotifyVoteUpdate sends a vote update to all clients in the group
-func NotifyVoteUpdate(groupID string, pollID string) {
-	message := Message{
-		Type: "vote_update",
-		Data: map[string]string{
-			"group_id": groupID,
-			"poll_id":  pollID,
-		},
+	publishPollEvent(update.GroupID, update.PollID, data)
+	if update.GroupID == "" && update.Type == "created" {
+		hub.Publish(PublicChannel, data)
 	}
+}
 
-	data, err := json.Marshal(message)
-	if err != nil {
-		log.Printf("error marshaling vote update: %v", err)
-		return
+// NotifyVoteUpdate sends a poll's updated tallies to the clients following the poll and its group
+func NotifyVoteUpdate(update VoteUpdate) {
+	if data, ok := marshalMessage("vote_update", update); ok {
+		publishPollEvent(update.GroupID, update.PollID, data)
 	}
-
-	hub.BroadcastToGroup(groupID, data)
 }
 
-// NotifyCommentUpdate sends a comment update to all clients in the group
-func NotifyCommentUpdate(groupID string, pollID string, updateType string) {
-	message := Message{
-		Type: "comment_update",
-		Data: map[string]string{
-			"group_id": groupID,
-			"poll_id":  pollID,
-			"type":     updateType,
-		},
+// NotifyCommentUpdate sends a created or deleted comment to the clients
+// following the poll and its group
+func NotifyCommentUpdate(update CommentUpdate) {
+	if data, ok := marshalMessage("comment_update", update); ok {
+		publishPollEvent(update.GroupID, update.PollID, data)
 	}
+}
 
-	data, err := json.Marshal(message)
+// marshalMessage encodes an event message
+func marshalMessage(messageType string, data interface{}) ([]byte, bool) {
+	encoded, err := json.Marshal(Message{Type: messageType, Data: data})
 	if err != nil {
-		log.Printf("error marshaling comment update: %v", err)
-		return
+		log.Printf("error marshaling %s: %v", messageType, err)
+		return nil, false
 	}
+	return encoded, true
+}
 
-	hub.BroadcastToGroup(groupID, data)
+// publishPollEvent sends a message about a poll to the poll's channel and, for
+// group polls, the group's channel
+func publishPollEvent(groupID, pollID string, data []byte) {
+	hub.Publish(PollChannel(pollID), data)
+	if groupID != "" {
+		hub.Publish(GroupChannel(groupID), data)
+	}
 }
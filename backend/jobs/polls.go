@@ -0,0 +1,72 @@
+package jobs
+
+import (
+	"context"
+	"log"
+	"time"
+	"voteverse/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const pollCloseInterval = time.Minute
+
+// RunPollCloser deactivates polls once their end time has passed and calls
+// closed for each one. It runs once immediately and then every minute until
+// ctx is done.
+func RunPollCloser(ctx context.Context, db *mongo.Database, closed func(models.Poll)) {
+	ticker := time.NewTicker(pollCloseInterval)
+	defer ticker.Stop()
+
+	for {
+		CloseExpiredPolls(ctx, db, closed)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// CloseExpiredPolls runs a single pass and returns how many polls it closed.
+// closed is only called by the instance that actually flipped is_active, so
+// each poll is reported once even with several instances running.
+func CloseExpiredPolls(ctx context.Context, db *mongo.Database, closed func(models.Poll)) int {
+	cursor, err := db.Collection("polls").Find(ctx, bson.M{
+		"is_active":  true,
+		"end_time":   bson.M{"$lt": primitive.NewDateTimeFromTime(time.Now())},
+		"deleted_at": nil,
+	})
+	if err != nil {
+		log.Printf("Poll closer: failed to find expired polls: %v", err)
+		return 0
+	}
+	defer cursor.Close(ctx)
+
+	var polls []models.Poll
+	if err := cursor.All(ctx, &polls); err != nil {
+		log.Printf("Poll closer: failed to decode expired polls: %v", err)
+		return 0
+	}
+
+	count := 0
+	for _, poll := range polls {
+		result, err := db.Collection("polls").UpdateOne(ctx,
+			bson.M{"_id": poll.ID, "is_active": true},
+			bson.M{"$set": bson.M{"is_active": false}},
+		)
+		if err != nil {
+			log.Printf("Poll closer: failed to close poll %s: %v", poll.ID.Hex(), err)
+			continue
+		}
+		if result.ModifiedCount == 1 {
+			count++
+			poll.IsActive = false
+			closed(poll)
+		}
+	}
+	return count
+}
@@ -0,0 +1,92 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"strconv"
+	"time"
+	"voteverse/services"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	defaultRetentionDays = 30
+	purgeInterval        = time.Hour
+)
+
+// RetentionFromEnv returns how long soft-deleted documents are kept before they
+// are purged, read from SOFT_DELETE_RETENTION_DAYS (default 30 days)
+func RetentionFromEnv() time.Duration {
+	days, err := strconv.Atoi(os.Getenv("SOFT_DELETE_RETENTION_DAYS"))
+	if err != nil || days < 0 {
+		days = defaultRetentionDays
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// RunPurge permanently removes soft-deleted documents once they are older than
+// the retention window. It runs once immediately and then every hour until ctx is done.
+func RunPurge(ctx context.Context, db *mongo.Database, retention time.Duration) {
+	ticker := time.NewTicker(purgeInterval)
+	defer ticker.Stop()
+
+	for {
+		PurgeExpired(ctx, db, retention)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// PurgeExpired runs a single purge pass. Each expired document is removed with
+// its dependents through the cascade service, one transaction per document.
+func PurgeExpired(ctx context.Context, db *mongo.Database, retention time.Duration) {
+	cutoff := primitive.NewDateTimeFromTime(time.Now().Add(-retention))
+	expired := bson.M{"deleted_at": bson.M{"$lt": cutoff}}
+	cascade := services.NewCascadeService(db)
+	hardDelete := services.DeleteOptions{Mode: services.HardDelete}
+
+	// Parents go first so their dependents are removed in the same transaction
+	purges := []struct {
+		collection string
+		purge      func(context.Context, primitive.ObjectID, services.DeleteOptions) (services.DeleteCounts, error)
+	}{
+		{"groups", cascade.DeleteGroup},
+		{"polls", cascade.DeletePoll},
+		{"comments", cascade.DeleteComment},
+		{"users", cascade.DeleteUser},
+	}
+
+	for _, p := range purges {
+		ids, err := db.Collection(p.collection).Distinct(ctx, "_id", expired)
+		if err != nil {
+			log.Printf("Purge: failed to find expired %s: %v", p.collection, err)
+			continue
+		}
+
+		for _, value := range ids {
+			id, ok := value.(primitive.ObjectID)
+			if !ok {
+				continue
+			}
+			counts, err := p.purge(ctx, id, hardDelete)
+			if errors.Is(err, services.ErrNotFound) {
+				// Already removed by an earlier cascade in this pass
+				continue
+			}
+			if err != nil {
+				log.Printf("Purge: failed to delete %s %s: %v", p.collection, id.Hex(), err)
+				continue
+			}
+			log.Printf("Purge: permanently deleted %s %s: %v", p.collection, id.Hex(), counts)
+		}
+	}
+}
@@ -2,10 +2,25 @@ package main
 
 import (
 	"context"
+	"errors"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"voteverse/broker"
 	"voteverse/database"
+	"voteverse/handlers"
+	"voteverse/jobs"
+	"voteverse/mailer"
+	"voteverse/models"
+	"voteverse/oidc"
 	"voteverse/routes"
+	"voteverse/signing"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
@@ -31,7 +46,11 @@ func main() {
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer client.Disconnect(context.Background())
+	defer func() {
+		if err := client.Disconnect(context.Background()); err != nil {
+			log.Printf("Failed to disconnect from MongoDB: %v", err)
+		}
+	}()
 
 	// Ping the database
 	if err := client.Ping(context.Background(), nil); err != nil {
@@ -50,9 +69,61 @@ func main() {
 		log.Fatal(err)
 	}
 
+	// Load the keys access tokens are signed with
+	tokenKeys, err := signing.FromEnv()
+	if err != nil {
+		log.Fatalf("Failed to load token signing keys: %v", err)
+	}
+	handlers.SetTokenKeys(tokenKeys)
+	log.Printf("Signing access tokens with key %s", tokenKeys.SigningKeyID())
+
+	// Share WebSocket events with the other server instances
+	eventBroker, err := broker.FromEnv(context.Background(), db)
+	if err != nil {
+		log.Fatalf("Failed to start event broker: %v", err)
+	}
+	defer eventBroker.Close()
+	handlers.SetBroker(eventBroker)
+
+	// Configure outgoing email
+	handlers.SetMailer(mailer.FromEnv())
+
+	// Configure single sign-on if an identity provider is set
+	if oidcConfig, ok := oidc.ConfigFromEnv(); ok {
+		provider, err := oidc.NewProvider(context.Background(), oidcConfig)
+		if err != nil {
+			log.Printf("Single sign-on disabled: %v", err)
+		} else {
+			handlers.SetOIDCProvider(provider)
+			log.Printf("Single sign-on enabled with %s", provider.Issuer())
+		}
+	}
+
+	// Start background jobs
+	jobsCtx, stopJobs := context.WithCancel(context.Background())
+	defer stopJobs()
+	var workers sync.WaitGroup
+	workers.Add(2)
+	go func() {
+		defer workers.Done()
+		jobs.RunPurge(jobsCtx, db, jobs.RetentionFromEnv())
+	}()
+	go func() {
+		defer workers.Done()
+		jobs.RunPollCloser(jobsCtx, db, func(poll models.Poll) {
+			handlers.NotifyPollClosed(db, poll)
+		})
+	}()
+
 	// Create Gin router
 	r := gin.Default()
 
+	// Only trusted proxies may set the client address with X-Forwarded-For,
+	// otherwise anyone could dodge the per-address limits by changing it
+	if err := r.SetTrustedProxies(trustedProxies()); err != nil {
+		log.Fatalf("Invalid TRUSTED_PROXIES: %v", err)
+	}
+
 	// Configure CORS
 	config := cors.DefaultConfig()
 	config.AllowOrigins = []string{"*"}
@@ -74,5 +145,72 @@ func main() {
 	if port == "" {
 		port = "8080"
 	}
-	r.Run(":" + port)
+	srv := &http.Server{
+		Addr:    ":" + port,
+		Handler: r,
+	}
+
+	stop, stopSignals := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stopSignals()
+
+	serveErr := make(chan error, 1)
+	go func() {
+		log.Printf("Listening on %s", srv.Addr)
+		serveErr <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serveErr:
+		if !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("Server failed: %v", err)
+		}
+	case <-stop.Done():
+	}
+	stopSignals()
+
+	// Stop taking requests and let WebSocket and event stream clients move to
+	// another instance. The deferred calls then close the broker and MongoDB.
+	timeout := shutdownTimeout()
+	log.Printf("Shutting down, waiting up to %s for connections to close", timeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	// Streams are drained alongside srv.Shutdown, which waits for event
+	// streams to end but not for hijacked WebSocket connections
+	drained := make(chan error, 1)
+	go func() {
+		drained <- handlers.Shutdown(shutdownCtx)
+	}()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Failed to finish open requests: %v", err)
+	}
+	if err := <-drained; err != nil {
+		log.Printf("Streaming connections did not close in time: %v", err)
+	}
+
+	stopJobs()
+	workers.Wait()
+	log.Println("Server stopped")
+}
+
+// shutdownTimeout is how long open connections get to close on shutdown, read
+// from SHUTDOWN_TIMEOUT_SECONDS (default 30)
+func shutdownTimeout() time.Duration {
+	seconds, err := strconv.Atoi(os.Getenv("SHUTDOWN_TIMEOUT_SECONDS"))
+	if err != nil || seconds <= 0 {
+		seconds = 30
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// trustedProxies reads TRUSTED_PROXIES, a comma-separated list of addresses or
+// CIDR ranges of the reverse proxies in front of the server (default none)
+func trustedProxies() []string {
+	var proxies []string
+	for _, proxy := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			proxies = append(proxies, proxy)
+		}
+	}
+	return proxies
 }
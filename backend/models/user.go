@@ -9,27 +9,82 @@ const (
 	RoleAdmin = "admin"
 )
 
+// Who may create polls in a group
+const (
+	PollCreationMembers = "members"
+	PollCreationAdmins  = "admins"
+)
+
+// How a user signed in
+const (
+	SignInMethodPassword  = "password"
+	SignInMethodTwoFactor = "two_factor"
+	SignInMethodOIDC      = "oidc"
+)
+
+// What an API token may be used for
+const (
+	ScopePollsRead   = "polls:read"
+	ScopePollsWrite  = "polls:write"
+	ScopeGroupsRead  = "groups:read"
+	ScopeGroupsWrite = "groups:write"
+)
+
+// When poll results are shown to voters
+const (
+	ResultsVisibilityAlways     = "always"
+	ResultsVisibilityAfterVote  = "after_vote"
+	ResultsVisibilityAfterClose = "after_close"
+)
+
 // User represents a user in the system
 type User struct {
-	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
-	Username  string             `bson:"username" json:"username" binding:"required"`
-	Email     string             `bson:"email" json:"email" binding:"required,email"`
-	Password  string             `bson:"password" json:"-" binding:"required"`
-	Role      string             `bson:"role" json:"role"`
-	CreatedAt primitive.DateTime `bson:"created_at" json:"created_at"`
-	UpdatedAt primitive.DateTime `bson:"updated_at" json:"updated_at"`
+	ID                     primitive.ObjectID  `bson:"_id,omitempty" json:"id,omitempty"`
+	Username               string              `bson:"username" json:"username" binding:"required"`
+	Email                  string              `bson:"email" json:"email" binding:"required,email"`
+	DisplayName            string              `bson:"display_name,omitempty" json:"display_name,omitempty"`
+	AvatarURL              string              `bson:"avatar_url,omitempty" json:"avatar_url,omitempty"`
+	Password               string              `bson:"password" json:"-" binding:"required"`
+	Role                   string              `bson:"role" json:"role"`
+	EmailVerified          bool                `bson:"email_verified" json:"email_verified"`
+	TwoFactorEnabled       bool                `bson:"two_factor_enabled" json:"two_factor_enabled"`
+	TwoFactorSecret        string              `bson:"two_factor_secret,omitempty" json:"-"`
+	PendingTwoFactorSecret string              `bson:"pending_two_factor_secret,omitempty" json:"-"` // Set during enrolment until the first code is confirmed
+	TwoFactorLastStep      int64               `bson:"two_factor_last_step,omitempty" json:"-"`      // Last accepted TOTP time step, so codes cannot be replayed
+	RecoveryCodeHashes     []string            `bson:"recovery_code_hashes,omitempty" json:"-"`
+	ExternalIdentities     []ExternalIdentity  `bson:"external_identities,omitempty" json:"-"`               // Single sign-on accounts linked to this user
+	LockedUntil            *primitive.DateTime `bson:"locked_until,omitempty" json:"locked_until,omitempty"` // Set after too many failed sign-ins
+	CreatedAt              primitive.DateTime  `bson:"created_at" json:"created_at"`
+	UpdatedAt              primitive.DateTime  `bson:"updated_at" json:"updated_at"`
+	DeletedAt              *primitive.DateTime `bson:"deleted_at,omitempty" json:"deleted_at,omitempty"`
+	DeletedBy              primitive.ObjectID  `bson:"deleted_by,omitempty" json:"deleted_by,omitempty"`
 }
 
 // Group represents a group where polls can be created
 type Group struct {
-	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
-	Name        string             `bson:"name" json:"name" binding:"required"`
-	Description string             `bson:"description" json:"description"`
-	CreatedBy   primitive.ObjectID `bson:"created_by" json:"created_by"`
-	CreatedAt   primitive.DateTime `bson:"created_at" json:"created_at"`
-	UpdatedAt   primitive.DateTime `bson:"updated_at" json:"updated_at"`
-	IsActive    bool               `bson:"is_active" json:"is_active"`
-	IsMember    bool               `bson:"-" json:"is_member"` // Not stored in DB, computed on the fly
+	ID          primitive.ObjectID   `bson:"_id,omitempty" json:"id,omitempty"`
+	Name        string               `bson:"name" json:"name" binding:"required"`
+	Description string               `bson:"description" json:"description"`
+	AvatarURL   string               `bson:"avatar_url,omitempty" json:"avatar_url,omitempty"`
+	ParentID    primitive.ObjectID   `bson:"parent_id,omitempty" json:"parent_id,omitempty"` // Set for subgroups
+	Ancestors   []primitive.ObjectID `bson:"ancestors,omitempty" json:"ancestors,omitempty"` // Root first, ending with ParentID
+	Settings    GroupSettings        `bson:"settings" json:"settings"`
+	CreatedBy   primitive.ObjectID   `bson:"created_by" json:"created_by"`
+	CreatedAt   primitive.DateTime   `bson:"created_at" json:"created_at"`
+	UpdatedAt   primitive.DateTime   `bson:"updated_at" json:"updated_at"`
+	IsActive    bool                 `bson:"is_active" json:"is_active"`
+	DeletedAt   *primitive.DateTime  `bson:"deleted_at,omitempty" json:"deleted_at,omitempty"`
+	DeletedBy   primitive.ObjectID   `bson:"deleted_by,omitempty" json:"deleted_by,omitempty"`
+	IsMember    bool                 `bson:"-" json:"is_member"` // Not stored in DB, computed on the fly
+}
+
+// GroupSettings holds per-group configuration and the defaults applied to new polls
+type GroupSettings struct {
+	PollCreation             string `bson:"poll_creation" json:"poll_creation"` // "members" or "admins"
+	DefaultPollDurationHours int    `bson:"default_poll_duration_hours" json:"default_poll_duration_hours"`
+	DefaultResultsVisibility string `bson:"default_results_visibility" json:"default_results_visibility"` // "always", "after_vote" or "after_close"
+	MembersCanComment        *bool  `bson:"members_can_comment,omitempty" json:"members_can_comment,omitempty"`
+	SharePollsWithSubgroups  bool   `bson:"share_polls_with_subgroups" json:"share_polls_with_subgroups"` // Subgroup members can see this group's polls
 }
 
 // GroupMember represents a user's membership in a group
@@ -44,18 +99,21 @@ type GroupMember struct {
 
 // Poll represents a poll in a group
 type Poll struct {
-	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
-	GroupID     primitive.ObjectID `bson:"group_id,omitempty" json:"group_id,omitempty"` // Optional for public polls
-	CreatedBy   primitive.ObjectID `bson:"created_by" json:"created_by"`
-	Title       string             `bson:"title" json:"title" binding:"required"`
-	Description string             `bson:"description" json:"description"`
-	Options     []PollOption       `bson:"options" json:"options" binding:"required,min=2"`
-	StartTime   primitive.DateTime `bson:"start_time" json:"start_time"`
-	EndTime     primitive.DateTime `bson:"end_time" json:"end_time"`
-	CreatedAt   primitive.DateTime `bson:"created_at" json:"created_at"`
-	UpdatedAt   primitive.DateTime `bson:"updated_at" json:"updated_at"`
-	IsActive    bool               `bson:"is_active" json:"is_active"`
-	Visibility  string             `bson:"visibility" json:"visibility"` // "public" or "group"
+	ID                primitive.ObjectID  `bson:"_id,omitempty" json:"id,omitempty"`
+	GroupID           primitive.ObjectID  `bson:"group_id,omitempty" json:"group_id,omitempty"` // Optional for public polls
+	CreatedBy         primitive.ObjectID  `bson:"created_by" json:"created_by"`
+	Title             string              `bson:"title" json:"title" binding:"required"`
+	Description       string              `bson:"description" json:"description"`
+	Options           []PollOption        `bson:"options" json:"options" binding:"required,min=2"`
+	StartTime         primitive.DateTime  `bson:"start_time" json:"start_time"`
+	EndTime           primitive.DateTime  `bson:"end_time" json:"end_time"`
+	CreatedAt         primitive.DateTime  `bson:"created_at" json:"created_at"`
+	UpdatedAt         primitive.DateTime  `bson:"updated_at" json:"updated_at"`
+	IsActive          bool                `bson:"is_active" json:"is_active"`
+	Visibility        string              `bson:"visibility" json:"visibility"`                                     // "public" or "group"
+	ResultsVisibility string              `bson:"results_visibility,omitempty" json:"results_visibility,omitempty"` // "always", "after_vote" or "after_close"
+	DeletedAt         *primitive.DateTime `bson:"deleted_at,omitempty" json:"deleted_at,omitempty"`
+	DeletedBy         primitive.ObjectID  `bson:"deleted_by,omitempty" json:"deleted_by,omitempty"`
 }
 
 // PollOption represents an option in a poll
@@ -78,10 +136,166 @@ type Vote struct {
 
 // Comment represents a comment on a poll
 type Comment struct {
+	ID        primitive.ObjectID  `bson:"_id,omitempty" json:"id,omitempty"`
+	PollID    primitive.ObjectID  `bson:"poll_id" json:"poll_id"`
+	UserID    primitive.ObjectID  `bson:"user_id" json:"user_id"` // Unset once the author deletes their account
+	Text      string              `bson:"text" json:"text" binding:"required"`
+	CreatedAt primitive.DateTime  `bson:"created_at" json:"created_at"`
+	UpdatedAt primitive.DateTime  `bson:"updated_at" json:"updated_at"`
+	DeletedAt *primitive.DateTime `bson:"deleted_at,omitempty" json:"deleted_at,omitempty"`
+	DeletedBy primitive.ObjectID  `bson:"deleted_by,omitempty" json:"deleted_by,omitempty"`
+}
+
+// Group activity event types
+const (
+	EventMemberJoined   = "member_joined"
+	EventMemberLeft     = "member_left"
+	EventPollCreated    = "poll_created"
+	EventPollClosed     = "poll_closed"
+	EventVoteCast       = "vote_cast"
+	EventCommentPosted  = "comment_posted"
+	EventCommentDeleted = "comment_deleted"
+)
+
+// GroupEvent represents an entry in a group's activity feed
+type GroupEvent struct {
 	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
-	PollID    primitive.ObjectID `bson:"poll_id" json:"poll_id"`
-	UserID    primitive.ObjectID `bson:"user_id" json:"user_id"`
-	Text      string             `bson:"text" json:"text" binding:"required"`
+	GroupID   primitive.ObjectID `bson:"group_id" json:"group_id"`
+	Type      string             `bson:"type" json:"type"`
+	ActorID   primitive.ObjectID `bson:"actor_id,omitempty" json:"actor_id,omitempty"` // Not set for votes, which are anonymous
+	PollID    primitive.ObjectID `bson:"poll_id,omitempty" json:"poll_id,omitempty"`
+	CommentID primitive.ObjectID `bson:"comment_id,omitempty" json:"comment_id,omitempty"`
+	VoteCount int                `bson:"vote_count,omitempty" json:"vote_count,omitempty"` // Total votes on the poll after a vote_cast
 	CreatedAt primitive.DateTime `bson:"created_at" json:"created_at"`
-	UpdatedAt primitive.DateTime `bson:"updated_at" json:"updated_at"`
+}
+
+// Session represents a signed-in device. Access tokens carry the session ID and
+// are only accepted while the session is active. The refresh token is stored
+// hashed and replaced on every refresh.
+type Session struct {
+	ID                     primitive.ObjectID  `bson:"_id,omitempty" json:"id,omitempty"`
+	UserID                 primitive.ObjectID  `bson:"user_id" json:"user_id"`
+	RefreshTokenHash       string              `bson:"refresh_token_hash" json:"-"`
+	PreviousTokenHash      string              `bson:"previous_token_hash,omitempty" json:"-"` // Used to detect refresh token reuse
+	UserAgent              string              `bson:"user_agent" json:"user_agent"`
+	IPAddress              string              `bson:"ip_address" json:"ip_address"`
+	CreatedAt              primitive.DateTime  `bson:"created_at" json:"created_at"`
+	LastUsedAt             primitive.DateTime  `bson:"last_used_at" json:"last_used_at"`
+	ExpiresAt              primitive.DateTime  `bson:"expires_at" json:"expires_at"`
+	RevokedAt              *primitive.DateTime `bson:"revoked_at,omitempty" json:"revoked_at,omitempty"`
+	TwoFactorSetupRequired bool                `bson:"two_factor_setup_required,omitempty" json:"two_factor_setup_required,omitempty"` // Admin who must enrol in 2FA before doing anything else
+}
+
+// APIToken is a personal access token for scripts. It acts as its user, but
+// only on the routes its scopes allow. Only the hash of the token is stored.
+type APIToken struct {
+	ID         primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	UserID     primitive.ObjectID  `bson:"user_id" json:"user_id"`
+	Name       string              `bson:"name" json:"name"`
+	TokenHash  string              `bson:"token_hash" json:"-"`
+	Prefix     string              `bson:"prefix" json:"prefix"` // Start of the token, so users can tell their tokens apart
+	Scopes     []string            `bson:"scopes" json:"scopes"`
+	CreatedAt  primitive.DateTime  `bson:"created_at" json:"created_at"`
+	ExpiresAt  *primitive.DateTime `bson:"expires_at,omitempty" json:"expires_at,omitempty"` // Never expires when unset
+	LastUsedAt *primitive.DateTime `bson:"last_used_at,omitempty" json:"last_used_at,omitempty"`
+	LastUsedIP string              `bson:"last_used_ip,omitempty" json:"last_used_ip,omitempty"`
+	RevokedAt  *primitive.DateTime `bson:"revoked_at,omitempty" json:"revoked_at,omitempty"`
+}
+
+// PasswordReset is a single-use password reset token. Only the hash of the
+// token that was emailed to the user is stored.
+type PasswordReset struct {
+	ID        primitive.ObjectID  `bson:"_id,omitempty" json:"id,omitempty"`
+	UserID    primitive.ObjectID  `bson:"user_id" json:"user_id"`
+	TokenHash string              `bson:"token_hash" json:"-"`
+	CreatedAt primitive.DateTime  `bson:"created_at" json:"created_at"`
+	ExpiresAt primitive.DateTime  `bson:"expires_at" json:"expires_at"`
+	UsedAt    *primitive.DateTime `bson:"used_at,omitempty" json:"used_at,omitempty"`
+}
+
+// ResetRequest records a request for a password reset email, once for the
+// email address and once for the client address, so that requests can be rate
+// limited whether or not the email belongs to an account.
+type ResetRequest struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	Key       string             `bson:"key" json:"key"` // "email:<email>" or "ip:<address>"
+	CreatedAt primitive.DateTime `bson:"created_at" json:"created_at"`
+	ExpiresAt primitive.DateTime `bson:"expires_at" json:"expires_at"`
+}
+
+// EmailVerification is a single-use token confirming that a user owns an email
+// address. It only verifies the address it was sent to.
+type EmailVerification struct {
+	ID        primitive.ObjectID  `bson:"_id,omitempty" json:"id,omitempty"`
+	UserID    primitive.ObjectID  `bson:"user_id" json:"user_id"`
+	Email     string              `bson:"email" json:"email"`
+	TokenHash string              `bson:"token_hash" json:"-"`
+	CreatedAt primitive.DateTime  `bson:"created_at" json:"created_at"`
+	ExpiresAt primitive.DateTime  `bson:"expires_at" json:"expires_at"`
+	UsedAt    *primitive.DateTime `bson:"used_at,omitempty" json:"used_at,omitempty"`
+}
+
+// SecuritySettings holds site-wide security policies, stored as a single document
+type SecuritySettings struct {
+	ID                    string             `bson:"_id" json:"-"`
+	RequireAdminTwoFactor bool               `bson:"require_admin_two_factor" json:"require_admin_two_factor"`
+	UpdatedBy             primitive.ObjectID `bson:"updated_by,omitempty" json:"updated_by,omitempty"`
+	UpdatedAt             primitive.DateTime `bson:"updated_at,omitempty" json:"updated_at,omitempty"`
+}
+
+// ExternalIdentity links a user to an account at an OpenID Connect provider
+type ExternalIdentity struct {
+	Issuer   string             `bson:"issuer" json:"issuer"`
+	Subject  string             `bson:"subject" json:"subject"`
+	Email    string             `bson:"email,omitempty" json:"email,omitempty"`
+	LinkedAt primitive.DateTime `bson:"linked_at" json:"linked_at"`
+}
+
+// OIDCState tracks a single sign-on attempt between the redirect to the
+// provider and the callback. It can only be used once.
+type OIDCState struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	StateHash string             `bson:"state_hash" json:"-"`
+	// BrowserHash is the hash of the cookie set on the browser that started
+	// the sign-on; the callback must come from that browser
+	BrowserHash string             `bson:"browser_hash" json:"-"`
+	Nonce       string             `bson:"nonce" json:"-"`
+	Verifier    string             `bson:"verifier" json:"-"`
+	CreatedAt   primitive.DateTime `bson:"created_at" json:"created_at"`
+	ExpiresAt   primitive.DateTime `bson:"expires_at" json:"expires_at"`
+}
+
+// SignInThrottle counts recent failed sign-ins for an account or an IP address.
+// Each failure past the free attempts doubles the wait before the next try.
+type SignInThrottle struct {
+	ID            primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	Key           string             `bson:"key" json:"key"` // "account:<email>" or "ip:<address>"
+	Failures      int                `bson:"failures" json:"failures"`
+	LastFailureAt primitive.DateTime `bson:"last_failure_at" json:"last_failure_at"`
+	BlockedUntil  primitive.DateTime `bson:"blocked_until" json:"blocked_until"`
+	ExpiresAt     primitive.DateTime `bson:"expires_at" json:"expires_at"`
+}
+
+// SignInEvent records a sign-in attempt for later review
+type SignInEvent struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID    primitive.ObjectID `bson:"user_id,omitempty" json:"user_id,omitempty"` // Unset when the email did not match an account
+	Email     string             `bson:"email,omitempty" json:"email,omitempty"`
+	Method    string             `bson:"method" json:"method"`
+	Success   bool               `bson:"success" json:"success"`
+	Reason    string             `bson:"reason,omitempty" json:"reason,omitempty"`
+	IPAddress string             `bson:"ip_address" json:"ip_address"`
+	UserAgent string             `bson:"user_agent" json:"user_agent"`
+	CreatedAt primitive.DateTime `bson:"created_at" json:"created_at"`
+}
+
+// Presence records that a WebSocket or event stream connection follows a
+// group or poll channel. Connections refresh their entries while they are
+// alive, so the entries of lost connections expire.
+type Presence struct {
+	ID           primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	ConnectionID string             `bson:"connection_id" json:"connection_id"`
+	Channel      string             `bson:"channel" json:"channel"` // "group:<id>" or "poll:<id>"
+	UserID       primitive.ObjectID `bson:"user_id" json:"user_id"`
+	ExpiresAt    primitive.DateTime `bson:"expires_at" json:"expires_at"`
 }
@@ -9,13 +9,28 @@ import (
 
 func SetupRoutes(r *gin.Engine, db *mongo.Database) {
 	// Public routes
+	r.GET("/.well-known/jwks.json", handlers.GetJWKS)
 	r.POST("/api/auth/signup", wrapHandler(handlers.SignUp))
 	r.POST("/api/auth/signin", wrapHandler(handlers.SignIn))
+	r.POST("/api/auth/refresh", wrapHandler(handlers.RefreshToken))
+	r.POST("/api/auth/password-reset/request", wrapHandler(handlers.RequestPasswordReset))
+	r.POST("/api/auth/password-reset/confirm", wrapHandler(handlers.ConfirmPasswordReset))
+	r.POST("/api/auth/verify-email", wrapHandler(handlers.VerifyEmail))
+	r.POST("/api/auth/2fa/verify", wrapHandler(handlers.VerifyTwoFactorChallenge))
+	r.GET("/api/auth/oidc/login", wrapHandler(handlers.OIDCLogin))
+	r.POST("/api/auth/oidc/callback", wrapHandler(handlers.OIDCCallback))
+
+	// Server-Sent Events; EventSource cannot send headers, so the stream checks ?token= itself
+	r.GET("/api/events", handlers.HandleEvents)
 
 	// Protected routes
 	api := r.Group("/api")
 	api.Use(handlers.AuthMiddleware(db))
 	{
+		// Auth
+		api.POST("/auth/logout", wrapHandler(handlers.Logout))
+		api.POST("/auth/verify-email/resend", wrapHandler(handlers.ResendVerificationEmail))
+
 		// WebSocket
 		api.GET("/ws", handlers.HandleWebSocket)
 
@@ -24,6 +39,9 @@ func SetupRoutes(r *gin.Engine, db *mongo.Database) {
 		api.POST("/groups", handlers.CreateGroup)
 		api.GET("/groups/search", handlers.SearchGroups)
 		api.GET("/groups/:id", handlers.GetGroup)
+		api.PATCH("/groups/:id", handlers.UpdateGroup)
+		api.GET("/groups/:id/activity", handlers.GetGroupActivity)
+		api.GET("/groups/:id/presence", handlers.GetGroupPresence)
 		api.POST("/groups/:id/join", handlers.JoinGroup)
 		api.POST("/groups/:id/leave", handlers.LeaveGroup)
 
@@ -34,6 +52,7 @@ func SetupRoutes(r *gin.Engine, db *mongo.Database) {
 		api.POST("/polls", handlers.CreatePoll)
 		api.POST("/polls/:id/vote", handlers.Vote)
 		api.GET("/polls/:id", handlers.GetPoll)
+		api.GET("/polls/:id/presence", handlers.GetPollPresence)
 
 		// Comments
 		api.POST("/comments/poll/:pollId", handlers.CreateComment)
@@ -42,17 +61,51 @@ func SetupRoutes(r *gin.Engine, db *mongo.Database) {
 
 		// User Profile
 		api.GET("/user/profile", handlers.GetProfile)
+		api.PATCH("/user/profile", wrapHandler(handlers.UpdateProfile))
+		api.POST("/user/password", wrapHandler(handlers.ChangePassword))
+		api.DELETE("/user/account", wrapHandler(handlers.DeleteAccount))
+
+		// Two-factor authentication
+		api.POST("/user/2fa/enroll", wrapHandler(handlers.EnrollTwoFactor))
+		api.POST("/user/2fa/confirm", wrapHandler(handlers.ConfirmTwoFactor))
+		api.POST("/user/2fa/disable", wrapHandler(handlers.DisableTwoFactor))
+
+		// API tokens
+		api.GET("/user/tokens", wrapHandler(handlers.ListAPITokens))
+		api.POST("/user/tokens", wrapHandler(handlers.CreateAPIToken))
+		api.DELETE("/user/tokens/:id", wrapHandler(handlers.RevokeAPIToken))
+
+		// Sessions
+		api.GET("/user/sessions", wrapHandler(handlers.ListSessions))
+		api.DELETE("/user/sessions", wrapHandler(handlers.RevokeOtherSessions))
+		api.DELETE("/user/sessions/:id", wrapHandler(handlers.RevokeSession))
 		
 		// Admin User Management
 		api.GET("/admin/users", wrapHandler(handlers.ListUsers))
 		api.POST("/admin/users", wrapHandler(handlers.CreateUser))
 		api.PUT("/admin/users/:id/role", wrapHandler(handlers.UpdateUserRole))
 		api.DELETE("/admin/users/:id", wrapHandler(handlers.DeleteUser))
+		api.POST("/admin/users/:id/restore", wrapHandler(handlers.AdminRestoreUser))
+		api.POST("/admin/users/:id/unlock", wrapHandler(handlers.AdminUnlockUser))
+		api.GET("/admin/signin-events", wrapHandler(handlers.AdminListSignInEvents))
+		api.GET("/admin/users/:id/sessions", wrapHandler(handlers.AdminListUserSessions))
+		api.DELETE("/admin/users/:id/sessions", wrapHandler(handlers.AdminRevokeUserSessions))
+		api.DELETE("/admin/users/:id/sessions/:sessionId", wrapHandler(handlers.AdminRevokeUserSession))
 		
 		// Admin Group and Poll Management
 		api.DELETE("/admin/groups/:id", wrapHandler(handlers.AdminDeleteGroup))
 		api.DELETE("/admin/polls/:id", wrapHandler(handlers.AdminDeletePoll))
+		api.POST("/admin/groups/:id/restore", wrapHandler(handlers.AdminRestoreGroup))
+		api.POST("/admin/polls/:id/restore", wrapHandler(handlers.AdminRestorePoll))
+		api.POST("/admin/comments/:id/restore", wrapHandler(handlers.AdminRestoreComment))
 		
+		// Admin Security Settings
+		api.GET("/admin/settings/security", wrapHandler(handlers.GetSecuritySettings))
+		api.PUT("/admin/settings/security", wrapHandler(handlers.UpdateSecuritySettings))
+
+		// Admin WebSocket and event stream metrics
+		api.GET("/admin/streams", wrapHandler(handlers.AdminGetStreamMetrics))
+
 		// Explicit Admin routes for getting all groups and polls
 		// These are optional as the regular routes now check for admin role
 		api.GET("/admin/groups/all", wrapHandler(handlers.AdminListAllGroups))
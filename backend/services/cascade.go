@@ -0,0 +1,359 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Collections touched by cascading deletes
+const (
+	groupsCollection        = "groups"
+	pollsCollection         = "polls"
+	votesCollection         = "votes"
+	commentsCollection      = "comments"
+	membersCollection       = "group_members"
+	eventsCollection        = "events"
+	usersCollection         = "users"
+	sessionsCollection      = "sessions"
+	resetsCollection        = "password_resets"
+	verificationsCollection = "email_verifications"
+	apiTokensCollection     = "api_tokens"
+)
+
+// ErrNotFound is returned when the root document of a cascade does not exist
+// (or, for soft deletes, is already deleted)
+var ErrNotFound = errors.New("document not found")
+
+// DeleteMode selects between marking documents as deleted and removing them
+type DeleteMode int
+
+const (
+	// SoftDelete sets deleted_at and deleted_by on groups, polls, comments and users.
+	// Votes, memberships and events are kept so a restore is lossless.
+	SoftDelete DeleteMode = iota
+	// HardDelete permanently removes the document and everything that depends on it
+	HardDelete
+)
+
+// DeleteOptions configures a cascading delete
+type DeleteOptions struct {
+	Mode              DeleteMode
+	DeletedBy         primitive.ObjectID // Recorded on soft-deleted documents
+	AnonymizeComments bool               // DeleteUser keeps the user's comments without an author instead of deleting them
+}
+
+// DeleteCounts maps a collection name to the number of documents deleted from it
+type DeleteCounts map[string]int64
+
+// CascadeService deletes groups, polls, comments and users together with their
+// dependent documents inside a single MongoDB transaction
+type CascadeService struct {
+	db *mongo.Database
+}
+
+// NewCascadeService creates a CascadeService for a database
+func NewCascadeService(db *mongo.Database) *CascadeService {
+	return &CascadeService{db: db}
+}
+
+// DeleteGroup deletes a group with all of its subgroups, their polls, the
+// polls' votes and comments, and the groups' memberships and activity events
+func (s *CascadeService) DeleteGroup(ctx context.Context, groupID primitive.ObjectID, opts DeleteOptions) (DeleteCounts, error) {
+	return s.inTransaction(ctx, opts, func(tx *cascadeTx) error {
+		if err := tx.deleteRoot(groupsCollection, groupID); err != nil {
+			return err
+		}
+
+		// Subgroups cannot outlive the group they hang from
+		subgroupIDs, err := tx.ids(groupsCollection, bson.M{"ancestors": groupID})
+		if err != nil {
+			return err
+		}
+		if len(subgroupIDs) > 0 {
+			if err := tx.deleteSoftDependents(groupsCollection, bson.M{"_id": bson.M{"$in": subgroupIDs}}); err != nil {
+				return err
+			}
+		}
+		groupFilter := bson.M{"group_id": bson.M{"$in": append([]primitive.ObjectID{groupID}, subgroupIDs...)}}
+
+		pollIDs, err := tx.ids(pollsCollection, groupFilter)
+		if err != nil {
+			return err
+		}
+		if err := tx.deletePolls(pollIDs); err != nil {
+			return err
+		}
+
+		if err := tx.deleteDependents(membersCollection, groupFilter); err != nil {
+			return err
+		}
+		return tx.deleteDependents(eventsCollection, groupFilter)
+	})
+}
+
+// DeletePoll deletes a poll with its votes and comments
+func (s *CascadeService) DeletePoll(ctx context.Context, pollID primitive.ObjectID, opts DeleteOptions) (DeleteCounts, error) {
+	return s.inTransaction(ctx, opts, func(tx *cascadeTx) error {
+		if err := tx.deleteRoot(pollsCollection, pollID); err != nil {
+			return err
+		}
+		return tx.deletePollDependents([]primitive.ObjectID{pollID})
+	})
+}
+
+// DeleteComment deletes a single comment
+func (s *CascadeService) DeleteComment(ctx context.Context, commentID primitive.ObjectID, opts DeleteOptions) (DeleteCounts, error) {
+	return s.inTransaction(ctx, opts, func(tx *cascadeTx) error {
+		return tx.deleteRoot(commentsCollection, commentID)
+	})
+}
+
+// DeleteUser deletes a user with their comments, votes and memberships,
+// and signs them out everywhere. With AnonymizeComments the comments are kept
+// without an author. Hard-deleting votes also takes them off the
+// tallies of the polls they were cast on.
+func (s *CascadeService) DeleteUser(ctx context.Context, userID primitive.ObjectID, opts DeleteOptions) (DeleteCounts, error) {
+	return s.inTransaction(ctx, opts, func(tx *cascadeTx) error {
+		if err := tx.deleteRoot(usersCollection, userID); err != nil {
+			return err
+		}
+		if tx.opts.AnonymizeComments {
+			if err := tx.anonymize(commentsCollection, bson.M{"user_id": userID}); err != nil {
+				return err
+			}
+		} else if err := tx.deleteSoftDependents(commentsCollection, bson.M{"user_id": userID}); err != nil {
+			return err
+		}
+		if tx.opts.Mode == HardDelete {
+			if err := tx.retractVotes(bson.M{"user_id": userID}); err != nil {
+				return err
+			}
+		}
+		if err := tx.deleteDependents(membersCollection, bson.M{"user_id": userID}); err != nil {
+			return err
+		}
+		if err := tx.deleteDependents(resetsCollection, bson.M{"user_id": userID}); err != nil {
+			return err
+		}
+		if err := tx.deleteDependents(verificationsCollection, bson.M{"user_id": userID}); err != nil {
+			return err
+		}
+		return tx.endSessions(userID)
+	})
+}
+
+// inTransaction runs a cascade in a session transaction and returns the counts
+// of the attempt that committed
+func (s *CascadeService) inTransaction(ctx context.Context, opts DeleteOptions, fn func(tx *cascadeTx) error) (DeleteCounts, error) {
+	session, err := s.db.Client().StartSession()
+	if err != nil {
+		return nil, err
+	}
+	defer session.EndSession(ctx)
+
+	var counts DeleteCounts
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		// Transactions may be retried, so counts start over on every attempt
+		tx := &cascadeTx{
+			ctx:       sc,
+			db:        s.db,
+			opts:      opts,
+			deletedAt: primitive.NewDateTimeFromTime(time.Now()),
+			counts:    DeleteCounts{},
+		}
+		if err := fn(tx); err != nil {
+			return nil, err
+		}
+		counts = tx.counts
+		return nil, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return counts, nil
+}
+
+// cascadeTx holds the state of a single transaction attempt
+type cascadeTx struct {
+	ctx       mongo.SessionContext
+	db        *mongo.Database
+	opts      DeleteOptions
+	deletedAt primitive.DateTime
+	counts    DeleteCounts
+}
+
+// deleteRoot deletes the document the cascade starts from, failing with
+// ErrNotFound if there is nothing to delete
+func (tx *cascadeTx) deleteRoot(collection string, id primitive.ObjectID) error {
+	var n int64
+	var err error
+	if tx.opts.Mode == SoftDelete {
+		n, err = tx.softDelete(collection, bson.M{"_id": id})
+	} else {
+		n, err = tx.hardDelete(collection, bson.M{"_id": id})
+	}
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// deletePolls deletes polls and everything attached to them
+func (tx *cascadeTx) deletePolls(pollIDs []primitive.ObjectID) error {
+	if len(pollIDs) == 0 {
+		return nil
+	}
+	if err := tx.deleteSoftDependents(pollsCollection, bson.M{"_id": bson.M{"$in": pollIDs}}); err != nil {
+		return err
+	}
+	return tx.deletePollDependents(pollIDs)
+}
+
+// deletePollDependents deletes the comments and votes of polls
+func (tx *cascadeTx) deletePollDependents(pollIDs []primitive.ObjectID) error {
+	filter := bson.M{"poll_id": bson.M{"$in": pollIDs}}
+	if err := tx.deleteSoftDependents(commentsCollection, filter); err != nil {
+		return err
+	}
+	return tx.deleteDependents(votesCollection, filter)
+}
+
+// deleteSoftDependents deletes documents from a collection that supports soft deletion
+func (tx *cascadeTx) deleteSoftDependents(collection string, filter bson.M) error {
+	var err error
+	if tx.opts.Mode == SoftDelete {
+		_, err = tx.softDelete(collection, filter)
+	} else {
+		_, err = tx.hardDelete(collection, filter)
+	}
+	return err
+}
+
+// anonymize removes the author from documents instead of deleting them
+func (tx *cascadeTx) anonymize(collection string, filter bson.M) error {
+	_, err := tx.db.Collection(collection).UpdateMany(tx.ctx, filter, bson.M{
+		"$unset": bson.M{"user_id": ""},
+	})
+	return err
+}
+
+// deleteDependents deletes documents from a collection without soft-delete
+// markers. These are only removed on hard deletes.
+func (tx *cascadeTx) deleteDependents(collection string, filter bson.M) error {
+	if tx.opts.Mode == SoftDelete {
+		return nil
+	}
+	_, err := tx.hardDelete(collection, filter)
+	return err
+}
+
+// retractVotes removes votes and decrements the option counts they contributed to
+func (tx *cascadeTx) retractVotes(filter bson.M) error {
+	cursor, err := tx.db.Collection(votesCollection).Find(tx.ctx, filter)
+	if err != nil {
+		return err
+	}
+	var votes []struct {
+		PollID   primitive.ObjectID `bson:"poll_id"`
+		OptionID primitive.ObjectID `bson:"option_id"`
+	}
+	if err := cursor.All(tx.ctx, &votes); err != nil {
+		return err
+	}
+
+	for _, vote := range votes {
+		_, err := tx.db.Collection(pollsCollection).UpdateOne(tx.ctx,
+			bson.M{"_id": vote.PollID, "options._id": vote.OptionID},
+			bson.M{"$inc": bson.M{"options.$.vote_count": -1}},
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.hardDelete(votesCollection, filter)
+	return err
+}
+
+// endSessions revokes a user's sessions and API tokens on soft deletes and
+// removes them on hard deletes
+func (tx *cascadeTx) endSessions(userID primitive.ObjectID) error {
+	for _, collection := range []string{sessionsCollection, apiTokensCollection} {
+		if tx.opts.Mode == HardDelete {
+			if _, err := tx.hardDelete(collection, bson.M{"user_id": userID}); err != nil {
+				return err
+			}
+			continue
+		}
+		_, err := tx.db.Collection(collection).UpdateMany(tx.ctx, bson.M{
+			"user_id":    userID,
+			"revoked_at": nil,
+		}, bson.M{
+			"$set": bson.M{"revoked_at": tx.deletedAt},
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ids returns the IDs of the documents matching a filter. Already soft-deleted
+// documents are skipped on soft deletes so they keep their original timestamp.
+func (tx *cascadeTx) ids(collection string, filter bson.M) ([]primitive.ObjectID, error) {
+	if tx.opts.Mode == SoftDelete {
+		filter = withNotDeleted(filter)
+	}
+	values, err := tx.db.Collection(collection).Distinct(tx.ctx, "_id", filter)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]primitive.ObjectID, 0, len(values))
+	for _, value := range values {
+		if id, ok := value.(primitive.ObjectID); ok {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+func (tx *cascadeTx) softDelete(collection string, filter bson.M) (int64, error) {
+	result, err := tx.db.Collection(collection).UpdateMany(tx.ctx, withNotDeleted(filter), bson.M{
+		"$set": bson.M{
+			"deleted_at": tx.deletedAt,
+			"deleted_by": tx.opts.DeletedBy,
+		},
+	})
+	if err != nil {
+		return 0, err
+	}
+	tx.counts[collection] += result.ModifiedCount
+	return result.ModifiedCount, nil
+}
+
+func (tx *cascadeTx) hardDelete(collection string, filter bson.M) (int64, error) {
+	result, err := tx.db.Collection(collection).DeleteMany(tx.ctx, filter)
+	if err != nil {
+		return 0, err
+	}
+	tx.counts[collection] += result.DeletedCount
+	return result.DeletedCount, nil
+}
+
+// withNotDeleted copies a filter and restricts it to documents that are not soft-deleted
+func withNotDeleted(filter bson.M) bson.M {
+	scoped := bson.M{"deleted_at": nil}
+	for key, value := range filter {
+		scoped[key] = value
+	}
+	return scoped
+}
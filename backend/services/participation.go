@@ -0,0 +1,254 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+	"voteverse/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Reasons a vote or comment is refused
+var (
+	ErrPollNotFound       = errors.New("poll not found or not active")
+	ErrPollEnded          = errors.New("poll has ended")
+	ErrInvalidOption      = errors.New("invalid option for this poll")
+	ErrEmailNotVerified   = errors.New("email address is not verified")
+	ErrNotGroupMember     = errors.New("not a member of this group")
+	ErrCommentsRestricted = errors.New("comments are restricted to group admins")
+	ErrEmptyComment       = errors.New("comment text is required")
+)
+
+// ParticipationPolicy holds the settings votes and comments are checked against
+type ParticipationPolicy struct {
+	// RequireVerifiedEmail only lets users with a verified email vote in public polls
+	RequireVerifiedEmail bool
+	// GroupSettings fills in the defaults for settings a group never stored
+	GroupSettings func(models.GroupSettings) models.GroupSettings
+	// CanViewGroup reports whether a user who is not a member of a group may
+	// still see its polls, e.g. through a subgroup. Such users comment as members.
+	CanViewGroup func(groupID, userID primitive.ObjectID) (bool, error)
+	// CanManageGroup reports whether a user administers a group, e.g. as an
+	// admin of a parent group. Without it only the group's own admins do.
+	CanManageGroup func(group models.Group, userID primitive.ObjectID) (bool, error)
+}
+
+// ParticipationService casts votes and posts comments, whichever way users
+// send them
+type ParticipationService struct {
+	db     *mongo.Database
+	policy ParticipationPolicy
+}
+
+// NewParticipationService creates a ParticipationService for a database
+func NewParticipationService(db *mongo.Database, policy ParticipationPolicy) *ParticipationService {
+	return &ParticipationService{db: db, policy: policy}
+}
+
+// CastVote records a user's vote on a poll, or moves their earlier vote to
+// another option, and returns the poll with its new tallies
+func (s *ParticipationService) CastVote(ctx context.Context, userID, pollID, optionID primitive.ObjectID) (models.Poll, error) {
+	poll, err := s.openPoll(ctx, pollID)
+	if err != nil {
+		return models.Poll{}, err
+	}
+
+	// Check if poll has ended
+	if time.Now().After(poll.EndTime.Time()) {
+		return models.Poll{}, ErrPollEnded
+	}
+
+	// Voting in public polls may require a verified email
+	if poll.Visibility == "public" && s.policy.RequireVerifiedEmail {
+		var user models.User
+		if err := s.db.Collection(usersCollection).FindOne(ctx, bson.M{"_id": userID}).Decode(&user); err != nil {
+			return models.Poll{}, err
+		}
+		if !user.EmailVerified {
+			return models.Poll{}, ErrEmailNotVerified
+		}
+	}
+
+	// Check if option belongs to poll
+	validOption := false
+	for _, opt := range poll.Options {
+		if opt.ID == optionID {
+			validOption = true
+			break
+		}
+	}
+	if !validOption {
+		return models.Poll{}, ErrInvalidOption
+	}
+
+	session, err := s.db.Client().StartSession()
+	if err != nil {
+		return models.Poll{}, err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(txCtx mongo.SessionContext) (interface{}, error) {
+		return nil, s.recordVote(txCtx, userID, pollID, optionID)
+	})
+	if err != nil {
+		return models.Poll{}, err
+	}
+
+	// Get the updated poll to return to the client
+	var updatedPoll models.Poll
+	err = s.db.Collection(pollsCollection).FindOne(ctx, bson.M{"_id": pollID}).Decode(&updatedPoll)
+	if err != nil {
+		log.Printf("Failed to fetch updated poll: %v", err)
+		return poll, nil
+	}
+	return updatedPoll, nil
+}
+
+// recordVote inserts or moves a user's vote and adjusts the option tallies
+func (s *ParticipationService) recordVote(ctx mongo.SessionContext, userID, pollID, optionID primitive.ObjectID) error {
+	votes := s.db.Collection(votesCollection)
+	polls := s.db.Collection(pollsCollection)
+
+	// Check if user has already voted
+	var existingVote models.Vote
+	err := votes.FindOne(ctx, bson.M{
+		"poll_id": pollID,
+		"user_id": userID,
+	}).Decode(&existingVote)
+
+	now := primitive.NewDateTimeFromTime(time.Now())
+	if err == mongo.ErrNoDocuments {
+		_, err = votes.InsertOne(ctx, models.Vote{
+			ID:        primitive.NewObjectID(),
+			PollID:    pollID,
+			UserID:    userID,
+			OptionID:  optionID,
+			CreatedAt: now,
+			UpdatedAt: now,
+		})
+		if err != nil {
+			return err
+		}
+
+		_, err = polls.UpdateOne(ctx,
+			bson.M{"_id": pollID, "options._id": optionID},
+			bson.M{"$inc": bson.M{"options.$.vote_count": 1}},
+		)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	if existingVote.OptionID == optionID {
+		return nil
+	}
+
+	// Move the vote from the old option to the new one
+	_, err = polls.UpdateOne(ctx,
+		bson.M{"_id": pollID, "options._id": existingVote.OptionID},
+		bson.M{"$inc": bson.M{"options.$.vote_count": -1}},
+	)
+	if err != nil {
+		return err
+	}
+	_, err = polls.UpdateOne(ctx,
+		bson.M{"_id": pollID, "options._id": optionID},
+		bson.M{"$inc": bson.M{"options.$.vote_count": 1}},
+	)
+	if err != nil {
+		return err
+	}
+	_, err = votes.UpdateOne(ctx,
+		bson.M{"_id": existingVote.ID},
+		bson.M{"$set": bson.M{
+			"option_id":  optionID,
+			"updated_at": now,
+		}},
+	)
+	return err
+}
+
+// PostComment adds a comment to a poll of a group the user can see and
+// returns it together with the poll
+func (s *ParticipationService) PostComment(ctx context.Context, userID, pollID primitive.ObjectID, text string) (models.Comment, models.Poll, error) {
+	if text == "" {
+		return models.Comment{}, models.Poll{}, ErrEmptyComment
+	}
+
+	poll, err := s.openPoll(ctx, pollID)
+	if err != nil {
+		return models.Comment{}, models.Poll{}, err
+	}
+
+	// Check if user is a member of the group
+	var member models.GroupMember
+	err = s.db.Collection(membersCollection).FindOne(ctx, bson.M{
+		"group_id": poll.GroupID,
+		"user_id":  userID,
+	}).Decode(&member)
+	if err == mongo.ErrNoDocuments {
+		canView := false
+		if s.policy.CanViewGroup != nil {
+			canView, err = s.policy.CanViewGroup(poll.GroupID, userID)
+			if err != nil {
+				return models.Comment{}, models.Poll{}, err
+			}
+		}
+		if !canView {
+			return models.Comment{}, models.Poll{}, ErrNotGroupMember
+		}
+	} else if err != nil {
+		return models.Comment{}, models.Poll{}, err
+	}
+
+	// Check if the group allows members to comment
+	if member.Role != "admin" {
+		var group models.Group
+		err = s.db.Collection(groupsCollection).FindOne(ctx, bson.M{"_id": poll.GroupID}).Decode(&group)
+		if err == nil && !*s.policy.GroupSettings(group.Settings).MembersCanComment {
+			canManage := false
+			if s.policy.CanManageGroup != nil {
+				canManage, err = s.policy.CanManageGroup(group, userID)
+				if err != nil {
+					return models.Comment{}, models.Poll{}, err
+				}
+			}
+			if !canManage {
+				return models.Comment{}, models.Poll{}, ErrCommentsRestricted
+			}
+		}
+	}
+
+	now := primitive.NewDateTimeFromTime(time.Now())
+	comment := models.Comment{
+		ID:        primitive.NewObjectID(),
+		PollID:    pollID,
+		UserID:    userID,
+		Text:      text,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if _, err := s.db.Collection(commentsCollection).InsertOne(ctx, comment); err != nil {
+		return models.Comment{}, models.Poll{}, err
+	}
+	return comment, poll, nil
+}
+
+// openPoll loads a poll that is active and not deleted
+func (s *ParticipationService) openPoll(ctx context.Context, pollID primitive.ObjectID) (models.Poll, error) {
+	var poll models.Poll
+	err := s.db.Collection(pollsCollection).FindOne(ctx, bson.M{
+		"_id":        pollID,
+		"is_active":  true,
+		"deleted_at": nil,
+	}).Decode(&poll)
+	if err == mongo.ErrNoDocuments {
+		return models.Poll{}, ErrPollNotFound
+	}
+	return poll, err
+}
@@ -0,0 +1,405 @@
+// Package signing signs and verifies VoteVerse JWTs with asymmetric keys.
+// A KeySet has one key that signs new tokens and any number of keys that are
+// still accepted, so keys can be rotated without invalidating live tokens.
+package signing
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Supported signing algorithms
+const (
+	AlgRS256 = "RS256"
+	AlgEdDSA = "EdDSA"
+)
+
+const rsaKeyBits = 2048
+
+var (
+	ErrUnknownKey         = errors.New("signing: unknown key ID")
+	ErrUnsupportedKey     = errors.New("signing: unsupported key type")
+	ErrNotSigningKey      = errors.New("signing: key has no private part")
+	ErrRetireSigningKey   = errors.New("signing: cannot retire the current signing key")
+	ErrAlgorithmMismatch  = errors.New("signing: token algorithm does not match its key")
+	ErrMissingKeyIDHeader = errors.New("signing: token has no kid header")
+)
+
+// Key is a signing key pair, or a public key that is only used for verification
+type Key struct {
+	ID        string
+	Algorithm string
+	private   crypto.Signer
+	public    crypto.PublicKey
+}
+
+// GenerateKey creates a new key pair for the algorithm. The key ID is the
+// key's JWK thumbprint.
+func GenerateKey(alg string) (*Key, error) {
+	switch alg {
+	case AlgRS256:
+		private, err := rsa.GenerateKey(rand.Reader, rsaKeyBits)
+		if err != nil {
+			return nil, err
+		}
+		return newKey("", private, &private.PublicKey)
+	case AlgEdDSA:
+		public, private, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		return newKey("", private, public)
+	}
+	return nil, fmt.Errorf("signing: unsupported algorithm %q", alg)
+}
+
+// ParseKey reads a PEM encoded RSA or Ed25519 key. Private keys (PKCS #8 or
+// PKCS #1) can sign; public keys (PKIX) can only verify. An empty kid is
+// replaced by the key's JWK thumbprint.
+func ParseKey(kid string, pemData []byte) (*Key, error) {
+	block, _ := pem.Decode(pemData)
+	if block == nil {
+		return nil, errors.New("signing: no PEM data found")
+	}
+
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		signer, ok := parsed.(crypto.Signer)
+		if !ok {
+			return nil, ErrUnsupportedKey
+		}
+		return newKey(kid, signer, signer.Public())
+	case "RSA PRIVATE KEY":
+		private, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return newKey(kid, private, &private.PublicKey)
+	case "PUBLIC KEY":
+		public, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return newKey(kid, nil, public)
+	}
+	return nil, fmt.Errorf("signing: unsupported PEM block %q", block.Type)
+}
+
+func newKey(kid string, private crypto.Signer, public crypto.PublicKey) (*Key, error) {
+	key := &Key{ID: kid, private: private, public: public}
+	switch public.(type) {
+	case *rsa.PublicKey:
+		key.Algorithm = AlgRS256
+	case ed25519.PublicKey:
+		key.Algorithm = AlgEdDSA
+	default:
+		return nil, ErrUnsupportedKey
+	}
+	if key.ID == "" {
+		key.ID = key.Thumbprint()
+	}
+	return key, nil
+}
+
+// CanSign reports whether the key has its private part
+func (k *Key) CanSign() bool {
+	return k.private != nil
+}
+
+// PrivateKeyPEM encodes the private key as PKCS #8, for storing a generated key
+func (k *Key) PrivateKeyPEM() ([]byte, error) {
+	if k.private == nil {
+		return nil, ErrNotSigningKey
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(k.private)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+func (k *Key) method() jwt.SigningMethod {
+	if k.Algorithm == AlgEdDSA {
+		return jwt.SigningMethodEdDSA
+	}
+	return jwt.SigningMethodRS256
+}
+
+// JWK returns the public part of the key as a JSON Web Key
+func (k *Key) JWK() JWK {
+	jwk := JWK{Use: "sig", Alg: k.Algorithm, Kid: k.ID}
+	switch public := k.public.(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+	case ed25519.PublicKey:
+		jwk.Kty = "OKP"
+		jwk.Crv = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(public)
+	}
+	return jwk
+}
+
+// Thumbprint returns the RFC 7638 thumbprint of the public key
+func (k *Key) Thumbprint() string {
+	jwk := k.JWK()
+
+	// The members are hashed in lexicographic order without whitespace
+	var members map[string]string
+	if jwk.Kty == "RSA" {
+		members = map[string]string{"e": jwk.E, "kty": jwk.Kty, "n": jwk.N}
+	} else {
+		members = map[string]string{"crv": jwk.Crv, "kty": jwk.Kty, "x": jwk.X}
+	}
+	data, _ := json.Marshal(members) // Maps are marshalled with sorted keys
+
+	sum := sha256.Sum256(data)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// JWK is a public key in JSON Web Key format
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	Kid string `json:"kid"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+// JWKS is the document served at /.well-known/jwks.json
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// KeySet holds the current signing key and every key tokens are verified with
+type KeySet struct {
+	mu      sync.RWMutex
+	signing *Key
+	keys    map[string]*Key
+}
+
+// NewKeySet returns a key set that signs with signingKey and also accepts
+// tokens signed by the verification keys
+func NewKeySet(signingKey *Key, verificationKeys ...*Key) (*KeySet, error) {
+	if !signingKey.CanSign() {
+		return nil, ErrNotSigningKey
+	}
+
+	s := &KeySet{signing: signingKey, keys: map[string]*Key{signingKey.ID: signingKey}}
+	for _, key := range verificationKeys {
+		if _, exists := s.keys[key.ID]; exists {
+			return nil, fmt.Errorf("signing: duplicate key ID %q", key.ID)
+		}
+		s.keys[key.ID] = key
+	}
+	return s, nil
+}
+
+// MustGenerateKeySet returns a key set with a new key. Tokens it signs stop
+// working when the process exits.
+func MustGenerateKeySet(alg string) *KeySet {
+	key, err := GenerateKey(alg)
+	if err != nil {
+		panic(err)
+	}
+	s, err := NewKeySet(key)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// SigningKeyID returns the ID of the key new tokens are signed with
+func (s *KeySet) SigningKeyID() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.signing.ID
+}
+
+// Sign signs the claims with the current signing key and sets the kid header
+func (s *KeySet) Sign(claims jwt.Claims) (string, error) {
+	s.mu.RLock()
+	key := s.signing
+	s.mu.RUnlock()
+
+	token := jwt.NewWithClaims(key.method(), claims)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.private)
+}
+
+// Parse verifies a token and decodes its claims. The token must name a known
+// key in its kid header and use that key's algorithm. Further checks, such as
+// the issuer or audience, can be passed as parser options.
+func (s *KeySet) Parse(tokenString string, claims jwt.Claims, opts ...jwt.ParserOption) error {
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, ok := token.Header["kid"].(string)
+		if !ok {
+			return nil, ErrMissingKeyIDHeader
+		}
+
+		s.mu.RLock()
+		key, ok := s.keys[kid]
+		s.mu.RUnlock()
+		if !ok {
+			return nil, ErrUnknownKey
+		}
+
+		if token.Method.Alg() != key.Algorithm {
+			return nil, ErrAlgorithmMismatch
+		}
+		return key.public, nil
+	}, append([]jwt.ParserOption{jwt.WithValidMethods([]string{AlgRS256, AlgEdDSA}), jwt.WithExpirationRequired()}, opts...)...)
+	if err != nil {
+		return err
+	}
+	if !token.Valid {
+		return jwt.ErrTokenSignatureInvalid
+	}
+	return nil
+}
+
+// Add accepts tokens signed by another key, usually one that will become the
+// signing key on every instance soon
+func (s *KeySet) Add(key *Key) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.keys[key.ID]; exists {
+		return fmt.Errorf("signing: duplicate key ID %q", key.ID)
+	}
+	s.keys[key.ID] = key
+	return nil
+}
+
+// Rotate makes key the signing key. The previous signing key is still
+// accepted until it is retired.
+func (s *KeySet) Rotate(key *Key) error {
+	if !key.CanSign() {
+		return ErrNotSigningKey
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.keys[key.ID] = key
+	s.signing = key
+	return nil
+}
+
+// Retire stops accepting tokens signed by a key
+func (s *KeySet) Retire(kid string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.signing.ID == kid {
+		return ErrRetireSigningKey
+	}
+	if _, exists := s.keys[kid]; !exists {
+		return ErrUnknownKey
+	}
+	delete(s.keys, kid)
+	return nil
+}
+
+// JWKS returns the public keys tokens are verified with
+func (s *KeySet) JWKS() JWKS {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	jwks := JWKS{Keys: make([]JWK, 0, len(s.keys))}
+	for _, key := range s.keys {
+		jwks.Keys = append(jwks.Keys, key.JWK())
+	}
+	sort.Slice(jwks.Keys, func(i, j int) bool { return jwks.Keys[i].Kid < jwks.Keys[j].Kid })
+	return jwks
+}
+
+// FromEnv loads the key set from the environment:
+//
+//	JWT_SIGNING_KEY_FILE        PEM private key that signs new tokens
+//	JWT_SIGNING_KEY_ID          optional kid for the signing key (default: JWK thumbprint)
+//	JWT_VERIFICATION_KEY_FILES  comma-separated PEM files, optionally as kid=path,
+//	                            that are accepted as well
+//
+// To rotate, add the new key to JWT_VERIFICATION_KEY_FILES everywhere, then make
+// it the signing key and keep the old one as a verification key until the
+// tokens it signed have expired. Without JWT_SIGNING_KEY_FILE a temporary
+// EdDSA key is generated.
+func FromEnv() (*KeySet, error) {
+	var signingKey *Key
+	path := os.Getenv("JWT_SIGNING_KEY_FILE")
+	if path == "" {
+		log.Println("WARNING: JWT_SIGNING_KEY_FILE is not set. Using a temporary signing key; access tokens stop working on restart!")
+		key, err := GenerateKey(AlgEdDSA)
+		if err != nil {
+			return nil, err
+		}
+		signingKey = key
+	} else {
+		key, err := readKeyFile(os.Getenv("JWT_SIGNING_KEY_ID"), path)
+		if err != nil {
+			return nil, err
+		}
+		signingKey = key
+	}
+
+	var verificationKeys []*Key
+	for _, entry := range strings.Split(os.Getenv("JWT_VERIFICATION_KEY_FILES"), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		kid, path := "", entry
+		if i := strings.Index(entry, "="); i >= 0 {
+			kid, path = entry[:i], entry[i+1:]
+		}
+		key, err := readKeyFile(kid, path)
+		if err != nil {
+			return nil, err
+		}
+		if key.ID == signingKey.ID {
+			continue
+		}
+		verificationKeys = append(verificationKeys, key)
+	}
+
+	return NewKeySet(signingKey, verificationKeys...)
+}
+
+func readKeyFile(kid, path string) (*Key, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := ParseKey(kid, data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return key, nil
+}
@@ -4,16 +4,27 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
 	"testing"
 	"time"
+	"voteverse/database"
 	"voteverse/handlers"
+	"voteverse/mailer"
 	"voteverse/models"
+	"voteverse/oidc"
+	"voteverse/signing"
 	"voteverse/testutils/helpers"
+	"voteverse/testutils/mocks"
+	"voteverse/totp"
 
 	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
 	"go.mongodb.org/mongo-driver/bson"
@@ -73,6 +84,28 @@ func (suite *AuthIntegrationTestSuite) SetupSuite() {
 	router.POST("/api/auth/signin", func(c *gin.Context) {
 		handlers.SignIn(c, suite.db)
 	})
+	router.POST("/api/auth/refresh", func(c *gin.Context) {
+		handlers.RefreshToken(c, suite.db)
+	})
+	router.POST("/api/auth/password-reset/request", func(c *gin.Context) {
+		handlers.RequestPasswordReset(c, suite.db)
+	})
+	router.POST("/api/auth/password-reset/confirm", func(c *gin.Context) {
+		handlers.ConfirmPasswordReset(c, suite.db)
+	})
+	router.POST("/api/auth/verify-email", func(c *gin.Context) {
+		handlers.VerifyEmail(c, suite.db)
+	})
+	router.POST("/api/auth/2fa/verify", func(c *gin.Context) {
+		handlers.VerifyTwoFactorChallenge(c, suite.db)
+	})
+	router.GET("/.well-known/jwks.json", handlers.GetJWKS)
+	router.GET("/api/auth/oidc/login", func(c *gin.Context) {
+		handlers.OIDCLogin(c, suite.db)
+	})
+	router.POST("/api/auth/oidc/callback", func(c *gin.Context) {
+		handlers.OIDCCallback(c, suite.db)
+	})
 
 	// Register a protected route
 	authMiddleware := handlers.AuthMiddleware(suite.db)
@@ -84,6 +117,43 @@ func (suite *AuthIntegrationTestSuite) SetupSuite() {
 		}
 		c.JSON(http.StatusOK, gin.H{"user_id": userID})
 	})
+	router.POST("/api/auth/logout", authMiddleware, func(c *gin.Context) {
+		handlers.Logout(c, suite.db)
+	})
+	router.POST("/api/admin/users/:id/unlock", authMiddleware, func(c *gin.Context) {
+		handlers.AdminUnlockUser(c, suite.db)
+	})
+	router.GET("/api/admin/signin-events", authMiddleware, func(c *gin.Context) {
+		handlers.AdminListSignInEvents(c, suite.db)
+	})
+	router.PATCH("/api/user/profile", authMiddleware, func(c *gin.Context) {
+		handlers.UpdateProfile(c, suite.db)
+	})
+	router.POST("/api/user/password", authMiddleware, func(c *gin.Context) {
+		handlers.ChangePassword(c, suite.db)
+	})
+	router.POST("/api/auth/verify-email/resend", authMiddleware, func(c *gin.Context) {
+		handlers.ResendVerificationEmail(c, suite.db)
+	})
+	router.DELETE("/api/user/account", authMiddleware, func(c *gin.Context) {
+		handlers.DeleteAccount(c, suite.db)
+	})
+	router.POST("/api/user/2fa/enroll", authMiddleware, func(c *gin.Context) {
+		handlers.EnrollTwoFactor(c, suite.db)
+	})
+	router.POST("/api/user/2fa/confirm", authMiddleware, func(c *gin.Context) {
+		handlers.ConfirmTwoFactor(c, suite.db)
+	})
+	router.POST("/api/user/tokens", authMiddleware, func(c *gin.Context) {
+		handlers.CreateAPIToken(c, suite.db)
+	})
+	router.DELETE("/api/user/tokens/:id", authMiddleware, func(c *gin.Context) {
+		handlers.RevokeAPIToken(c, suite.db)
+	})
+	// Stand-in for a route that API tokens with polls:read may use
+	router.GET("/api/polls", authMiddleware, func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"user_id": c.GetString("user_id")})
+	})
 
 	suite.router = router
 }
@@ -114,6 +184,46 @@ func (suite *AuthIntegrationTestSuite) SetupTest() {
 	if err != nil {
 		suite.T().Logf("Failed to clear users collection: %v", err)
 	}
+
+	_, err = suite.db.Collection("sessions").DeleteMany(ctx, bson.M{})
+	if err != nil {
+		suite.T().Logf("Failed to clear sessions collection: %v", err)
+	}
+
+	_, err = suite.db.Collection("password_resets").DeleteMany(ctx, bson.M{})
+	if err != nil {
+		suite.T().Logf("Failed to clear password_resets collection: %v", err)
+	}
+
+	_, err = suite.db.Collection("password_reset_requests").DeleteMany(ctx, bson.M{})
+	if err != nil {
+		suite.T().Logf("Failed to clear password_reset_requests collection: %v", err)
+	}
+
+	_, err = suite.db.Collection("email_verifications").DeleteMany(ctx, bson.M{})
+	if err != nil {
+		suite.T().Logf("Failed to clear email_verifications collection: %v", err)
+	}
+
+	_, err = suite.db.Collection("oidc_states").DeleteMany(ctx, bson.M{})
+	if err != nil {
+		suite.T().Logf("Failed to clear oidc_states collection: %v", err)
+	}
+
+	_, err = suite.db.Collection("api_tokens").DeleteMany(ctx, bson.M{})
+	if err != nil {
+		suite.T().Logf("Failed to clear api_tokens collection: %v", err)
+	}
+
+	_, err = suite.db.Collection("signin_throttles").DeleteMany(ctx, bson.M{})
+	if err != nil {
+		suite.T().Logf("Failed to clear signin_throttles collection: %v", err)
+	}
+
+	_, err = suite.db.Collection("signin_events").DeleteMany(ctx, bson.M{})
+	if err != nil {
+		suite.T().Logf("Failed to clear signin_events collection: %v", err)
+	}
 }
 
 func (suite *AuthIntegrationTestSuite) TestSignUpSignInFlow() {
@@ -312,6 +422,1001 @@ func (suite *AuthIntegrationTestSuite) TestProtectedRouteWithInvalidToken() {
 	assert.Contains(t, response, "error")
 }
 
+func (suite *AuthIntegrationTestSuite) TestAccessTokensSurviveKeyRotation() {
+	t := suite.T()
+
+	keys := signing.MustGenerateKeySet(signing.AlgRS256)
+	previousKeys := handlers.TokenKeys()
+	handlers.SetTokenKeys(keys)
+	defer handlers.SetTokenKeys(previousKeys)
+	oldKid := keys.SigningKeyID()
+
+	code, signupResponse := suite.postJSON("/api/auth/signup", "", map[string]interface{}{
+		"username": "rotationuser",
+		"email":    "rotation@example.com",
+		"password": "password123",
+	})
+	suite.Require().Equal(http.StatusCreated, code)
+	oldToken := signupResponse["token"].(string)
+
+	// A token with the same claims signed with a shared secret is rejected
+	claims := jwt.MapClaims{}
+	_, _, err := jwt.NewParser().ParseUnverified(oldToken, claims)
+	suite.Require().NoError(err)
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	forged.Header["kid"] = oldKid
+	forgedToken, err := forged.SignedString([]byte("your-secret-key"))
+	suite.Require().NoError(err)
+	assert.Equal(t, http.StatusUnauthorized, suite.getStatus("/api/protected", forgedToken))
+
+	// Rotate to an EdDSA key; tokens signed before keep working
+	newKey, err := signing.GenerateKey(signing.AlgEdDSA)
+	suite.Require().NoError(err)
+	suite.Require().NoError(keys.Rotate(newKey))
+	assert.Equal(t, http.StatusOK, suite.getStatus("/api/protected", oldToken))
+
+	code, signinResponse := suite.postJSON("/api/auth/signin", "", map[string]interface{}{
+		"email":    "rotation@example.com",
+		"password": "password123",
+	})
+	suite.Require().Equal(http.StatusOK, code)
+	newToken := signinResponse["token"].(string)
+	parsed, _, err := jwt.NewParser().ParseUnverified(newToken, jwt.MapClaims{})
+	suite.Require().NoError(err)
+	assert.Equal(t, newKey.ID, parsed.Header["kid"])
+
+	// Both keys are published while the old one is still accepted
+	req, _ := http.NewRequest("GET", "/.well-known/jwks.json", nil)
+	resp := httptest.NewRecorder()
+	suite.router.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	var jwks signing.JWKS
+	suite.Require().NoError(json.Unmarshal(resp.Body.Bytes(), &jwks))
+	assert.Len(t, jwks.Keys, 2)
+
+	suite.Require().NoError(keys.Retire(oldKid))
+	assert.Equal(t, http.StatusUnauthorized, suite.getStatus("/api/protected", oldToken))
+	assert.Equal(t, http.StatusOK, suite.getStatus("/api/protected", newToken))
+}
+
+// postJSON sends a JSON request to the test router and decodes the response
+func (suite *AuthIntegrationTestSuite) postJSON(path, token string, body interface{}) (int, map[string]interface{}) {
+	return suite.sendJSON("POST", path, token, body)
+}
+
+func (suite *AuthIntegrationTestSuite) sendJSON(method, path, token string, body interface{}) (int, map[string]interface{}) {
+	payload, _ := json.Marshal(body)
+	req, _ := http.NewRequest(method, path, bytes.NewBuffer(payload))
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp := httptest.NewRecorder()
+
+	suite.router.ServeHTTP(resp, req)
+
+	var response map[string]interface{}
+	json.Unmarshal(resp.Body.Bytes(), &response)
+	return resp.Code, response
+}
+
+func (suite *AuthIntegrationTestSuite) TestRefreshRotationAndLogout() {
+	t := suite.T()
+
+	// Sign up to start a session
+	code, signupResponse := suite.postJSON("/api/auth/signup", "", map[string]interface{}{
+		"username": "sessionuser",
+		"email":    "session@example.com",
+		"password": "password123",
+	})
+	assert.Equal(t, http.StatusCreated, code)
+	refreshToken := signupResponse["refresh_token"].(string)
+	assert.NotEmpty(t, refreshToken)
+
+	// Refresh returns a new access token and rotates the refresh token
+	code, refreshResponse := suite.postJSON("/api/auth/refresh", "", map[string]interface{}{
+		"refresh_token": refreshToken,
+	})
+	assert.Equal(t, http.StatusOK, code)
+	token := refreshResponse["token"].(string)
+	rotatedToken := refreshResponse["refresh_token"].(string)
+	assert.NotEqual(t, refreshToken, rotatedToken)
+
+	// Logging out revokes the session, so the access token stops working
+	code, _ = suite.postJSON("/api/auth/logout", token, nil)
+	assert.Equal(t, http.StatusOK, code)
+
+	req, _ := http.NewRequest("GET", "/api/protected", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp := httptest.NewRecorder()
+	suite.router.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+
+	// Neither refresh token can be used after logout
+	code, _ = suite.postJSON("/api/auth/refresh", "", map[string]interface{}{"refresh_token": rotatedToken})
+	assert.Equal(t, http.StatusUnauthorized, code)
+}
+
+func (suite *AuthIntegrationTestSuite) TestRefreshTokenReuseRevokesSession() {
+	t := suite.T()
+
+	code, signupResponse := suite.postJSON("/api/auth/signup", "", map[string]interface{}{
+		"username": "reuseuser",
+		"email":    "reuse@example.com",
+		"password": "password123",
+	})
+	assert.Equal(t, http.StatusCreated, code)
+	refreshToken := signupResponse["refresh_token"].(string)
+
+	code, refreshResponse := suite.postJSON("/api/auth/refresh", "", map[string]interface{}{"refresh_token": refreshToken})
+	assert.Equal(t, http.StatusOK, code)
+	rotatedToken := refreshResponse["refresh_token"].(string)
+
+	// Presenting the old token again is treated as theft and ends the session
+	code, _ = suite.postJSON("/api/auth/refresh", "", map[string]interface{}{"refresh_token": refreshToken})
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	code, _ = suite.postJSON("/api/auth/refresh", "", map[string]interface{}{"refresh_token": rotatedToken})
+	assert.Equal(t, http.StatusUnauthorized, code)
+}
+
+func (suite *AuthIntegrationTestSuite) TestPasswordResetFlow() {
+	t := suite.T()
+
+	// Capture emails in a file
+	mailPath := filepath.Join(t.TempDir(), "mail.log")
+	defer handlers.SetMailer(handlers.Mailer())
+	handlers.SetMailer(mailer.NewFileMailer(mailPath, "test@voteverse.com"))
+
+	code, signupResponse := suite.postJSON("/api/auth/signup", "", map[string]interface{}{
+		"username": "resetuser",
+		"email":    "reset@example.com",
+		"password": "password123",
+	})
+	assert.Equal(t, http.StatusCreated, code)
+	oldToken := signupResponse["token"].(string)
+
+	code, tokenResponse := suite.postJSON("/api/user/tokens", oldToken, map[string]interface{}{
+		"name":   "ci",
+		"scopes": []string{"polls:read"},
+	})
+	suite.Require().Equal(http.StatusCreated, code)
+	apiToken := tokenResponse["token"].(string)
+
+	// Unknown emails get the same response but no email
+	code, _ = suite.postJSON("/api/auth/password-reset/request", "", map[string]interface{}{"email": "nobody@example.com"})
+	assert.Equal(t, http.StatusOK, code)
+
+	code, _ = suite.postJSON("/api/auth/password-reset/request", "", map[string]interface{}{"email": "reset@example.com"})
+	assert.Equal(t, http.StatusOK, code)
+
+	// The email is sent after the response
+	var match [][]byte
+	suite.Require().Eventually(func() bool {
+		mail, err := os.ReadFile(mailPath)
+		if err != nil {
+			return false
+		}
+		match = regexp.MustCompile(`reset-password\?token=([A-Za-z0-9_-]+)`).FindSubmatch(mail)
+		return match != nil
+	}, 2*time.Second, 20*time.Millisecond)
+	mail, err := os.ReadFile(mailPath)
+	suite.Require().NoError(err)
+	assert.NotContains(t, string(mail), "nobody@example.com")
+	resetToken := string(match[1])
+
+	code, _ = suite.postJSON("/api/auth/password-reset/confirm", "", map[string]interface{}{
+		"token":    resetToken,
+		"password": "newpassword123",
+	})
+	assert.Equal(t, http.StatusOK, code)
+
+	// The token is single-use
+	code, _ = suite.postJSON("/api/auth/password-reset/confirm", "", map[string]interface{}{
+		"token":    resetToken,
+		"password": "anotherpassword",
+	})
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	// Existing sessions are signed out and API tokens revoked
+	assert.Equal(t, http.StatusUnauthorized, suite.getStatus("/api/protected", oldToken))
+	assert.Equal(t, http.StatusUnauthorized, suite.getStatus("/api/polls", apiToken))
+
+	// The new password works
+	code, _ = suite.postJSON("/api/auth/signin", "", map[string]interface{}{
+		"email":    "reset@example.com",
+		"password": "newpassword123",
+	})
+	assert.Equal(t, http.StatusOK, code)
+}
+
+// failingMailer fails to send every email
+type failingMailer struct{}
+
+func (failingMailer) Send(context.Context, mailer.Message) error {
+	return errors.New("mail server unavailable")
+}
+
+func (suite *AuthIntegrationTestSuite) TestPasswordResetHidesMailFailures() {
+	t := suite.T()
+
+	defer handlers.SetMailer(handlers.Mailer())
+	handlers.SetMailer(failingMailer{})
+
+	code, _ := suite.postJSON("/api/auth/signup", "", map[string]interface{}{
+		"username": "unluckyuser",
+		"email":    "unlucky@example.com",
+		"password": "password123",
+	})
+	suite.Require().Equal(http.StatusCreated, code)
+
+	// Known and unknown emails get the same answer even when sending fails
+	knownCode, known := suite.postJSON("/api/auth/password-reset/request", "", map[string]interface{}{"email": "unlucky@example.com"})
+	unknownCode, unknown := suite.postJSON("/api/auth/password-reset/request", "", map[string]interface{}{"email": "nobody@example.com"})
+	assert.Equal(t, http.StatusOK, knownCode)
+	assert.Equal(t, unknownCode, knownCode)
+	assert.Equal(t, unknown, known)
+}
+
+func (suite *AuthIntegrationTestSuite) TestPasswordResetRequestsAreRateLimited() {
+	t := suite.T()
+
+	defer handlers.SetMailer(handlers.Mailer())
+	handlers.SetMailer(failingMailer{})
+
+	code, _ := suite.postJSON("/api/auth/signup", "", map[string]interface{}{
+		"username": "floodeduser",
+		"email":    "flooded@example.com",
+		"password": "password123",
+	})
+	suite.Require().Equal(http.StatusCreated, code)
+
+	requestReset := func(email string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(map[string]interface{}{"email": email})
+		req, _ := http.NewRequest("POST", "/api/auth/password-reset/request", bytes.NewBuffer(body))
+		req.Header.Set("Content-Type", "application/json")
+		resp := httptest.NewRecorder()
+		suite.router.ServeHTTP(resp, req)
+		return resp
+	}
+
+	// A second request for the same email has to wait, known or not
+	for _, email := range []string{"flooded@example.com", "nobody@example.com"} {
+		assert.Equal(t, http.StatusOK, requestReset(email).Code)
+		resp := requestReset(email)
+		assert.Equal(t, http.StatusTooManyRequests, resp.Code)
+		assert.NotEmpty(t, resp.Header().Get("Retry-After"))
+	}
+
+	// One address can ask for 20 emails an hour, whichever they are for
+	for i := 2; i < 20; i++ {
+		assert.Equal(t, http.StatusOK, requestReset("user"+strconv.Itoa(i)+"@example.com").Code)
+	}
+	assert.Equal(t, http.StatusTooManyRequests, requestReset("another@example.com").Code)
+
+	// The throttled request did not replace the link already sent
+	suite.Require().Eventually(func() bool {
+		count, err := suite.db.Collection("password_resets").CountDocuments(context.Background(), bson.M{"used_at": nil})
+		return err == nil && count == 1
+	}, 2*time.Second, 20*time.Millisecond)
+	total, err := suite.db.Collection("password_resets").CountDocuments(context.Background(), bson.M{})
+	suite.Require().NoError(err)
+	assert.Equal(t, int64(1), total)
+}
+
+func (suite *AuthIntegrationTestSuite) TestEmailVerificationFlow() {
+	t := suite.T()
+
+	mailPath := filepath.Join(t.TempDir(), "mail.log")
+	defer handlers.SetMailer(handlers.Mailer())
+	handlers.SetMailer(mailer.NewFileMailer(mailPath, "test@voteverse.com"))
+
+	code, signupResponse := suite.postJSON("/api/auth/signup", "", map[string]interface{}{
+		"username": "verifyuser",
+		"email":    "verify@example.com",
+		"password": "password123",
+	})
+	assert.Equal(t, http.StatusCreated, code)
+	user := signupResponse["user"].(map[string]interface{})
+	assert.Equal(t, false, user["email_verified"])
+
+	mail, err := os.ReadFile(mailPath)
+	suite.Require().NoError(err)
+	match := regexp.MustCompile(`verify-email\?token=([A-Za-z0-9_-]+)`).FindSubmatch(mail)
+	suite.Require().NotNil(match)
+
+	code, _ = suite.postJSON("/api/auth/verify-email", "", map[string]interface{}{"token": string(match[1])})
+	assert.Equal(t, http.StatusOK, code)
+
+	var stored models.User
+	err = suite.db.Collection("users").FindOne(context.Background(), bson.M{"email": "verify@example.com"}).Decode(&stored)
+	suite.Require().NoError(err)
+	assert.True(t, stored.EmailVerified)
+
+	// The token is single-use
+	code, _ = suite.postJSON("/api/auth/verify-email", "", map[string]interface{}{"token": string(match[1])})
+	assert.Equal(t, http.StatusBadRequest, code)
+}
+
+func (suite *AuthIntegrationTestSuite) TestResendVerificationEmailIsRateLimited() {
+	t := suite.T()
+
+	mailPath := filepath.Join(t.TempDir(), "mail.log")
+	defer handlers.SetMailer(handlers.Mailer())
+	handlers.SetMailer(mailer.NewFileMailer(mailPath, "test@voteverse.com"))
+
+	code, signupResponse := suite.postJSON("/api/auth/signup", "", map[string]interface{}{
+		"username": "resenduser",
+		"email":    "resend@example.com",
+		"password": "password123",
+	})
+	suite.Require().Equal(http.StatusCreated, code)
+	token := signupResponse["token"].(string)
+	userID, _ := primitive.ObjectIDFromHex(signupResponse["user"].(map[string]interface{})["id"].(string))
+
+	resend := func() *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", "/api/auth/verify-email/resend", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp := httptest.NewRecorder()
+		suite.router.ServeHTTP(resp, req)
+		return resp
+	}
+	backdate := func(age time.Duration) {
+		_, err := suite.db.Collection("email_verifications").UpdateMany(context.Background(), bson.M{"user_id": userID}, bson.M{
+			"$set": bson.M{"created_at": primitive.NewDateTimeFromTime(time.Now().Add(-age))},
+		})
+		suite.Require().NoError(err)
+	}
+
+	// The sign-up email counts, so an immediate resend has to wait
+	resp := resend()
+	assert.Equal(t, http.StatusTooManyRequests, resp.Code)
+	assert.NotEmpty(t, resp.Header().Get("Retry-After"))
+
+	backdate(2 * time.Minute)
+	assert.Equal(t, http.StatusOK, resend().Code)
+
+	// At most five emails an hour, however far apart
+	for i := 0; i < 3; i++ {
+		backdate(2 * time.Minute)
+		assert.Equal(t, http.StatusOK, resend().Code)
+	}
+	backdate(2 * time.Minute)
+	resp = resend()
+	assert.Equal(t, http.StatusTooManyRequests, resp.Code)
+	retryAfter, err := strconv.Atoi(resp.Header().Get("Retry-After"))
+	suite.Require().NoError(err)
+	assert.Greater(t, retryAfter, 60)
+
+	backdate(2 * time.Hour)
+	assert.Equal(t, http.StatusOK, resend().Code)
+}
+
+func (suite *AuthIntegrationTestSuite) TestInitializeDatabaseVerifiesExistingUsers() {
+	t := suite.T()
+
+	// Users stored before email verification existed have no email_verified field
+	legacyID := primitive.NewObjectID()
+	_, err := suite.db.Collection("users").InsertOne(context.Background(), bson.M{
+		"_id":      legacyID,
+		"username": "legacyuser",
+		"email":    "legacy@example.com",
+		"role":     models.RoleUser,
+	})
+	suite.Require().NoError(err)
+
+	code, _ := suite.postJSON("/api/auth/signup", "", map[string]interface{}{
+		"username": "newuser",
+		"email":    "new@example.com",
+		"password": "password123",
+	})
+	suite.Require().Equal(http.StatusCreated, code)
+
+	suite.Require().NoError(database.InitializeDatabase(suite.db))
+	suite.Require().NoError(database.InitializeDatabase(suite.db))
+
+	var legacy, fresh models.User
+	suite.Require().NoError(suite.db.Collection("users").FindOne(context.Background(), bson.M{"_id": legacyID}).Decode(&legacy))
+	suite.Require().NoError(suite.db.Collection("users").FindOne(context.Background(), bson.M{"email": "new@example.com"}).Decode(&fresh))
+	assert.True(t, legacy.EmailVerified)
+	assert.False(t, fresh.EmailVerified, "users who signed up since still have to verify")
+}
+
+func (suite *AuthIntegrationTestSuite) TestProfileUpdateAndPasswordChange() {
+	t := suite.T()
+
+	mailPath := filepath.Join(t.TempDir(), "mail.log")
+	defer handlers.SetMailer(handlers.Mailer())
+	handlers.SetMailer(mailer.NewFileMailer(mailPath, "test@voteverse.com"))
+
+	code, signupResponse := suite.postJSON("/api/auth/signup", "", map[string]interface{}{
+		"username": "profileuser",
+		"email":    "profile@example.com",
+		"password": "password123",
+	})
+	assert.Equal(t, http.StatusCreated, code)
+	token := signupResponse["token"].(string)
+
+	code, _ = suite.postJSON("/api/auth/signup", "", map[string]interface{}{
+		"username": "takenuser",
+		"email":    "taken@example.com",
+		"password": "password123",
+	})
+	assert.Equal(t, http.StatusCreated, code)
+
+	code, profile := suite.sendJSON("PATCH", "/api/user/profile", token, map[string]interface{}{
+		"display_name": "Profile User",
+		"avatar_url":   "https://example.com/avatar.png",
+	})
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "Profile User", profile["display_name"])
+	assert.Nil(t, profile["password"])
+
+	code, _ = suite.sendJSON("PATCH", "/api/user/profile", token, map[string]interface{}{"username": "takenuser"})
+	assert.Equal(t, http.StatusConflict, code)
+
+	// Changing the email needs the password and a new verification
+	code, _ = suite.sendJSON("PATCH", "/api/user/profile", token, map[string]interface{}{"email": "new@example.com"})
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	code, profile = suite.sendJSON("PATCH", "/api/user/profile", token, map[string]interface{}{
+		"email":            "new@example.com",
+		"current_password": "password123",
+	})
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "new@example.com", profile["email"])
+	assert.Equal(t, false, profile["email_verified"])
+
+	mail, err := os.ReadFile(mailPath)
+	suite.Require().NoError(err)
+	assert.Contains(t, string(mail), "To: new@example.com")
+	assert.Contains(t, string(mail), "To: profile@example.com")
+
+	// A second device is signed out by the password change
+	code, otherSession := suite.postJSON("/api/auth/signin", "", map[string]interface{}{
+		"email":    "new@example.com",
+		"password": "password123",
+	})
+	assert.Equal(t, http.StatusOK, code)
+	otherToken := otherSession["token"].(string)
+
+	// API tokens are revoked too
+	code, tokenResponse := suite.postJSON("/api/user/tokens", token, map[string]interface{}{
+		"name":   "ci",
+		"scopes": []string{"polls:read"},
+	})
+	suite.Require().Equal(http.StatusCreated, code)
+	apiToken := tokenResponse["token"].(string)
+
+	code, _ = suite.postJSON("/api/user/password", token, map[string]interface{}{
+		"current_password": "wrongpassword",
+		"new_password":     "newpassword123",
+	})
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	code, _ = suite.postJSON("/api/user/password", token, map[string]interface{}{
+		"current_password": "password123",
+		"new_password":     "newpassword123",
+	})
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, http.StatusOK, suite.getStatus("/api/protected", token))
+	assert.Equal(t, http.StatusUnauthorized, suite.getStatus("/api/protected", otherToken))
+	assert.Equal(t, http.StatusUnauthorized, suite.getStatus("/api/polls", apiToken))
+
+	code, _ = suite.postJSON("/api/auth/signin", "", map[string]interface{}{
+		"email":    "new@example.com",
+		"password": "newpassword123",
+	})
+	assert.Equal(t, http.StatusOK, code)
+}
+
+func (suite *AuthIntegrationTestSuite) TestCurrentPasswordChecksAreThrottled() {
+	t := suite.T()
+
+	code, signupResponse := suite.postJSON("/api/auth/signup", "", map[string]interface{}{
+		"username": "guesseduser",
+		"email":    "guessed@example.com",
+		"password": "password123",
+	})
+	suite.Require().Equal(http.StatusCreated, code)
+	token := signupResponse["token"].(string)
+
+	// Wrong current passwords count like failed sign-ins
+	code, _ = suite.sendJSON("PATCH", "/api/user/profile", token, map[string]interface{}{
+		"email":            "other@example.com",
+		"current_password": "wrong-1",
+	})
+	assert.Equal(t, http.StatusUnauthorized, code)
+	code, _ = suite.postJSON("/api/user/password", token, map[string]interface{}{
+		"current_password": "wrong-2",
+		"new_password":     "newpassword123",
+	})
+	assert.Equal(t, http.StatusUnauthorized, code)
+	code, _ = suite.sendJSON("DELETE", "/api/user/account", token, map[string]interface{}{"password": "wrong-3"})
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	// Now even the right password has to wait, here and on the sign-in form
+	code, response := suite.postJSON("/api/user/password", token, map[string]interface{}{
+		"current_password": "password123",
+		"new_password":     "newpassword123",
+	})
+	assert.Equal(t, http.StatusTooManyRequests, code)
+	assert.Equal(t, "Too many failed sign-in attempts. Please try again later", response["error"])
+
+	code, _ = suite.sendJSON("DELETE", "/api/user/account", token, map[string]interface{}{"password": "password123"})
+	assert.Equal(t, http.StatusTooManyRequests, code)
+
+	code, _ = suite.postJSON("/api/auth/signin", "", map[string]interface{}{
+		"email":    "guessed@example.com",
+		"password": "password123",
+	})
+	assert.Equal(t, http.StatusTooManyRequests, code)
+}
+
+func (suite *AuthIntegrationTestSuite) TestDeleteAccountAnonymizesComments() {
+	t := suite.T()
+
+	code, signupResponse := suite.postJSON("/api/auth/signup", "", map[string]interface{}{
+		"username": "leavinguser",
+		"email":    "leaving@example.com",
+		"password": "password123",
+	})
+	assert.Equal(t, http.StatusCreated, code)
+	token := signupResponse["token"].(string)
+	userID, err := primitive.ObjectIDFromHex(signupResponse["user"].(map[string]interface{})["id"].(string))
+	suite.Require().NoError(err)
+
+	commentID := primitive.NewObjectID()
+	_, err = suite.db.Collection("comments").InsertOne(context.Background(), bson.M{
+		"_id":        commentID,
+		"poll_id":    primitive.NewObjectID(),
+		"user_id":    userID,
+		"text":       "Still worth reading",
+		"created_at": primitive.NewDateTimeFromTime(time.Now()),
+	})
+	suite.Require().NoError(err)
+	defer suite.db.Collection("comments").DeleteOne(context.Background(), bson.M{"_id": commentID})
+
+	code, _ = suite.sendJSON("DELETE", "/api/user/account", token, map[string]interface{}{"password": "wrongpassword"})
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	code, _ = suite.sendJSON("DELETE", "/api/user/account", token, map[string]interface{}{"password": "password123"})
+	assert.Equal(t, http.StatusOK, code)
+
+	// The account is gone and its session with it
+	assert.Equal(t, http.StatusUnauthorized, suite.getStatus("/api/protected", token))
+	code, _ = suite.postJSON("/api/auth/signin", "", map[string]interface{}{
+		"email":    "leaving@example.com",
+		"password": "password123",
+	})
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	// The comment stays up, without an author
+	var comment bson.M
+	err = suite.db.Collection("comments").FindOne(context.Background(), bson.M{"_id": commentID}).Decode(&comment)
+	suite.Require().NoError(err)
+	assert.NotContains(t, comment, "user_id")
+	assert.Nil(t, comment["deleted_at"])
+}
+
+func (suite *AuthIntegrationTestSuite) TestTwoFactorSignIn() {
+	t := suite.T()
+
+	authHelper := helpers.NewAuthHelper()
+	hashedPassword, _ := authHelper.HashPassword("password123")
+	secret, err := totp.GenerateSecret()
+	suite.Require().NoError(err)
+
+	user := models.User{
+		ID:                 primitive.NewObjectID(),
+		Username:           "totpuser",
+		Email:              "totp@example.com",
+		Password:           hashedPassword,
+		Role:               models.RoleUser,
+		TwoFactorEnabled:   true,
+		TwoFactorSecret:    secret,
+		RecoveryCodeHashes: []string{},
+	}
+	_, err = suite.db.Collection("users").InsertOne(context.Background(), user)
+	suite.Require().NoError(err)
+
+	// The password alone only yields a challenge token
+	code, signinResponse := suite.postJSON("/api/auth/signin", "", map[string]interface{}{
+		"email":    "totp@example.com",
+		"password": "password123",
+	})
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, true, signinResponse["two_factor_required"])
+	assert.NotContains(t, signinResponse, "token")
+	challengeToken := signinResponse["challenge_token"].(string)
+
+	// The challenge token is not an access token
+	req, _ := http.NewRequest("GET", "/api/protected", nil)
+	req.Header.Set("Authorization", "Bearer "+challengeToken)
+	resp := httptest.NewRecorder()
+	suite.router.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+
+	totpCode, err := totp.CodeAt(secret, totp.Step(time.Now()))
+	suite.Require().NoError(err)
+
+	// Nor can services that check access tokens with the published keys take
+	// it for one
+	challengeClaims := jwt.MapClaims{}
+	_, _, err = jwt.NewParser().ParseUnverified(challengeToken, challengeClaims)
+	suite.Require().NoError(err)
+	assert.NotEqual(t, handlers.AccessTokenAudience, challengeClaims["aud"])
+	assert.NotEqual(t, "access", challengeClaims["typ"])
+
+	code, verifyResponse := suite.postJSON("/api/auth/2fa/verify", "", map[string]interface{}{
+		"challenge_token": challengeToken,
+		"code":            totpCode,
+	})
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, verifyResponse, "token")
+
+	accessClaims := jwt.MapClaims{}
+	_, _, err = jwt.NewParser().ParseUnverified(verifyResponse["token"].(string), accessClaims)
+	suite.Require().NoError(err)
+	assert.Equal(t, handlers.TokenIssuer(), accessClaims["iss"])
+	assert.Equal(t, handlers.AccessTokenAudience, accessClaims["aud"])
+	assert.Equal(t, "access", accessClaims["typ"])
+
+	// An access token has to name its issuer, audience and type
+	for claim, value := range map[string]interface{}{"iss": "someone-else", "aud": "voteverse-2fa", "typ": nil} {
+		forgedClaims := jwt.MapClaims{}
+		for key, original := range accessClaims {
+			forgedClaims[key] = original
+		}
+		if value == nil {
+			delete(forgedClaims, claim)
+		} else {
+			forgedClaims[claim] = value
+		}
+		forged, err := handlers.TokenKeys().Sign(forgedClaims)
+		suite.Require().NoError(err)
+		assert.Equal(t, http.StatusUnauthorized, suite.getStatus("/api/protected", forged), claim)
+	}
+
+	// The same code cannot be used twice
+	code, _ = suite.postJSON("/api/auth/2fa/verify", "", map[string]interface{}{
+		"challenge_token": challengeToken,
+		"code":            totpCode,
+	})
+	assert.Equal(t, http.StatusUnauthorized, code)
+}
+
+func (suite *AuthIntegrationTestSuite) TestTwoFactorEnrolmentNeedsPassword() {
+	t := suite.T()
+
+	code, signupResponse := suite.postJSON("/api/auth/signup", "", map[string]interface{}{
+		"username": "enroluser",
+		"email":    "enrol@example.com",
+		"password": "password123",
+	})
+	suite.Require().Equal(http.StatusCreated, code)
+	token := signupResponse["token"].(string)
+
+	// A session alone cannot set up a second factor
+	code, _ = suite.postJSON("/api/user/2fa/enroll", token, map[string]interface{}{})
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = suite.postJSON("/api/user/2fa/enroll", token, map[string]interface{}{"password": "wrongpassword"})
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	code, enrolResponse := suite.postJSON("/api/user/2fa/enroll", token, map[string]interface{}{"password": "password123"})
+	suite.Require().Equal(http.StatusOK, code)
+	secret := enrolResponse["secret"].(string)
+
+	totpCode, err := totp.CodeAt(secret, totp.Step(time.Now()))
+	suite.Require().NoError(err)
+
+	code, _ = suite.postJSON("/api/user/2fa/confirm", token, map[string]interface{}{
+		"password": "wrongpassword",
+		"code":     totpCode,
+	})
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	var stored models.User
+	suite.Require().NoError(suite.db.Collection("users").FindOne(context.Background(), bson.M{"email": "enrol@example.com"}).Decode(&stored))
+	assert.False(t, stored.TwoFactorEnabled)
+
+	code, confirmResponse := suite.postJSON("/api/user/2fa/confirm", token, map[string]interface{}{
+		"password": "password123",
+		"code":     totpCode,
+	})
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, confirmResponse, "recovery_codes")
+}
+
+func (suite *AuthIntegrationTestSuite) getStatus(path, token string) int {
+	req, _ := http.NewRequest("GET", path, nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp := httptest.NewRecorder()
+	suite.router.ServeHTTP(resp, req)
+	return resp.Code
+}
+
+func (suite *AuthIntegrationTestSuite) TestAPITokenScopesAndRevocation() {
+	t := suite.T()
+
+	code, signupResponse := suite.postJSON("/api/auth/signup", "", map[string]interface{}{
+		"username": "scriptuser",
+		"email":    "script@example.com",
+		"password": "password123",
+	})
+	suite.Require().Equal(http.StatusCreated, code)
+	sessionToken := signupResponse["token"].(string)
+
+	// Unknown scopes are rejected
+	code, _ = suite.postJSON("/api/user/tokens", sessionToken, map[string]interface{}{
+		"name":   "ci",
+		"scopes": []string{"admin"},
+	})
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	code, tokenResponse := suite.postJSON("/api/user/tokens", sessionToken, map[string]interface{}{
+		"name":       "ci",
+		"scopes":     []string{"polls:read"},
+		"expires_at": time.Now().Add(24 * time.Hour).Format(time.RFC3339),
+	})
+	suite.Require().Equal(http.StatusCreated, code)
+	apiToken := tokenResponse["token"].(string)
+	tokenID := tokenResponse["id"].(string)
+	assert.Regexp(t, `^vv_pat_`, apiToken)
+
+	// Only the hash is stored
+	var stored models.APIToken
+	err := suite.db.Collection("api_tokens").FindOne(context.Background(), bson.M{}).Decode(&stored)
+	suite.Require().NoError(err)
+	assert.NotEqual(t, apiToken, stored.TokenHash)
+	assert.Nil(t, stored.LastUsedAt)
+
+	// The token works where its scope allows, and records its use
+	assert.Equal(t, http.StatusOK, suite.getStatus("/api/polls", apiToken))
+	err = suite.db.Collection("api_tokens").FindOne(context.Background(), bson.M{}).Decode(&stored)
+	suite.Require().NoError(err)
+	assert.NotNil(t, stored.LastUsedAt)
+
+	// Other routes need a session
+	assert.Equal(t, http.StatusForbidden, suite.getStatus("/api/protected", apiToken))
+	code, _ = suite.postJSON("/api/user/tokens", apiToken, map[string]interface{}{
+		"name":   "escalate",
+		"scopes": []string{"polls:write"},
+	})
+	assert.Equal(t, http.StatusForbidden, code)
+
+	// A revoked token stops working
+	req, _ := http.NewRequest("DELETE", "/api/user/tokens/"+tokenID, nil)
+	req.Header.Set("Authorization", "Bearer "+sessionToken)
+	resp := httptest.NewRecorder()
+	suite.router.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, http.StatusUnauthorized, suite.getStatus("/api/polls", apiToken))
+}
+
+func (suite *AuthIntegrationTestSuite) TestFailedSignInsBackOff() {
+	t := suite.T()
+
+	code, _ := suite.postJSON("/api/auth/signup", "", map[string]interface{}{
+		"username": "backoffuser",
+		"email":    "backoff@example.com",
+		"password": "password123",
+	})
+	suite.Require().Equal(http.StatusCreated, code)
+
+	wrong := map[string]interface{}{"email": "backoff@example.com", "password": "wrong-password"}
+	for i := 0; i < 3; i++ {
+		code, _ = suite.postJSON("/api/auth/signin", "", wrong)
+		assert.Equal(t, http.StatusUnauthorized, code)
+	}
+
+	// Even the right password has to wait for the backoff
+	payload, _ := json.Marshal(map[string]interface{}{"email": "backoff@example.com", "password": "password123"})
+	req, _ := http.NewRequest("POST", "/api/auth/signin", bytes.NewBuffer(payload))
+	req.Header.Set("Content-Type", "application/json")
+	resp := httptest.NewRecorder()
+	suite.router.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusTooManyRequests, resp.Code)
+	assert.NotEmpty(t, resp.Header().Get("Retry-After"))
+
+	// Every attempt is recorded
+	count, err := suite.db.Collection("signin_events").CountDocuments(context.Background(), bson.M{
+		"email":   "backoff@example.com",
+		"success": false,
+	})
+	suite.Require().NoError(err)
+	assert.Equal(t, int64(4), count)
+}
+
+func (suite *AuthIntegrationTestSuite) TestLockoutNotifiesOwnerAndAdminUnlocks() {
+	t := suite.T()
+	t.Setenv("SIGNIN_LOCKOUT_THRESHOLD", "3")
+
+	mailPath := filepath.Join(t.TempDir(), "mail.log")
+	defer handlers.SetMailer(handlers.Mailer())
+	handlers.SetMailer(mailer.NewFileMailer(mailPath, "test@voteverse.com"))
+
+	// The first user becomes an admin
+	code, adminResponse := suite.postJSON("/api/auth/signup", "", map[string]interface{}{
+		"username": "lockadmin",
+		"email":    "lockadmin@example.com",
+		"password": "password123",
+	})
+	suite.Require().Equal(http.StatusCreated, code)
+	adminToken := adminResponse["token"].(string)
+
+	code, userResponse := suite.postJSON("/api/auth/signup", "", map[string]interface{}{
+		"username": "lockeduser",
+		"email":    "locked@example.com",
+		"password": "password123",
+	})
+	suite.Require().Equal(http.StatusCreated, code)
+	userID := userResponse["user"].(map[string]interface{})["id"].(string)
+
+	wrong := map[string]interface{}{"email": "locked@example.com", "password": "wrong-password"}
+	for i := 0; i < 3; i++ {
+		code, _ = suite.postJSON("/api/auth/signin", "", wrong)
+		assert.Equal(t, http.StatusUnauthorized, code)
+	}
+
+	// The account is locked and its owner is told. The answer is the same as
+	// for throttling, which unknown emails get too.
+	right := map[string]interface{}{"email": "locked@example.com", "password": "password123"}
+	code, lockedResponse := suite.postJSON("/api/auth/signin", "", right)
+	assert.Equal(t, http.StatusTooManyRequests, code)
+	assert.Equal(t, "Too many failed sign-in attempts. Please try again later", lockedResponse["error"])
+	assert.NotContains(t, lockedResponse, "locked_until")
+
+	mail, err := os.ReadFile(mailPath)
+	suite.Require().NoError(err)
+	assert.Contains(t, string(mail), "Your VoteVerse account has been locked")
+
+	// Sessions started before the lockout keep working, but only admins can unlock
+	code, _ = suite.postJSON("/api/admin/users/"+userID+"/unlock", userResponse["token"].(string), nil)
+	assert.Equal(t, http.StatusForbidden, code)
+
+	code, _ = suite.postJSON("/api/admin/users/"+userID+"/unlock", adminToken, nil)
+	assert.Equal(t, http.StatusOK, code)
+
+	code, _ = suite.postJSON("/api/auth/signin", "", right)
+	assert.Equal(t, http.StatusOK, code)
+
+	// The admin can review the attempts
+	req, _ := http.NewRequest("GET", "/api/admin/signin-events?user_id="+userID, nil)
+	req.Header.Set("Authorization", "Bearer "+adminToken)
+	resp := httptest.NewRecorder()
+	suite.router.ServeHTTP(resp, req)
+	suite.Require().Equal(http.StatusOK, resp.Code)
+
+	var events []models.SignInEvent
+	suite.Require().NoError(json.Unmarshal(resp.Body.Bytes(), &events))
+	suite.Require().Len(events, 5)
+	assert.True(t, events[0].Success)
+	assert.Equal(t, "locked", events[1].Reason)
+	assert.Equal(t, "invalid_password", events[2].Reason)
+}
+
+// oidcSignIn runs the single sign-on flow against the mock provider
+func (suite *AuthIntegrationTestSuite) oidcSignIn(provider *mocks.OIDCProvider) (int, map[string]interface{}) {
+	code, state, cookies := suite.oidcAuthorize(provider)
+	return suite.oidcCallback(code, state, cookies)
+}
+
+// oidcAuthorize starts a sign-on and returns the provider's code and state
+// along with the cookies the login response set on the browser
+func (suite *AuthIntegrationTestSuite) oidcAuthorize(provider *mocks.OIDCProvider) (string, string, []*http.Cookie) {
+	req, _ := http.NewRequest("GET", "/api/auth/oidc/login", nil)
+	resp := httptest.NewRecorder()
+	suite.router.ServeHTTP(resp, req)
+	suite.Require().Equal(http.StatusOK, resp.Code)
+
+	var loginResponse map[string]string
+	suite.Require().NoError(json.Unmarshal(resp.Body.Bytes(), &loginResponse))
+
+	code, state, err := provider.Authorize(loginResponse["authorization_url"])
+	suite.Require().NoError(err)
+	return code, state, resp.Result().Cookies()
+}
+
+// oidcCallback finishes a sign-on from a browser holding the given cookies
+func (suite *AuthIntegrationTestSuite) oidcCallback(code, state string, cookies []*http.Cookie) (int, map[string]interface{}) {
+	payload, _ := json.Marshal(map[string]interface{}{"code": code, "state": state})
+	req, _ := http.NewRequest("POST", "/api/auth/oidc/callback", bytes.NewBuffer(payload))
+	req.Header.Set("Content-Type", "application/json")
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+	resp := httptest.NewRecorder()
+	suite.router.ServeHTTP(resp, req)
+
+	var response map[string]interface{}
+	json.Unmarshal(resp.Body.Bytes(), &response)
+	return resp.Code, response
+}
+
+func (suite *AuthIntegrationTestSuite) TestOIDCSignIn() {
+	t := suite.T()
+
+	mockProvider := mocks.NewOIDCProvider("voteverse", "secret")
+	defer mockProvider.Close()
+
+	provider, err := oidc.NewProvider(context.Background(), oidc.Config{
+		IssuerURL:    mockProvider.Issuer(),
+		ClientID:     "voteverse",
+		ClientSecret: "secret",
+		RedirectURL:  "http://localhost:3000/auth/oidc/callback",
+		Scopes:       []string{"openid", "email", "profile"},
+	})
+	suite.Require().NoError(err)
+	handlers.SetOIDCProvider(provider)
+	defer handlers.SetOIDCProvider(nil)
+
+	// A new identity is provisioned just in time
+	mockProvider.User = mocks.OIDCUser{
+		Subject:           "employee-1",
+		Email:             "employee@example.com",
+		EmailVerified:     true,
+		PreferredUsername: "employee",
+	}
+	code, response := suite.oidcSignIn(mockProvider)
+	assert.Equal(t, http.StatusCreated, code)
+	assert.Contains(t, response, "token")
+
+	var user models.User
+	err = suite.db.Collection("users").FindOne(context.Background(), bson.M{"email": "employee@example.com"}).Decode(&user)
+	suite.Require().NoError(err)
+	assert.Equal(t, "employee", user.Username)
+	assert.True(t, user.EmailVerified)
+	suite.Require().Len(user.ExternalIdentities, 1)
+	assert.Equal(t, "employee-1", user.ExternalIdentities[0].Subject)
+
+	// Signing in again finds the same user
+	code, response = suite.oidcSignIn(mockProvider)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, user.ID.Hex(), response["user"].(map[string]interface{})["id"])
+
+	// A state cannot be replayed
+	code, _ = suite.postJSON("/api/auth/oidc/callback", "", map[string]interface{}{
+		"code":  "any",
+		"state": "unknown",
+	})
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	// A code and state only finish the sign-on in the browser that started it
+	code1, state1, cookies1 := suite.oidcAuthorize(mockProvider)
+	_, _, cookies2 := suite.oidcAuthorize(mockProvider)
+	code, _ = suite.oidcCallback(code1, state1, nil)
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = suite.oidcCallback(code1, state1, cookies2)
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = suite.oidcCallback(code1, state1, cookies1)
+	assert.Equal(t, http.StatusOK, code)
+	code, _ = suite.oidcCallback(code1, state1, cookies1)
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	// An existing password account is linked only if the provider verified the email
+	authHelper := helpers.NewAuthHelper()
+	hashedPassword, _ := authHelper.HashPassword("password123")
+	existing := models.User{
+		ID:       primitive.NewObjectID(),
+		Username: "existing",
+		Email:    "existing@example.com",
+		Password: hashedPassword,
+		Role:     models.RoleUser,
+	}
+	_, err = suite.db.Collection("users").InsertOne(context.Background(), existing)
+	suite.Require().NoError(err)
+
+	mockProvider.User = mocks.OIDCUser{Subject: "employee-2", Email: "existing@example.com"}
+	code, _ = suite.oidcSignIn(mockProvider)
+	assert.Equal(t, http.StatusConflict, code)
+
+	mockProvider.User.EmailVerified = true
+	code, response = suite.oidcSignIn(mockProvider)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, existing.ID.Hex(), response["user"].(map[string]interface{})["id"])
+}
+
 func TestAuthIntegrationTestSuite(t *testing.T) {
 	if testing.Short() {
 		t.Skip("Skipping integration tests in short mode")
@@ -0,0 +1,749 @@
+package integration_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+	"voteverse/handlers"
+	"voteverse/jobs"
+	"voteverse/models"
+	"voteverse/testutils/helpers"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// GroupIntegrationTestSuite covers group settings, subgroups and the poll and
+// comment rules that depend on them
+type GroupIntegrationTestSuite struct {
+	suite.Suite
+	server     *helpers.TestServer
+	db         *mongo.Database
+	client     *mongo.Client
+	adminToken string // Site admin, signed up first so the other users are regular users
+}
+
+func (suite *GroupIntegrationTestSuite) SetupSuite() {
+	mongoURI := os.Getenv("MONGODB_URI")
+	if mongoURI == "" {
+		mongoURI = "mongodb://localhost:27017"
+	}
+
+	dbName := os.Getenv("DB_NAME")
+	if dbName == "" {
+		dbName = "voteverse_test"
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(mongoURI))
+	if err != nil {
+		suite.T().Fatalf("Failed to connect to MongoDB: %v", err)
+	}
+	if err := client.Ping(ctx, nil); err != nil {
+		suite.T().Fatalf("Failed to ping MongoDB: %v", err)
+	}
+
+	suite.client = client
+	suite.db = client.Database(dbName)
+
+	suite.server = helpers.NewTestServer()
+	router := suite.server.Engine
+	router.POST("/api/auth/signup", func(c *gin.Context) {
+		handlers.SignUp(c, suite.db)
+	})
+
+	authMiddleware := handlers.AuthMiddleware(suite.db)
+	api := router.Group("/api", authMiddleware)
+	api.GET("/groups", handlers.ListGroups)
+	api.POST("/groups", handlers.CreateGroup)
+	api.GET("/groups/:id", handlers.GetGroup)
+	api.PATCH("/groups/:id", handlers.UpdateGroup)
+	api.GET("/groups/:id/activity", handlers.GetGroupActivity)
+	api.GET("/polls", handlers.ListPolls)
+	api.POST("/polls", handlers.CreatePoll)
+	api.GET("/polls/:id", handlers.GetPoll)
+	api.POST("/polls/:id/vote", handlers.Vote)
+	api.GET("/comments/poll/:pollId", handlers.ListComments)
+	api.POST("/comments/poll/:pollId", handlers.CreateComment)
+	api.DELETE("/comments/:id", handlers.DeleteComment)
+	api.DELETE("/admin/groups/:id", func(c *gin.Context) {
+		handlers.AdminDeleteGroup(c, suite.db)
+	})
+	api.DELETE("/admin/polls/:id", func(c *gin.Context) {
+		handlers.AdminDeletePoll(c, suite.db)
+	})
+	api.POST("/admin/groups/:id/restore", func(c *gin.Context) {
+		handlers.AdminRestoreGroup(c, suite.db)
+	})
+	api.POST("/admin/polls/:id/restore", func(c *gin.Context) {
+		handlers.AdminRestorePoll(c, suite.db)
+	})
+	api.POST("/admin/comments/:id/restore", func(c *gin.Context) {
+		handlers.AdminRestoreComment(c, suite.db)
+	})
+}
+
+func (suite *GroupIntegrationTestSuite) TearDownSuite() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := suite.db.Drop(ctx); err != nil {
+		suite.T().Logf("Failed to drop test database: %v", err)
+	}
+	if err := suite.client.Disconnect(ctx); err != nil {
+		suite.T().Logf("Failed to disconnect from MongoDB: %v", err)
+	}
+}
+
+func (suite *GroupIntegrationTestSuite) SetupTest() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	for _, collection := range []string{"users", "sessions", "email_verifications", "groups", "group_members", "events", "polls", "votes", "comments"} {
+		if _, err := suite.db.Collection(collection).DeleteMany(ctx, bson.M{}); err != nil {
+			suite.T().Logf("Failed to clear %s collection: %v", collection, err)
+		}
+	}
+	_, suite.adminToken = suite.signUp("siteadmin")
+}
+
+// sendJSON sends a JSON request to the test router and decodes the response
+func (suite *GroupIntegrationTestSuite) sendJSON(method, path, token string, body interface{}) (int, map[string]interface{}) {
+	code, raw := suite.send(method, path, token, body)
+	var response map[string]interface{}
+	json.Unmarshal(raw, &response)
+	return code, response
+}
+
+// send sends a JSON request to the test router and returns the raw response
+func (suite *GroupIntegrationTestSuite) send(method, path, token string, body interface{}) (int, []byte) {
+	var payload []byte
+	if body != nil {
+		payload, _ = json.Marshal(body)
+	}
+	req, _ := http.NewRequest(method, path, bytes.NewBuffer(payload))
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp := suite.server.ServeHTTP(req)
+	return resp.Code, resp.Body.Bytes()
+}
+
+// getList fetches a JSON array from the test router
+func (suite *GroupIntegrationTestSuite) getList(path, token string) []interface{} {
+	code, raw := suite.send("GET", path, token, nil)
+	suite.Require().Equal(http.StatusOK, code, string(raw))
+	var list []interface{}
+	suite.Require().NoError(json.Unmarshal(raw, &list))
+	return list
+}
+
+// pollIDs returns the IDs of the polls in a list
+func pollIDs(polls []interface{}) []string {
+	ids := make([]string, len(polls))
+	for i, poll := range polls {
+		ids[i] = poll.(map[string]interface{})["id"].(string)
+	}
+	return ids
+}
+
+// signUp creates a user and returns their ID and access token
+func (suite *GroupIntegrationTestSuite) signUp(username string) (primitive.ObjectID, string) {
+	code, response := suite.sendJSON("POST", "/api/auth/signup", "", map[string]interface{}{
+		"username": username,
+		"email":    username + "@example.com",
+		"password": "password123",
+	})
+	suite.Require().Equal(http.StatusCreated, code)
+
+	userID, err := primitive.ObjectIDFromHex(response["user"].(map[string]interface{})["id"].(string))
+	suite.Require().NoError(err)
+	return userID, response["token"].(string)
+}
+
+// insertGroup stores a group as given, with the first member as admin
+func (suite *GroupIntegrationTestSuite) insertGroup(group bson.M, members ...primitive.ObjectID) primitive.ObjectID {
+	now := primitive.NewDateTimeFromTime(time.Now())
+	groupID := primitive.NewObjectID()
+	group["_id"] = groupID
+	group["is_active"] = true
+	group["created_at"] = now
+	group["updated_at"] = now
+	if _, ok := group["name"]; !ok {
+		group["name"] = "group-" + groupID.Hex()
+	}
+	_, err := suite.db.Collection("groups").InsertOne(context.Background(), group)
+	suite.Require().NoError(err)
+
+	for i, userID := range members {
+		role := "member"
+		if i == 0 {
+			role = "admin"
+		}
+		_, err := suite.db.Collection("group_members").InsertOne(context.Background(), models.GroupMember{
+			ID:       primitive.NewObjectID(),
+			GroupID:  groupID,
+			UserID:   userID,
+			Role:     role,
+			JoinedAt: now,
+		})
+		suite.Require().NoError(err)
+	}
+	return groupID
+}
+
+// createPoll creates a group poll through the API and returns its ID and first option
+func (suite *GroupIntegrationTestSuite) createPoll(token string, groupID primitive.ObjectID, resultsVisibility string) (string, string) {
+	body := map[string]interface{}{
+		"group_id":   groupID.Hex(),
+		"title":      "Lunch?",
+		"visibility": "group",
+		"options":    []map[string]string{{"text": "Pizza"}, {"text": "Salad"}},
+	}
+	if resultsVisibility != "" {
+		body["results_visibility"] = resultsVisibility
+	}
+	code, poll := suite.sendJSON("POST", "/api/polls", token, body)
+	suite.Require().Equal(http.StatusCreated, code, poll)
+	return poll["id"].(string), poll["options"].([]interface{})[0].(map[string]interface{})["id"].(string)
+}
+
+// voteCount returns the first option's vote count as a user sees it
+func (suite *GroupIntegrationTestSuite) voteCount(token, pollID string) float64 {
+	code, poll := suite.sendJSON("GET", "/api/polls/"+pollID, token, nil)
+	suite.Require().Equal(http.StatusOK, code, poll)
+	count, _ := poll["options"].([]interface{})[0].(map[string]interface{})["vote_count"].(float64)
+	return count
+}
+
+func (suite *GroupIntegrationTestSuite) TestUpdateGroupRequiresGroupAdmin() {
+	t := suite.T()
+
+	adminID, adminToken := suite.signUp("patchadmin")
+	memberID, memberToken := suite.signUp("patchmember")
+	_, outsiderToken := suite.signUp("patchoutsider")
+	groupID := suite.insertGroup(bson.M{"name": "Patch Group"}, adminID, memberID)
+	subgroupID := suite.insertGroup(bson.M{"name": "Patch Subgroup", "parent_id": groupID, "ancestors": bson.A{groupID}})
+
+	code, _ := suite.sendJSON("PATCH", "/api/groups/"+groupID.Hex(), memberToken, map[string]interface{}{"name": "Taken Over"})
+	assert.Equal(t, http.StatusForbidden, code)
+	code, _ = suite.sendJSON("PATCH", "/api/groups/"+groupID.Hex(), outsiderToken, map[string]interface{}{"description": "Nope"})
+	assert.Equal(t, http.StatusForbidden, code)
+
+	code, group := suite.sendJSON("PATCH", "/api/groups/"+groupID.Hex(), adminToken, map[string]interface{}{
+		"name":     "Renamed Group",
+		"settings": map[string]interface{}{"poll_creation": "admins"},
+	})
+	suite.Require().Equal(http.StatusOK, code, group)
+	assert.Equal(t, "Renamed Group", group["name"])
+	assert.Equal(t, "admins", group["settings"].(map[string]interface{})["poll_creation"])
+
+	// Admins of a parent group manage its subgroups
+	code, _ = suite.sendJSON("PATCH", "/api/groups/"+subgroupID.Hex(), adminToken, map[string]interface{}{"description": "Managed from above"})
+	assert.Equal(t, http.StatusOK, code)
+
+	code, _ = suite.sendJSON("PATCH", "/api/groups/"+groupID.Hex(), adminToken, map[string]interface{}{})
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = suite.sendJSON("PATCH", "/api/groups/"+groupID.Hex(), adminToken, map[string]interface{}{"avatar_url": "javascript:alert(1)"})
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = suite.sendJSON("PATCH", "/api/groups/"+groupID.Hex(), adminToken, map[string]interface{}{"name": "Patch Subgroup"})
+	assert.Equal(t, http.StatusConflict, code)
+}
+
+func (suite *GroupIntegrationTestSuite) TestGroupsWithoutStoredSettingsUseDefaults() {
+	t := suite.T()
+
+	adminID, adminToken := suite.signUp("legacyadmin")
+	memberID, memberToken := suite.signUp("legacymember")
+	// Groups created before settings existed have no settings document
+	groupID := suite.insertGroup(bson.M{"name": "Legacy Group"}, adminID, memberID)
+
+	// Members may create polls, which last a day and always show results
+	code, poll := suite.sendJSON("POST", "/api/polls", memberToken, map[string]interface{}{
+		"group_id":   groupID.Hex(),
+		"title":      "Legacy poll",
+		"visibility": "group",
+		"options":    []map[string]string{{"text": "Yes"}, {"text": "No"}},
+	})
+	suite.Require().Equal(http.StatusCreated, code, poll)
+	assert.Equal(t, models.ResultsVisibilityAlways, poll["results_visibility"])
+	start, _ := time.Parse(time.RFC3339, poll["start_time"].(string))
+	end, _ := time.Parse(time.RFC3339, poll["end_time"].(string))
+	assert.Equal(t, 24*time.Hour, end.Sub(start))
+
+	// Members may comment
+	code, _ = suite.sendJSON("POST", "/api/comments/poll/"+poll["id"].(string), memberToken, map[string]interface{}{"text": "Hi"})
+	assert.Equal(t, http.StatusCreated, code)
+
+	// Stored settings replace the defaults
+	code, _ = suite.sendJSON("PATCH", "/api/groups/"+groupID.Hex(), adminToken, map[string]interface{}{
+		"settings": map[string]interface{}{"poll_creation": "admins", "default_poll_duration_hours": 2},
+	})
+	suite.Require().Equal(http.StatusOK, code)
+	code, _ = suite.sendJSON("POST", "/api/polls", memberToken, map[string]interface{}{
+		"group_id":   groupID.Hex(),
+		"title":      "Not allowed",
+		"visibility": "group",
+		"options":    []map[string]string{{"text": "Yes"}, {"text": "No"}},
+	})
+	assert.Equal(t, http.StatusForbidden, code)
+	code, poll = suite.sendJSON("POST", "/api/polls", adminToken, map[string]interface{}{
+		"group_id":   groupID.Hex(),
+		"title":      "Short poll",
+		"visibility": "group",
+		"options":    []map[string]string{{"text": "Yes"}, {"text": "No"}},
+	})
+	suite.Require().Equal(http.StatusCreated, code)
+	start, _ = time.Parse(time.RFC3339, poll["start_time"].(string))
+	end, _ = time.Parse(time.RFC3339, poll["end_time"].(string))
+	assert.Equal(t, 2*time.Hour, end.Sub(start))
+}
+
+func (suite *GroupIntegrationTestSuite) TestMembersCanCommentSetting() {
+	t := suite.T()
+
+	adminID, adminToken := suite.signUp("commentadmin")
+	memberID, memberToken := suite.signUp("commentmember")
+	groupID := suite.insertGroup(bson.M{"name": "Quiet Group"}, adminID, memberID)
+	pollID, _ := suite.createPoll(adminToken, groupID, "")
+
+	code, _ := suite.sendJSON("PATCH", "/api/groups/"+groupID.Hex(), adminToken, map[string]interface{}{
+		"settings": map[string]interface{}{"members_can_comment": false},
+	})
+	suite.Require().Equal(http.StatusOK, code)
+
+	code, response := suite.sendJSON("POST", "/api/comments/poll/"+pollID, memberToken, map[string]interface{}{"text": "Can I?"})
+	assert.Equal(t, http.StatusForbidden, code)
+	assert.Equal(t, "Comments are restricted to group admins", response["error"])
+	code, _ = suite.sendJSON("POST", "/api/comments/poll/"+pollID, adminToken, map[string]interface{}{"text": "Admins can"})
+	assert.Equal(t, http.StatusCreated, code)
+
+	code, _ = suite.sendJSON("PATCH", "/api/groups/"+groupID.Hex(), adminToken, map[string]interface{}{
+		"settings": map[string]interface{}{"members_can_comment": true},
+	})
+	suite.Require().Equal(http.StatusOK, code)
+	code, _ = suite.sendJSON("POST", "/api/comments/poll/"+pollID, memberToken, map[string]interface{}{"text": "Now I can"})
+	assert.Equal(t, http.StatusCreated, code)
+}
+
+func (suite *GroupIntegrationTestSuite) TestResultsVisibilityModes() {
+	t := suite.T()
+
+	creatorID, creatorToken := suite.signUp("resultscreator")
+	voterID, voterToken := suite.signUp("resultsvoter")
+	watcherID, watcherToken := suite.signUp("resultswatcher")
+	groupID := suite.insertGroup(bson.M{"name": "Results Group"}, creatorID, voterID, watcherID)
+
+	for _, mode := range []string{models.ResultsVisibilityAlways, models.ResultsVisibilityAfterVote, models.ResultsVisibilityAfterClose} {
+		pollID, optionID := suite.createPoll(creatorToken, groupID, mode)
+		code, _ := suite.sendJSON("POST", "/api/polls/"+pollID+"/vote", voterToken, map[string]interface{}{"option_id": optionID})
+		suite.Require().Equal(http.StatusOK, code, mode)
+
+		// Creators always see results
+		assert.Equal(t, float64(1), suite.voteCount(creatorToken, pollID), mode)
+
+		switch mode {
+		case models.ResultsVisibilityAlways:
+			assert.Equal(t, float64(1), suite.voteCount(voterToken, pollID))
+			assert.Equal(t, float64(1), suite.voteCount(watcherToken, pollID))
+		case models.ResultsVisibilityAfterVote:
+			assert.Equal(t, float64(1), suite.voteCount(voterToken, pollID))
+			assert.Equal(t, float64(0), suite.voteCount(watcherToken, pollID))
+		case models.ResultsVisibilityAfterClose:
+			assert.Equal(t, float64(0), suite.voteCount(voterToken, pollID))
+			assert.Equal(t, float64(0), suite.voteCount(watcherToken, pollID))
+
+			// Results appear once the poll has ended
+			pollObjectID, _ := primitive.ObjectIDFromHex(pollID)
+			_, err := suite.db.Collection("polls").UpdateOne(context.Background(), bson.M{"_id": pollObjectID}, bson.M{
+				"$set": bson.M{"end_time": primitive.NewDateTimeFromTime(time.Now().Add(-time.Minute))},
+			})
+			suite.Require().NoError(err)
+			assert.Equal(t, float64(1), suite.voteCount(watcherToken, pollID))
+		}
+	}
+}
+
+func (suite *GroupIntegrationTestSuite) TestGroupTreeNestsSubgroups() {
+	t := suite.T()
+
+	adminID, adminToken := suite.signUp("treeadmin")
+	memberID, memberToken := suite.signUp("treemember")
+	rootID := suite.insertGroup(bson.M{"name": "Tree Root"}, adminID)
+	childID := suite.insertGroup(bson.M{"name": "Tree Child", "parent_id": rootID, "ancestors": bson.A{rootID}}, adminID, memberID)
+	grandchildID := suite.insertGroup(bson.M{"name": "Tree Grandchild", "parent_id": childID, "ancestors": bson.A{rootID, childID}})
+
+	// The root's admin manages the whole hierarchy
+	tree := suite.getList("/api/groups?tree=true", adminToken)
+	suite.Require().Len(tree, 1)
+	root := tree[0].(map[string]interface{})
+	assert.Equal(t, rootID.Hex(), root["id"])
+	children := root["children"].([]interface{})
+	suite.Require().Len(children, 1)
+	child := children[0].(map[string]interface{})
+	assert.Equal(t, childID.Hex(), child["id"])
+	grandchildren := child["children"].([]interface{})
+	suite.Require().Len(grandchildren, 1)
+	assert.Equal(t, grandchildID.Hex(), grandchildren[0].(map[string]interface{})["id"])
+
+	// A subgroup member only sees their subgroup, as a root
+	tree = suite.getList("/api/groups?tree=true", memberToken)
+	suite.Require().Len(tree, 1)
+	assert.Equal(t, childID.Hex(), tree[0].(map[string]interface{})["id"])
+	assert.Empty(t, tree[0].(map[string]interface{})["children"])
+
+	// Without tree the groups are a flat list
+	assert.Len(t, suite.getList("/api/groups", adminToken), 3)
+}
+
+func (suite *GroupIntegrationTestSuite) TestSubgroupMembersSeeSharedPolls() {
+	t := suite.T()
+
+	adminID, adminToken := suite.signUp("shareadmin")
+	memberID, memberToken := suite.signUp("sharemember")
+	_, outsiderToken := suite.signUp("shareoutsider")
+	parentID := suite.insertGroup(bson.M{"name": "Sharing Parent"}, adminID)
+	childID := suite.insertGroup(bson.M{"name": "Sharing Child", "parent_id": parentID, "ancestors": bson.A{parentID}}, adminID, memberID)
+	parentPollID, _ := suite.createPoll(adminToken, parentID, "")
+	childPollID, _ := suite.createPoll(adminToken, childID, "")
+
+	// Parent polls stay private until the parent shares them
+	assert.ElementsMatch(t, []string{childPollID}, pollIDs(suite.getList("/api/polls", memberToken)))
+	code, _ := suite.sendJSON("GET", "/api/polls/"+parentPollID, memberToken, nil)
+	assert.Equal(t, http.StatusForbidden, code)
+	code, _ = suite.sendJSON("GET", "/api/comments/poll/"+parentPollID, memberToken, nil)
+	assert.Equal(t, http.StatusForbidden, code)
+	code, _ = suite.sendJSON("POST", "/api/comments/poll/"+parentPollID, memberToken, map[string]interface{}{"text": "Too early"})
+	assert.Equal(t, http.StatusForbidden, code)
+
+	code, _ = suite.sendJSON("PATCH", "/api/groups/"+parentID.Hex(), adminToken, map[string]interface{}{
+		"settings": map[string]interface{}{"share_polls_with_subgroups": true},
+	})
+	suite.Require().Equal(http.StatusOK, code)
+
+	// Subgroup members see, read and discuss shared polls like members
+	assert.ElementsMatch(t, []string{parentPollID, childPollID}, pollIDs(suite.getList("/api/polls", memberToken)))
+	assert.ElementsMatch(t, []string{parentPollID}, pollIDs(suite.getList("/api/polls?group_id="+parentID.Hex(), memberToken)))
+	code, _ = suite.sendJSON("GET", "/api/polls/"+parentPollID, memberToken, nil)
+	assert.Equal(t, http.StatusOK, code)
+	code, _ = suite.sendJSON("POST", "/api/comments/poll/"+parentPollID, memberToken, map[string]interface{}{"text": "From the subgroup"})
+	assert.Equal(t, http.StatusCreated, code)
+	comments := suite.getList("/api/comments/poll/"+parentPollID, memberToken)
+	suite.Require().Len(comments, 1)
+	assert.Equal(t, "From the subgroup", comments[0].(map[string]interface{})["text"])
+
+	// The parent's comment setting applies to them too
+	code, _ = suite.sendJSON("PATCH", "/api/groups/"+parentID.Hex(), adminToken, map[string]interface{}{
+		"settings": map[string]interface{}{"members_can_comment": false},
+	})
+	suite.Require().Equal(http.StatusOK, code)
+	code, _ = suite.sendJSON("POST", "/api/comments/poll/"+parentPollID, memberToken, map[string]interface{}{"text": "Restricted"})
+	assert.Equal(t, http.StatusForbidden, code)
+
+	// Users outside the hierarchy still see nothing
+	assert.Empty(t, suite.getList("/api/polls", outsiderToken))
+	code, _ = suite.sendJSON("GET", "/api/polls/"+parentPollID, outsiderToken, nil)
+	assert.Equal(t, http.StatusForbidden, code)
+	code, _ = suite.sendJSON("GET", "/api/comments/poll/"+parentPollID, outsiderToken, nil)
+	assert.Equal(t, http.StatusForbidden, code)
+}
+
+func (suite *GroupIntegrationTestSuite) TestParentAdminsManageSubgroupPolls() {
+	t := suite.T()
+
+	adminID, adminToken := suite.signUp("parentadmin")
+	childAdminID, _ := suite.signUp("childadmin")
+	memberID, memberToken := suite.signUp("childmember")
+	parentID := suite.insertGroup(bson.M{"name": "Managing Parent"}, adminID)
+	childID := suite.insertGroup(bson.M{
+		"name":      "Managed Child",
+		"parent_id": parentID,
+		"ancestors": bson.A{parentID},
+		"settings":  bson.M{"poll_creation": "admins", "members_can_comment": false},
+	}, childAdminID, memberID)
+
+	// The parent's admin is no member of the subgroup but creates polls there
+	pollID, _ := suite.createPoll(adminToken, childID, "")
+	code, _ := suite.sendJSON("POST", "/api/polls", memberToken, map[string]interface{}{
+		"group_id":   childID.Hex(),
+		"title":      "Not allowed",
+		"visibility": "group",
+		"options":    []map[string]string{{"text": "Yes"}, {"text": "No"}},
+	})
+	assert.Equal(t, http.StatusForbidden, code)
+
+	// They see the subgroup's polls and comment where members may not
+	assert.ElementsMatch(t, []string{pollID}, pollIDs(suite.getList("/api/polls", adminToken)))
+	code, _ = suite.sendJSON("POST", "/api/comments/poll/"+pollID, adminToken, map[string]interface{}{"text": "From above"})
+	assert.Equal(t, http.StatusCreated, code)
+	code, _ = suite.sendJSON("POST", "/api/comments/poll/"+pollID, memberToken, map[string]interface{}{"text": "Me too"})
+	assert.Equal(t, http.StatusForbidden, code)
+
+	// Subgroups of a deleted parent are not managed any more
+	_, err := suite.db.Collection("groups").UpdateOne(context.Background(), bson.M{"_id": parentID}, bson.M{
+		"$set": bson.M{"deleted_at": primitive.NewDateTimeFromTime(time.Now())},
+	})
+	suite.Require().NoError(err)
+	assert.Empty(t, suite.getList("/api/groups", adminToken))
+}
+
+// activity returns a group's activity feed as a user sees it
+func (suite *GroupIntegrationTestSuite) activity(groupID primitive.ObjectID, token, query string) []map[string]interface{} {
+	code, response := suite.sendJSON("GET", "/api/groups/"+groupID.Hex()+"/activity"+query, token, nil)
+	suite.Require().Equal(http.StatusOK, code, response)
+	var events []map[string]interface{}
+	for _, event := range response["events"].([]interface{}) {
+		events = append(events, event.(map[string]interface{}))
+	}
+	return events
+}
+
+func (suite *GroupIntegrationTestSuite) TestActivityFeedRecordsGroupEvents() {
+	t := suite.T()
+
+	adminID, adminToken := suite.signUp("feedadmin")
+	memberID, memberToken := suite.signUp("feedmember")
+	_, outsiderToken := suite.signUp("feedoutsider")
+	groupID := suite.insertGroup(bson.M{"name": "Feed Group"}, adminID, memberID)
+
+	pollID, optionID := suite.createPoll(adminToken, groupID, models.ResultsVisibilityAlways)
+	code, _ := suite.sendJSON("POST", "/api/polls/"+pollID+"/vote", memberToken, map[string]interface{}{"option_id": optionID})
+	suite.Require().Equal(http.StatusOK, code)
+	code, _ = suite.sendJSON("POST", "/api/comments/poll/"+pollID, memberToken, map[string]interface{}{"text": "Pizza it is"})
+	suite.Require().Equal(http.StatusCreated, code)
+
+	// Events come newest first
+	events := suite.activity(groupID, memberToken, "")
+	suite.Require().Len(events, 3)
+	assert.Equal(t, models.EventCommentPosted, events[0]["type"])
+	assert.Equal(t, "feedmember", events[0]["actor"].(map[string]interface{})["username"])
+	assert.NotEmpty(t, events[0]["comment_id"])
+	assert.Equal(t, models.EventVoteCast, events[1]["type"])
+	assert.Equal(t, float64(1), events[1]["vote_count"])
+	assert.Nil(t, events[1]["actor"], "votes are anonymous")
+	assert.Equal(t, models.EventPollCreated, events[2]["type"])
+	assert.Equal(t, pollID, events[2]["poll_id"])
+	assert.Equal(t, "feedadmin", events[2]["actor"].(map[string]interface{})["username"])
+
+	// Pages continue from next_before
+	code, page := suite.sendJSON("GET", "/api/groups/"+groupID.Hex()+"/activity?limit=2", memberToken, nil)
+	suite.Require().Equal(http.StatusOK, code)
+	assert.Len(t, page["events"], 2)
+	suite.Require().NotEmpty(page["next_before"])
+	rest := suite.activity(groupID, memberToken, "?limit=2&before="+page["next_before"].(string))
+	suite.Require().Len(rest, 1)
+	assert.Equal(t, models.EventPollCreated, rest[0]["type"])
+
+	code, _ = suite.sendJSON("GET", "/api/groups/"+groupID.Hex()+"/activity", outsiderToken, nil)
+	assert.Equal(t, http.StatusForbidden, code)
+
+	// A deleted group's feed is gone
+	code, _ = suite.sendJSON("DELETE", "/api/admin/groups/"+groupID.Hex(), suite.adminToken, nil)
+	suite.Require().Equal(http.StatusOK, code)
+	code, _ = suite.sendJSON("GET", "/api/groups/"+groupID.Hex()+"/activity", memberToken, nil)
+	assert.Equal(t, http.StatusNotFound, code)
+}
+
+func (suite *GroupIntegrationTestSuite) TestActivityFeedHidesHiddenVoteCounts() {
+	t := suite.T()
+
+	adminID, adminToken := suite.signUp("hiddenfeedadmin")
+	memberID, memberToken := suite.signUp("hiddenfeedmember")
+	groupID := suite.insertGroup(bson.M{"name": "Hidden Feed Group"}, adminID, memberID)
+
+	pollID, optionID := suite.createPoll(adminToken, groupID, models.ResultsVisibilityAfterClose)
+	code, _ := suite.sendJSON("POST", "/api/polls/"+pollID+"/vote", memberToken, map[string]interface{}{"option_id": optionID})
+	suite.Require().Equal(http.StatusOK, code)
+
+	events := suite.activity(groupID, memberToken, "")
+	suite.Require().Equal(models.EventVoteCast, events[0]["type"])
+	assert.NotContains(t, events[0], "vote_count")
+
+	// Totals recorded before a poll hid its results are not shown either
+	pollObjectID, _ := primitive.ObjectIDFromHex(pollID)
+	_, err := suite.db.Collection("events").InsertOne(context.Background(), models.GroupEvent{
+		ID:        primitive.NewObjectID(),
+		GroupID:   groupID,
+		Type:      models.EventVoteCast,
+		PollID:    pollObjectID,
+		VoteCount: 1,
+		CreatedAt: primitive.NewDateTimeFromTime(time.Now()),
+	})
+	suite.Require().NoError(err)
+	events = suite.activity(groupID, memberToken, "")
+	suite.Require().Equal(models.EventVoteCast, events[0]["type"])
+	assert.NotContains(t, events[0], "vote_count")
+}
+
+func (suite *GroupIntegrationTestSuite) TestPollCloserPublishesPollClosed() {
+	t := suite.T()
+
+	adminID, adminToken := suite.signUp("closeradmin")
+	groupID := suite.insertGroup(bson.M{"name": "Closer Group"}, adminID)
+	pollID, _ := suite.createPoll(adminToken, groupID, "")
+	openPollID, _ := suite.createPoll(adminToken, groupID, "")
+
+	pollObjectID, _ := primitive.ObjectIDFromHex(pollID)
+	_, err := suite.db.Collection("polls").UpdateOne(context.Background(), bson.M{"_id": pollObjectID}, bson.M{
+		"$set": bson.M{"end_time": primitive.NewDateTimeFromTime(time.Now().Add(-time.Minute))},
+	})
+	suite.Require().NoError(err)
+
+	var closed []string
+	notify := func(poll models.Poll) {
+		closed = append(closed, poll.ID.Hex())
+		handlers.NotifyPollClosed(suite.db, poll)
+	}
+	assert.Equal(t, 1, jobs.CloseExpiredPolls(context.Background(), suite.db, notify))
+	assert.Equal(t, []string{pollID}, closed)
+
+	var poll models.Poll
+	suite.Require().NoError(suite.db.Collection("polls").FindOne(context.Background(), bson.M{"_id": pollObjectID}).Decode(&poll))
+	assert.False(t, poll.IsActive)
+	code, openPoll := suite.sendJSON("GET", "/api/polls/"+openPollID, adminToken, nil)
+	suite.Require().Equal(http.StatusOK, code)
+	assert.Equal(t, true, openPoll["is_active"])
+
+	events := suite.activity(groupID, adminToken, "")
+	assert.Equal(t, models.EventPollClosed, events[0]["type"])
+	assert.Equal(t, pollID, events[0]["poll_id"])
+
+	// Closed polls are only reported once
+	assert.Equal(t, 0, jobs.CloseExpiredPolls(context.Background(), suite.db, notify))
+	assert.Len(t, closed, 1)
+}
+
+func (suite *GroupIntegrationTestSuite) TestRestoreGroupBringsBackItsPolls() {
+	t := suite.T()
+
+	adminID, adminToken := suite.signUp("restoreadmin")
+	memberID, memberToken := suite.signUp("restoremember")
+	groupID := suite.insertGroup(bson.M{"name": "Restore Group"}, adminID, memberID)
+	pollID, _ := suite.createPoll(adminToken, groupID, "")
+	code, _ := suite.sendJSON("POST", "/api/comments/poll/"+pollID, memberToken, map[string]interface{}{"text": "Keep me"})
+	suite.Require().Equal(http.StatusCreated, code)
+
+	code, _ = suite.sendJSON("DELETE", "/api/admin/groups/"+groupID.Hex(), suite.adminToken, nil)
+	suite.Require().Equal(http.StatusOK, code)
+	code, _ = suite.sendJSON("GET", "/api/polls/"+pollID, memberToken, nil)
+	assert.Equal(t, http.StatusNotFound, code)
+
+	// Only site admins may restore
+	code, _ = suite.sendJSON("POST", "/api/admin/groups/"+groupID.Hex()+"/restore", adminToken, nil)
+	assert.Equal(t, http.StatusForbidden, code)
+
+	code, response := suite.sendJSON("POST", "/api/admin/groups/"+groupID.Hex()+"/restore", suite.adminToken, nil)
+	suite.Require().Equal(http.StatusOK, code, response)
+	assert.Equal(t, float64(1), response["restored_polls"])
+	assert.Equal(t, float64(1), response["restored_comments"])
+	code, _ = suite.sendJSON("GET", "/api/polls/"+pollID, memberToken, nil)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Len(t, suite.getList("/api/comments/poll/"+pollID, memberToken), 1)
+
+	code, _ = suite.sendJSON("POST", "/api/admin/groups/"+groupID.Hex()+"/restore", suite.adminToken, nil)
+	assert.Equal(t, http.StatusNotFound, code)
+}
+
+func (suite *GroupIntegrationTestSuite) TestDeletingGroupTakesItsSubgroups() {
+	t := suite.T()
+
+	adminID, adminToken := suite.signUp("cascadeadmin")
+	memberID, memberToken := suite.signUp("cascademember")
+	parentID := suite.insertGroup(bson.M{"name": "Cascade Parent"}, adminID)
+	childID := suite.insertGroup(bson.M{"name": "Cascade Child", "parent_id": parentID, "ancestors": bson.A{parentID}}, adminID, memberID)
+	pollID, _ := suite.createPoll(adminToken, childID, "")
+
+	code, _ := suite.sendJSON("DELETE", "/api/admin/groups/"+parentID.Hex(), suite.adminToken, nil)
+	suite.Require().Equal(http.StatusOK, code)
+	code, _ = suite.sendJSON("GET", "/api/groups/"+childID.Hex(), memberToken, nil)
+	assert.Equal(t, http.StatusNotFound, code)
+	code, _ = suite.sendJSON("GET", "/api/polls/"+pollID, memberToken, nil)
+	assert.Equal(t, http.StatusNotFound, code)
+
+	// The subgroup only comes back with its parent
+	code, _ = suite.sendJSON("POST", "/api/admin/groups/"+childID.Hex()+"/restore", suite.adminToken, nil)
+	assert.Equal(t, http.StatusConflict, code)
+
+	code, response := suite.sendJSON("POST", "/api/admin/groups/"+parentID.Hex()+"/restore", suite.adminToken, nil)
+	suite.Require().Equal(http.StatusOK, code, response)
+	assert.Equal(t, float64(1), response["restored_subgroups"])
+	assert.Equal(t, float64(1), response["restored_polls"])
+	code, _ = suite.sendJSON("GET", "/api/groups/"+childID.Hex(), memberToken, nil)
+	assert.Equal(t, http.StatusOK, code)
+	code, _ = suite.sendJSON("GET", "/api/polls/"+pollID, memberToken, nil)
+	assert.Equal(t, http.StatusOK, code)
+}
+
+func (suite *GroupIntegrationTestSuite) TestRestorePollWaitsForItsGroup() {
+	t := suite.T()
+
+	adminID, adminToken := suite.signUp("restorepolladmin")
+	groupID := suite.insertGroup(bson.M{"name": "Restore Poll Group"}, adminID)
+	pollID, _ := suite.createPoll(adminToken, groupID, "")
+	code, _ := suite.sendJSON("POST", "/api/comments/poll/"+pollID, adminToken, map[string]interface{}{"text": "Keep me too"})
+	suite.Require().Equal(http.StatusCreated, code)
+
+	// The poll is deleted on its own before its group is
+	code, _ = suite.sendJSON("DELETE", "/api/admin/polls/"+pollID, suite.adminToken, nil)
+	suite.Require().Equal(http.StatusOK, code)
+	code, _ = suite.sendJSON("DELETE", "/api/admin/groups/"+groupID.Hex(), suite.adminToken, nil)
+	suite.Require().Equal(http.StatusOK, code)
+
+	code, response := suite.sendJSON("POST", "/api/admin/polls/"+pollID+"/restore", suite.adminToken, nil)
+	assert.Equal(t, http.StatusConflict, code, response)
+
+	// Restoring the group leaves polls deleted separately alone
+	code, response = suite.sendJSON("POST", "/api/admin/groups/"+groupID.Hex()+"/restore", suite.adminToken, nil)
+	suite.Require().Equal(http.StatusOK, code)
+	assert.Equal(t, float64(0), response["restored_polls"])
+	code, _ = suite.sendJSON("GET", "/api/polls/"+pollID, adminToken, nil)
+	assert.Equal(t, http.StatusNotFound, code)
+
+	code, response = suite.sendJSON("POST", "/api/admin/polls/"+pollID+"/restore", suite.adminToken, nil)
+	suite.Require().Equal(http.StatusOK, code, response)
+	assert.Equal(t, float64(1), response["restored_comments"])
+	code, _ = suite.sendJSON("GET", "/api/polls/"+pollID, adminToken, nil)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Len(t, suite.getList("/api/comments/poll/"+pollID, adminToken), 1)
+}
+
+func (suite *GroupIntegrationTestSuite) TestRestoreComment() {
+	t := suite.T()
+
+	adminID, adminToken := suite.signUp("restorecommentadmin")
+	memberID, memberToken := suite.signUp("restorecommentmember")
+	groupID := suite.insertGroup(bson.M{"name": "Restore Comment Group"}, adminID, memberID)
+	pollID, _ := suite.createPoll(adminToken, groupID, "")
+	code, comment := suite.sendJSON("POST", "/api/comments/poll/"+pollID, memberToken, map[string]interface{}{"text": "Oops"})
+	suite.Require().Equal(http.StatusCreated, code)
+	commentID := comment["id"].(string)
+
+	code, _ = suite.sendJSON("DELETE", "/api/comments/"+commentID, memberToken, nil)
+	suite.Require().Equal(http.StatusOK, code)
+	assert.Empty(t, suite.getList("/api/comments/poll/"+pollID, memberToken))
+
+	code, _ = suite.sendJSON("POST", "/api/admin/comments/"+commentID+"/restore", memberToken, nil)
+	assert.Equal(t, http.StatusForbidden, code)
+	code, _ = suite.sendJSON("POST", "/api/admin/comments/"+commentID+"/restore", suite.adminToken, nil)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Len(t, suite.getList("/api/comments/poll/"+pollID, memberToken), 1)
+
+	code, _ = suite.sendJSON("POST", "/api/admin/comments/"+commentID+"/restore", suite.adminToken, nil)
+	assert.Equal(t, http.StatusNotFound, code)
+}
+
+func TestGroupIntegrationTestSuite(t *testing.T) {
+	suite.Run(t, new(GroupIntegrationTestSuite))
+}
@@ -0,0 +1,1294 @@
+package integration_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"voteverse/broker"
+	"voteverse/handlers"
+	"voteverse/models"
+	"voteverse/testutils/helpers"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type WebSocketIntegrationTestSuite struct {
+	suite.Suite
+	server *helpers.TestServer
+	db     *mongo.Database
+	client *mongo.Client
+}
+
+func (suite *WebSocketIntegrationTestSuite) SetupSuite() {
+	mongoURI := os.Getenv("MONGODB_URI")
+	if mongoURI == "" {
+		mongoURI = "mongodb://localhost:27017"
+	}
+
+	dbName := os.Getenv("DB_NAME")
+	if dbName == "" {
+		dbName = "voteverse_test"
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(mongoURI))
+	if err != nil {
+		suite.T().Fatalf("Failed to connect to MongoDB: %v", err)
+	}
+	if err := client.Ping(ctx, nil); err != nil {
+		suite.T().Fatalf("Failed to ping MongoDB: %v", err)
+	}
+
+	suite.client = client
+	suite.db = client.Database(dbName)
+
+	// The WebSocket needs a real listener, so use a test server instead of a recorder
+	suite.server = helpers.NewTestServer()
+	router := suite.server.Engine
+	router.POST("/api/auth/signup", func(c *gin.Context) {
+		handlers.SignUp(c, suite.db)
+	})
+	router.POST("/api/auth/signin", func(c *gin.Context) {
+		handlers.SignIn(c, suite.db)
+	})
+
+	authMiddleware := handlers.AuthMiddleware(suite.db)
+	router.GET("/api/ws", func(c *gin.Context) {
+		c.Set("db", suite.db)
+		handlers.HandleWebSocket(c)
+	})
+	router.GET("/api/groups/:id/presence", authMiddleware, handlers.GetGroupPresence)
+	router.GET("/api/polls/:id/presence", authMiddleware, handlers.GetPollPresence)
+	router.GET("/api/events", func(c *gin.Context) {
+		c.Set("db", suite.db)
+		handlers.HandleEvents(c)
+	})
+	router.POST("/api/groups/:id/leave", authMiddleware, handlers.LeaveGroup)
+	router.POST("/api/polls", authMiddleware, handlers.CreatePoll)
+	router.POST("/api/polls/:id/vote", authMiddleware, handlers.Vote)
+	router.POST("/api/comments/poll/:pollId", authMiddleware, handlers.CreateComment)
+	router.GET("/api/user/sessions", authMiddleware, func(c *gin.Context) {
+		handlers.ListSessions(c, suite.db)
+	})
+	router.DELETE("/api/user/sessions", authMiddleware, func(c *gin.Context) {
+		handlers.RevokeOtherSessions(c, suite.db)
+	})
+	router.DELETE("/api/user/sessions/:id", authMiddleware, func(c *gin.Context) {
+		handlers.RevokeSession(c, suite.db)
+	})
+	router.GET("/api/admin/users/:id/sessions", authMiddleware, func(c *gin.Context) {
+		handlers.AdminListUserSessions(c, suite.db)
+	})
+	router.DELETE("/api/admin/users/:id/sessions", authMiddleware, func(c *gin.Context) {
+		handlers.AdminRevokeUserSessions(c, suite.db)
+	})
+	router.DELETE("/api/admin/users/:id/sessions/:sessionId", authMiddleware, func(c *gin.Context) {
+		handlers.AdminRevokeUserSession(c, suite.db)
+	})
+	router.DELETE("/api/admin/polls/:id", authMiddleware, func(c *gin.Context) {
+		handlers.AdminDeletePoll(c, suite.db)
+	})
+	suite.server.Start()
+}
+
+func (suite *WebSocketIntegrationTestSuite) TearDownSuite() {
+	suite.server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := suite.db.Drop(ctx); err != nil {
+		suite.T().Logf("Failed to drop test database: %v", err)
+	}
+	if err := suite.client.Disconnect(ctx); err != nil {
+		suite.T().Logf("Failed to disconnect from MongoDB: %v", err)
+	}
+}
+
+func (suite *WebSocketIntegrationTestSuite) SetupTest() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	for _, collection := range []string{"users", "sessions", "email_verifications", "groups", "group_members", "events", "polls", "votes", "comments", "presence"} {
+		if _, err := suite.db.Collection(collection).DeleteMany(ctx, bson.M{}); err != nil {
+			suite.T().Logf("Failed to clear %s collection: %v", collection, err)
+		}
+	}
+}
+
+func (suite *WebSocketIntegrationTestSuite) postJSON(path, token string, body interface{}) (int, map[string]interface{}) {
+	payload, _ := json.Marshal(body)
+	req, _ := http.NewRequest("POST", path, bytes.NewBuffer(payload))
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp := suite.server.ServeHTTP(req)
+
+	var response map[string]interface{}
+	json.Unmarshal(resp.Body.Bytes(), &response)
+	return resp.Code, response
+}
+
+// signUp creates a user and returns their ID and access token
+func (suite *WebSocketIntegrationTestSuite) signUp(username string) (primitive.ObjectID, string) {
+	code, response := suite.postJSON("/api/auth/signup", "", map[string]interface{}{
+		"username": username,
+		"email":    username + "@example.com",
+		"password": "password123",
+	})
+	suite.Require().Equal(http.StatusCreated, code)
+
+	userID, err := primitive.ObjectIDFromHex(response["user"].(map[string]interface{})["id"].(string))
+	suite.Require().NoError(err)
+	return userID, response["token"].(string)
+}
+
+// createGroup stores a group with the given members, the first one as admin
+func (suite *WebSocketIntegrationTestSuite) createGroup(name string, members ...primitive.ObjectID) primitive.ObjectID {
+	now := primitive.NewDateTimeFromTime(time.Now())
+	group := models.Group{
+		ID:        primitive.NewObjectID(),
+		Name:      name,
+		CreatedBy: members[0],
+		CreatedAt: now,
+		UpdatedAt: now,
+		IsActive:  true,
+	}
+	_, err := suite.db.Collection("groups").InsertOne(context.Background(), group)
+	suite.Require().NoError(err)
+
+	for i, userID := range members {
+		role := "member"
+		if i == 0 {
+			role = "admin"
+		}
+		_, err := suite.db.Collection("group_members").InsertOne(context.Background(), models.GroupMember{
+			ID:       primitive.NewObjectID(),
+			GroupID:  group.ID,
+			UserID:   userID,
+			Role:     role,
+			JoinedAt: now,
+		})
+		suite.Require().NoError(err)
+	}
+	return group.ID
+}
+
+// createPoll stores an open poll with two options, a public one if groupID is nil
+func (suite *WebSocketIntegrationTestSuite) createPoll(groupID, creatorID primitive.ObjectID, resultsVisibility string) models.Poll {
+	now := time.Now()
+	visibility := "group"
+	if groupID.IsZero() {
+		visibility = "public"
+	}
+	poll := models.Poll{
+		ID:        primitive.NewObjectID(),
+		GroupID:   groupID,
+		CreatedBy: creatorID,
+		Title:     "Lunch?",
+		Options: []models.PollOption{
+			{ID: primitive.NewObjectID(), Text: "Pizza"},
+			{ID: primitive.NewObjectID(), Text: "Salad"},
+		},
+		StartTime:         primitive.NewDateTimeFromTime(now),
+		EndTime:           primitive.NewDateTimeFromTime(now.Add(time.Hour)),
+		CreatedAt:         primitive.NewDateTimeFromTime(now),
+		UpdatedAt:         primitive.NewDateTimeFromTime(now),
+		IsActive:          true,
+		Visibility:        visibility,
+		ResultsVisibility: resultsVisibility,
+	}
+	_, err := suite.db.Collection("polls").InsertOne(context.Background(), poll)
+	suite.Require().NoError(err)
+	return poll
+}
+
+func (suite *WebSocketIntegrationTestSuite) dial(token string) *websocket.Conn {
+	return suite.dialURL(suite.server.URL(), token)
+}
+
+func (suite *WebSocketIntegrationTestSuite) dialURL(baseURL, token string) *websocket.Conn {
+	url := "ws" + strings.TrimPrefix(baseURL, "http") + "/api/ws?token=" + token
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	suite.Require().NoError(err)
+	suite.T().Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// next reads the next message other than a presence_update, failing the test
+// if none arrives in time
+func (suite *WebSocketIntegrationTestSuite) next(conn *websocket.Conn) handlers.Message {
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		conn.SetReadDeadline(deadline)
+		var message handlers.Message
+		suite.Require().NoError(conn.ReadJSON(&message))
+		if message.Type != "presence_update" {
+			return message
+		}
+	}
+}
+
+// waitForPresence reads presence updates until one has the given count.
+// Updates are debounced, so intermediate counts may or may not be sent.
+func (suite *WebSocketIntegrationTestSuite) waitForPresence(conn *websocket.Conn, count int) map[string]interface{} {
+	conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	for {
+		var message handlers.Message
+		suite.Require().NoError(conn.ReadJSON(&message))
+		if message.Type != "presence_update" {
+			continue
+		}
+		data := message.Data.(map[string]interface{})
+		if data["count"] == float64(count) {
+			return data
+		}
+	}
+}
+
+// expectSilence checks that no message other than a presence_update arrives
+// for a short while
+func (suite *WebSocketIntegrationTestSuite) expectSilence(conn *websocket.Conn) {
+	conn.SetReadDeadline(time.Now().Add(300 * time.Millisecond))
+	for {
+		var message handlers.Message
+		if err := conn.ReadJSON(&message); err != nil {
+			return
+		}
+		suite.Require().Equal("presence_update", message.Type, "unexpected %s message", message.Type)
+	}
+}
+
+func (suite *WebSocketIntegrationTestSuite) TestJoinGroupRequiresMembership() {
+	t := suite.T()
+
+	memberID, memberToken := suite.signUp("wsmember")
+	_, outsiderToken := suite.signUp("wsoutsider")
+	groupID := suite.createGroup("WebSocket Group", memberID).Hex()
+
+	member := suite.dial(memberToken)
+	suite.Require().NoError(member.WriteJSON(handlers.Message{Type: "join_group", GroupID: groupID}))
+	reply := suite.next(member)
+	assert.Equal(t, "subscribed", reply.Type)
+	assert.Equal(t, groupID, reply.GroupID)
+
+	outsider := suite.dial(outsiderToken)
+	suite.Require().NoError(outsider.WriteJSON(handlers.Message{Type: "join_group", GroupID: groupID}))
+	reply = suite.next(outsider)
+	assert.Equal(t, "error", reply.Type)
+	assert.Equal(t, groupID, reply.GroupID)
+	assert.NotEmpty(t, reply.Error)
+
+	suite.Require().NoError(outsider.WriteJSON(handlers.Message{Type: "join_group", GroupID: "not-a-group"}))
+	assert.Equal(t, "error", suite.next(outsider).Type)
+
+	// Only the member gets the group's events
+	handlers.NotifyPollUpdate(handlers.PollUpdate{GroupID: groupID, PollID: primitive.NewObjectID().Hex(), Type: "created"})
+	assert.Equal(t, "poll_update", suite.next(member).Type)
+	suite.expectSilence(outsider)
+}
+
+func (suite *WebSocketIntegrationTestSuite) TestLeavingGroupDropsSubscription() {
+	t := suite.T()
+
+	adminID, _ := suite.signUp("wsadmin")
+	memberID, memberToken := suite.signUp("wsleaver")
+	groupID := suite.createGroup("Leaving Group", adminID, memberID)
+
+	conn := suite.dial(memberToken)
+	suite.Require().NoError(conn.WriteJSON(handlers.Message{Type: "join_group", GroupID: groupID.Hex()}))
+	assert.Equal(t, "subscribed", suite.next(conn).Type)
+
+	code, _ := suite.postJSON("/api/groups/"+groupID.Hex()+"/leave", memberToken, nil)
+	suite.Require().Equal(http.StatusOK, code)
+
+	reply := suite.next(conn)
+	assert.Equal(t, "unsubscribed", reply.Type)
+	assert.Equal(t, groupID.Hex(), reply.GroupID)
+
+	handlers.NotifyPollUpdate(handlers.PollUpdate{GroupID: groupID.Hex(), PollID: primitive.NewObjectID().Hex(), Type: "created"})
+	suite.expectSilence(conn)
+}
+
+func (suite *WebSocketIntegrationTestSuite) TestResumeReplaysMissedEvents() {
+	t := suite.T()
+
+	memberID, token := suite.signUp("wsresumer")
+	groupID := suite.createGroup("Resume Group", memberID).Hex()
+	pollID := primitive.NewObjectID().Hex()
+
+	conn := suite.dial(token)
+	suite.Require().NoError(conn.WriteJSON(handlers.Message{Type: "join_group", GroupID: groupID}))
+	subscribed := suite.next(conn)
+	suite.Require().Equal("subscribed", subscribed.Type)
+	suite.Require().NotEmpty(subscribed.Epoch)
+
+	// Events are numbered per channel
+	for i := 1; i <= 2; i++ {
+		handlers.NotifyVoteUpdate(handlers.VoteUpdate{GroupID: groupID, PollID: pollID})
+		event := suite.next(conn)
+		assert.Equal(t, subscribed.Seq+uint64(i), event.Seq)
+		assert.Equal(t, subscribed.Epoch, event.Epoch)
+	}
+	lastSeen := subscribed.Seq + 2
+	conn.Close()
+
+	// Events published while the client is away are replayed in order
+	handlers.NotifyVoteUpdate(handlers.VoteUpdate{GroupID: groupID, PollID: pollID})
+	handlers.NotifyCommentUpdate(handlers.CommentUpdate{GroupID: groupID, PollID: pollID, Type: "created"})
+
+	conn = suite.dial(token)
+	suite.Require().NoError(conn.WriteJSON(handlers.Message{Type: "resume", GroupID: groupID, Epoch: subscribed.Epoch, Seq: lastSeen}))
+	replayed := suite.next(conn)
+	assert.Equal(t, "vote_update", replayed.Type)
+	assert.Equal(t, lastSeen+1, replayed.Seq)
+	replayed = suite.next(conn)
+	assert.Equal(t, "comment_update", replayed.Type)
+	assert.Equal(t, lastSeen+2, replayed.Seq)
+	resumed := suite.next(conn)
+	assert.Equal(t, "resumed", resumed.Type)
+	assert.Equal(t, lastSeen+2, resumed.Seq)
+
+	// Live events carry on from there
+	handlers.NotifyVoteUpdate(handlers.VoteUpdate{GroupID: groupID, PollID: pollID})
+	assert.Equal(t, lastSeen+3, suite.next(conn).Seq)
+
+	// A sequence number from another epoch cannot be replayed
+	other := suite.dial(token)
+	suite.Require().NoError(other.WriteJSON(handlers.Message{Type: "resume", GroupID: groupID, Epoch: "unknown", Seq: lastSeen}))
+	resync := suite.next(other)
+	assert.Equal(t, "resync", resync.Type)
+	assert.Equal(t, subscribed.Epoch, resync.Epoch)
+	assert.Equal(t, lastSeen+3, resync.Seq)
+
+	// Resuming still requires access to the group
+	_, outsiderToken := suite.signUp("wsresumeoutsider")
+	outsider := suite.dial(outsiderToken)
+	suite.Require().NoError(outsider.WriteJSON(handlers.Message{Type: "resume", GroupID: groupID, Epoch: subscribed.Epoch, Seq: 0}))
+	assert.Equal(t, "error", suite.next(outsider).Type)
+}
+
+func (suite *WebSocketIntegrationTestSuite) TestUpdatesCarryTalliesAndComments() {
+	t := suite.T()
+	t.Setenv("VOTE_UPDATE_INTERVAL_MS", "1000")
+
+	memberID, token := suite.signUp("wstallies")
+	groupID := suite.createGroup("Tallies Group", memberID)
+	poll := suite.createPoll(groupID, primitive.NewObjectID(), models.ResultsVisibilityAlways)
+
+	conn := suite.dial(token)
+	suite.Require().NoError(conn.WriteJSON(handlers.Message{Type: "join_group", GroupID: groupID.Hex()}))
+	suite.Require().Equal("subscribed", suite.next(conn).Type)
+
+	vote := func(option int) {
+		code, _ := suite.postJSON("/api/polls/"+poll.ID.Hex()+"/vote", token, map[string]interface{}{
+			"option_id": poll.Options[option].ID.Hex(),
+		})
+		suite.Require().Equal(http.StatusOK, code)
+	}
+	tallies := func(message handlers.Message) []interface{} {
+		suite.Require().Equal("vote_update", message.Type)
+		data := message.Data.(map[string]interface{})
+		assert.Equal(t, false, data["results_hidden"])
+		options := data["options"].([]interface{})
+		return []interface{}{
+			options[0].(map[string]interface{})["vote_count"],
+			options[1].(map[string]interface{})["vote_count"],
+		}
+	}
+
+	// The first vote is sent right away, with the new counts
+	vote(0)
+	assert.Equal(t, []interface{}{float64(1), float64(0)}, tallies(suite.next(conn)))
+
+	// A burst of votes becomes one update with the final counts
+	vote(1)
+	vote(0)
+	vote(1)
+	assert.Equal(t, []interface{}{float64(0), float64(1)}, tallies(suite.next(conn)))
+	suite.expectSilence(conn)
+
+	// New comments come with their text and author
+	code, _ := suite.postJSON("/api/comments/poll/"+poll.ID.Hex(), token, map[string]interface{}{"text": "Pizza again?"})
+	suite.Require().Equal(http.StatusCreated, code)
+	message := suite.next(conn)
+	suite.Require().Equal("comment_update", message.Type)
+	data := message.Data.(map[string]interface{})
+	assert.Equal(t, "created", data["type"])
+	comment := data["comment"].(map[string]interface{})
+	assert.Equal(t, "Pizza again?", comment["text"])
+	assert.Equal(t, "wstallies", comment["user"].(map[string]interface{})["username"])
+}
+
+func (suite *WebSocketIntegrationTestSuite) TestVoteUpdatesRespectResultsVisibility() {
+	t := suite.T()
+
+	memberID, token := suite.signUp("wshidden")
+	groupID := suite.createGroup("Hidden Results Group", memberID)
+	poll := suite.createPoll(groupID, primitive.NewObjectID(), models.ResultsVisibilityAfterClose)
+
+	conn := suite.dial(token)
+	suite.Require().NoError(conn.WriteJSON(handlers.Message{Type: "join_group", GroupID: groupID.Hex()}))
+	suite.Require().Equal("subscribed", suite.next(conn).Type)
+
+	code, _ := suite.postJSON("/api/polls/"+poll.ID.Hex()+"/vote", token, map[string]interface{}{
+		"option_id": poll.Options[0].ID.Hex(),
+	})
+	suite.Require().Equal(http.StatusOK, code)
+
+	message := suite.next(conn)
+	suite.Require().Equal("vote_update", message.Type)
+	data := message.Data.(map[string]interface{})
+	assert.Equal(t, true, data["results_hidden"])
+	assert.NotContains(t, data, "total_votes")
+	assert.NotContains(t, data, "options")
+}
+
+func (suite *WebSocketIntegrationTestSuite) TestSubscribePollFollowsPublicPoll() {
+	t := suite.T()
+
+	_, viewerToken := suite.signUp("wsviewer")
+	_, voterToken := suite.signUp("wsvoter")
+	poll := suite.createPoll(primitive.NilObjectID, primitive.NewObjectID(), models.ResultsVisibilityAlways)
+	other := suite.createPoll(primitive.NilObjectID, primitive.NewObjectID(), models.ResultsVisibilityAlways)
+
+	conn := suite.dial(viewerToken)
+	suite.Require().NoError(conn.WriteJSON(handlers.Message{Type: "subscribe_poll", PollID: poll.ID.Hex()}))
+	reply := suite.next(conn)
+	suite.Require().Equal("subscribed", reply.Type)
+	assert.Equal(t, poll.ID.Hex(), reply.PollID)
+	assert.Equal(t, handlers.PollChannel(poll.ID.Hex()), reply.Channel)
+
+	// Any signed-in user can follow a public poll's tallies
+	code, _ := suite.postJSON("/api/polls/"+poll.ID.Hex()+"/vote", voterToken, map[string]interface{}{
+		"option_id": poll.Options[1].ID.Hex(),
+	})
+	suite.Require().Equal(http.StatusOK, code)
+
+	message := suite.next(conn)
+	suite.Require().Equal("vote_update", message.Type)
+	assert.Equal(t, handlers.PollChannel(poll.ID.Hex()), message.Channel)
+	assert.Equal(t, reply.Seq+1, message.Seq)
+	data := message.Data.(map[string]interface{})
+	assert.Equal(t, poll.ID.Hex(), data["poll_id"])
+	assert.NotContains(t, data, "group_id")
+	assert.Equal(t, float64(1), data["total_votes"])
+
+	handlers.NotifyCommentUpdate(handlers.CommentUpdate{PollID: poll.ID.Hex(), Type: "deleted", CommentID: primitive.NewObjectID().Hex()})
+	assert.Equal(t, "comment_update", suite.next(conn).Type)
+
+	// Other polls' events are not sent
+	code, _ = suite.postJSON("/api/polls/"+other.ID.Hex()+"/vote", voterToken, map[string]interface{}{
+		"option_id": other.Options[0].ID.Hex(),
+	})
+	suite.Require().Equal(http.StatusOK, code)
+	suite.expectSilence(conn)
+
+	suite.Require().NoError(conn.WriteJSON(handlers.Message{Type: "unsubscribe_poll", PollID: poll.ID.Hex()}))
+	assert.Equal(t, "unsubscribed", suite.next(conn).Type)
+	handlers.NotifyVoteUpdate(handlers.VoteUpdate{PollID: poll.ID.Hex()})
+	suite.expectSilence(conn)
+}
+
+func (suite *WebSocketIntegrationTestSuite) TestDeletingPollDropsSubscribers() {
+	t := suite.T()
+
+	// The first user becomes an admin
+	_, adminToken := suite.signUp("wspolladmin")
+	_, viewerToken := suite.signUp("wspollviewer")
+	poll := suite.createPoll(primitive.NilObjectID, primitive.NewObjectID(), models.ResultsVisibilityAlways)
+
+	conn := suite.dial(viewerToken)
+	suite.Require().NoError(conn.WriteJSON(handlers.Message{Type: "subscribe_poll", PollID: poll.ID.Hex()}))
+	suite.Require().Equal("subscribed", suite.next(conn).Type)
+
+	code, _ := suite.deleteJSON("/api/admin/polls/"+poll.ID.Hex(), adminToken)
+	suite.Require().Equal(http.StatusOK, code)
+
+	reply := suite.next(conn)
+	assert.Equal(t, "unsubscribed", reply.Type)
+	assert.Equal(t, poll.ID.Hex(), reply.PollID)
+
+	handlers.NotifyVoteUpdate(handlers.VoteUpdate{PollID: poll.ID.Hex()})
+	suite.expectSilence(conn)
+}
+
+func (suite *WebSocketIntegrationTestSuite) TestSubscribePollChecksGroupAccess() {
+	t := suite.T()
+
+	adminID, _ := suite.signUp("wspolladmin")
+	memberID, memberToken := suite.signUp("wspollmember")
+	_, outsiderToken := suite.signUp("wspolloutsider")
+	groupID := suite.createGroup("Poll Channel Group", adminID, memberID)
+	poll := suite.createPoll(groupID, adminID, models.ResultsVisibilityAlways)
+
+	outsider := suite.dial(outsiderToken)
+	suite.Require().NoError(outsider.WriteJSON(handlers.Message{Type: "subscribe_poll", PollID: poll.ID.Hex()}))
+	reply := suite.next(outsider)
+	assert.Equal(t, "error", reply.Type)
+	assert.Equal(t, poll.ID.Hex(), reply.PollID)
+
+	suite.Require().NoError(outsider.WriteJSON(handlers.Message{Type: "subscribe_poll", PollID: primitive.NewObjectID().Hex()}))
+	assert.Equal(t, "error", suite.next(outsider).Type)
+
+	member := suite.dial(memberToken)
+	suite.Require().NoError(member.WriteJSON(handlers.Message{Type: "subscribe_poll", PollID: poll.ID.Hex()}))
+	suite.Require().Equal("subscribed", suite.next(member).Type)
+
+	// Group poll events reach the poll's followers too
+	handlers.NotifyVoteUpdate(handlers.VoteUpdate{GroupID: groupID.Hex(), PollID: poll.ID.Hex()})
+	assert.Equal(t, "vote_update", suite.next(member).Type)
+	suite.expectSilence(outsider)
+
+	// Leaving the group drops the poll subscription
+	code, _ := suite.postJSON("/api/groups/"+groupID.Hex()+"/leave", memberToken, nil)
+	suite.Require().Equal(http.StatusOK, code)
+	reply = suite.next(member)
+	assert.Equal(t, "unsubscribed", reply.Type)
+	assert.Equal(t, poll.ID.Hex(), reply.PollID)
+
+	handlers.NotifyVoteUpdate(handlers.VoteUpdate{GroupID: groupID.Hex(), PollID: poll.ID.Hex()})
+	suite.expectSilence(member)
+}
+
+func (suite *WebSocketIntegrationTestSuite) TestPublicFeedAnnouncesPublicPolls() {
+	t := suite.T()
+
+	creatorID, creatorToken := suite.signUp("wspubliccreator")
+	_, viewerToken := suite.signUp("wspublicviewer")
+	groupID := suite.createGroup("Private Polls Group", creatorID)
+
+	conn := suite.dial(viewerToken)
+	suite.Require().NoError(conn.WriteJSON(handlers.Message{Type: "subscribe_public"}))
+	reply := suite.next(conn)
+	suite.Require().Equal("subscribed", reply.Type)
+	assert.Equal(t, handlers.PublicChannel, reply.Channel)
+
+	createPoll := func(body map[string]interface{}) string {
+		body["title"] = "Best editor?"
+		body["options"] = []map[string]string{{"text": "vim"}, {"text": "emacs"}}
+		code, response := suite.postJSON("/api/polls", creatorToken, body)
+		suite.Require().Equal(http.StatusCreated, code)
+		return response["id"].(string)
+	}
+
+	pollID := createPoll(map[string]interface{}{"visibility": "public"})
+	message := suite.next(conn)
+	suite.Require().Equal("poll_update", message.Type)
+	assert.Equal(t, handlers.PublicChannel, message.Channel)
+	data := message.Data.(map[string]interface{})
+	assert.Equal(t, "created", data["type"])
+	assert.Equal(t, pollID, data["poll_id"])
+	assert.Equal(t, "Best editor?", data["poll"].(map[string]interface{})["title"])
+
+	// Group polls stay off the public feed
+	createPoll(map[string]interface{}{"visibility": "group", "group_id": groupID.Hex()})
+	suite.expectSilence(conn)
+}
+
+// request sends a client request and returns the ack or error with its
+// request ID, along with the other messages that arrived in the meantime
+func (suite *WebSocketIntegrationTestSuite) request(conn *websocket.Conn, msg handlers.Message) (handlers.Message, []handlers.Message) {
+	suite.Require().NoError(conn.WriteJSON(msg))
+	var others []handlers.Message
+	for {
+		message := suite.next(conn)
+		if (message.Type == "ack" || message.Type == "error") && message.RequestID == msg.RequestID {
+			return message, others
+		}
+		others = append(others, message)
+	}
+}
+
+func (suite *WebSocketIntegrationTestSuite) TestVotingAndCommentingOverWebSocket() {
+	t := suite.T()
+
+	memberID, token := suite.signUp("wsactor")
+	_, outsiderToken := suite.signUp("wsactoroutsider")
+	groupID := suite.createGroup("Socket Actions Group", memberID)
+	poll := suite.createPoll(groupID, memberID, models.ResultsVisibilityAlways)
+
+	conn := suite.dial(token)
+	suite.Require().NoError(conn.WriteJSON(handlers.Message{Type: "join_group", GroupID: groupID.Hex()}))
+	suite.Require().Equal("subscribed", suite.next(conn).Type)
+
+	reply, others := suite.request(conn, handlers.Message{Type: "cast_vote", RequestID: "vote-1", PollID: poll.ID.Hex(), OptionID: poll.Options[1].ID.Hex()})
+	suite.Require().Equal("ack", reply.Type, reply.Error)
+	data := reply.Data.(map[string]interface{})
+	assert.Equal(t, poll.Options[1].ID.Hex(), data["user_vote"])
+
+	// The vote is stored and broadcast like a REST vote
+	count, err := suite.db.Collection("votes").CountDocuments(context.Background(), bson.M{"poll_id": poll.ID, "user_id": memberID})
+	suite.Require().NoError(err)
+	assert.Equal(t, int64(1), count)
+	if len(others) == 0 {
+		others = append(others, suite.next(conn))
+	}
+	assert.Equal(t, "vote_update", others[0].Type)
+
+	// Refusals carry the same message as the REST endpoint
+	reply, _ = suite.request(conn, handlers.Message{Type: "cast_vote", RequestID: "vote-2", PollID: poll.ID.Hex(), OptionID: primitive.NewObjectID().Hex()})
+	assert.Equal(t, "error", reply.Type)
+	assert.Equal(t, "Invalid option for this poll", reply.Error)
+
+	reply, _ = suite.request(conn, handlers.Message{Type: "cast_vote", RequestID: "vote-3", PollID: "not-a-poll"})
+	assert.Equal(t, "error", reply.Type)
+	assert.Equal(t, "Invalid poll ID", reply.Error)
+
+	reply, _ = suite.request(conn, handlers.Message{Type: "post_comment", RequestID: "comment-1", PollID: poll.ID.Hex(), Text: "Salad it is"})
+	suite.Require().Equal("ack", reply.Type, reply.Error)
+	assert.Equal(t, "Salad it is", reply.Data.(map[string]interface{})["text"])
+
+	outsider := suite.dial(outsiderToken)
+	reply, _ = suite.request(outsider, handlers.Message{Type: "post_comment", RequestID: "comment-2", PollID: poll.ID.Hex(), Text: "Let me in"})
+	assert.Equal(t, "error", reply.Type)
+	assert.Equal(t, "Not a member of this group", reply.Error)
+
+	reply, _ = suite.request(outsider, handlers.Message{Type: "post_comment", RequestID: "comment-3", PollID: poll.ID.Hex()})
+	assert.Equal(t, "error", reply.Type)
+	assert.Equal(t, "Comment text is required", reply.Error)
+}
+
+func (suite *WebSocketIntegrationTestSuite) getJSON(path, token string) (int, map[string]interface{}) {
+	req, _ := http.NewRequest("GET", path, nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp := suite.server.ServeHTTP(req)
+
+	var response map[string]interface{}
+	json.Unmarshal(resp.Body.Bytes(), &response)
+	return resp.Code, response
+}
+
+func (suite *WebSocketIntegrationTestSuite) TestPresenceTracksGroupAndPollViewers() {
+	t := suite.T()
+	t.Setenv("PRESENCE_UPDATE_INTERVAL_MS", "100")
+
+	aliceID, aliceToken := suite.signUp("wspresencealice")
+	bobID, bobToken := suite.signUp("wspresencebob")
+	_, outsiderToken := suite.signUp("wspresenceoutsider")
+	groupID := suite.createGroup("Presence Group", aliceID, bobID)
+	poll := suite.createPoll(groupID, aliceID, models.ResultsVisibilityAlways)
+	usernames := func(presence map[string]interface{}) []string {
+		var names []string
+		for _, user := range presence["users"].([]interface{}) {
+			names = append(names, user.(map[string]interface{})["username"].(string))
+		}
+		return names
+	}
+
+	alice := suite.dial(aliceToken)
+	suite.Require().NoError(alice.WriteJSON(handlers.Message{Type: "join_group", GroupID: groupID.Hex()}))
+	suite.Require().Equal("subscribed", suite.next(alice).Type)
+	presence := suite.waitForPresence(alice, 1)
+	assert.Equal(t, []string{"wspresencealice"}, usernames(presence))
+
+	// Users are counted once, however many connections they have
+	bob := suite.dial(bobToken)
+	bobAgain := suite.dial(bobToken)
+	for _, conn := range []*websocket.Conn{bob, bobAgain} {
+		suite.Require().NoError(conn.WriteJSON(handlers.Message{Type: "join_group", GroupID: groupID.Hex()}))
+		suite.Require().Equal("subscribed", suite.next(conn).Type)
+	}
+	presence = suite.waitForPresence(alice, 2)
+	assert.Equal(t, []string{"wspresencealice", "wspresencebob"}, usernames(presence))
+
+	code, snapshot := suite.getJSON("/api/groups/"+groupID.Hex()+"/presence", aliceToken)
+	suite.Require().Equal(http.StatusOK, code)
+	assert.Equal(t, float64(2), snapshot["count"])
+
+	code, _ = suite.getJSON("/api/groups/"+groupID.Hex()+"/presence", outsiderToken)
+	assert.Equal(t, http.StatusForbidden, code)
+
+	bob.Close()
+	bobAgain.Close()
+	suite.waitForPresence(alice, 1)
+
+	// Polls only get a viewer count
+	suite.Require().NoError(alice.WriteJSON(handlers.Message{Type: "subscribe_poll", PollID: poll.ID.Hex()}))
+	suite.Require().Equal("subscribed", suite.next(alice).Type)
+	presence = suite.waitForPresence(alice, 1)
+	for presence["poll_id"] != poll.ID.Hex() {
+		presence = suite.waitForPresence(alice, 1)
+	}
+	assert.NotContains(t, presence, "users")
+
+	code, snapshot = suite.getJSON("/api/polls/"+poll.ID.Hex()+"/presence", aliceToken)
+	suite.Require().Equal(http.StatusOK, code)
+	assert.Equal(t, float64(1), snapshot["count"])
+}
+
+// sseEvent is one Server-Sent Event
+type sseEvent struct {
+	id      string
+	event   string
+	message handlers.Message
+}
+
+// openEvents opens an event stream on the given channels
+func (suite *WebSocketIntegrationTestSuite) openEvents(token, lastEventID string, channels ...string) *bufio.Reader {
+	query := url.Values{"token": {token}, "channel": channels}
+	req, err := http.NewRequest("GET", suite.server.URL()+"/api/events?"+query.Encode(), nil)
+	suite.Require().NoError(err)
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+
+	resp, err := (&http.Client{Timeout: 10 * time.Second}).Do(req)
+	suite.Require().NoError(err)
+	suite.T().Cleanup(func() { resp.Body.Close() })
+	suite.Require().Equal(http.StatusOK, resp.StatusCode)
+	suite.Require().Equal("text/event-stream", resp.Header.Get("Content-Type"))
+	return bufio.NewReader(resp.Body)
+}
+
+// nextEvent reads the next event from a stream, skipping comments and presence updates
+func (suite *WebSocketIntegrationTestSuite) nextEvent(stream *bufio.Reader) sseEvent {
+	var event sseEvent
+	for {
+		line, err := stream.ReadString('\n')
+		suite.Require().NoError(err)
+		line = strings.TrimSuffix(line, "\n")
+
+		switch {
+		case line == "" && event.event == "presence_update":
+			event = sseEvent{}
+		case line == "" && event.event != "":
+			return event
+		case strings.HasPrefix(line, "id: "):
+			event.id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "event: "):
+			event.event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			suite.Require().NoError(json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &event.message))
+		}
+	}
+}
+
+func (suite *WebSocketIntegrationTestSuite) TestEventStreamResumesFromLastEventID() {
+	t := suite.T()
+
+	memberID, token := suite.signUp("sseviewer")
+	groupID := suite.createGroup("Event Stream Group", memberID).Hex()
+	otherGroupID := suite.createGroup("Other Group", primitive.NewObjectID()).Hex()
+	poll := suite.createPoll(primitive.NilObjectID, primitive.NewObjectID(), models.ResultsVisibilityAlways)
+
+	stream := suite.openEvents(token, "", handlers.GroupChannel(groupID), handlers.PollChannel(poll.ID.Hex()), handlers.GroupChannel(otherGroupID))
+	subscribed := map[string]bool{}
+	for i := 0; i < 3; i++ {
+		event := suite.nextEvent(stream)
+		if event.event == "error" {
+			// The same authorization as WebSocket subscriptions applies
+			assert.Equal(t, otherGroupID, event.message.GroupID)
+			continue
+		}
+		suite.Require().Equal("subscribed", event.event)
+		subscribed[event.message.Channel] = true
+	}
+	assert.Len(t, subscribed, 2)
+
+	// Events have the same types and data as over a WebSocket
+	handlers.NotifyPollUpdate(handlers.PollUpdate{GroupID: groupID, PollID: primitive.NewObjectID().Hex(), Type: "closed"})
+	event := suite.nextEvent(stream)
+	suite.Require().Equal("poll_update", event.event)
+	assert.Equal(t, "poll_update", event.message.Type)
+	assert.Equal(t, handlers.GroupChannel(groupID), event.message.Channel)
+	assert.Equal(t, "closed", event.message.Data.(map[string]interface{})["type"])
+
+	handlers.NotifyVoteUpdate(handlers.VoteUpdate{PollID: poll.ID.Hex()})
+	event = suite.nextEvent(stream)
+	suite.Require().Equal("vote_update", event.event)
+	lastEventID := event.id
+	assert.Contains(t, lastEventID, handlers.GroupChannel(groupID)+"/")
+	assert.Contains(t, lastEventID, handlers.PollChannel(poll.ID.Hex())+"/")
+
+	// Reconnecting with Last-Event-ID replays what was missed on each channel
+	handlers.NotifyCommentUpdate(handlers.CommentUpdate{GroupID: groupID, PollID: primitive.NewObjectID().Hex(), Type: "deleted"})
+	handlers.NotifyVoteUpdate(handlers.VoteUpdate{PollID: poll.ID.Hex()})
+
+	resumed := suite.openEvents(token, lastEventID, handlers.GroupChannel(groupID), handlers.PollChannel(poll.ID.Hex()))
+	var types []string
+	for len(types) < 4 {
+		types = append(types, suite.nextEvent(resumed).event)
+	}
+	assert.ElementsMatch(t, []string{"comment_update", "resumed", "vote_update", "resumed"}, types)
+}
+
+// serveHub starts a test server for a hub of its own, standing in for another
+// server instance
+func (suite *WebSocketIntegrationTestSuite) serveHub(h *handlers.Hub) string {
+	server := helpers.NewTestServer()
+	server.Engine.GET("/api/ws", func(c *gin.Context) {
+		c.Set("db", suite.db)
+		h.ServeWebSocket(c)
+	})
+	server.Start()
+	suite.T().Cleanup(server.Close)
+	return server.URL()
+}
+
+// joinOnEachHub connects a member of a new group to every hub and joins the group
+func (suite *WebSocketIntegrationTestSuite) joinOnEachHub(hubs ...*handlers.Hub) (string, []*websocket.Conn) {
+	memberID, token := suite.signUp("wsreplica")
+	groupID := suite.createGroup("Replicated Group", memberID).Hex()
+
+	var conns []*websocket.Conn
+	for _, h := range hubs {
+		conn := suite.dialURL(suite.serveHub(h), token)
+		suite.Require().NoError(conn.WriteJSON(handlers.Message{Type: "join_group", GroupID: groupID}))
+		suite.Require().Equal("subscribed", suite.next(conn).Type)
+		conns = append(conns, conn)
+	}
+	return groupID, conns
+}
+
+// expectRevocationsReachEveryHub removes a member and revokes their session
+// through the first hub, and checks that the connections on every hub follow
+func (suite *WebSocketIntegrationTestSuite) expectRevocationsReachEveryHub(hubs ...*handlers.Hub) {
+	t := suite.T()
+	groupID, conns := suite.joinOnEachHub(hubs...)
+
+	var member models.User
+	suite.Require().NoError(suite.db.Collection("users").FindOne(context.Background(), bson.M{"username": "wsreplica"}).Decode(&member))
+	groupObjID, err := primitive.ObjectIDFromHex(groupID)
+	suite.Require().NoError(err)
+
+	// Leaving the group on one instance unsubscribes the member on all of them
+	_, err = suite.db.Collection("group_members").DeleteOne(context.Background(), bson.M{"group_id": groupObjID, "user_id": member.ID})
+	suite.Require().NoError(err)
+	hubs[0].RecheckUser(suite.db, member.ID)
+	for _, conn := range conns {
+		reply := suite.next(conn)
+		assert.Equal(t, "unsubscribed", reply.Type)
+		assert.Equal(t, groupID, reply.GroupID)
+	}
+
+	// So does revoking the session
+	sessionIDs, err := suite.db.Collection("sessions").Distinct(context.Background(), "_id", bson.M{"user_id": member.ID})
+	suite.Require().NoError(err)
+	var revoked []string
+	for _, id := range sessionIDs {
+		revoked = append(revoked, id.(primitive.ObjectID).Hex())
+	}
+	hubs[0].DisconnectSessions(revoked...)
+	for _, conn := range conns {
+		suite.expectClosed(conn, websocket.ClosePolicyViolation, "session revoked")
+	}
+}
+
+func (suite *WebSocketIntegrationTestSuite) TestHubsShareEventsThroughBroker() {
+	t := suite.T()
+
+	shared := broker.NewMemoryBroker()
+	first := handlers.NewHub(shared)
+	second := handlers.NewHub(shared)
+	groupID, conns := suite.joinOnEachHub(first, second)
+
+	payload, _ := json.Marshal(handlers.Message{Type: "poll_update", GroupID: groupID})
+	first.Publish(handlers.GroupChannel(groupID), payload)
+	for _, conn := range conns {
+		assert.Equal(t, "poll_update", suite.next(conn).Type)
+	}
+
+	// Channels nobody joined are not delivered
+	first.Publish(handlers.GroupChannel(primitive.NewObjectID().Hex()), payload)
+	for _, conn := range conns {
+		suite.expectSilence(conn)
+	}
+}
+
+func (suite *WebSocketIntegrationTestSuite) TestHubsShareRevocationsThroughBroker() {
+	shared := broker.NewMemoryBroker()
+	suite.expectRevocationsReachEveryHub(handlers.NewHub(shared), handlers.NewHub(shared))
+}
+
+func (suite *WebSocketIntegrationTestSuite) TestMongoBrokerSharesEventsBetweenInstances() {
+	t := suite.T()
+	ctx := context.Background()
+
+	var hubs []*handlers.Hub
+	for i := 0; i < 2; i++ {
+		b, err := broker.NewMongoBroker(ctx, suite.db, "ws_events_test")
+		suite.Require().NoError(err)
+		t.Cleanup(func() { b.Close() })
+		hubs = append(hubs, handlers.NewHub(b))
+	}
+	groupID, conns := suite.joinOnEachHub(hubs...)
+
+	// Each event arrives once on every instance, whichever instance published it
+	for _, publisher := range hubs {
+		payload, _ := json.Marshal(handlers.Message{Type: "vote_update", GroupID: groupID})
+		publisher.Publish(handlers.GroupChannel(groupID), payload)
+		for _, conn := range conns {
+			assert.Equal(t, "vote_update", suite.next(conn).Type)
+		}
+		for _, conn := range conns {
+			suite.expectSilence(conn)
+		}
+	}
+}
+
+func (suite *WebSocketIntegrationTestSuite) TestMongoBrokerSharesRevocationsBetweenInstances() {
+	var hubs []*handlers.Hub
+	for i := 0; i < 2; i++ {
+		b, err := broker.NewMongoBroker(context.Background(), suite.db, "ws_events_test")
+		suite.Require().NoError(err)
+		suite.T().Cleanup(func() { b.Close() })
+		hubs = append(hubs, handlers.NewHub(b))
+	}
+	suite.expectRevocationsReachEveryHub(hubs...)
+}
+
+func (suite *WebSocketIntegrationTestSuite) TestShutdownClosesConnectionsWithRestartHint() {
+	t := suite.T()
+
+	h := handlers.NewHub(broker.NewMemoryBroker())
+	baseURL := suite.serveHub(h)
+	memberID, token := suite.signUp("wsrestart")
+	groupID := suite.createGroup("Restarting Group", memberID).Hex()
+	conn := suite.dialURL(baseURL, token)
+	suite.Require().NoError(conn.WriteJSON(handlers.Message{Type: "join_group", GroupID: groupID}))
+	suite.Require().Equal("subscribed", suite.next(conn).Type)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- h.Shutdown(ctx) }()
+
+	// Clients are told when to reconnect, then get a service restart close frame
+	message := suite.next(conn)
+	suite.Require().Equal("server_restarting", message.Type)
+	retryAfter := message.Data.(map[string]interface{})["retry_after_ms"].(float64)
+	assert.GreaterOrEqual(t, retryAfter, float64(1000))
+	assert.Less(t, retryAfter, float64(5000))
+
+	_, _, err := conn.ReadMessage()
+	var closeErr *websocket.CloseError
+	suite.Require().ErrorAs(err, &closeErr)
+	assert.Equal(t, websocket.CloseServiceRestart, closeErr.Code)
+	assert.Equal(t, "server restarting", closeErr.Text)
+
+	// The hub drains once the client has gone
+	select {
+	case err := <-shutdown:
+		suite.Require().NoError(err)
+	case <-time.After(3 * time.Second):
+		t.Fatal("hub did not drain")
+	}
+
+	// New connections are turned away while shutting down
+	_, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(baseURL, "http")+"/api/ws?token="+token, nil)
+	suite.Require().Error(err)
+	suite.Require().NotNil(resp)
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	assert.NotEmpty(t, resp.Header.Get("Retry-After"))
+}
+
+// dialStatus tries to open a WebSocket and returns the HTTP status of a refused handshake
+func (suite *WebSocketIntegrationTestSuite) dialStatus(baseURL, token string) int {
+	_, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(baseURL, "http")+"/api/ws?token="+token, nil)
+	suite.Require().Error(err)
+	suite.Require().NotNil(resp)
+	return resp.StatusCode
+}
+
+func (suite *WebSocketIntegrationTestSuite) TestConnectionLimitsPerUserAndAddress() {
+	t := suite.T()
+	t.Setenv("MAX_CONNECTIONS_PER_USER", "2")
+	t.Setenv("MAX_CONNECTIONS_PER_IP", "3")
+
+	h := handlers.NewHub(broker.NewMemoryBroker())
+	baseURL := suite.serveHub(h)
+	_, firstToken := suite.signUp("wscapfirst")
+	_, secondToken := suite.signUp("wscapsecond")
+
+	first := suite.dialURL(baseURL, firstToken)
+	suite.dialURL(baseURL, firstToken)
+	assert.Equal(t, http.StatusTooManyRequests, suite.dialStatus(baseURL, firstToken))
+
+	// Every user counts towards the limit of their address (all tests connect from localhost)
+	suite.dialURL(baseURL, secondToken)
+	assert.Equal(t, http.StatusTooManyRequests, suite.dialStatus(baseURL, secondToken))
+
+	metrics := h.Metrics()
+	assert.Equal(t, 3, metrics.Connections)
+	assert.Equal(t, uint64(1), metrics.RejectedPerUser)
+	assert.Equal(t, uint64(1), metrics.RejectedPerIP)
+
+	// Closing a connection frees its slot
+	first.Close()
+	suite.Require().Eventually(func() bool { return h.Metrics().Connections == 2 }, 2*time.Second, 20*time.Millisecond)
+	suite.dialURL(baseURL, secondToken)
+}
+
+func (suite *WebSocketIntegrationTestSuite) TestSlowConsumerIsDisconnected() {
+	t := suite.T()
+
+	h := handlers.NewHub(broker.NewMemoryBroker())
+	baseURL := suite.serveHub(h)
+	memberID, token := suite.signUp("wsslow")
+	groupID := suite.createGroup("Slow Group", memberID).Hex()
+
+	slow := suite.dialURL(baseURL, token)
+	suite.Require().NoError(slow.WriteJSON(handlers.Message{Type: "join_group", GroupID: groupID}))
+	suite.Require().Equal("subscribed", suite.next(slow).Type)
+	fast := suite.dialURL(baseURL, token)
+	suite.Require().NoError(fast.WriteJSON(handlers.Message{Type: "join_group", GroupID: groupID}))
+	suite.Require().Equal("subscribed", suite.next(fast).Type)
+
+	// The fast client keeps reading, the slow one never does
+	received := make(chan int, 1)
+	go func() {
+		count := 0
+		fast.SetReadDeadline(time.Now().Add(30 * time.Second))
+		for {
+			if _, _, err := fast.ReadMessage(); err != nil {
+				received <- count
+				return
+			}
+			count++
+		}
+	}()
+
+	// Publish from several goroutines at once until socket buffers and the
+	// slow client's queue are full
+	payload, _ := json.Marshal(handlers.Message{Type: "poll_update", GroupID: groupID, Data: strings.Repeat("x", 32<<10)})
+	var publishers sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		publishers.Add(1)
+		go func() {
+			defer publishers.Done()
+			for j := 0; j < 2000 && h.Metrics().SlowConsumerDisconnects == 0; j++ {
+				h.Publish(handlers.GroupChannel(groupID), payload)
+				time.Sleep(2 * time.Millisecond)
+			}
+		}()
+	}
+	publishers.Wait()
+
+	suite.Require().Equal(uint64(1), h.Metrics().SlowConsumerDisconnects)
+	suite.Require().Eventually(func() bool { return h.Metrics().Connections == 1 }, 5*time.Second, 20*time.Millisecond)
+
+	// The slow client's connection ends, with a try again later close frame
+	// unless its socket was too full to write one
+	slow.SetReadDeadline(time.Now().Add(10 * time.Second))
+	var err error
+	for err == nil {
+		_, _, err = slow.ReadMessage()
+	}
+	var closeErr *websocket.CloseError
+	if errors.As(err, &closeErr) {
+		assert.Equal(t, websocket.CloseTryAgainLater, closeErr.Code)
+	}
+
+	// The other client is still connected and gets further events
+	metrics := h.Metrics()
+	assert.Equal(t, 1, metrics.WebSockets)
+	assert.Equal(t, 256, metrics.QueueCapacity)
+	small, _ := json.Marshal(handlers.Message{Type: "poll_update", GroupID: groupID})
+	h.Publish(handlers.GroupChannel(groupID), small)
+	fast.Close()
+	assert.Positive(t, <-received)
+}
+
+// deleteJSON sends a DELETE request and decodes the response
+func (suite *WebSocketIntegrationTestSuite) deleteJSON(path, token string) (int, map[string]interface{}) {
+	req, _ := http.NewRequest("DELETE", path, nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp := suite.server.ServeHTTP(req)
+
+	var response map[string]interface{}
+	json.Unmarshal(resp.Body.Bytes(), &response)
+	return resp.Code, response
+}
+
+// signIn starts another session for a user and returns its access token
+func (suite *WebSocketIntegrationTestSuite) signIn(username string) string {
+	code, response := suite.postJSON("/api/auth/signin", "", map[string]interface{}{
+		"email":    username + "@example.com",
+		"password": "password123",
+	})
+	suite.Require().Equal(http.StatusOK, code, response)
+	return response["token"].(string)
+}
+
+// sessions lists the active sessions of a user as seen with a token, and
+// which of them the token belongs to
+func (suite *WebSocketIntegrationTestSuite) sessions(path, token string) (ids []string, current string) {
+	req, _ := http.NewRequest("GET", path, nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp := suite.server.ServeHTTP(req)
+	suite.Require().Equal(http.StatusOK, resp.Code, resp.Body.String())
+
+	var sessions []map[string]interface{}
+	suite.Require().NoError(json.Unmarshal(resp.Body.Bytes(), &sessions))
+	for _, session := range sessions {
+		ids = append(ids, session["id"].(string))
+		if session["current"].(bool) {
+			current = session["id"].(string)
+		}
+	}
+	return ids, current
+}
+
+// expectClosed checks that the server closes a WebSocket with a close frame
+func (suite *WebSocketIntegrationTestSuite) expectClosed(conn *websocket.Conn, code int, text string) {
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		_, _, err := conn.ReadMessage()
+		if err == nil {
+			continue
+		}
+		var closeErr *websocket.CloseError
+		suite.Require().ErrorAs(err, &closeErr)
+		assert.Equal(suite.T(), code, closeErr.Code)
+		assert.Equal(suite.T(), text, closeErr.Text)
+		return
+	}
+}
+
+// expectOpen checks that a WebSocket still answers requests
+func (suite *WebSocketIntegrationTestSuite) expectOpen(conn *websocket.Conn, groupID string) {
+	suite.Require().NoError(conn.WriteJSON(handlers.Message{Type: "join_group", GroupID: groupID}))
+	suite.Require().Equal("subscribed", suite.next(conn).Type)
+}
+
+func (suite *WebSocketIntegrationTestSuite) TestRevokingSessionClosesItsWebSocket() {
+	t := suite.T()
+
+	userID, firstToken := suite.signUp("sessionowner")
+	_, otherToken := suite.signUp("sessionother")
+	secondToken := suite.signIn("sessionowner")
+	groupID := suite.createGroup("Session Group", userID).Hex()
+
+	first := suite.dial(firstToken)
+	second := suite.dial(secondToken)
+	suite.expectOpen(first, groupID)
+
+	sessions, secondSessionID := suite.sessions("/api/user/sessions", secondToken)
+	suite.Require().Len(sessions, 2)
+	firstSessionID := sessions[0]
+	if firstSessionID == secondSessionID {
+		firstSessionID = sessions[1]
+	}
+
+	// Nobody else can revoke the session
+	code, _ := suite.deleteJSON("/api/user/sessions/"+firstSessionID, otherToken)
+	assert.Equal(t, http.StatusNotFound, code)
+
+	code, _ = suite.deleteJSON("/api/user/sessions/"+firstSessionID, secondToken)
+	suite.Require().Equal(http.StatusOK, code)
+
+	// The revoked session's socket is closed and its token stops working
+	suite.expectClosed(first, websocket.ClosePolicyViolation, "session revoked")
+	code, _ = suite.getJSON("/api/user/sessions", firstToken)
+	assert.Equal(t, http.StatusUnauthorized, code)
+	assert.Equal(t, http.StatusUnauthorized, suite.dialStatus(suite.server.URL(), firstToken))
+
+	// The other session is unaffected
+	suite.expectOpen(second, groupID)
+	sessions, _ = suite.sessions("/api/user/sessions", secondToken)
+	assert.Equal(t, []string{secondSessionID}, sessions)
+
+	code, _ = suite.deleteJSON("/api/user/sessions/"+firstSessionID, secondToken)
+	assert.Equal(t, http.StatusNotFound, code)
+}
+
+func (suite *WebSocketIntegrationTestSuite) TestRevokeOtherSessionsKeepsCurrentOne() {
+	t := suite.T()
+
+	userID, firstToken := suite.signUp("othersessions")
+	secondToken := suite.signIn("othersessions")
+	currentToken := suite.signIn("othersessions")
+	groupID := suite.createGroup("Other Sessions Group", userID).Hex()
+
+	first := suite.dial(firstToken)
+	second := suite.dial(secondToken)
+	current := suite.dial(currentToken)
+	suite.expectOpen(current, groupID)
+
+	code, response := suite.deleteJSON("/api/user/sessions", currentToken)
+	suite.Require().Equal(http.StatusOK, code)
+	assert.Equal(t, float64(2), response["revoked"])
+
+	suite.expectClosed(first, websocket.ClosePolicyViolation, "session revoked")
+	suite.expectClosed(second, websocket.ClosePolicyViolation, "session revoked")
+	for _, token := range []string{firstToken, secondToken} {
+		code, _ = suite.getJSON("/api/user/sessions", token)
+		assert.Equal(t, http.StatusUnauthorized, code)
+	}
+
+	suite.expectOpen(current, groupID)
+	sessions, currentSessionID := suite.sessions("/api/user/sessions", currentToken)
+	assert.Equal(t, []string{currentSessionID}, sessions)
+}
+
+func (suite *WebSocketIntegrationTestSuite) TestAdminRevokesUserSessions() {
+	t := suite.T()
+
+	// The first user becomes an admin
+	_, adminToken := suite.signUp("sessionadmin")
+	userID, firstToken := suite.signUp("sessiontarget")
+	secondToken := suite.signIn("sessiontarget")
+
+	first := suite.dial(firstToken)
+	second := suite.dial(secondToken)
+	adminConn := suite.dial(adminToken)
+
+	path := "/api/admin/users/" + userID.Hex() + "/sessions"
+	code, _ := suite.getJSON(path, firstToken)
+	assert.Equal(t, http.StatusForbidden, code)
+	code, _ = suite.deleteJSON(path, firstToken)
+	assert.Equal(t, http.StatusForbidden, code)
+
+	// A single session
+	sessions, _ := suite.sessions(path, adminToken)
+	suite.Require().Len(sessions, 2)
+	code, _ = suite.deleteJSON(path+"/"+sessions[0], adminToken)
+	suite.Require().Equal(http.StatusOK, code)
+	remaining, _ := suite.sessions(path, adminToken)
+	assert.Equal(t, sessions[1:], remaining)
+
+	// Then every session
+	code, response := suite.deleteJSON(path, adminToken)
+	suite.Require().Equal(http.StatusOK, code)
+	assert.Equal(t, float64(1), response["revoked"])
+
+	suite.expectClosed(first, websocket.ClosePolicyViolation, "session revoked")
+	suite.expectClosed(second, websocket.ClosePolicyViolation, "session revoked")
+	for _, token := range []string{firstToken, secondToken} {
+		code, _ = suite.getJSON("/api/user/sessions", token)
+		assert.Equal(t, http.StatusUnauthorized, code)
+		assert.Equal(t, http.StatusUnauthorized, suite.dialStatus(suite.server.URL(), token))
+	}
+
+	// The admin's own connection stays open
+	suite.expectSilence(adminConn)
+	code, _ = suite.getJSON("/api/user/sessions", adminToken)
+	assert.Equal(t, http.StatusOK, code)
+}
+
+func TestWebSocketIntegrationSuite(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration tests in short mode")
+	}
+	suite.Run(t, new(WebSocketIntegrationTestSuite))
+}
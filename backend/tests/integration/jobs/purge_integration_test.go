@@ -0,0 +1,212 @@
+package integration_test
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+	"voteverse/jobs"
+	"voteverse/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const retention = 30 * 24 * time.Hour
+
+// PurgeIntegrationTestSuite needs a MongoDB replica set, since purges cascade in transactions
+type PurgeIntegrationTestSuite struct {
+	suite.Suite
+	db     *mongo.Database
+	client *mongo.Client
+}
+
+func (suite *PurgeIntegrationTestSuite) SetupSuite() {
+	mongoURI := os.Getenv("MONGODB_URI")
+	if mongoURI == "" {
+		mongoURI = "mongodb://localhost:27017"
+	}
+
+	dbName := os.Getenv("DB_NAME")
+	if dbName == "" {
+		dbName = "voteverse_test"
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(mongoURI))
+	if err != nil {
+		suite.T().Skipf("MongoDB not available: %v", err)
+	}
+	if err := client.Ping(ctx, nil); err != nil {
+		suite.T().Skipf("MongoDB not available: %v", err)
+	}
+
+	suite.client = client
+	suite.db = client.Database(dbName)
+}
+
+func (suite *PurgeIntegrationTestSuite) TearDownSuite() {
+	if suite.client == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := suite.db.Drop(ctx); err != nil {
+		suite.T().Logf("Failed to drop test database: %v", err)
+	}
+	if err := suite.client.Disconnect(ctx); err != nil {
+		suite.T().Logf("Failed to disconnect from MongoDB: %v", err)
+	}
+}
+
+func (suite *PurgeIntegrationTestSuite) SetupTest() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	for _, name := range []string{"users", "groups", "polls", "votes", "comments", "group_members", "events"} {
+		if _, err := suite.db.Collection(name).DeleteMany(ctx, bson.M{}); err != nil {
+			suite.T().Logf("Failed to clear %s collection: %v", name, err)
+		}
+	}
+}
+
+// deletedAt returns a soft-delete timestamp from some time ago, or nil for
+// documents that are not deleted
+func deletedAt(ago time.Duration) *primitive.DateTime {
+	if ago == 0 {
+		return nil
+	}
+	at := primitive.NewDateTimeFromTime(time.Now().Add(-ago))
+	return &at
+}
+
+// seedPoll creates a poll with one vote and one comment. Comments deleted with
+// their poll share its deleted_at.
+func (suite *PurgeIntegrationTestSuite) seedPoll(groupID primitive.ObjectID, deleted *primitive.DateTime) (pollID, commentID primitive.ObjectID) {
+	ctx := context.Background()
+	userID := primitive.NewObjectID()
+	optionID := primitive.NewObjectID()
+	pollID = primitive.NewObjectID()
+	commentID = primitive.NewObjectID()
+
+	_, err := suite.db.Collection("polls").InsertOne(ctx, models.Poll{
+		ID:        pollID,
+		GroupID:   groupID,
+		Title:     "poll",
+		Options:   []models.PollOption{{ID: optionID, Text: "a", VoteCount: 1}, {ID: primitive.NewObjectID(), Text: "b"}},
+		DeletedAt: deleted,
+	})
+	suite.Require().NoError(err)
+	_, err = suite.db.Collection("votes").InsertOne(ctx, models.Vote{ID: primitive.NewObjectID(), PollID: pollID, UserID: userID, OptionID: optionID})
+	suite.Require().NoError(err)
+	_, err = suite.db.Collection("comments").InsertOne(ctx, models.Comment{ID: commentID, PollID: pollID, UserID: userID, Text: "hi", DeletedAt: deleted})
+	suite.Require().NoError(err)
+	return pollID, commentID
+}
+
+// seedGroup creates a group with one poll, deleted together with the group
+func (suite *PurgeIntegrationTestSuite) seedGroup(deleted *primitive.DateTime) (groupID, pollID primitive.ObjectID) {
+	groupID = primitive.NewObjectID()
+	_, err := suite.db.Collection("groups").InsertOne(context.Background(), models.Group{
+		ID:        groupID,
+		Name:      "purge-" + groupID.Hex(),
+		IsActive:  true,
+		DeletedAt: deleted,
+	})
+	suite.Require().NoError(err)
+	pollID, _ = suite.seedPoll(groupID, deleted)
+	return groupID, pollID
+}
+
+// exists reports whether a document is still stored, deleted or not
+func (suite *PurgeIntegrationTestSuite) exists(collection string, filter bson.M) bool {
+	count, err := suite.db.Collection(collection).CountDocuments(context.Background(), filter)
+	suite.Require().NoError(err)
+	return count > 0
+}
+
+func (suite *PurgeIntegrationTestSuite) TestPurgeRemovesExpiredDocumentsWithDependents() {
+	t := suite.T()
+
+	expiredGroupID, expiredGroupPollID := suite.seedGroup(deletedAt(40 * 24 * time.Hour))
+	recentGroupID, recentGroupPollID := suite.seedGroup(deletedAt(24 * time.Hour))
+	liveGroupID, livePollID := suite.seedGroup(nil)
+	expiredPollID, expiredPollCommentID := suite.seedPoll(liveGroupID, deletedAt(40*24*time.Hour))
+
+	expiredCommentID := primitive.NewObjectID()
+	_, err := suite.db.Collection("comments").InsertOne(context.Background(), models.Comment{
+		ID:        expiredCommentID,
+		PollID:    livePollID,
+		Text:      "old",
+		DeletedAt: deletedAt(40 * 24 * time.Hour),
+	})
+	suite.Require().NoError(err)
+
+	jobs.PurgeExpired(context.Background(), suite.db, retention)
+
+	// Expired documents go together with their dependents
+	assert.False(t, suite.exists("groups", bson.M{"_id": expiredGroupID}))
+	assert.False(t, suite.exists("polls", bson.M{"_id": expiredGroupPollID}))
+	assert.False(t, suite.exists("votes", bson.M{"poll_id": expiredGroupPollID}))
+	assert.False(t, suite.exists("comments", bson.M{"poll_id": expiredGroupPollID}))
+	assert.False(t, suite.exists("polls", bson.M{"_id": expiredPollID}))
+	assert.False(t, suite.exists("votes", bson.M{"poll_id": expiredPollID}))
+	assert.False(t, suite.exists("comments", bson.M{"_id": expiredPollCommentID}))
+	assert.False(t, suite.exists("comments", bson.M{"_id": expiredCommentID}))
+
+	// Documents deleted within the retention window can still be restored
+	assert.True(t, suite.exists("groups", bson.M{"_id": recentGroupID}))
+	assert.True(t, suite.exists("polls", bson.M{"_id": recentGroupPollID}))
+	assert.True(t, suite.exists("comments", bson.M{"poll_id": recentGroupPollID}))
+
+	// Live documents are untouched
+	assert.True(t, suite.exists("groups", bson.M{"_id": liveGroupID}))
+	assert.True(t, suite.exists("polls", bson.M{"_id": livePollID}))
+	assert.True(t, suite.exists("votes", bson.M{"poll_id": livePollID}))
+	assert.True(t, suite.exists("comments", bson.M{"poll_id": livePollID, "deleted_at": nil}))
+}
+
+func (suite *PurgeIntegrationTestSuite) TestPurgeRemovesExpiredUsers() {
+	t := suite.T()
+
+	expiredUserID := primitive.NewObjectID()
+	recentUserID := primitive.NewObjectID()
+	for _, user := range []models.User{
+		{ID: expiredUserID, Username: "expired", Email: "expired@example.com", DeletedAt: deletedAt(40 * 24 * time.Hour)},
+		{ID: recentUserID, Username: "recent", Email: "recent@example.com", DeletedAt: deletedAt(24 * time.Hour)},
+	} {
+		_, err := suite.db.Collection("users").InsertOne(context.Background(), user)
+		suite.Require().NoError(err)
+	}
+
+	jobs.PurgeExpired(context.Background(), suite.db, retention)
+
+	assert.False(t, suite.exists("users", bson.M{"_id": expiredUserID}))
+	assert.True(t, suite.exists("users", bson.M{"_id": recentUserID}))
+
+	// A zero retention purges everything that is deleted
+	jobs.PurgeExpired(context.Background(), suite.db, 0)
+	assert.False(t, suite.exists("users", bson.M{"_id": recentUserID}))
+}
+
+func TestPurgeIntegrationTestSuite(t *testing.T) {
+	suite.Run(t, new(PurgeIntegrationTestSuite))
+}
+
+func TestRetentionFromEnv(t *testing.T) {
+	t.Setenv("SOFT_DELETE_RETENTION_DAYS", "")
+	assert.Equal(t, retention, jobs.RetentionFromEnv())
+
+	t.Setenv("SOFT_DELETE_RETENTION_DAYS", "7")
+	assert.Equal(t, 7*24*time.Hour, jobs.RetentionFromEnv())
+
+	t.Setenv("SOFT_DELETE_RETENTION_DAYS", "-1")
+	assert.Equal(t, retention, jobs.RetentionFromEnv())
+}
@@ -0,0 +1,224 @@
+package integration_test
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+	"voteverse/models"
+	"voteverse/services"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// CascadeIntegrationTestSuite needs a MongoDB replica set, since cascades run in transactions
+type CascadeIntegrationTestSuite struct {
+	suite.Suite
+	db     *mongo.Database
+	client *mongo.Client
+}
+
+func (suite *CascadeIntegrationTestSuite) SetupSuite() {
+	mongoURI := os.Getenv("MONGODB_URI")
+	if mongoURI == "" {
+		mongoURI = "mongodb://localhost:27017"
+	}
+
+	dbName := os.Getenv("DB_NAME")
+	if dbName == "" {
+		dbName = "voteverse_test"
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(mongoURI))
+	if err != nil {
+		suite.T().Skipf("MongoDB not available: %v", err)
+	}
+	if err := client.Ping(ctx, nil); err != nil {
+		suite.T().Skipf("MongoDB not available: %v", err)
+	}
+
+	suite.client = client
+	suite.db = client.Database(dbName)
+}
+
+func (suite *CascadeIntegrationTestSuite) TearDownSuite() {
+	if suite.client == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := suite.db.Drop(ctx); err != nil {
+		suite.T().Logf("Failed to drop test database: %v", err)
+	}
+	if err := suite.client.Disconnect(ctx); err != nil {
+		suite.T().Logf("Failed to disconnect from MongoDB: %v", err)
+	}
+}
+
+func (suite *CascadeIntegrationTestSuite) SetupTest() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	for _, name := range []string{"users", "groups", "polls", "votes", "comments", "group_members", "events"} {
+		if _, err := suite.db.Collection(name).DeleteMany(ctx, bson.M{}); err != nil {
+			suite.T().Logf("Failed to clear %s collection: %v", name, err)
+		}
+	}
+}
+
+// seedGroup creates a group with two polls, each with one vote and one comment,
+// one membership and one event. The group hangs below the given ancestors,
+// root first.
+func (suite *CascadeIntegrationTestSuite) seedGroup(ancestors ...primitive.ObjectID) (groupID primitive.ObjectID, pollIDs []primitive.ObjectID) {
+	ctx := context.Background()
+	now := primitive.NewDateTimeFromTime(time.Now())
+	userID := primitive.NewObjectID()
+	groupID = primitive.NewObjectID()
+
+	group := models.Group{ID: groupID, Name: "cascade-" + groupID.Hex(), IsActive: true, CreatedAt: now}
+	if len(ancestors) > 0 {
+		group.ParentID = ancestors[len(ancestors)-1]
+		group.Ancestors = ancestors
+	}
+	_, err := suite.db.Collection("groups").InsertOne(ctx, group)
+	suite.Require().NoError(err)
+
+	for i := 0; i < 2; i++ {
+		optionID := primitive.NewObjectID()
+		pollID := primitive.NewObjectID()
+		pollIDs = append(pollIDs, pollID)
+
+		_, err = suite.db.Collection("polls").InsertOne(ctx, models.Poll{
+			ID:      pollID,
+			GroupID: groupID,
+			Title:   "poll",
+			Options: []models.PollOption{{ID: optionID, Text: "a", VoteCount: 1}, {ID: primitive.NewObjectID(), Text: "b"}},
+		})
+		suite.Require().NoError(err)
+		_, err = suite.db.Collection("votes").InsertOne(ctx, models.Vote{ID: primitive.NewObjectID(), PollID: pollID, UserID: userID, OptionID: optionID})
+		suite.Require().NoError(err)
+		_, err = suite.db.Collection("comments").InsertOne(ctx, models.Comment{ID: primitive.NewObjectID(), PollID: pollID, UserID: userID, Text: "hi"})
+		suite.Require().NoError(err)
+	}
+
+	_, err = suite.db.Collection("group_members").InsertOne(ctx, models.GroupMember{ID: primitive.NewObjectID(), GroupID: groupID, UserID: userID, Role: "admin"})
+	suite.Require().NoError(err)
+	_, err = suite.db.Collection("events").InsertOne(ctx, models.GroupEvent{ID: primitive.NewObjectID(), GroupID: groupID, Type: models.EventMemberJoined})
+	suite.Require().NoError(err)
+
+	return groupID, pollIDs
+}
+
+func (suite *CascadeIntegrationTestSuite) TestHardDeleteGroupReturnsExactCounts() {
+	t := suite.T()
+	groupID, _ := suite.seedGroup()
+	otherGroupID, _ := suite.seedGroup()
+
+	counts, err := services.NewCascadeService(suite.db).DeleteGroup(context.Background(), groupID, services.DeleteOptions{Mode: services.HardDelete})
+	suite.Require().NoError(err)
+
+	assert.Equal(t, services.DeleteCounts{
+		"groups":        1,
+		"polls":         2,
+		"votes":         2,
+		"comments":      2,
+		"group_members": 1,
+		"events":        1,
+	}, counts)
+
+	// The other group is untouched
+	remaining, err := suite.db.Collection("polls").CountDocuments(context.Background(), bson.M{"group_id": otherGroupID})
+	suite.Require().NoError(err)
+	assert.Equal(t, int64(2), remaining)
+}
+
+func (suite *CascadeIntegrationTestSuite) TestSoftDeleteGroupMarksPollsAndComments() {
+	t := suite.T()
+	groupID, pollIDs := suite.seedGroup()
+	adminID := primitive.NewObjectID()
+
+	counts, err := services.NewCascadeService(suite.db).DeleteGroup(context.Background(), groupID, services.DeleteOptions{
+		Mode:      services.SoftDelete,
+		DeletedBy: adminID,
+	})
+	suite.Require().NoError(err)
+	assert.Equal(t, services.DeleteCounts{"groups": 1, "polls": 2, "comments": 2}, counts)
+
+	deleted, err := suite.db.Collection("polls").CountDocuments(context.Background(), bson.M{
+		"_id":        bson.M{"$in": pollIDs},
+		"deleted_by": adminID,
+	})
+	suite.Require().NoError(err)
+	assert.Equal(t, int64(2), deleted)
+
+	// Votes are kept so the group can be restored
+	votes, err := suite.db.Collection("votes").CountDocuments(context.Background(), bson.M{"poll_id": bson.M{"$in": pollIDs}})
+	suite.Require().NoError(err)
+	assert.Equal(t, int64(2), votes)
+
+	// Deleting again finds nothing
+	_, err = services.NewCascadeService(suite.db).DeleteGroup(context.Background(), groupID, services.DeleteOptions{Mode: services.SoftDelete})
+	assert.ErrorIs(t, err, services.ErrNotFound)
+}
+
+func (suite *CascadeIntegrationTestSuite) TestDeleteGroupCascadesIntoSubgroups() {
+	t := suite.T()
+	rootID, _ := suite.seedGroup()
+	childID, _ := suite.seedGroup(rootID)
+	grandchildID, grandchildPollIDs := suite.seedGroup(rootID, childID)
+	otherGroupID, _ := suite.seedGroup()
+	groupIDs := []primitive.ObjectID{rootID, childID, grandchildID}
+	cascade := services.NewCascadeService(suite.db)
+
+	// Soft-deleting the root takes the whole hierarchy with it, in one stamp
+	counts, err := cascade.DeleteGroup(context.Background(), rootID, services.DeleteOptions{Mode: services.SoftDelete})
+	suite.Require().NoError(err)
+	assert.Equal(t, services.DeleteCounts{"groups": 3, "polls": 6, "comments": 6}, counts)
+
+	var root models.Group
+	suite.Require().NoError(suite.db.Collection("groups").FindOne(context.Background(), bson.M{"_id": rootID}).Decode(&root))
+	stamped, err := suite.db.Collection("groups").CountDocuments(context.Background(), bson.M{
+		"_id":        bson.M{"$in": groupIDs},
+		"deleted_at": root.DeletedAt,
+	})
+	suite.Require().NoError(err)
+	assert.Equal(t, int64(3), stamped)
+	deletedPolls, err := suite.db.Collection("polls").CountDocuments(context.Background(), bson.M{
+		"_id":        bson.M{"$in": grandchildPollIDs},
+		"deleted_at": root.DeletedAt,
+	})
+	suite.Require().NoError(err)
+	assert.Equal(t, int64(2), deletedPolls)
+
+	// Purging the root leaves nothing of its subgroups behind
+	counts, err = cascade.DeleteGroup(context.Background(), rootID, services.DeleteOptions{Mode: services.HardDelete})
+	suite.Require().NoError(err)
+	assert.Equal(t, services.DeleteCounts{
+		"groups":        3,
+		"polls":         6,
+		"votes":         6,
+		"comments":      6,
+		"group_members": 3,
+		"events":        3,
+	}, counts)
+
+	remaining, err := suite.db.Collection("groups").CountDocuments(context.Background(), bson.M{})
+	suite.Require().NoError(err)
+	assert.Equal(t, int64(1), remaining)
+	remaining, err = suite.db.Collection("polls").CountDocuments(context.Background(), bson.M{"group_id": otherGroupID})
+	suite.Require().NoError(err)
+	assert.Equal(t, int64(2), remaining)
+}
+
+func TestCascadeIntegrationTestSuite(t *testing.T) {
+	suite.Run(t, new(CascadeIntegrationTestSuite))
+}
@@ -2,9 +2,10 @@ package helpers
 
 import (
 	"context"
-	"os"
 	"time"
+	"voteverse/handlers"
 	"voteverse/models"
+	"voteverse/signing"
 
 	"github.com/golang-jwt/jwt/v5"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -13,28 +14,32 @@ import (
 
 // AuthHelper provides utility functions for authentication in tests
 type AuthHelper struct {
-	JWTSecret string
+	Keys *signing.KeySet
 }
 
-// NewAuthHelper creates a new AuthHelper
+// NewAuthHelper creates a new AuthHelper that signs tokens with the keys the handlers verify
 func NewAuthHelper() *AuthHelper {
-	jwtSecret := os.Getenv("JWT_SECRET")
-	if jwtSecret == "" {
-		jwtSecret = "test-jwt-secret"
-	}
-
 	return &AuthHelper{
-		JWTSecret: jwtSecret,
+		Keys: handlers.TokenKeys(),
 	}
 }
 
-// GenerateToken generates a JWT token for a user ID
+// GenerateToken generates a JWT token for a user ID. AuthMiddleware only accepts it
+// if a matching document exists in the sessions collection, see GenerateSessionToken.
 func (h *AuthHelper) GenerateToken(userID primitive.ObjectID) (string, error) {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+	return h.GenerateSessionToken(userID, primitive.NewObjectID())
+}
+
+// GenerateSessionToken generates a JWT token bound to a session ID
+func (h *AuthHelper) GenerateSessionToken(userID, sessionID primitive.ObjectID) (string, error) {
+	return h.Keys.Sign(jwt.MapClaims{
+		"iss":     handlers.TokenIssuer(),
+		"aud":     handlers.AccessTokenAudience,
+		"typ":     "access",
 		"user_id": userID.Hex(),
+		"sid":     sessionID.Hex(),
 		"exp":     time.Now().Add(time.Hour * 24).Unix(),
 	})
-	return token.SignedString([]byte(h.JWTSecret))
 }
 
 // HashPassword hashes a password for testing
@@ -0,0 +1,44 @@
+package broker_test
+
+import (
+	"context"
+	"testing"
+	"voteverse/broker"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryBrokerFansOut(t *testing.T) {
+	b := broker.NewMemoryBroker()
+
+	var first, second []broker.Event
+	unsubscribeFirst := b.Subscribe(func(event broker.Event) { first = append(first, event) })
+	b.Subscribe(func(event broker.Event) { second = append(second, event) })
+
+	event := broker.Event{Channel: "group:1", Payload: []byte(`{"type":"poll_update"}`)}
+	require.NoError(t, b.Publish(context.Background(), event))
+	assert.Equal(t, []broker.Event{event}, first)
+	assert.Equal(t, []broker.Event{event}, second)
+
+	// Unsubscribed handlers get nothing more
+	unsubscribeFirst()
+	require.NoError(t, b.Publish(context.Background(), event))
+	assert.Len(t, first, 1)
+	assert.Len(t, second, 2)
+
+	require.NoError(t, b.Close())
+	require.NoError(t, b.Publish(context.Background(), event))
+	assert.Len(t, second, 2)
+}
+
+func TestFromEnv(t *testing.T) {
+	t.Setenv("EVENT_BROKER", "")
+	b, err := broker.FromEnv(context.Background(), nil)
+	require.NoError(t, err)
+	assert.IsType(t, &broker.MemoryBroker{}, b)
+
+	t.Setenv("EVENT_BROKER", "redis")
+	_, err = broker.FromEnv(context.Background(), nil)
+	assert.Error(t, err)
+}
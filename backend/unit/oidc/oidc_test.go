@@ -0,0 +1,114 @@
+package oidc_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+	"voteverse/oidc"
+	"voteverse/testutils/mocks"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newProvider(t *testing.T) (*mocks.OIDCProvider, *oidc.Provider) {
+	mock := mocks.NewOIDCProvider("voteverse", "secret")
+	t.Cleanup(mock.Close)
+
+	provider, err := oidc.NewProvider(context.Background(), oidc.Config{
+		IssuerURL:    mock.Issuer(),
+		ClientID:     "voteverse",
+		ClientSecret: "secret",
+		RedirectURL:  "http://localhost:3000/auth/oidc/callback",
+		Scopes:       []string{"openid", "email"},
+	})
+	require.NoError(t, err)
+	return mock, provider
+}
+
+func TestAuthorizationCodeFlowWithPKCE(t *testing.T) {
+	mock, provider := newProvider(t)
+	mock.User = mocks.OIDCUser{Subject: "user-1", Email: "sso@example.com", EmailVerified: true}
+
+	verifier, _ := oidc.RandomString()
+	code, state, err := mock.Authorize(provider.AuthCodeURL("state-1", "nonce-1", verifier))
+	require.NoError(t, err)
+	assert.Equal(t, "state-1", state)
+
+	// A wrong verifier is rejected by the provider
+	_, err = provider.Exchange(context.Background(), code, "wrong-verifier")
+	assert.Error(t, err)
+
+	code, _, err = mock.Authorize(provider.AuthCodeURL("state-2", "nonce-2", verifier))
+	require.NoError(t, err)
+	tokens, err := provider.Exchange(context.Background(), code, verifier)
+	require.NoError(t, err)
+
+	claims, err := provider.VerifyIDToken(context.Background(), tokens.IDToken, "nonce-2")
+	require.NoError(t, err)
+	assert.Equal(t, "user-1", claims.Subject)
+	assert.Equal(t, "sso@example.com", claims.Email)
+	assert.True(t, bool(claims.EmailVerified))
+
+	_, err = provider.VerifyIDToken(context.Background(), tokens.IDToken, "other-nonce")
+	assert.True(t, errors.Is(err, oidc.ErrNonceMismatch))
+}
+
+func TestVerifyIDTokenRejectsBadClaims(t *testing.T) {
+	mock, provider := newProvider(t)
+	now := time.Now()
+	valid := func() jwt.MapClaims {
+		return jwt.MapClaims{
+			"iss":   mock.Issuer(),
+			"sub":   "user-1",
+			"aud":   "voteverse",
+			"exp":   now.Add(time.Minute).Unix(),
+			"iat":   now.Unix(),
+			"nonce": "n",
+		}
+	}
+
+	_, err := provider.VerifyIDToken(context.Background(), mock.SignIDToken(valid()), "n")
+	assert.NoError(t, err)
+
+	cases := map[string]func(jwt.MapClaims){
+		"wrong issuer":   func(c jwt.MapClaims) { c["iss"] = "https://evil.example.com" },
+		"wrong audience": func(c jwt.MapClaims) { c["aud"] = "another-client" },
+		"expired":        func(c jwt.MapClaims) { c["exp"] = now.Add(-time.Hour).Unix() },
+		"no expiry":      func(c jwt.MapClaims) { delete(c, "exp") },
+		"no subject":     func(c jwt.MapClaims) { delete(c, "sub") },
+	}
+	for name, mutate := range cases {
+		claims := valid()
+		mutate(claims)
+		_, err := provider.VerifyIDToken(context.Background(), mock.SignIDToken(claims), "n")
+		assert.ErrorIs(t, err, oidc.ErrInvalidIDToken, name)
+	}
+
+	// Unsigned tokens are never accepted
+	unsigned, _ := jwt.NewWithClaims(jwt.SigningMethodNone, valid()).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	_, err = provider.VerifyIDToken(context.Background(), unsigned, "n")
+	assert.ErrorIs(t, err, oidc.ErrInvalidIDToken)
+}
+
+func TestVerifyIDTokenAfterKeyRotation(t *testing.T) {
+	mock, provider := newProvider(t)
+	claims := func() jwt.MapClaims {
+		return jwt.MapClaims{
+			"iss": mock.Issuer(), "sub": "user-1", "aud": "voteverse",
+			"exp": time.Now().Add(time.Minute).Unix(), "iat": time.Now().Unix(), "nonce": "n",
+		}
+	}
+
+	// A token signed with a new key triggers a JWKS refetch
+	mock.RotateKey()
+	_, err := provider.VerifyIDToken(context.Background(), mock.SignIDToken(claims()), "n")
+	assert.NoError(t, err)
+
+	// Further unknown keys are not refetched again straight away
+	mock.RotateKey()
+	_, err = provider.VerifyIDToken(context.Background(), mock.SignIDToken(claims()), "n")
+	assert.ErrorIs(t, err, oidc.ErrInvalidIDToken)
+}
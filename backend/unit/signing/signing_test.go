@@ -0,0 +1,194 @@
+package signing_test
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"voteverse/signing"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func claims(subject string) jwt.MapClaims {
+	return jwt.MapClaims{
+		"sub": subject,
+		"exp": time.Now().Add(time.Minute).Unix(),
+	}
+}
+
+func TestSignAndParse(t *testing.T) {
+	for _, alg := range []string{signing.AlgRS256, signing.AlgEdDSA} {
+		t.Run(alg, func(t *testing.T) {
+			keys := signing.MustGenerateKeySet(alg)
+
+			token, err := keys.Sign(claims("user-1"))
+			require.NoError(t, err)
+
+			parsed, _, err := jwt.NewParser().ParseUnverified(token, jwt.MapClaims{})
+			require.NoError(t, err)
+			assert.Equal(t, alg, parsed.Method.Alg())
+			assert.Equal(t, keys.SigningKeyID(), parsed.Header["kid"])
+
+			got := jwt.MapClaims{}
+			require.NoError(t, keys.Parse(token, got))
+			assert.Equal(t, "user-1", got["sub"])
+		})
+	}
+}
+
+func TestParseAppliesParserOptions(t *testing.T) {
+	keys := signing.MustGenerateKeySet(signing.AlgEdDSA)
+
+	tokenClaims := claims("user-1")
+	tokenClaims["aud"] = "api"
+	token, err := keys.Sign(tokenClaims)
+	require.NoError(t, err)
+
+	assert.NoError(t, keys.Parse(token, jwt.MapClaims{}, jwt.WithAudience("api")))
+	assert.Error(t, keys.Parse(token, jwt.MapClaims{}, jwt.WithAudience("2fa")))
+	assert.Error(t, keys.Parse(token, jwt.MapClaims{}, jwt.WithIssuer("voteverse")))
+}
+
+func TestParseRejectsForeignAndMalformedTokens(t *testing.T) {
+	keys := signing.MustGenerateKeySet(signing.AlgEdDSA)
+	kid := keys.SigningKeyID()
+
+	// The old shared-secret tokens are not accepted, even with a valid kid
+	hmacToken := jwt.NewWithClaims(jwt.SigningMethodHS256, claims("user-1"))
+	hmacToken.Header["kid"] = kid
+	signed, err := hmacToken.SignedString([]byte("secret"))
+	require.NoError(t, err)
+	assert.Error(t, keys.Parse(signed, jwt.MapClaims{}))
+
+	// Unsigned tokens are rejected
+	noneToken := jwt.NewWithClaims(jwt.SigningMethodNone, claims("user-1"))
+	noneToken.Header["kid"] = kid
+	signed, err = noneToken.SignedString(jwt.UnsafeAllowNoneSignatureType)
+	require.NoError(t, err)
+	assert.Error(t, keys.Parse(signed, jwt.MapClaims{}))
+
+	// Tokens from another key set are rejected
+	other := signing.MustGenerateKeySet(signing.AlgEdDSA)
+	signed, err = other.Sign(claims("user-1"))
+	require.NoError(t, err)
+	assert.True(t, errors.Is(keys.Parse(signed, jwt.MapClaims{}), signing.ErrUnknownKey))
+
+	// A token without an expiry is rejected
+	signed, err = keys.Sign(jwt.MapClaims{"sub": "user-1"})
+	require.NoError(t, err)
+	assert.Error(t, keys.Parse(signed, jwt.MapClaims{}))
+}
+
+func TestParseRejectsAlgorithmOfAnotherKey(t *testing.T) {
+	rsaKey, err := signing.GenerateKey(signing.AlgRS256)
+	require.NoError(t, err)
+	edKey, err := signing.GenerateKey(signing.AlgEdDSA)
+	require.NoError(t, err)
+
+	keys, err := signing.NewKeySet(rsaKey, edKey)
+	require.NoError(t, err)
+
+	// Sign with the Ed25519 key but claim to be the RSA key
+	impostor, err := signing.NewKeySet(edKey)
+	require.NoError(t, err)
+	token := jwt.NewWithClaims(jwt.SigningMethodEdDSA, claims("user-1"))
+	token.Header["kid"] = rsaKey.ID
+	pemData, err := edKey.PrivateKeyPEM()
+	require.NoError(t, err)
+	private, err := jwt.ParseEdPrivateKeyFromPEM(pemData)
+	require.NoError(t, err)
+	signed, err := token.SignedString(private)
+	require.NoError(t, err)
+	assert.True(t, errors.Is(keys.Parse(signed, jwt.MapClaims{}), signing.ErrAlgorithmMismatch))
+
+	// Under its own kid the same key is accepted
+	signed, err = impostor.Sign(claims("user-1"))
+	require.NoError(t, err)
+	assert.NoError(t, keys.Parse(signed, jwt.MapClaims{}))
+}
+
+func TestRotation(t *testing.T) {
+	keys := signing.MustGenerateKeySet(signing.AlgRS256)
+	oldKid := keys.SigningKeyID()
+	oldToken, err := keys.Sign(claims("user-1"))
+	require.NoError(t, err)
+
+	newKey, err := signing.GenerateKey(signing.AlgEdDSA)
+	require.NoError(t, err)
+	require.NoError(t, keys.Rotate(newKey))
+	assert.Equal(t, newKey.ID, keys.SigningKeyID())
+
+	// Tokens from before the rotation keep working
+	assert.NoError(t, keys.Parse(oldToken, jwt.MapClaims{}))
+	newToken, err := keys.Sign(claims("user-1"))
+	require.NoError(t, err)
+	assert.NoError(t, keys.Parse(newToken, jwt.MapClaims{}))
+	assert.Len(t, keys.JWKS().Keys, 2)
+
+	// Until the old key is retired
+	assert.True(t, errors.Is(keys.Retire(newKey.ID), signing.ErrRetireSigningKey))
+	require.NoError(t, keys.Retire(oldKid))
+	assert.Error(t, keys.Parse(oldToken, jwt.MapClaims{}))
+	assert.NoError(t, keys.Parse(newToken, jwt.MapClaims{}))
+	assert.Len(t, keys.JWKS().Keys, 1)
+}
+
+func TestJWKSPublishesPublicKeys(t *testing.T) {
+	key, err := signing.GenerateKey(signing.AlgEdDSA)
+	require.NoError(t, err)
+	keys, err := signing.NewKeySet(key)
+	require.NoError(t, err)
+
+	jwks := keys.JWKS()
+	require.Len(t, jwks.Keys, 1)
+	jwk := jwks.Keys[0]
+	assert.Equal(t, "OKP", jwk.Kty)
+	assert.Equal(t, "Ed25519", jwk.Crv)
+	assert.Equal(t, key.ID, jwk.Kid)
+
+	// Another service can verify tokens with only the published key
+	public, err := base64.RawURLEncoding.DecodeString(jwk.X)
+	require.NoError(t, err)
+	token, err := keys.Sign(claims("user-1"))
+	require.NoError(t, err)
+	_, err = jwt.Parse(token, func(*jwt.Token) (interface{}, error) {
+		return ed25519.PublicKey(public), nil
+	}, jwt.WithValidMethods([]string{jwk.Alg}))
+	assert.NoError(t, err)
+}
+
+func TestFromEnv(t *testing.T) {
+	dir := t.TempDir()
+
+	current, err := signing.GenerateKey(signing.AlgRS256)
+	require.NoError(t, err)
+	previous, err := signing.GenerateKey(signing.AlgEdDSA)
+	require.NoError(t, err)
+
+	currentPEM, err := current.PrivateKeyPEM()
+	require.NoError(t, err)
+	previousPEM, err := previous.PrivateKeyPEM()
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "current.pem"), currentPEM, 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "previous.pem"), previousPEM, 0600))
+
+	t.Setenv("JWT_SIGNING_KEY_FILE", filepath.Join(dir, "current.pem"))
+	t.Setenv("JWT_SIGNING_KEY_ID", "2026-10")
+	t.Setenv("JWT_VERIFICATION_KEY_FILES", "2026-09="+filepath.Join(dir, "previous.pem"))
+
+	keys, err := signing.FromEnv()
+	require.NoError(t, err)
+	assert.Equal(t, "2026-10", keys.SigningKeyID())
+
+	jwks := keys.JWKS()
+	require.Len(t, jwks.Keys, 2)
+	assert.Equal(t, "2026-09", jwks.Keys[0].Kid)
+	assert.Equal(t, "2026-10", jwks.Keys[1].Kid)
+	assert.Equal(t, "RSA", jwks.Keys[1].Kty)
+}
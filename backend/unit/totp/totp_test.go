@@ -0,0 +1,139 @@
+package totp_test
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+	"voteverse/totp"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// rfcSecret is the SHA-1 seed of RFC 6238 appendix B, "12345678901234567890"
+var rfcSecret = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+func TestCodeAtMatchesRFC6238Vectors(t *testing.T) {
+	// The RFC lists 8-digit codes; 6-digit codes are their last six digits
+	vectors := []struct {
+		unix int64
+		code string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	}
+
+	for _, v := range vectors {
+		code, err := totp.CodeAt(rfcSecret, totp.Step(time.Unix(v.unix, 0)))
+		require.NoError(t, err)
+		assert.Equal(t, v.code[len(v.code)-totp.Digits:], code, "time %d", v.unix)
+
+		step, ok := totp.Validate(rfcSecret, code, time.Unix(v.unix, 0))
+		assert.True(t, ok, "time %d", v.unix)
+		assert.Equal(t, totp.Step(time.Unix(v.unix, 0)), step)
+	}
+}
+
+func TestCodeAtAcceptsLowerCaseAndPaddedSecrets(t *testing.T) {
+	want, err := totp.CodeAt(rfcSecret, 1)
+	require.NoError(t, err)
+
+	for _, secret := range []string{strings.ToLower(rfcSecret), rfcSecret + "===="} {
+		code, err := totp.CodeAt(secret, 1)
+		require.NoError(t, err)
+		assert.Equal(t, want, code)
+	}
+
+	_, err = totp.CodeAt("not base32!", 1)
+	assert.Error(t, err)
+}
+
+func TestValidateAllowsClockSkew(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	current := totp.Step(now)
+
+	for offset := int64(-totp.Skew); offset <= totp.Skew; offset++ {
+		code, err := totp.CodeAt(rfcSecret, current+offset)
+		require.NoError(t, err)
+
+		step, ok := totp.Validate(rfcSecret, code, now)
+		assert.True(t, ok, "offset %d", offset)
+		assert.Equal(t, current+offset, step)
+	}
+
+	// Codes outside the window are refused
+	for _, offset := range []int64{-totp.Skew - 1, totp.Skew + 1} {
+		code, err := totp.CodeAt(rfcSecret, current+offset)
+		require.NoError(t, err)
+
+		_, ok := totp.Validate(rfcSecret, code, now)
+		assert.False(t, ok, "offset %d", offset)
+	}
+}
+
+func TestValidateNormalizesInput(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	code, err := totp.CodeAt(rfcSecret, totp.Step(now))
+	require.NoError(t, err)
+
+	_, ok := totp.Validate(rfcSecret, " "+code[:3]+" "+code[3:]+" ", now)
+	assert.True(t, ok)
+
+	for _, bad := range []string{"", code[:5], code + "0", "abcdef"} {
+		_, ok := totp.Validate(rfcSecret, bad, now)
+		assert.False(t, ok, "code %q", bad)
+	}
+}
+
+func TestValidateAfterRefusesReplayedCodes(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	current := totp.Step(now)
+	code, err := totp.CodeAt(rfcSecret, current)
+	require.NoError(t, err)
+
+	// The first use is accepted and its step becomes TwoFactorLastStep
+	lastStep, ok := totp.ValidateAfter(rfcSecret, code, now, 0)
+	require.True(t, ok)
+	assert.Equal(t, current, lastStep)
+
+	// The same code is refused, even later within the skew window
+	_, ok = totp.ValidateAfter(rfcSecret, code, now, lastStep)
+	assert.False(t, ok)
+	_, ok = totp.ValidateAfter(rfcSecret, code, now.Add(totp.Period), lastStep)
+	assert.False(t, ok)
+
+	// So is an older code that is still within the window
+	previous, err := totp.CodeAt(rfcSecret, current-1)
+	require.NoError(t, err)
+	_, ok = totp.ValidateAfter(rfcSecret, previous, now, lastStep)
+	assert.False(t, ok)
+
+	// The next period's code still works
+	next, err := totp.CodeAt(rfcSecret, current+1)
+	require.NoError(t, err)
+	step, ok := totp.ValidateAfter(rfcSecret, next, now.Add(totp.Period), lastStep)
+	assert.True(t, ok)
+	assert.Equal(t, current+1, step)
+}
+
+func TestGenerateSecretAndURI(t *testing.T) {
+	secret, err := totp.GenerateSecret()
+	require.NoError(t, err)
+	assert.Len(t, secret, 32) // 160 bits, base32 without padding
+
+	other, err := totp.GenerateSecret()
+	require.NoError(t, err)
+	assert.NotEqual(t, secret, other)
+
+	uri := totp.URI("VoteVerse", "alice@example.com", secret)
+	assert.True(t, strings.HasPrefix(uri, "otpauth://totp/VoteVerse:alice@example.com?"), uri)
+	assert.Contains(t, uri, "secret="+secret)
+	assert.Contains(t, uri, "issuer=VoteVerse")
+	assert.Contains(t, uri, "digits=6")
+	assert.Contains(t, uri, "period=30")
+}